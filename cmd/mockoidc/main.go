@@ -0,0 +1,35 @@
+// mockoidc runs a throwaway OpenID Connect provider for trying "Sign in with…" locally:
+//
+//	go run ./cmd/mockoidc
+//	OIDC_ISSUER=http://localhost:9090 OIDC_CLIENT_ID=forum OIDC_CLIENT_SECRET=secret \
+//	OIDC_NAME=Mock SITE_URL=http://localhost:8080 MAIL_SECRET=dev AUTH_SECRET=dev go run ./cmd/forum
+package main
+
+import (
+	"fmt"
+	"forum1/internal/oidc/oidctest"
+	"net/http"
+	"os"
+)
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	addr := getenv("MOCK_OIDC_ADDR", ":9090")
+	issuer := getenv("MOCK_OIDC_ISSUER", "http://localhost:9090")
+	p, err := oidctest.New(issuer, getenv("OIDC_CLIENT_ID", "forum"), getenv("OIDC_CLIENT_SECRET", "secret"))
+	if err != nil {
+		fmt.Println("mockoidc:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Mock OIDC provider", issuer, "client", p.ClientID)
+	if err := http.ListenAndServe(addr, p.Handler()); err != nil {
+		fmt.Println("mockoidc:", err)
+		os.Exit(1)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	_ "github.com/lib/pq"
 )
@@ -16,18 +17,8 @@ func InitDB() error {
 	if DB != nil {
 		return nil
 	}
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		host := getenv("DB_HOST", "localhost")
-		port := getenv("DB_PORT", "5432")
-		user := getenv("DB_USER", "postgres")
-		pass := getenv("DB_PASSWORD", "120311")
-		name := getenv("DB_NAME", "forumdb")
-		ssl := getenv("DB_SSLMODE", "disable")
-		dsn = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", host, port, user, pass, name, ssl)
-	}
 	var err error
-	DB, err = sql.Open("postgres", dsn)
+	DB, err = sql.Open("postgres", DSN())
 	if err != nil {
 		return fmt.Errorf("open db: %w", err)
 	}
@@ -44,6 +35,20 @@ func InitDB() error {
 
 func GetDB() *sql.DB { return DB }
 
+// DSN returns the connection string from DATABASE_URL or the DB_* variables
+func DSN() string {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		return dsn
+	}
+	host := getenv("DB_HOST", "localhost")
+	port := getenv("DB_PORT", "5432")
+	user := getenv("DB_USER", "postgres")
+	pass := getenv("DB_PASSWORD", "120311")
+	name := getenv("DB_NAME", "forumdb")
+	ssl := getenv("DB_SSLMODE", "disable")
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", host, port, user, pass, name, ssl)
+}
+
 func CloseDB() {
 	if DB != nil {
 		_ = DB.Close()
@@ -53,12 +58,21 @@ func CloseDB() {
 func runMigrations(db *sql.DB) error {
 	// Try likely migration paths depending on working directory
 	candidates := []string{
-		filepath.Join("migrations", "001_init.sql"),             // run from repo root
-		filepath.Join("..", "migrations", "001_init.sql"),       // run from cmd/forum
-		filepath.Join("..", "..", "migrations", "001_init.sql"), // run from deeper dirs
+		"migrations",                            // run from repo root
+		filepath.Join("..", "migrations"),       // run from cmd/forum
+		filepath.Join("..", "..", "migrations"), // run from deeper dirs
 	}
-	for _, path := range candidates {
-		if _, err := os.Stat(path); err == nil {
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, "001_init.sql")); err != nil {
+			continue
+		}
+		// migrations are applied in lexical order: 001_init.sql, 002_..., ...
+		files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+		if err != nil {
+			return fmt.Errorf("list migrations: %w", err)
+		}
+		sort.Strings(files)
+		for _, path := range files {
 			bytes, err := ioutil.ReadFile(path)
 			if err != nil {
 				return fmt.Errorf("read migration %s: %w", path, err)
@@ -66,8 +80,8 @@ func runMigrations(db *sql.DB) error {
 			if _, err := db.Exec(string(bytes)); err != nil {
 				return fmt.Errorf("apply migration %s: %w", path, err)
 			}
-			return nil
 		}
+		return nil
 	}
 	// fallback minimal ensures (valid syntax for PostgreSQL)
 	if _, err := db.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS image_data BYTEA`); err != nil {
@@ -1,15 +1,28 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"forum1/db"
+	"forum1/internal/entity"
 	handler "forum1/internal/handler"
 	"forum1/internal/handlers"
+	"forum1/internal/jobs"
+	"forum1/internal/linkpreview"
+	"forum1/internal/mail"
+	"forum1/internal/oidc"
+	"forum1/internal/pubsub"
+	"forum1/internal/ratelimit"
 	"forum1/internal/repository"
 
 	"forum1/internal/router"
 	"forum1/internal/service"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -26,27 +39,267 @@ func Run() {
 
 	database := db.GetDB() // получаем *sql.DB
 
+	// SIGINT/SIGTERM: перестаём принимать запросы, фоновые воркеры дописывают своё и выходят
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// слой repository
 	postRepo := repository.NewPostRepository(database)
 	boardRepo := repository.NewBoardRepository(database)
 	commentRepo := repository.NewCommentRepository(database)
 	clubRepo := repository.NewClubRepository(database)
+	linkPreviewRepo := repository.NewLinkPreviewRepository(database)
+	mentionRepo := repository.NewMentionRepository(database)
+	userRepo := repository.NewUserRepository(database)
+	notificationRepo := repository.NewNotificationRepository(database)
+	messageRepo := repository.NewMessageRepository(database)
+	blockRepo := repository.NewBlockRepository(database)
+	emailRepo := repository.NewEmailRepository(database)
+	sessionRepo := repository.NewSessionRepository(database)
+	tokenRepo := repository.NewTokenRepository(database)
+	throttleRepo := repository.NewThrottleRepository(database)
+	twoFactorRepo := repository.NewTwoFactorRepository(database)
+	apiTokenRepo := repository.NewAPITokenRepository(database)
+	identityRepo := repository.NewIdentityRepository(database)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(database)
+	spamRepo := repository.NewSpamRepository(database)
+	moderationRepo := repository.NewModerationRepository(database)
+	automodRepo := repository.NewAutomodRepository(database)
+	viewRepo := repository.NewViewRepository(database)
+	rankingRepo := repository.NewRankingRepository(database)
+	pollRepo := repository.NewPollRepository(database)
+	subscriptionRepo := repository.NewSubscriptionRepository(database)
+	bookmarkRepo := repository.NewBookmarkRepository(database)
+	readRepo := repository.NewReadRepository(database)
+	karmaRepo := repository.NewKarmaRepository(database)
+
+	// pub/sub для live-обновлений: memory для одного инстанса, postgres (LISTEN/NOTIFY) для нескольких
+	var backend pubsub.Backend = pubsub.NewMemoryBackend()
+	if os.Getenv("PUBSUB_BACKEND") == "postgres" {
+		backend = pubsub.NewPostgresBackend(database, db.DSN(), os.Getenv("PUBSUB_CHANNEL"))
+	}
+	hub := pubsub.NewHub(backend)
+	if err := hub.Start(ctx); err != nil {
+		fmt.Println("Ошибка запуска pub/sub:", err)
+		return
+	}
+
+	// RATELIMIT_BACKEND=postgres — общие лимиты для нескольких инстансов; RATE_LIMITS=post=10/1m,… переопределяет политики
+	var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if os.Getenv("RATELIMIT_BACKEND") == "postgres" {
+		limitStore = ratelimit.NewPostgresStore(database)
+	}
+	limitPolicies := ratelimit.DefaultPolicies()
+	if err := ratelimit.ParsePolicies(os.Getenv("RATE_LIMITS"), limitPolicies); err != nil {
+		fmt.Println("RATE_LIMITS:", err)
+		return
+	}
+	limiter := ratelimit.New(limitStore, limitPolicies)
+	go limiter.Run(ctx)
+	fmt.Println("rate limits:", limiter.Policies())
+
+	// фоновые задачи в таблице jobs; JOB_QUEUES=default=4,notifications=2 задаёт воркеров на очередь
+	jobConfig := jobs.DefaultConfig()
+	if err := jobs.ParseQueues(os.Getenv("JOB_QUEUES"), jobConfig.Queues); err != nil {
+		fmt.Println("JOB_QUEUES:", err)
+		return
+	}
+	jobRunner := jobs.New(database, jobConfig)
+
+	// почта: MAIL_TRANSPORT=smtp|file|memory, письма уходят из outbox фоновым воркером
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		fmt.Println("Ошибка настройки почты:", err)
+		return
+	}
+	emailConfig := service.DefaultEmailConfig()
+	if v := os.Getenv("SITE_URL"); v != "" {
+		emailConfig.SiteURL = v
+	}
+	emailConfig.Secret = []byte(os.Getenv("MAIL_SECRET"))
+	if len(emailConfig.Secret) == 0 {
+		// с пустым ключом ссылку отписки за любого пользователя соберёт кто угодно
+		fmt.Println("MAIL_SECRET не задан: без него ссылки отписки можно подделать, сервер не запущен")
+		return
+	}
+	// AUTH_SECRET подписывает ссылки подтверждения и сброса пароля и шифрует секреты TOTP и коды восстановления
+	authSecret := []byte(os.Getenv("AUTH_SECRET"))
+	if len(authSecret) == 0 {
+		fmt.Println("AUTH_SECRET не задан: с пустым ключом ссылки сброса пароля и секреты 2FA можно подделать, сервер не запущен")
+		return
+	}
 
 	// слой service
-	postService := service.NewPostService(postRepo)
+	emailService := service.NewEmailService(emailRepo, mailer, mail.NewRenderer(""), emailConfig)
+	go emailService.Run(ctx)
+	sessionService := service.NewSessionService(sessionRepo)
+	accountService := service.NewAccountService(userRepo, tokenRepo, throttleRepo, sessionService, emailService, authSecret)
+	twoFactorConfig := service.DefaultTwoFactorConfig()
+	twoFactorConfig.Secret = authSecret
+	if v, ok := os.LookupEnv("TWO_FACTOR_REQUIRED_ROLE"); ok {
+		// "" или "none" — 2FA необязательна ни для кого
+		if v == "none" {
+			v = ""
+		}
+		if v != "" && !entity.ValidRole(v) {
+			fmt.Println("TWO_FACTOR_REQUIRED_ROLE: неизвестная роль", v)
+			return
+		}
+		twoFactorConfig.RequiredRole = v
+	}
+	apiTokenService := service.NewAPITokenService(apiTokenRepo)
+	// вход через OpenID Connect включается переменной OIDC_ISSUER
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = strings.TrimRight(emailConfig.SiteURL, "/") + "/auth/oidc/callback"
+		}
+		oidcProvider = oidc.New(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+			Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		}, nil)
+	}
+	// CAPTCHA для подозрительных IP: любой сервис с API siteverify (hCaptcha, reCAPTCHA, Turnstile)
+	loginGuardOpts := []service.LoginGuardOption{service.WithLockoutNotices(userRepo, emailService)}
+	captchaWidget := handler.CaptchaWidget{
+		ScriptURL: os.Getenv("CAPTCHA_SCRIPT_URL"),
+		Class:     os.Getenv("CAPTCHA_CLASS"),
+		SiteKey:   os.Getenv("CAPTCHA_SITE_KEY"),
+	}
+	if secret := os.Getenv("CAPTCHA_SECRET"); secret != "" && captchaWidget.SiteKey != "" {
+		verifyURL := os.Getenv("CAPTCHA_VERIFY_URL")
+		if verifyURL == "" {
+			verifyURL = "https://api.hcaptcha.com/siteverify"
+		}
+		if captchaWidget.ScriptURL == "" {
+			captchaWidget.ScriptURL, captchaWidget.Class = "https://js.hcaptcha.com/1/api.js", "h-captcha"
+		}
+		loginGuardOpts = append(loginGuardOpts, service.WithCaptcha(service.NewSiteverifyCaptcha(verifyURL, secret)))
+	}
+	loginGuard := service.NewLoginGuard(loginAttemptRepo, service.DefaultLoginGuardConfig(), loginGuardOpts...)
+	oidcService := service.NewOIDCService(oidcProvider, os.Getenv("OIDC_NAME"), userRepo, identityRepo, authSecret)
+	twoFactorService := service.NewTwoFactorService(twoFactorRepo, userRepo, tokenRepo, throttleRepo, twoFactorConfig)
+	realtime := service.NewRealtimePublisher(hub, postRepo, commentRepo, notificationRepo, messageRepo)
+	notificationService := service.NewNotificationService(notificationRepo, postRepo, commentRepo, clubRepo,
+		service.WithNotificationMutes(subscriptionRepo))
+	// подписки на доски, клубы, треды и авторов; рассылка подписчикам идёт через очередь задач
+	subscriptionService := service.NewSubscriptionService(subscriptionRepo, postRepo, commentRepo, boardRepo, clubRepo,
+		userRepo, notificationService, service.WithSubscriptionJobs(jobRunner))
+	bookmarkService := service.NewBookmarkService(bookmarkRepo, postRepo, commentRepo)
+	mentionService := service.NewMentionService(mentionRepo, userRepo)
+	mentionService.AddListener(notificationService)
+	notificationService.AddListener(realtime)
+	// антиспам: подозрительные посты и комментарии ждут модератора в /moderation
+	// автомодератор: правила форума, клубов и досок из /automod, отклонение и очередь — через тот же фильтр
+	automodService := service.NewAutomodService(automodRepo, postRepo, boardRepo, clubRepo, userRepo, notificationService)
+	spamConfig := service.DefaultSpamConfig()
+	spamFilter := service.NewSpamFilter(spamRepo, moderationRepo, postRepo, userRepo, spamConfig,
+		append(service.DefaultSpamChecks(spamRepo, spamConfig), automodService)...)
+	// очки hot/top/rising пересчитываются в фоне, ленты только сортируют по ним
+	rankingService := service.NewRankingService(rankingRepo, service.DefaultRankingConfig())
+	go rankingService.Run(ctx)
+	pollService := service.NewPollService(pollRepo, postRepo)
+	// карма: KARMA_THRESHOLDS=post_links=5,downvote=10,create_board=50 — сколько нужно для ссылок, минусов и досок
+	karmaConfig := service.DefaultKarmaConfig()
+	if err := service.ParseKarmaThresholds(os.Getenv("KARMA_THRESHOLDS"), karmaConfig.Thresholds); err != nil {
+		fmt.Println("KARMA_THRESHOLDS:", err)
+		return
+	}
+	karmaService := service.NewKarmaService(karmaRepo, userRepo, karmaConfig)
+	jobs.Handle(jobRunner, "karma.recount", jobs.Options{Queue: "maintenance"}, func(ctx context.Context, _ struct{}) error {
+		_, err := karmaService.Recount(ctx)
+		return err
+	})
+	if err := jobRunner.Cron("@daily", "karma.recount", nil); err != nil {
+		fmt.Println("jobs:", err)
+		return
+	}
+	postService := service.NewPostService(postRepo,
+		service.WithPostJobs(jobRunner),
+		service.WithPostKarma(karmaService),
+		service.WithPostPolls(pollService),
+		service.WithPostSpamFilter(spamFilter),
+		service.WithPostListener(rankingService),
+		service.WithPostListener(subscriptionService),
+		service.WithPostListener(automodService),
+		service.WithPostMentions(mentionService),
+		service.WithPostVoteListener(notificationService),
+		service.WithPostVoteListener(realtime),
+		service.WithPostListener(realtime))
 	boardService := service.NewBoardService(boardRepo)
-	commentService := service.NewCommentService(commentRepo)
+	commentService := service.NewCommentService(commentRepo,
+		service.WithCommentKarma(karmaService),
+		service.WithCommentSpamFilter(spamFilter),
+		service.WithCommentListener(automodService),
+		service.WithCommentMentions(mentionService),
+		service.WithCommentListener(notificationService),
+		service.WithCommentListener(subscriptionService),
+		service.WithCommentVoteListener(notificationService),
+		service.WithCommentListener(realtime),
+		service.WithCommentVoteListener(realtime))
+	moderationService := service.NewModerationService(moderationRepo, spamRepo, spamFilter, postService, commentService,
+		service.WithModerationListener(notificationService))
 	clubService := service.NewClubService(clubRepo)
+	linkPreviewService := service.NewLinkPreviewService(linkPreviewRepo,
+		linkpreview.NewFetcher(linkpreview.DefaultConfig()), service.DefaultLinkPreviewConfig())
+	go linkPreviewService.Run(ctx)
+	// просмотры постов пишутся пачками в фоне
+	viewConfig := service.DefaultViewConfig()
+	viewConfig.Secret = authSecret
+	viewService := service.NewViewService(viewRepo, postRepo, viewConfig)
+	go viewService.Run(ctx)
+	// прочитанное в тредах — тоже пачками, до записи позиции держатся в памяти
+	readService := service.NewReadService(readRepo, service.DefaultReadConfig())
+	go readService.Run(ctx)
+	// отложенные посты публикует планировщик; уведомления — задача post.published, поставленная в той же транзакции
+	postScheduler := service.NewPostScheduler(postService, 30*time.Second)
+	go postScheduler.Run(ctx)
+	// закреплённые, закрытые и архивные треды; неактивные уходят в архив по cron
+	threadService := service.NewThreadService(postRepo, service.DefaultThreadConfig(), service.WithThreadListener(notificationService))
+	jobs.Handle(jobRunner, "threads.archive", jobs.Options{Queue: "maintenance"}, func(ctx context.Context, _ struct{}) error {
+		_, err := threadService.ArchiveInactive(ctx)
+		return err
+	})
+	if err := jobRunner.Cron("@hourly", "threads.archive", nil); err != nil {
+		fmt.Println("jobs:", err)
+		return
+	}
+	messageService := service.NewMessageService(messageRepo, blockRepo)
+	messageService.AddListener(realtime)
+	userService := service.NewUserService(userRepo,
+		service.WithUserAccounts(accountService),
+		service.WithUserAdmins(strings.Split(os.Getenv("ADMIN_USERNAMES"), ",")),
+	)
 
 	// слой handler
-	userRepo := repository.NewUserRepository(database)
-	postHandler := handler.NewPostHandler(postService, userRepo)
+	postHandler := handler.NewPostHandler(postService, userRepo).WithPreviews(linkPreviewService)
 	commentHandler := handler.NewCommentHandler(commentService, userRepo).WithPosts(postService)
-	pageHandler := handler.NewPageHandler(postService, boardService).WithComments(commentService).WithClubs(clubService)
-	userHandler := handler.NewUserHandler(service.NewUserService(repository.NewUserRepository(database)))
-	clubPageHandler := handler.NewClubPageHandler(clubService)
-	clubAPIHandler := handler.NewClubHandler(clubService)
+	pageHandler := handler.NewPageHandler(postService, boardService).WithComments(commentService).WithClubs(clubService).WithPreviews(linkPreviewService).WithMentions(mentionService).WithViews(viewService).WithPolls(pollService).WithSubscriptions(subscriptionService).WithBookmarks(bookmarkService).WithReads(readService).WithKarma(karmaService).WithUsers(userService)
+	userHandler := handler.NewUserHandler(userService).WithSessions(sessionService).WithTwoFactor(twoFactorService).WithOIDC(oidcService).WithLoginGuard(loginGuard, captchaWidget)
+	loginAuditHandler := handler.NewLoginAuditHandler(loginGuard)
+	moderationHandler := handler.NewModerationHandler(moderationService).WithBoards(boardService)
+	automodHandler := handler.NewAutomodHandler(automodService)
+	threadHandler := handler.NewThreadHandler(threadService)
+	jobHandler := handler.NewJobHandler(service.NewJobAdmin(jobRunner))
+	subscriptionHandler := handler.NewSubscriptionHandler(subscriptionService)
+	karmaHandler := handler.NewKarmaHandler(karmaService)
+	bookmarkHandler := handler.NewBookmarkHandler(bookmarkService).WithSiteURL(emailConfig.SiteURL)
+	pollHandler := handler.NewPollHandler(pollService)
+	draftHandler := handler.NewDraftHandler(postService, boardService)
+	twoFactorHandler := handler.NewTwoFactorHandler(twoFactorService).WithIdentities(oidcService)
+	apiTokenHandler := handler.NewAPITokenHandler(apiTokenService)
+	accountHandler := handler.NewAccountHandler(accountService)
+	clubPageHandler := handler.NewClubPageHandler(clubService).WithSubscriptions(subscriptionService)
+	clubAPIHandler := handler.NewClubHandler(clubService).WithNotifications(notificationService, userRepo)
+	notificationHandler := handler.NewNotificationHandler(notificationService)
 	boardAPIHandler := handlers.NewBoardAPIHandler(boardService)
+	streamHandler := handler.NewStreamHandler(hub).WithPosts(postService)
+	messageHandler := handler.NewMessageHandler(messageService, userRepo)
+	emailHandler := handler.NewEmailHandler(emailService, os.Getenv("MAIL_WEBHOOK_SECRET"))
 
 	// слой router
 	r := router.NewRouter(postHandler)
@@ -56,12 +309,13 @@ func Run() {
 	r.HandleFunc("/board/education", pageHandler.EducationPageHTML).Methods(http.MethodGet)
 	r.HandleFunc("/board/title", pageHandler.TitlePageHTML).Methods(http.MethodGet)
 	r.HandleFunc("/board/{slug}", pageHandler.BoardPage).Methods(http.MethodGet)
+	r.HandleFunc("/board/{slug}/read", pageHandler.MarkBoardRead).Methods(http.MethodPost)
 	r.HandleFunc("/post/{id}", pageHandler.PostPage).Methods(http.MethodGet)
 	// Clubs pages
 	r.HandleFunc("/clubs", clubPageHandler.ListPage).Methods(http.MethodGet)
 	r.HandleFunc("/clubs/new", clubPageHandler.NewPage).Methods(http.MethodGet)
 	r.HandleFunc("/clubs/{id:[0-9]+}", clubPageHandler.DetailPage).Methods(http.MethodGet)
-	r.HandleFunc("/clubs", clubPageHandler.CreatePage).Methods(http.MethodPost)
+	r.HandleFunc("/clubs", handler.Limited("club", clubPageHandler.CreatePage)).Methods(http.MethodPost)
 
 	// post image
 	r.HandleFunc("/post/{id}/image", pageHandler.PostImage).Methods(http.MethodGet)
@@ -69,36 +323,84 @@ func Run() {
 	r.HandleFunc("/comment/{id}/image", pageHandler.CommentImage).Methods(http.MethodGet)
 	// club image
 	r.HandleFunc("/club/{id}/image", pageHandler.ClubImage).Methods(http.MethodGet)
-	// like/dislike GET endpoints
-	r.HandleFunc("/post/{id}/like", pageHandler.LikePost).Methods(http.MethodGet)
-	r.HandleFunc("/post/{id}/dislike", pageHandler.DislikePost).Methods(http.MethodGet)
-	r.HandleFunc("/comment/{id}/like", pageHandler.LikeComment).Methods(http.MethodGet)
-	r.HandleFunc("/comment/{id}/dislike", pageHandler.DislikeComment).Methods(http.MethodGet)
+	// like/dislike: только POST с CSRF-токеном, GET-ссылку можно было подсунуть в <img>
+	r.HandleFunc("/post/{id}/like", handler.Limited("vote", pageHandler.LikePost)).Methods(http.MethodPost)
+	r.HandleFunc("/post/{id}/dislike", handler.Limited("vote", pageHandler.DislikePost)).Methods(http.MethodPost)
+	r.HandleFunc("/comment/{id}/like", handler.Limited("vote", pageHandler.LikeComment)).Methods(http.MethodPost)
+	r.HandleFunc("/comment/{id}/dislike", handler.Limited("vote", pageHandler.DislikeComment)).Methods(http.MethodPost)
 	r.HandleFunc("/profile/{id}", pageHandler.ProfilePageHTML).Methods(http.MethodGet)
-	r.HandleFunc("/login", pageHandler.LoginPageHTML).Methods(http.MethodGet)
+	r.HandleFunc("/login", userHandler.LoginPage).Methods(http.MethodGet)
 	r.HandleFunc("/login", userHandler.Login).Methods(http.MethodPost)
+	r.HandleFunc("/login/2fa", userHandler.LoginTwoFactor).Methods(http.MethodPost)
+	r.HandleFunc("/auth/oidc/login", userHandler.OIDCLogin).Methods(http.MethodGet)
+	r.HandleFunc("/auth/oidc/link", userHandler.OIDCLink).Methods(http.MethodPost)
+	r.HandleFunc("/auth/oidc/callback", userHandler.OIDCCallback).Methods(http.MethodGet)
+	r.HandleFunc("/logout", userHandler.Logout).Methods(http.MethodPost)
 	r.HandleFunc("/register", pageHandler.RegisterPageHTML).Methods(http.MethodGet)
+	r.HandleFunc("/verify-email", accountHandler.VerifyEmailPage).Methods(http.MethodGet)
+	r.HandleFunc("/forgot-password", accountHandler.ForgotPasswordPage).Methods(http.MethodGet)
+	r.HandleFunc("/forgot-password", accountHandler.ForgotPassword).Methods(http.MethodPost)
+	r.HandleFunc("/reset-password", accountHandler.ResetPasswordPage).Methods(http.MethodGet)
+	r.HandleFunc("/reset-password", accountHandler.ResetPassword).Methods(http.MethodPost)
 	r.HandleFunc("/create-post", pageHandler.CreatePostPageHTML).Methods(http.MethodGet)
+	r.HandleFunc("/drafts", draftHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/drafts/{id:[0-9]+}/delete", draftHandler.Delete).Methods(http.MethodPost)
+	r.HandleFunc("/following", pageHandler.FollowingPage).Methods(http.MethodGet)
+	r.HandleFunc("/subscriptions", subscriptionHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/subscriptions", subscriptionHandler.Form).Methods(http.MethodPost)
+	r.HandleFunc("/saved", bookmarkHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/saved", bookmarkHandler.Form).Methods(http.MethodPost)
+	r.HandleFunc("/saved/folders", bookmarkHandler.FolderForm).Methods(http.MethodPost)
+	r.HandleFunc("/saved/export", bookmarkHandler.Export).Methods(http.MethodGet)
 	r.HandleFunc("/boards/search", pageHandler.BoardsSearchPageHTML).Methods(http.MethodGet)
-	r.HandleFunc("/search", pageHandler.SearchPageHTML).Methods(http.MethodGet)
-	r.HandleFunc("/settings", pageHandler.SettingsPageHTML).Methods(http.MethodGet)
-	r.HandleFunc("/messages", pageHandler.MessagesPageHTML).Methods(http.MethodGet)
-	r.HandleFunc("/notifications", pageHandler.NotificationsPageHTML).Methods(http.MethodGet)
+	r.HandleFunc("/search", handler.Limited("search", pageHandler.SearchPageHTML)).Methods(http.MethodGet)
+	r.HandleFunc("/settings", apiTokenHandler.SettingsPage).Methods(http.MethodGet)
+	r.HandleFunc("/settings/tokens", apiTokenHandler.CreateForm).Methods(http.MethodPost)
+	r.HandleFunc("/settings/tokens/{id:[0-9]+}/revoke", apiTokenHandler.RevokeForm).Methods(http.MethodPost)
+	r.HandleFunc("/settings/security", twoFactorHandler.SecurityPage).Methods(http.MethodGet)
+	r.HandleFunc("/settings/identities/{id:[0-9]+}/unlink", userHandler.UnlinkIdentity).Methods(http.MethodPost)
+	r.HandleFunc("/admin/logins", loginAuditHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/admin/logins/unlock", loginAuditHandler.Unlock).Methods(http.MethodPost)
+	r.HandleFunc("/admin/jobs", jobHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/admin/jobs/{id:[0-9]+}/{action:retry|delete}", jobHandler.Apply).Methods(http.MethodPost)
+	r.HandleFunc("/moderation", moderationHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/moderation/queue/{id:[0-9]+}/{decision:approved|spam}", moderationHandler.Decide).Methods(http.MethodPost)
+	r.HandleFunc("/moderation/rules", moderationHandler.AddRule).Methods(http.MethodPost)
+	r.HandleFunc("/moderation/rules/{id:[0-9]+}/delete", moderationHandler.DeleteRule).Methods(http.MethodPost)
+	r.HandleFunc("/post/{id:[0-9]+}/poll/vote", handler.Limited("vote", pollHandler.Vote)).Methods(http.MethodPost)
+	r.HandleFunc("/post/{id:[0-9]+}/{action:pin|unpin|lock|unlock|archive|unarchive}", threadHandler.Apply).Methods(http.MethodPost)
+	r.HandleFunc("/automod", automodHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/automod/rules", automodHandler.Create).Methods(http.MethodPost)
+	r.HandleFunc("/automod/rules/{id:[0-9]+}/{state:enable|disable}", automodHandler.SetEnabled).Methods(http.MethodPost)
+	r.HandleFunc("/automod/rules/{id:[0-9]+}/delete", automodHandler.Delete).Methods(http.MethodPost)
+	r.HandleFunc("/automod/rules/{id:[0-9]+}/dry-run", automodHandler.DryRun).Methods(http.MethodPost)
+	r.HandleFunc("/automod/dry-run", automodHandler.DryRun).Methods(http.MethodPost)
+	r.HandleFunc("/messages", messageHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/messages/image/{id:[0-9]+}", messageHandler.Image).Methods(http.MethodGet)
+	r.HandleFunc("/notifications", notificationHandler.Page).Methods(http.MethodGet)
+	r.HandleFunc("/email/unsubscribe", emailHandler.UnsubscribePage).Methods(http.MethodGet)
+	r.HandleFunc("/email/unsubscribe", emailHandler.Unsubscribe).Methods(http.MethodPost)
 
-	// CORS (dev permissive)
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			if req.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			next.ServeHTTP(w, req)
-		})
-	})
+	// CSRF: формы и скрипты возвращают токен из cookie, JSON-запросы с чужим Origin отклоняются.
+	// CORS_ALLOWED_ORIGINS — через запятую сайты, которым можно ходить в API с cookie пользователя.
+	var corsOrigins []string
+	for _, o := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			corsOrigins = append(corsOrigins, o)
+		}
+	}
+	r.Use(handler.CSRF(handler.CSRFConfig{
+		AllowedOrigins: corsOrigins,
+		// вебхук почтового провайдера и one-click отписка (RFC 8058) приходят без cookie и подписаны сами
+		Exempt: []string{"/api/email/bounce", "/email/unsubscribe"},
+	}))
+
+	// Сессии и личные API-токены: пользователь из cookie "session" или Authorization: Bearer кладётся в контекст запроса
+	r.Use(handler.AuthMiddleware(sessionService, apiTokenService))
+	// ограничение частоты: маршруты с handler.Limited, бакеты по пользователю, токену или IP
+	r.Use(handler.RateLimit(limiter))
+	// 2FA обязательна для ролей от TWO_FACTOR_REQUIRED_ROLE (по умолчанию moderator)
+	r.Use(handler.TwoFactorPolicy(twoFactorService))
 
 	// Logging middleware
 	r.Use(func(next http.Handler) http.Handler {
@@ -117,22 +419,122 @@ func Run() {
 
 	// API auth endpoints
 	api := r.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/register", userHandler.RegisterPage).Methods(http.MethodPost)
-	api.HandleFunc("/comment", commentHandler.CreateComment).Methods(http.MethodPost)
-	api.HandleFunc("/delete_comment", commentHandler.DeleteComment).Methods(http.MethodPost)
+	api.HandleFunc("/register", handler.Limited("register", userHandler.RegisterPage)).Methods(http.MethodPost)
+	api.HandleFunc("/login/2fa", userHandler.LoginTwoFactor).Methods(http.MethodPost)
+	api.HandleFunc("/2fa", twoFactorHandler.Status).Methods(http.MethodGet)
+	api.HandleFunc("/2fa/enroll", twoFactorHandler.Enroll).Methods(http.MethodPost)
+	api.HandleFunc("/2fa/confirm", twoFactorHandler.Confirm).Methods(http.MethodPost)
+	api.HandleFunc("/2fa/disable", twoFactorHandler.Disable).Methods(http.MethodPost)
+	api.HandleFunc("/2fa/recovery-codes", twoFactorHandler.RecoveryCodes).Methods(http.MethodPost)
+	api.HandleFunc("/2fa/devices/forget", twoFactorHandler.ForgetDevices).Methods(http.MethodPost)
+	api.HandleFunc("/admin/users/{id:[0-9]+}/role", handler.Scoped(entity.ScopeModerate, userHandler.SetRole)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/logins", handler.Scoped(entity.ScopeModerate, loginAuditHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/admin/logins/unlock", handler.Scoped(entity.ScopeModerate, loginAuditHandler.Unlock)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/jobs", handler.Scoped(entity.ScopeModerate, jobHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/admin/jobs/{id:[0-9]+}/{action:retry|delete}", handler.Scoped(entity.ScopeModerate, jobHandler.Apply)).Methods(http.MethodPost)
+	api.HandleFunc("/moderation/queue", handler.Scoped(entity.ScopeModerate, moderationHandler.Queue)).Methods(http.MethodGet)
+	api.HandleFunc("/moderation/queue/{id:[0-9]+}/{decision:approved|spam}", handler.Scoped(entity.ScopeModerate, moderationHandler.Decide)).Methods(http.MethodPost)
+	api.HandleFunc("/moderation/rules", handler.Scoped(entity.ScopeModerate, moderationHandler.Rules)).Methods(http.MethodGet)
+	api.HandleFunc("/moderation/rules", handler.Scoped(entity.ScopeModerate, moderationHandler.AddRule)).Methods(http.MethodPost)
+	api.HandleFunc("/moderation/rules/{id:[0-9]+}/delete", handler.Scoped(entity.ScopeModerate, moderationHandler.DeleteRule)).Methods(http.MethodPost)
+	api.HandleFunc("/automod/rules", handler.Scoped(entity.ScopeModerate, automodHandler.Rules)).Methods(http.MethodGet)
+	api.HandleFunc("/automod/rules", handler.Scoped(entity.ScopeModerate, automodHandler.Create)).Methods(http.MethodPost)
+	api.HandleFunc("/automod/rules/{id:[0-9]+}/{state:enable|disable}", handler.Scoped(entity.ScopeModerate, automodHandler.SetEnabled)).Methods(http.MethodPost)
+	api.HandleFunc("/automod/rules/{id:[0-9]+}/delete", handler.Scoped(entity.ScopeModerate, automodHandler.Delete)).Methods(http.MethodPost)
+	api.HandleFunc("/automod/rules/{id:[0-9]+}/dry-run", handler.Scoped(entity.ScopeModerate, automodHandler.DryRun)).Methods(http.MethodPost)
+	api.HandleFunc("/automod/dry-run", handler.Scoped(entity.ScopeModerate, automodHandler.DryRun)).Methods(http.MethodPost)
+	api.HandleFunc("/verify-email", accountHandler.VerifyEmail).Methods(http.MethodPost)
+	api.HandleFunc("/verify-email/resend", accountHandler.ResendVerification).Methods(http.MethodPost)
+	api.HandleFunc("/forgot-password", accountHandler.ForgotPassword).Methods(http.MethodPost)
+	api.HandleFunc("/reset-password", accountHandler.ResetPassword).Methods(http.MethodPost)
+	api.HandleFunc("/users/{id:[0-9]+}/karma", handler.Scoped(entity.ScopeRead, karmaHandler.Get)).Methods(http.MethodGet)
+	api.HandleFunc("/users/autocomplete", handler.Scoped(entity.ScopeRead, handler.Limited("search", userHandler.Autocomplete))).Methods(http.MethodGet)
+	api.HandleFunc("/comment", handler.Scoped(entity.ScopeComment, handler.Limited("comment", commentHandler.CreateComment))).Methods(http.MethodPost)
+	api.HandleFunc("/delete_comment", handler.Scoped(entity.ScopeComment, commentHandler.DeleteComment)).Methods(http.MethodPost)
 	// Clubs API
-	api.HandleFunc("/clubs", clubAPIHandler.List).Methods(http.MethodGet)
-	api.HandleFunc("/clubs", clubAPIHandler.Create).Methods(http.MethodPost)
-	api.HandleFunc("/clubs/{id}", clubAPIHandler.GetByID).Methods(http.MethodGet)
+	api.HandleFunc("/clubs", handler.Scoped(entity.ScopeRead, clubAPIHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/clubs", handler.Scoped(entity.ScopePost, handler.Limited("club", clubAPIHandler.Create))).Methods(http.MethodPost)
+	api.HandleFunc("/clubs/{id}", handler.Scoped(entity.ScopeRead, clubAPIHandler.GetByID)).Methods(http.MethodGet)
+	api.HandleFunc("/clubs/{id}/invite", handler.Scoped(entity.ScopePost, handler.Limited("invite", clubAPIHandler.Invite))).Methods(http.MethodPost)
 	// Boards API
-	api.HandleFunc("/boards", boardAPIHandler.GetAllBoards).Methods(http.MethodGet)
-	api.HandleFunc("/boards", boardAPIHandler.CreateBoard).Methods(http.MethodPost)
-	api.HandleFunc("/clubs/{id}/boards", boardAPIHandler.GetClubBoards).Methods(http.MethodGet)
+	api.HandleFunc("/boards", handler.Scoped(entity.ScopeRead, boardAPIHandler.GetAllBoards)).Methods(http.MethodGet)
+	api.HandleFunc("/boards", handler.Scoped(entity.ScopePost, handler.Limited("board", karmaHandler.Require(entity.PrivilegeCreateBoard, boardAPIHandler.CreateBoard)))).Methods(http.MethodPost)
+	api.HandleFunc("/boards/{slug}/read", handler.Scoped(entity.ScopeRead, pageHandler.MarkBoardRead)).Methods(http.MethodPost)
+	api.HandleFunc("/clubs/{id}/boards", handler.Scoped(entity.ScopeRead, boardAPIHandler.GetClubBoards)).Methods(http.MethodGet)
+	// Votes API
+	api.HandleFunc("/post/{id:[0-9]+}/{action:pin|unpin|lock|unlock|archive|unarchive}", handler.Scoped(entity.ScopeModerate, threadHandler.Apply)).Methods(http.MethodPost)
+	api.HandleFunc("/drafts", handler.Scoped(entity.ScopeRead, draftHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/drafts", handler.Scoped(entity.ScopePost, draftHandler.Save)).Methods(http.MethodPost)
+	api.HandleFunc("/drafts/{id:[0-9]+}/delete", handler.Scoped(entity.ScopePost, draftHandler.Delete)).Methods(http.MethodPost)
+	api.HandleFunc("/subscriptions", handler.Scoped(entity.ScopeRead, subscriptionHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/subscriptions", handler.Scoped(entity.ScopeAccount, subscriptionHandler.Create)).Methods(http.MethodPost)
+	api.HandleFunc("/subscriptions", handler.Scoped(entity.ScopeAccount, subscriptionHandler.Delete)).Methods(http.MethodDelete)
+	api.HandleFunc("/bookmarks", handler.Scoped(entity.ScopeRead, bookmarkHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/bookmarks", handler.Scoped(entity.ScopeAccount, bookmarkHandler.Create)).Methods(http.MethodPost)
+	api.HandleFunc("/bookmarks/{id:[0-9]+}", handler.Scoped(entity.ScopeAccount, bookmarkHandler.Delete)).Methods(http.MethodDelete)
+	api.HandleFunc("/bookmarks/export", handler.Scoped(entity.ScopeRead, bookmarkHandler.Export)).Methods(http.MethodGet)
+	api.HandleFunc("/bookmarks/folders", handler.Scoped(entity.ScopeRead, bookmarkHandler.Folders)).Methods(http.MethodGet)
+	api.HandleFunc("/bookmarks/folders", handler.Scoped(entity.ScopeAccount, bookmarkHandler.SaveFolder)).Methods(http.MethodPost)
+	api.HandleFunc("/bookmarks/folders/{id:[0-9]+}", handler.Scoped(entity.ScopeAccount, bookmarkHandler.SaveFolder)).Methods(http.MethodPost)
+	api.HandleFunc("/bookmarks/folders/{id:[0-9]+}", handler.Scoped(entity.ScopeAccount, bookmarkHandler.DeleteFolder)).Methods(http.MethodDelete)
+	api.HandleFunc("/post/{id:[0-9]+}/poll", handler.Scoped(entity.ScopeRead, pollHandler.Get)).Methods(http.MethodGet)
+	api.HandleFunc("/post/{id:[0-9]+}/poll/vote", handler.Scoped(entity.ScopeVote, handler.Limited("vote", pollHandler.Vote))).Methods(http.MethodPost)
+	api.HandleFunc("/post/{id:[0-9]+}/views", handler.Scoped(entity.ScopeRead, pageHandler.PostViews)).Methods(http.MethodGet)
+	api.HandleFunc("/post/{id:[0-9]+}/like", handler.Scoped(entity.ScopeVote, handler.Limited("vote", pageHandler.LikePost))).Methods(http.MethodPost)
+	api.HandleFunc("/post/{id:[0-9]+}/dislike", handler.Scoped(entity.ScopeVote, handler.Limited("vote", pageHandler.DislikePost))).Methods(http.MethodPost)
+	api.HandleFunc("/comment/{id:[0-9]+}/like", handler.Scoped(entity.ScopeVote, handler.Limited("vote", pageHandler.LikeComment))).Methods(http.MethodPost)
+	api.HandleFunc("/comment/{id:[0-9]+}/dislike", handler.Scoped(entity.ScopeVote, handler.Limited("vote", pageHandler.DislikeComment))).Methods(http.MethodPost)
+	// Personal API tokens (browser session only)
+	api.HandleFunc("/tokens", apiTokenHandler.List).Methods(http.MethodGet)
+	api.HandleFunc("/tokens", apiTokenHandler.Create).Methods(http.MethodPost)
+	api.HandleFunc("/tokens/{id:[0-9]+}/revoke", apiTokenHandler.Revoke).Methods(http.MethodPost)
 	// Auth API
-	api.HandleFunc("/auth/check", boardAPIHandler.CheckAuth).Methods(http.MethodGet)
+	api.HandleFunc("/auth/check", handler.Scoped(entity.ScopeRead, boardAPIHandler.CheckAuth)).Methods(http.MethodGet)
 	// Search API
-	api.HandleFunc("/search", boardAPIHandler.SearchAll).Methods(http.MethodGet)
+	api.HandleFunc("/search", handler.Scoped(entity.ScopeRead, handler.Limited("search", boardAPIHandler.SearchAll))).Methods(http.MethodGet)
+	// Notifications API
+	api.HandleFunc("/notifications", handler.Scoped(entity.ScopeRead, notificationHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/notifications/unread", handler.Scoped(entity.ScopeRead, notificationHandler.Unread)).Methods(http.MethodGet)
+	api.HandleFunc("/notifications/read-all", handler.Scoped(entity.ScopeRead, notificationHandler.MarkAllRead)).Methods(http.MethodPost)
+	api.HandleFunc("/notifications/preferences", handler.Scoped(entity.ScopeRead, notificationHandler.GetPreferences)).Methods(http.MethodGet)
+	api.HandleFunc("/notifications/preferences", handler.Scoped(entity.ScopeAccount, notificationHandler.SetPreferences)).Methods(http.MethodPost)
+	api.HandleFunc("/notifications/{id:[0-9]+}/read", handler.Scoped(entity.ScopeRead, notificationHandler.MarkRead)).Methods(http.MethodPost)
+	// Private messages API
+	api.HandleFunc("/messages", handler.Scoped(entity.ScopeRead, messageHandler.List)).Methods(http.MethodGet)
+	api.HandleFunc("/messages", handler.Scoped(entity.ScopeMessage, handler.Limited("conversation", messageHandler.Start))).Methods(http.MethodPost)
+	api.HandleFunc("/messages/unread", handler.Scoped(entity.ScopeRead, messageHandler.Unread)).Methods(http.MethodGet)
+	api.HandleFunc("/messages/blocks", handler.Scoped(entity.ScopeRead, messageHandler.Blocks)).Methods(http.MethodGet)
+	api.HandleFunc("/messages/{id:[0-9]+}", handler.Scoped(entity.ScopeRead, messageHandler.Get)).Methods(http.MethodGet)
+	api.HandleFunc("/messages/{id:[0-9]+}", handler.Scoped(entity.ScopeMessage, handler.Limited("message", messageHandler.Send))).Methods(http.MethodPost)
+	api.HandleFunc("/messages/{id:[0-9]+}/read", handler.Scoped(entity.ScopeRead, messageHandler.MarkRead)).Methods(http.MethodPost)
+	api.HandleFunc("/users/{id:[0-9]+}/block", handler.Scoped(entity.ScopeMessage, messageHandler.Block)).Methods(http.MethodPost)
+	api.HandleFunc("/users/{id:[0-9]+}/unblock", handler.Scoped(entity.ScopeMessage, messageHandler.Unblock)).Methods(http.MethodPost)
+	// Mail provider webhook
+	api.HandleFunc("/email/bounce", emailHandler.Bounce).Methods(http.MethodPost)
+	// Live events (SSE)
+	api.HandleFunc("/stream", handler.Scoped(entity.ScopeRead, streamHandler.Stream)).Methods(http.MethodGet)
+
+	jobsDone := make(chan struct{})
+	go func() {
+		jobRunner.Run(ctx)
+		close(jobsDone)
+	}()
+
+	server := &http.Server{Addr: ":8080", Handler: handler.CORS(corsOrigins)(r)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("Ошибка остановки сервера:", err)
+		}
+	}()
 
 	fmt.Println("Server is running on http://localhost:8080")
-	http.ListenAndServe(":8080", r)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Println("Ошибка сервера:", err)
+	}
+	stop() // если сервер упал сам, останавливаем и воркеры
+	<-jobsDone
+	fmt.Println("Server stopped")
 }
@@ -0,0 +1,47 @@
+package entity
+
+import "time"
+
+// Scopes of personal API tokens
+const (
+	ScopeRead     = "read"     // чтение ленты, уведомлений, сообщений
+	ScopePost     = "post"     // посты, доски, клубы
+	ScopeComment  = "comment"  // комментарии
+	ScopeVote     = "vote"     // лайки и дизлайки
+	ScopeMessage  = "message"  // личные сообщения и блокировки
+	ScopeAccount  = "account"  // подписки, закладки, настройки уведомлений
+	ScopeModerate = "moderate" // действия модератора, только для moderator и выше
+)
+
+// Scopes lists every scope in the order they are shown; moderate stays last
+var Scopes = []string{ScopeRead, ScopePost, ScopeComment, ScopeVote, ScopeMessage, ScopeAccount, ScopeModerate}
+
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // начало токена, чтобы отличать их в списке
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package entity
+
+import "time"
+
+// Automoderator actions
+const (
+	AutomodReject = "reject" // отклонить сразу, автор видит Message
+	AutomodHold   = "hold"   // в очередь модерации
+	AutomodTag    = "tag"    // пометить тред тегом Tag
+	AutomodLock   = "lock"   // закрыть тред для комментариев
+	AutomodNotify = "notify" // уведомить модераторов (и владельца клуба)
+)
+
+// What an automoderator rule looks at
+const (
+	AutomodTargetAll     = "all"
+	AutomodTargetPost    = "post"
+	AutomodTargetComment = "comment"
+)
+
+// Automoderator rule scopes, see AutomodRule.Scope
+const (
+	AutomodScopeSite  = "site"
+	AutomodScopeClub  = "club"
+	AutomodScopeBoard = "board"
+)
+
+// AutomodRule is a condition set and an action. Every condition that is set must match;
+// a rule with no conditions is rejected when saved.
+type AutomodRule struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	ClubID  *int64 `json:"club_id,omitempty"`  // правило для всех досок клуба
+	BoardID *int64 `json:"board_id,omitempty"` // правило одной доски; оба nil — весь форум
+	Target  string `json:"target"`             // all | post | comment
+
+	Pattern            string   `json:"pattern,omitempty"`               // регулярное выражение по заголовку и тексту, без учёта регистра
+	MaxAccountAgeHours int      `json:"max_account_age_hours,omitempty"` // автор зарегистрирован меньше N часов назад
+	MaxKarma           *int     `json:"max_karma,omitempty"`             // карма автора не выше N
+	LinkDomains        []string `json:"link_domains,omitempty"`          // ссылка на домен из списка или его поддомен
+	Attachment         bool     `json:"attachment,omitempty"`            // к сообщению приложена картинка
+
+	Action  string `json:"action"`
+	Tag     string `json:"tag,omitempty"`
+	Message string `json:"message,omitempty"` // для reject — текст для автора, иначе пояснение модераторам
+
+	Enabled   bool      `json:"enabled"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *AutomodRule) Scope() string {
+	switch {
+	case r.BoardID != nil:
+		return AutomodScopeBoard
+	case r.ClubID != nil:
+		return AutomodScopeClub
+	}
+	return AutomodScopeSite
+}
+
+// AutomodMatch is an existing post a rule would have matched, reported by a dry run
+type AutomodMatch struct {
+	PostID    int64     `json:"post_id"`
+	Title     string    `json:"title"`
+	BoardID   int64     `json:"board_id"`
+	AuthorID  int64     `json:"author_id"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AutomodDryRun is the result of trying a rule on recent posts without acting on them
+type AutomodDryRun struct {
+	Checked int            `json:"checked"` // сколько постов проверено
+	Matches []AutomodMatch `json:"matches"`
+}
@@ -0,0 +1,46 @@
+package entity
+
+import (
+	"strconv"
+	"time"
+)
+
+// Bookmark of a post or a comment. PostID is always set, for a comment it is the comment's post;
+// CommentID is set only for comments.
+type Bookmark struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	PostID     int64     `json:"post_id"`
+	CommentID  *int64    `json:"comment_id,omitempty"`
+	FolderID   *int64    `json:"folder_id,omitempty"`
+	FolderName string    `json:"folder,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// what was saved, for lists and export
+	PostTitle string `json:"post_title"`
+	Excerpt   string `json:"excerpt,omitempty"` // начало комментария
+}
+
+func (b Bookmark) Link() string {
+	link := "/post/" + strconv.FormatInt(b.PostID, 10)
+	if b.CommentID != nil {
+		link += "#comment-" + strconv.FormatInt(*b.CommentID, 10)
+	}
+	return link
+}
+
+type BookmarkFolder struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BookmarkQuery selects a page of the user's bookmarks
+type BookmarkQuery struct {
+	UserID   int64
+	FolderID int64 // 0 — все, -1 — без папки
+	Limit    int   // 0 — все
+	Offset   int
+}
@@ -6,4 +6,5 @@ type Club struct {
 	Topic       string `json:"topic"`
 	Description string `json:"description"`
 	ImageData   []byte `json:"-"`
+	OwnerID     *int64 `json:"owner_id,omitempty"` // создатель клуба, управляет его правилами
 }
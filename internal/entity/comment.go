@@ -13,4 +13,6 @@ type Comment struct {
 	Likes     int64     `json:"likes"`
 	Dislikes  int64     `json:"dislikes"`
 	ParentID  *int64    `json:"parent_id,omitempty"`
+	QuoteID   *int64    `json:"quote_id,omitempty"`
+	Status    string    `json:"status,omitempty"` // published | pending | rejected
 }
@@ -0,0 +1,37 @@
+package entity
+
+import "time"
+
+const (
+	EmailPending    = "pending"
+	EmailSending    = "sending"
+	EmailSent       = "sent"
+	EmailFailed     = "failed"
+	EmailSuppressed = "suppressed"
+)
+
+// Suppression reasons
+const (
+	SuppressBounce      = "bounce"
+	SuppressComplaint   = "complaint"
+	SuppressUnsubscribe = "unsubscribe"
+)
+
+// Email is a row of the outbox
+type Email struct {
+	ID            int64      `json:"id"`
+	UserID        *int64     `json:"user_id,omitempty"`
+	To            string     `json:"to"`
+	Template      string     `json:"template"`
+	Lang          string     `json:"lang"`
+	Subject       string     `json:"subject"`
+	Text          string     `json:"-"`
+	HTML          string     `json:"-"`
+	Transactional bool       `json:"transactional"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
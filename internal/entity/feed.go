@@ -0,0 +1,77 @@
+package entity
+
+import "time"
+
+// Post feed sort modes
+const (
+	SortNew    = "new"
+	SortHot    = "hot"
+	SortTop    = "top"
+	SortRising = "rising"
+)
+
+// Time windows of the top feed
+const (
+	WindowDay   = "day"
+	WindowWeek  = "week"
+	WindowMonth = "month"
+	WindowAll   = "all"
+)
+
+// SortMode is a feed tab
+type SortMode struct {
+	Sort  string `json:"sort"`
+	Title string `json:"title"`
+}
+
+var SortModes = []SortMode{
+	{SortNew, "Новые"},
+	{SortHot, "Горячие"},
+	{SortTop, "Лучшие"},
+	{SortRising, "Набирающие"},
+}
+
+var TopWindows = []SortMode{
+	{WindowDay, "за день"},
+	{WindowWeek, "за неделю"},
+	{WindowMonth, "за месяц"},
+	{WindowAll, "за всё время"},
+}
+
+// WindowSince is the earliest creation time of posts in a top window; zero for all time
+func WindowSince(window string, now time.Time) time.Time {
+	switch window {
+	case WindowDay:
+		return now.AddDate(0, 0, -1)
+	case WindowWeek:
+		return now.AddDate(0, 0, -7)
+	case WindowMonth:
+		return now.AddDate(0, -1, 0)
+	}
+	return time.Time{}
+}
+
+// PostQuery selects a feed of published posts
+type PostQuery struct {
+	BoardID    int64     // 0 — все доски
+	FollowerID int64     // не 0 — только доски, клубы, треды и авторы из подписок этого пользователя
+	Sort       string    // SortNew, SortHot, SortTop, SortRising
+	Since      time.Time // только посты новее; нулевое — без ограничения
+	Limit      int       // 0 — без ограничения
+	Offset     int
+}
+
+// ParseFeed validates the sort and window of a feed request, falling back to new and week
+func ParseFeed(sort, window string) (string, string) {
+	switch sort {
+	case SortHot, SortTop, SortRising:
+	default:
+		sort = SortNew
+	}
+	switch window {
+	case WindowDay, WindowMonth, WindowAll:
+	default:
+		window = WindowWeek
+	}
+	return sort, window
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// Identity is an account at an external OpenID Connect provider linked to a forum user
+type Identity struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Issuer      string    `json:"issuer"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
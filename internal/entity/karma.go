@@ -0,0 +1,42 @@
+package entity
+
+// Karma is the sum of votes other users gave to someone's published posts and comments
+type Karma struct {
+	UserID  int64       `json:"user_id"`
+	Post    int         `json:"post"`
+	Comment int         `json:"comment"`
+	Clubs   []ClubKarma `json:"clubs,omitempty"` // репутация в клубах, больше — выше
+}
+
+func (k Karma) Total() int {
+	return k.Post + k.Comment
+}
+
+// ClubKarma is the karma earned in the boards of one club
+type ClubKarma struct {
+	ClubID   int64  `json:"club_id"`
+	ClubName string `json:"club_name"`
+	Karma    int    `json:"karma"`
+}
+
+// Actions that need karma; the thresholds are configured in the service
+const (
+	PrivilegeCreateBoard = "create_board"
+	PrivilegePostLinks   = "post_links"
+	PrivilegeDownvote    = "downvote"
+)
+
+// Privilege is one privilege as a user has it
+type Privilege struct {
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	Karma    int    `json:"karma"` // сколько кармы нужно
+	Unlocked bool   `json:"unlocked"`
+}
+
+// Privileges lists every privilege in the order they are shown
+var Privileges = []Privilege{
+	{Name: PrivilegePostLinks, Title: "Ссылки в постах и комментариях"},
+	{Name: PrivilegeDownvote, Title: "Минусы"},
+	{Name: PrivilegeCreateBoard, Title: "Создание досок"},
+}
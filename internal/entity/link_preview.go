@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	Error       string    `json:"-"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	ExpiresAt   time.Time `json:"-"`
+}
+
+// Empty reports whether the preview has nothing worth rendering
+func (p *LinkPreview) Empty() bool {
+	return p == nil || (p.Title == "" && p.Description == "" && p.ImageURL == "")
+}
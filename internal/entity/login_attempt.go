@@ -0,0 +1,23 @@
+package entity
+
+import "time"
+
+// LoginAttempt is one password login, successful or not
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	UserID    *int64    `json:"user_id,omitempty"` // nil when no such account
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginLockout blocks password logins to a username until LockedUntil
+type LoginLockout struct {
+	Username    string    `json:"username"`
+	LockedAt    time.Time `json:"locked_at"`
+	LockedUntil time.Time `json:"locked_until"`
+	Failures    int       `json:"failures"`
+}
@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// Mention of a user in a post body (CommentID == nil) or in a comment
+type Mention struct {
+	PostID    int64     `json:"post_id"`
+	CommentID *int64    `json:"comment_id,omitempty"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	AuthorID  int64     `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
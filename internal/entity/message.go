@@ -0,0 +1,50 @@
+package entity
+
+import "time"
+
+type Conversation struct {
+	ID            int64     `json:"id"`
+	Title         string    `json:"title"`
+	IsGroup       bool      `json:"is_group"`
+	CreatedBy     *int64    `json:"created_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastMessageAt time.Time `json:"last_message_at"`
+	Members       []User    `json:"members"`
+	// для текущего пользователя
+	LastReadID  int64    `json:"last_read_message_id"`
+	Unread      int      `json:"unread"`
+	LastMessage *Message `json:"last_message,omitempty"`
+}
+
+// Name is the title shown in the conversation list: group title or the other members' names
+func (c Conversation) Name(me int64) string {
+	if c.Title != "" {
+		return c.Title
+	}
+	name := ""
+	for _, m := range c.Members {
+		if m.ID == me {
+			continue
+		}
+		if name != "" {
+			name += ", "
+		}
+		name += m.Username
+	}
+	if name == "" {
+		return "Диалог"
+	}
+	return name
+}
+
+type Message struct {
+	ID             int64     `json:"id"`
+	ConversationID int64     `json:"conversation_id"`
+	SenderID       int64     `json:"sender_id"`
+	SenderName     string    `json:"sender_name"`
+	Content        string    `json:"content"`
+	ImageData      []byte    `json:"-"`
+	ImageType      string    `json:"-"`
+	HasImage       bool      `json:"has_image"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,47 @@
+package entity
+
+import "time"
+
+// Publication status of posts and comments
+const (
+	StatusPublished = "published"
+	StatusPending   = "pending"   // задержан антиспамом, ждёт модератора
+	StatusRejected  = "rejected"  // модератор признал спамом
+	StatusDraft     = "draft"     // черновик, виден только автору
+	StatusScheduled = "scheduled" // опубликуется в PublishAt
+)
+
+// Decisions on moderation queue items
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationSpam     = "spam"
+)
+
+// ModerationItem is a post or a comment held by the spam filter
+type ModerationItem struct {
+	ID         int64      `json:"id"`
+	PostID     int64      `json:"post_id"`
+	CommentID  *int64     `json:"comment_id,omitempty"` // nil when the post itself is held
+	AuthorID   int64      `json:"author_id"`
+	AuthorName string     `json:"author_name"`
+	BoardID    int64      `json:"board_id"`
+	Title      string     `json:"title,omitempty"` // заголовок поста
+	Content    string     `json:"content"`
+	Reasons    []string   `json:"reasons"`
+	Score      float64    `json:"score"`
+	Status     string     `json:"status"`
+	DecidedBy  *int64     `json:"decided_by,omitempty"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// SpamRule is a banned word (matched case-insensitively as a whole word) or a regular expression
+type SpamRule struct {
+	ID        int64     `json:"id"`
+	BoardID   *int64    `json:"board_id,omitempty"` // nil: all boards
+	Pattern   string    `json:"pattern"`
+	Regex     bool      `json:"regex"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
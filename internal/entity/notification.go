@@ -0,0 +1,64 @@
+package entity
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	NotificationReplyPost     = "reply_post"     // комментарий к моему посту
+	NotificationReplyComment  = "reply_comment"  // ответ на мой комментарий
+	NotificationMention       = "mention"        // @упоминание
+	NotificationVoteMilestone = "vote_milestone" // пост/комментарий набрал N голосов
+	NotificationClubInvite    = "club_invite"
+	NotificationModeration    = "moderation"   // действие модератора над моим контентом
+	NotificationAutomod       = "automod"      // сработало правило автомодератора (для модераторов)
+	NotificationSubscription  = "subscription" // новый пост на доске, в клубе или у автора из подписок
+	NotificationThreadReply   = "thread_reply" // новый комментарий в треде из подписок
+)
+
+// NotificationType describes a type for the preferences page
+type NotificationType struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+}
+
+var NotificationTypes = []NotificationType{
+	{NotificationReplyPost, "Комментарии к моим постам"},
+	{NotificationReplyComment, "Ответы на мои комментарии"},
+	{NotificationMention, "Упоминания"},
+	{NotificationVoteMilestone, "Пороги голосов"},
+	{NotificationClubInvite, "Приглашения в клубы"},
+	{NotificationModeration, "Действия модераторов"},
+	{NotificationAutomod, "Срабатывания автомодератора"},
+	{NotificationSubscription, "Новые посты в подписках"},
+	{NotificationThreadReply, "Комментарии в отслеживаемых тредах"},
+}
+
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Type      string     `json:"type"`
+	ActorID   *int64     `json:"actor_id,omitempty"`
+	ActorName string     `json:"actor_name,omitempty"`
+	PostID    *int64     `json:"post_id,omitempty"`
+	CommentID *int64     `json:"comment_id,omitempty"`
+	ClubID    *int64     `json:"club_id,omitempty"`
+	Message   string     `json:"message"`
+	DedupeKey string     `json:"-"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Link points to the object the notification is about
+func (n Notification) Link() string {
+	switch {
+	case n.PostID != nil && n.CommentID != nil:
+		return "/post/" + strconv.FormatInt(*n.PostID, 10) + "#comment-" + strconv.FormatInt(*n.CommentID, 10)
+	case n.PostID != nil:
+		return "/post/" + strconv.FormatInt(*n.PostID, 10)
+	case n.ClubID != nil:
+		return "/clubs/" + strconv.FormatInt(*n.ClubID, 10)
+	}
+	return "/notifications"
+}
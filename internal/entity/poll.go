@@ -0,0 +1,45 @@
+package entity
+
+import "time"
+
+// Poll is attached to a post; the post id is the poll's id
+type Poll struct {
+	PostID      int64        `json:"post_id"`
+	Question    string       `json:"question"`
+	Multiple    bool         `json:"multiple"`     // можно выбрать несколько вариантов
+	Anonymous   bool         `json:"anonymous"`    // не показывать, кто за что голосовал
+	AllowChange bool         `json:"allow_change"` // можно переголосовать до закрытия
+	HideResults bool         `json:"hide_results"` // результаты видны только после закрытия
+	ClosesAt    *time.Time   `json:"closes_at,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Options     []PollOption `json:"options"`
+
+	// Per viewer
+	Voters         int     `json:"voters"`          // сколько человек проголосовало
+	ResultsVisible bool    `json:"results_visible"` // иначе Votes, VoterNames и Voters пустые
+	MyVotes        []int64 `json:"my_votes"`        // варианты, выбранные зрителем
+	CanVote        bool    `json:"can_vote"`        // зритель может проголосовать сейчас
+}
+
+type PollOption struct {
+	ID         int64    `json:"id"`
+	Text       string   `json:"text"`
+	Votes      int      `json:"votes"`
+	Percent    int      `json:"percent"`
+	VoterNames []string `json:"voter_names,omitempty"` // только в открытых голосованиях
+}
+
+// Closed reports whether voting has ended by now
+func (p *Poll) Closed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+}
+
+// Voted reports whether the viewer picked the option
+func (p *Poll) Voted(optionID int64) bool {
+	for _, id := range p.MyVotes {
+		if id == optionID {
+			return true
+		}
+	}
+	return false
+}
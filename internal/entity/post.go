@@ -3,17 +3,43 @@ package entity
 import "time"
 
 type Post struct {
-	ID        int64     `json:"id"`
-	Title     string    `json:"title"`
-	BoardID   int64     `json:"board_id"`
-	Content   string    `json:"content"`
-	AuthorID  int64     `json:"author_id"`
-	ImageURL  string    `json:"image_url,omitempty"`
-	LinkURL   string    `json:"link_url,omitempty"`
-	ImageData []byte    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Likes     int       `json:"likes"`
-	Dislikes  int       `json:"dislikes"`
-	Comments  []Comment `json:"comments,omitempty"`
+	ID        int64      `json:"id"`
+	Title     string     `json:"title"`
+	BoardID   int64      `json:"board_id"`
+	Content   string     `json:"content"`
+	AuthorID  int64      `json:"author_id"`
+	ImageURL  string     `json:"image_url,omitempty"`
+	LinkURL   string     `json:"link_url,omitempty"`
+	ImageData []byte     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Likes     int        `json:"likes"`
+	Dislikes  int        `json:"dislikes"`
+	Views     int        `json:"views"`
+	Comments  []Comment  `json:"comments,omitempty"`
+	Status    string     `json:"status,omitempty"`    // published | pending | rejected | draft | scheduled
+	LockedAt  *time.Time `json:"locked_at,omitempty"` // закрыт для новых комментариев
+	Tags      []string   `json:"tags,omitempty"`
+
+	PinPosition *int       `json:"pin_position,omitempty"` // закреплён наверху доски, меньше — выше
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`  // в архиве: только чтение
+	Poll        *Poll      `json:"poll,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"` // отложенная публикация
+}
+
+func (p *Post) Locked() bool {
+	return p.LockedAt != nil
+}
+
+func (p *Post) Pinned() bool {
+	return p.PinPosition != nil
+}
+
+func (p *Post) Archived() bool {
+	return p.ArchivedAt != nil
+}
+
+// Closed threads take no new comments or votes
+func (p *Post) Closed() bool {
+	return p.Locked() || p.Archived()
 }
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// ReadMark says that a user has seen a thread up to LastCommentID
+type ReadMark struct {
+	UserID        int64
+	PostID        int64
+	LastCommentID int64
+}
+
+// ReadState is a thread as one user last left it. Visited is false for threads the user has never
+// opened (and that are newer than the board's read mark); BoardReadAt is set once the board was
+// marked as read, everything older counts as read then.
+type ReadState struct {
+	PostID        int64      `json:"post_id"`
+	Visited       bool       `json:"visited"`
+	LastCommentID int64      `json:"last_comment_id"`
+	BoardReadAt   *time.Time `json:"board_read_at,omitempty"`
+	Unread        int        `json:"unread"`                    // непрочитанные комментарии, свои не считаются
+	FirstUnreadID int64      `json:"first_unread_id,omitempty"` // самый ранний из них
+}
+
+// IsUnread reports whether c is new for viewerID; own comments are always read
+func (s ReadState) IsUnread(c Comment, viewerID int64) bool {
+	if c.AuthorID == viewerID || c.ID <= s.LastCommentID {
+		return false
+	}
+	return s.BoardReadAt == nil || c.CreatedAt.After(*s.BoardReadAt)
+}
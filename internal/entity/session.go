@@ -0,0 +1,27 @@
+package entity
+
+import "time"
+
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Purposes of single-use e-mail tokens
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	TokenLogin2FA      = "login_2fa" // пароль верный, ждём второй фактор
+)
+
+// UserToken is a consumed single-use token
+type UserToken struct {
+	UserID  int64
+	Purpose string
+	Email   string
+}
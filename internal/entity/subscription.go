@@ -0,0 +1,61 @@
+package entity
+
+import (
+	"strconv"
+	"time"
+)
+
+// Subscription targets
+const (
+	SubscribeBoard = "board"
+	SubscribeClub  = "club"
+	SubscribePost  = "post" // тред: новые комментарии
+	SubscribeUser  = "user" // автор: новые посты
+)
+
+// SubscriptionTarget describes a target for the subscriptions page
+type SubscriptionTarget struct {
+	Target string `json:"target"`
+	Title  string `json:"title"`
+}
+
+var SubscriptionTargets = []SubscriptionTarget{
+	{SubscribeBoard, "Доски"},
+	{SubscribeClub, "Клубы"},
+	{SubscribeUser, "Авторы"},
+	{SubscribePost, "Треды"},
+}
+
+func ValidSubscriptionTarget(target string) bool {
+	for _, t := range SubscriptionTargets {
+		if t.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription of a user to a board, club, thread or author. A muted thread subscription keeps
+// the thread on the list but silences its notifications, including replies to the author.
+type Subscription struct {
+	UserID    int64     `json:"user_id"`
+	Target    string    `json:"target"`
+	TargetID  int64     `json:"target_id"`
+	Title     string    `json:"title,omitempty"` // название доски или клуба, заголовок поста, имя автора
+	Slug      string    `json:"slug,omitempty"`  // только у досок
+	Muted     bool      `json:"muted"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s Subscription) Link() string {
+	id := strconv.FormatInt(s.TargetID, 10)
+	switch s.Target {
+	case SubscribeBoard:
+		return "/board/" + s.Slug
+	case SubscribeClub:
+		return "/clubs/" + id
+	case SubscribePost:
+		return "/post/" + id
+	}
+	return "/profile/" + id
+}
@@ -2,11 +2,47 @@ package entity
 
 import "time"
 
+// Roles, from least to most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{RoleUser: 1, RoleModerator: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                 int64      `json:"id"`
+	Username           string     `json:"username"`
+	Email              string     `json:"email"`
+	Password           string     `json:"-"`
+	Locale             string     `json:"locale"`                          // язык писем: ru | en
+	Role               string     `json:"role"`                            // user | moderator | admin
+	EmailVerifiedAt    *time.Time `json:"email_verified_at,omitempty"`     // когда подтверждён email
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at,omitempty"` // когда включена 2FA
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// HasRole reports whether the user's role is min or higher
+func (u *User) HasRole(min string) bool {
+	return roleRank[u.Role] >= roleRank[min] && roleRank[min] > 0
+}
+
+func (u *User) TwoFactorEnabled() bool {
+	return u.TwoFactorEnabledAt != nil
+}
+
+// TrustedDevice is a browser remembered after a successful second factor
+type TrustedDevice struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
 }
@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+// PostView is one visitor opening a post; Visitor is "u:<id>" for users and "a:<hash>" for guests
+type PostView struct {
+	PostID  int64
+	UserID  int64 // 0 у гостя
+	Visitor string
+}
+
+// ViewDay is a post's unique views during one day
+type ViewDay struct {
+	Day   time.Time `json:"day"`
+	Views int       `json:"views"`
+	Users int       `json:"users"` // из них вошедших пользователей
+}
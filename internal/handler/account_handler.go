@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+)
+
+// forgotPasswordReply is shown whether or not the address has an account
+const forgotPasswordReply = "Если аккаунт с таким адресом существует, мы отправили на него ссылку для сброса пароля."
+
+type AccountHandler struct {
+	svc service.AccountService
+}
+
+func NewAccountHandler(svc service.AccountService) *AccountHandler {
+	return &AccountHandler{svc: svc}
+}
+
+// isJSON reports whether the request body is JSON (API endpoints) rather than a form
+func isJSON(r *http.Request) bool {
+	return r.Header.Get("Content-Type") == "application/json"
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// accountErrorStatus maps service errors to HTTP statuses
+func accountErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrInvalidToken), errors.Is(err, service.ErrWeakPassword), errors.Is(err, service.ErrInvalidInput):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func accountErrorText(err error) string {
+	switch {
+	case errors.Is(err, service.ErrRateLimited):
+		return "Слишком много попыток, попробуйте позже."
+	case errors.Is(err, service.ErrInvalidToken):
+		return "Ссылка недействительна или устарела."
+	case errors.Is(err, service.ErrWeakPassword):
+		return "Пароль должен быть не короче 8 символов."
+	case errors.Is(err, service.ErrInvalidInput):
+		return "Проверьте введённые данные."
+	}
+	return "Что-то пошло не так, попробуйте позже."
+}
+
+// GET /verify-email?token=
+func (h *AccountHandler) VerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{}
+	u, err := h.svc.VerifyEmail(r.Context(), r.URL.Query().Get("token"), clientIP(r))
+	if err != nil {
+		w.WriteHeader(accountErrorStatus(err))
+		data["Error"] = accountErrorText(err)
+	} else {
+		data["Email"] = u.Email
+	}
+	utils.RenderTemplate(w, "verify_email.html", data)
+}
+
+// POST /api/verify-email {"token": "..."}
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	u, err := h.svc.VerifyEmail(r.Context(), in.Token, clientIP(r))
+	if err != nil {
+		writeJSONStatus(w, accountErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]any{"status": "ok", "email": u.Email})
+}
+
+// POST /api/verify-email/resend — for the logged-in user
+func (h *AccountHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.svc.SendVerification(r.Context(), u, clientIP(r)); err != nil {
+		writeJSONStatus(w, accountErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /forgot-password
+func (h *AccountHandler) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	utils.RenderTemplate(w, "forgot_password.html", map[string]interface{}{})
+}
+
+// POST /forgot-password (form) and /api/forgot-password (JSON {"email": "..."})
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var email string
+	if isJSON(r) {
+		var in struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		email = in.Email
+	} else {
+		email = r.FormValue("email")
+	}
+
+	err := h.svc.RequestPasswordReset(r.Context(), email, clientIP(r))
+	if isJSON(r) {
+		if err != nil {
+			writeJSONStatus(w, accountErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	data := map[string]interface{}{"Email": email}
+	if err != nil {
+		w.WriteHeader(accountErrorStatus(err))
+		data["Error"] = accountErrorText(err)
+	} else {
+		data["Sent"] = forgotPasswordReply
+	}
+	utils.RenderTemplate(w, "forgot_password.html", data)
+}
+
+// GET /reset-password?token= — shows the form; the token is only used up on submit
+func (h *AccountHandler) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	data := map[string]interface{}{"Token": token}
+	if !h.svc.CheckResetToken(r.Context(), token) {
+		w.WriteHeader(http.StatusBadRequest)
+		data["Error"] = accountErrorText(service.ErrInvalidToken)
+		data["Token"] = ""
+	}
+	utils.RenderTemplate(w, "reset_password.html", data)
+}
+
+// POST /reset-password (form) and /api/reset-password (JSON {"token": "...", "password": "..."})
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		in.Token, in.Password = r.FormValue("token"), r.FormValue("password")
+		if in.Password != r.FormValue("password_confirm") {
+			w.WriteHeader(http.StatusBadRequest)
+			utils.RenderTemplate(w, "reset_password.html", map[string]interface{}{
+				"Token": in.Token,
+				"Error": "Пароли не совпадают.",
+			})
+			return
+		}
+	}
+
+	err := h.svc.ResetPassword(r.Context(), in.Token, in.Password, clientIP(r))
+	if isJSON(r) {
+		if err != nil {
+			writeJSONStatus(w, accountErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(accountErrorStatus(err))
+		data := map[string]interface{}{"Error": accountErrorText(err)}
+		if errors.Is(err, service.ErrWeakPassword) {
+			data["Token"] = in.Token // токен не потрачен, можно попробовать ещё раз
+		}
+		utils.RenderTemplate(w, "reset_password.html", data)
+		return
+	}
+	// все сессии завершены, в том числе текущая
+	clearSessionCookie(w, r)
+	utils.RenderTemplate(w, "reset_password.html", map[string]interface{}{"Done": true})
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiTokenTTLs are the lifetimes offered on the settings page, in days; 0 means no expiry
+var apiTokenTTLs = []int{30, 90, 365, 0}
+
+type APITokenHandler struct {
+	svc service.APITokenService
+}
+
+func NewAPITokenHandler(svc service.APITokenService) *APITokenHandler {
+	return &APITokenHandler{svc: svc}
+}
+
+func apiTokenErrorText(err error) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidInput):
+		return "Укажите название и хотя бы одно право."
+	case errors.Is(err, service.ErrForbidden):
+		return "Право moderate доступно только модераторам."
+	case errors.Is(err, service.ErrTooManyTokens):
+		return "Слишком много активных токенов, отзовите ненужные."
+	}
+	return "Не удалось создать токен, попробуйте позже."
+}
+
+func apiTokenErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidInput), errors.Is(err, service.ErrTooManyTokens):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrForbidden):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// GET /settings
+func (h *APITokenHandler) SettingsPage(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	h.renderSettings(w, r, u, map[string]interface{}{})
+}
+
+func (h *APITokenHandler) renderSettings(w http.ResponseWriter, r *http.Request, u *entity.User, data map[string]interface{}) {
+	tokens, err := h.svc.List(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "tokens error", http.StatusInternalServerError)
+		return
+	}
+	scopes := entity.Scopes
+	if !u.HasRole(entity.RoleModerator) {
+		scopes = scopes[:len(scopes)-1] // без moderate
+	}
+	data["User"] = u
+	data["Tokens"] = tokens
+	data["Scopes"] = scopes
+	data["TTLs"] = apiTokenTTLs
+	utils.RenderTemplate(w, "settings_page.html", data)
+}
+
+// POST /settings/tokens (form) — the new token is shown once on the settings page
+func (h *APITokenHandler) CreateForm(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	days, _ := strconv.Atoi(r.FormValue("expires_days"))
+	raw, t, err := h.svc.Create(r.Context(), u, r.FormValue("name"), r.Form["scope"], time.Duration(days)*24*time.Hour)
+	if err != nil {
+		w.WriteHeader(apiTokenErrorStatus(err))
+		h.renderSettings(w, r, u, map[string]interface{}{"Error": apiTokenErrorText(err)})
+		return
+	}
+	h.renderSettings(w, r, u, map[string]interface{}{"NewToken": raw, "NewTokenName": t.Name})
+}
+
+// POST /settings/tokens/{id}/revoke (form)
+func (h *APITokenHandler) RevokeForm(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Revoke(r.Context(), u.ID, id); err != nil && err != sql.ErrNoRows {
+		http.Error(w, "revoke error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// GET /api/tokens — browser session only, a token can't list tokens
+func (h *APITokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	tokens, err := h.svc.List(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "tokens error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, tokens)
+}
+
+// POST /api/tokens {"name", "scopes": [...], "expires_days"}
+func (h *APITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in struct {
+		Name        string   `json:"name"`
+		Scopes      []string `json:"scopes"`
+		ExpiresDays int      `json:"expires_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	raw, t, err := h.svc.Create(r.Context(), u, in.Name, in.Scopes, time.Duration(in.ExpiresDays)*24*time.Hour)
+	if err != nil {
+		writeJSONStatus(w, apiTokenErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, map[string]any{"token": raw, "info": t})
+}
+
+// POST /api/tokens/{id}/revoke
+func (h *APITokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Revoke(r.Context(), u.ID, id); err == sql.ErrNoRows {
+		http.Error(w, "token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "revoke error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
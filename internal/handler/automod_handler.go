@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"encoding/json"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// AutomodHandler manages automoderator rules: moderators for the whole forum, club owners for their clubs
+type AutomodHandler struct {
+	svc service.AutomodService
+}
+
+func NewAutomodHandler(svc service.AutomodService) *AutomodHandler {
+	return &AutomodHandler{svc: svc}
+}
+
+// ruleFromForm reads the rule form; scope is "site", "club:ID" or "board:ID"
+func ruleFromForm(r *http.Request) *entity.AutomodRule {
+	rule := &entity.AutomodRule{
+		Name:       r.FormValue("name"),
+		Target:     r.FormValue("target"),
+		Pattern:    r.FormValue("pattern"),
+		Attachment: r.FormValue("attachment") != "",
+		Action:     r.FormValue("action"),
+		Tag:        r.FormValue("tag"),
+		Message:    r.FormValue("message"),
+	}
+	kind, id, _ := strings.Cut(r.FormValue("scope"), ":")
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		switch kind {
+		case entity.AutomodScopeClub:
+			rule.ClubID = &n
+		case entity.AutomodScopeBoard:
+			rule.BoardID = &n
+		}
+	}
+	rule.MaxAccountAgeHours, _ = strconv.Atoi(r.FormValue("max_account_age_hours"))
+	if k, err := strconv.Atoi(strings.TrimSpace(r.FormValue("max_karma"))); err == nil {
+		rule.MaxKarma = &k
+	}
+	rule.LinkDomains = strings.FieldsFunc(r.FormValue("link_domains"), func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\n' || c == '\r'
+	})
+	return rule
+}
+
+// readRule takes a rule from JSON or from the form
+func readRule(w http.ResponseWriter, r *http.Request) (*entity.AutomodRule, bool) {
+	if !isJSON(r) {
+		return ruleFromForm(r), true
+	}
+	var rule entity.AutomodRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return nil, false
+	}
+	return &rule, true
+}
+
+// ruleScopeValue is the scope select's value for a rule
+func ruleScopeValue(rule *entity.AutomodRule) string {
+	switch {
+	case rule.BoardID != nil:
+		return entity.AutomodScopeBoard + ":" + strconv.FormatInt(*rule.BoardID, 10)
+	case rule.ClubID != nil:
+		return entity.AutomodScopeClub + ":" + strconv.FormatInt(*rule.ClubID, 10)
+	}
+	return entity.AutomodScopeSite
+}
+
+// render shows the rules page; draft and dryRun are set after a dry run from the form
+func (h *AutomodHandler) render(w http.ResponseWriter, r *http.Request, u *entity.User, draft *entity.AutomodRule,
+	dryRun *entity.AutomodDryRun) {
+	scopes, err := h.svc.Scopes(r.Context(), u)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	rules, err := h.svc.Rules(r.Context(), u)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	boardTitles := map[int64]string{}
+	for _, b := range scopes.Boards {
+		boardTitles[b.ID] = b.Title
+	}
+	clubNames := map[int64]string{}
+	for _, c := range scopes.Clubs {
+		clubNames[c.ID] = c.Name
+	}
+	// правило -> где оно действует
+	ruleScopes := map[int64]string{}
+	for _, rule := range rules {
+		switch rule.Scope() {
+		case entity.AutomodScopeBoard:
+			ruleScopes[rule.ID] = "доска " + boardTitles[*rule.BoardID]
+		case entity.AutomodScopeClub:
+			ruleScopes[rule.ID] = "клуб " + clubNames[*rule.ClubID]
+		default:
+			ruleScopes[rule.ID] = "весь форум"
+		}
+	}
+	scope := ""
+	if draft != nil {
+		scope = ruleScopeValue(draft)
+	} else if club := r.URL.Query().Get("club"); club != "" {
+		scope = entity.AutomodScopeClub + ":" + club
+	}
+	utils.RenderTemplate(w, "automod.html", map[string]interface{}{
+		"User":        u,
+		"Rules":       rules,
+		"Scopes":      scopes,
+		"RuleScopes":  ruleScopes,
+		"BoardTitles": boardTitles,
+		"Draft":       draft,
+		"DraftScope":  scope,
+		"DryRun":      dryRun,
+	})
+}
+
+// GET /automod
+func (h *AutomodHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	h.render(w, r, u, nil, nil)
+}
+
+// GET /api/automod/rules
+func (h *AutomodHandler) Rules(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rules, err := h.svc.Rules(r.Context(), u)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"rules": rules})
+}
+
+// POST /automod/rules (form) and /api/automod/rules (JSON entity.AutomodRule)
+func (h *AutomodHandler) Create(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rule, ok := readRule(w, r)
+	if !ok {
+		return
+	}
+	if err := h.svc.CreateRule(r.Context(), u, rule); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusCreated, rule)
+		return
+	}
+	http.Redirect(w, r, "/automod", http.StatusSeeOther)
+}
+
+// POST /automod/rules/{id}/{state:enable|disable} and the same under /api
+func (h *AutomodHandler) SetEnabled(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	enabled := mux.Vars(r)["state"] == "enable"
+	if err := h.svc.SetRuleEnabled(r.Context(), u, id, enabled); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, map[string]bool{"enabled": enabled})
+		return
+	}
+	http.Redirect(w, r, "/automod", http.StatusSeeOther)
+}
+
+// POST /automod/rules/{id}/delete and /api/automod/rules/{id}/delete
+func (h *AutomodHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.DeleteRule(r.Context(), u, id); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/automod", http.StatusSeeOther)
+}
+
+// DryRun tries an unsaved rule (POST /automod/dry-run, /api/automod/dry-run) or a saved one
+// (POST /automod/rules/{id}/dry-run and under /api) on recent posts; ?limit= caps how many
+func (h *AutomodHandler) DryRun(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var rule *entity.AutomodRule
+	if idStr, saved := mux.Vars(r)["id"]; saved {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+		if rule, err = h.svc.Rule(r.Context(), u, id); err != nil {
+			moderationError(w, err)
+			return
+		}
+	} else {
+		var ok bool
+		if rule, ok = readRule(w, r); !ok {
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	res, err := h.svc.DryRun(r.Context(), u, rule, limit)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, res)
+		return
+	}
+	h.render(w, r, u, rule, res)
+}
@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const bookmarksPageSize = 20
+
+// BookmarkHandler serves saved posts and comments: the /saved page, its forms, the API and export
+type BookmarkHandler struct {
+	svc     service.BookmarkService
+	siteURL string
+}
+
+func NewBookmarkHandler(svc service.BookmarkService) *BookmarkHandler {
+	return &BookmarkHandler{svc: svc}
+}
+
+// WithSiteURL makes links in the Markdown export absolute
+func (h *BookmarkHandler) WithSiteURL(u string) *BookmarkHandler {
+	h.siteURL = strings.TrimRight(u, "/")
+	return h
+}
+
+func bookmarkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrFolderExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		moderationError(w, err)
+	}
+}
+
+// folderParam: "" и "all" — все закладки, "none" — без папки, иначе id папки
+func folderParam(v string) (int64, error) {
+	switch v {
+	case "", "all":
+		return 0, nil
+	case "none":
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, service.ErrInvalidInput
+	}
+	return id, nil
+}
+
+// GET /saved?folder=&page=
+func (h *BookmarkHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	folder := r.URL.Query().Get("folder")
+	folderID, err := folderParam(folder)
+	if err != nil {
+		http.Error(w, "bad folder", http.StatusBadRequest)
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	list, total, err := h.svc.List(r.Context(), u, folderID, bookmarksPageSize, (page-1)*bookmarksPageSize)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	folders, err := h.svc.Folders(r.Context(), u)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	utils.RenderTemplate(w, "saved_page.html", map[string]interface{}{
+		"User":      u,
+		"Bookmarks": list,
+		"Total":     total,
+		"Folders":   folders,
+		"Folder":    folder,
+		"FolderID":  folderID,
+		"Page":      page,
+		"PrevPage":  page - 1,
+		"NextPage":  page + 1,
+		"HasMore":   page*bookmarksPageSize < total,
+	})
+}
+
+// POST /saved — forms on post pages and /saved. action=save takes post_id or comment_id,
+// folder_id or a new folder name, and note; action=delete takes id. next is where to go back to.
+func (h *BookmarkHandler) Form(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	switch r.FormValue("action") {
+	case "save":
+		in := service.BookmarkInput{Folder: r.FormValue("folder"), Note: r.FormValue("note")}
+		in.PostID, _ = strconv.ParseInt(r.FormValue("post_id"), 10, 64)
+		in.CommentID, _ = strconv.ParseInt(r.FormValue("comment_id"), 10, 64)
+		in.FolderID, _ = strconv.ParseInt(r.FormValue("folder_id"), 10, 64)
+		_, err = h.svc.Save(r.Context(), u, in)
+	case "delete":
+		id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		err = h.svc.Delete(r.Context(), u, id)
+	default:
+		err = service.ErrInvalidInput
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		bookmarkError(w, err)
+		return
+	}
+	http.Redirect(w, r, localNext(r.FormValue("next"), "/saved"), http.StatusSeeOther)
+}
+
+// POST /saved/folders — action create (name), rename (id, name) or delete (id)
+func (h *BookmarkHandler) FolderForm(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	next := "/saved"
+	switch r.FormValue("action") {
+	case "create":
+		var f *entity.BookmarkFolder
+		if f, err = h.svc.CreateFolder(r.Context(), u, r.FormValue("name")); err == nil {
+			next = "/saved?folder=" + strconv.FormatInt(f.ID, 10)
+		}
+	case "rename":
+		err = h.svc.RenameFolder(r.Context(), u, id, r.FormValue("name"))
+		next = "/saved?folder=" + strconv.FormatInt(id, 10)
+	case "delete":
+		err = h.svc.DeleteFolder(r.Context(), u, id)
+	default:
+		err = service.ErrInvalidInput
+	}
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// GET /api/bookmarks?folder=&limit=&offset=
+func (h *BookmarkHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	folderID, err := folderParam(r.URL.Query().Get("folder"))
+	if err != nil {
+		http.Error(w, "bad folder", http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = bookmarksPageSize
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	list, total, err := h.svc.List(r.Context(), u, folderID, limit, offset)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{
+		"bookmarks": list,
+		"total":     total,
+		"has_more":  offset+len(list) < total,
+	})
+}
+
+// POST /api/bookmarks {"post_id" | "comment_id", "folder_id" | "folder", "note"}
+func (h *BookmarkHandler) Create(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in service.BookmarkInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	b, err := h.svc.Save(r.Context(), u, in)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, b)
+}
+
+// DELETE /api/bookmarks/{id}
+func (h *BookmarkHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Delete(r.Context(), u, id); err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /api/bookmarks/folders
+func (h *BookmarkHandler) Folders(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	folders, err := h.svc.Folders(r.Context(), u)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, folders)
+}
+
+// POST /api/bookmarks/folders {"name"} creates a folder, POST /api/bookmarks/folders/{id} {"name"} renames it
+func (h *BookmarkHandler) SaveFolder(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if v, ok := mux.Vars(r)["id"]; ok {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		if err := h.svc.RenameFolder(r.Context(), u, id, in.Name); err != nil {
+			bookmarkError(w, err)
+			return
+		}
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	f, err := h.svc.CreateFolder(r.Context(), u, in.Name)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, f)
+}
+
+// DELETE /api/bookmarks/folders/{id}
+func (h *BookmarkHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.DeleteFolder(r.Context(), u, id); err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /saved/export?format=json|md and /api/bookmarks/export — every bookmark as a download
+func (h *BookmarkHandler) Export(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	list, err := h.svc.Export(r.Context(), u)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	name := "bookmarks-" + time.Now().Format("2006-01-02")
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		writeJSONStatus(w, http.StatusOK, list)
+	case "md", "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.md"`)
+		w.Write([]byte(h.markdown(list)))
+	default:
+		http.Error(w, "format must be json or md", http.StatusBadRequest)
+	}
+}
+
+// markdown groups bookmarks by folder, unfiled ones last
+func (h *BookmarkHandler) markdown(list []entity.Bookmark) string {
+	var order []string
+	groups := map[string][]entity.Bookmark{}
+	for _, b := range list {
+		if _, ok := groups[b.FolderName]; !ok && b.FolderName != "" {
+			order = append(order, b.FolderName)
+		}
+		groups[b.FolderName] = append(groups[b.FolderName], b)
+	}
+	if len(groups[""]) > 0 {
+		order = append(order, "")
+	}
+	var sb strings.Builder
+	sb.WriteString("# Закладки\n")
+	for _, folder := range order {
+		heading := folder
+		if heading == "" {
+			heading = "Без папки"
+		}
+		fmt.Fprintf(&sb, "\n## %s\n\n", mdEscape(heading))
+		for _, b := range groups[folder] {
+			title := b.PostTitle
+			if b.CommentID != nil {
+				title = "Комментарий в «" + title + "»"
+			}
+			fmt.Fprintf(&sb, "- [%s](%s%s) — %s\n", mdEscape(title), h.siteURL, b.Link(), b.CreatedAt.Format("02.01.2006"))
+			if b.Excerpt != "" {
+				fmt.Fprintf(&sb, "  > %s\n", strings.Join(strings.Fields(b.Excerpt), " "))
+			}
+			if b.Note != "" {
+				fmt.Fprintf(&sb, "\n  %s\n", strings.ReplaceAll(b.Note, "\n", "\n  "))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// mdEscape keeps titles from breaking link syntax
+func mdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, "\n", " ").Replace(s)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubBookmarkService struct {
+	service.BookmarkService
+	list []entity.Bookmark
+}
+
+func (s stubBookmarkService) Export(ctx context.Context, actor *entity.User) ([]entity.Bookmark, error) {
+	return s.list, nil
+}
+
+func TestFolderParam(t *testing.T) {
+	for v, want := range map[string]int64{"": 0, "all": 0, "none": -1, "7": 7} {
+		if got, err := folderParam(v); err != nil || got != want {
+			t.Errorf("folderParam(%q) = %d, %v; want %d", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"0", "-3", "misc"} {
+		if _, err := folderParam(v); err == nil {
+			t.Errorf("folderParam(%q) accepted", v)
+		}
+	}
+}
+
+func TestBookmarkExportMarkdown(t *testing.T) {
+	day := time.Date(2026, 3, 8, 12, 0, 0, 0, time.UTC)
+	folder, comment := int64(1), int64(9)
+	h := NewBookmarkHandler(stubBookmarkService{list: []entity.Bookmark{
+		{PostID: 3, PostTitle: "Без [папки]", CreatedAt: day},
+		{PostID: 1, FolderID: &folder, FolderName: "Рецепты", PostTitle: "Борщ", Note: "на выходные\nс пампушками", CreatedAt: day},
+		{PostID: 1, CommentID: &comment, FolderID: &folder, FolderName: "Рецепты", PostTitle: "Борщ",
+			Excerpt: "свёклу   отдельно\nзапечь", CreatedAt: day},
+	}}).WithSiteURL("https://forum.example")
+	export := func(format string) *httptest.ResponseRecorder {
+		r := withUser(httptest.NewRequest(http.MethodGet, "/bookmarks/export?format="+format, nil), &entity.User{ID: 1, Username: "cook"})
+		w := httptest.NewRecorder()
+		h.Export(w, r)
+		return w
+	}
+
+	w := export("md")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/markdown") {
+		t.Fatalf("md export: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := "# Закладки\n" +
+		"\n## Рецепты\n\n" +
+		"- [Борщ](https://forum.example/post/1) — 08.03.2026\n" +
+		"\n  на выходные\n  с пампушками\n" +
+		"- [Комментарий в «Борщ»](https://forum.example/post/1#comment-9) — 08.03.2026\n" +
+		"  > свёклу отдельно запечь\n" +
+		"\n## Без папки\n\n" +
+		"- [Без \\[папки\\]](https://forum.example/post/3) — 08.03.2026\n"
+	if got := w.Body.String(); got != want {
+		t.Fatalf("markdown:\n%s\nwant:\n%s", got, want)
+	}
+
+	if w := export("json"); w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), ".json") {
+		t.Fatalf("json export: %d %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+	if w := export("csv"); w.Code != http.StatusBadRequest {
+		t.Fatalf("csv export: %d, want 400", w.Code)
+	}
+}
@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"forum1/internal/entity"
+	"forum1/internal/repository"
 	"forum1/internal/service"
 	"forum1/utils"
 	"io"
@@ -14,13 +18,22 @@ import (
 )
 
 type ClubHandler struct {
-	service service.ClubService
+	service       service.ClubService
+	notifications service.NotificationService
+	users         repository.UserRepository
 }
 
 func NewClubHandler(s service.ClubService) *ClubHandler {
 	return &ClubHandler{service: s}
 }
 
+// WithNotifications enables club invites
+func (h *ClubHandler) WithNotifications(n service.NotificationService, users repository.UserRepository) *ClubHandler {
+	h.notifications = n
+	h.users = users
+	return h
+}
+
 // POST /clubs
 func (h *ClubHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var c entity.Club
@@ -28,6 +41,10 @@ func (h *ClubHandler) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	c.OwnerID = nil
+	if u, err := currentUser(r); err == nil {
+		c.OwnerID = &u.ID
+	}
 
 	id, err := h.service.Create(r.Context(), &c)
 	if err != nil {
@@ -72,14 +89,76 @@ func (h *ClubHandler) List(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(clubs)
 }
 
+// POST /clubs/{id}/invite — приглашение пользователя в клуб (JSON {"username"} или форма)
+func (h *ClubHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	if h.notifications == nil {
+		http.NotFound(w, r)
+		return
+	}
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	clubID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var username string
+	if r.Header.Get("Content-Type") == "application/json" {
+		var in struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		username = in.Username
+	} else {
+		username = r.FormValue("username")
+	}
+	invitee, err := h.users.GetUserByName(r.Context(), strings.TrimSpace(username))
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err := h.notifications.InviteToClub(r.Context(), clubID, u, invitee.ID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrForbidden):
+			http.Error(w, "only the club owner and moderators can invite", http.StatusForbidden)
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "club not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrInvalidInput):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "invite error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if acceptsJSON(r) || r.Header.Get("Content-Type") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/clubs/"+strconv.FormatInt(clubID, 10), http.StatusSeeOther)
+}
+
 type ClubPageHandler struct {
 	service service.ClubService
+	subs    service.SubscriptionService
 }
 
 func NewClubPageHandler(s service.ClubService) *ClubPageHandler {
 	return &ClubPageHandler{service: s}
 }
 
+// WithSubscriptions shows the subscribe button on club pages
+func (h *ClubPageHandler) WithSubscriptions(s service.SubscriptionService) *ClubPageHandler {
+	h.subs = s
+	return h
+}
+
 // GET /boards/club
 func (h *ClubPageHandler) ListPage(w http.ResponseWriter, r *http.Request) {
 	clubs, err := h.service.List(r.Context())
@@ -126,9 +205,26 @@ func (h *ClubPageHandler) DetailPage(w http.ResponseWriter, r *http.Request) {
 		user = map[string]string{"username": c.Value}
 	}
 
+	// владелец клуба и модераторы настраивают автомодератор клуба и приглашают в него
+	canAutomod := false
+	var subscription *entity.Subscription
+	u, err := currentUser(r)
+	if err == nil {
+		canAutomod = u.HasRole(entity.RoleModerator) || (club.OwnerID != nil && *club.OwnerID == u.ID)
+		if h.subs != nil {
+			if subscription, err = h.subs.Get(r.Context(), u.ID, entity.SubscribeClub, club.ID); err != nil {
+				fmt.Println("subscription:", err)
+			}
+		}
+	}
+
 	data := map[string]interface{}{
-		"Club": club,
-		"User": user,
+		"Club":         club,
+		"User":         user,
+		"CanAutomod":   canAutomod,
+		"CanInvite":    canAutomod,
+		"CanSubscribe": u != nil && h.subs != nil,
+		"Subscription": subscription,
 	}
 
 	// Render with shared layout
@@ -168,6 +264,9 @@ func (h *ClubPageHandler) CreatePage(w http.ResponseWriter, r *http.Request) {
 		Topic:       r.FormValue("topic"),
 		Description: r.FormValue("description"),
 	}
+	if u, err := currentUser(r); err == nil {
+		club.OwnerID = &u.ID // создатель управляет правилами автомодератора клуба
+	}
 
 	// Обработка изображения
 	var imageData []byte
@@ -2,9 +2,11 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"forum1/internal/entity"
 	"forum1/internal/repository"
 	"forum1/internal/service"
+	"forum1/utils"
 	"io"
 	"net/http"
 	"strconv"
@@ -40,6 +42,7 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 			PostID   int64  `json:"post_id"`
 			Content  string `json:"content"`
 			ParentID *int64 `json:"parent_id,omitempty"`
+			QuoteID  *int64 `json:"quote_id,omitempty"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 			http.Error(w, "bad json", http.StatusBadRequest)
@@ -50,14 +53,15 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 			AuthorID: u.ID,
 			Content:  in.Content,
 			ParentID: in.ParentID,
+			QuoteID:  in.QuoteID,
 		}
 		id, err := h.svc.CreateComment(r.Context(), cmt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			commentError(w, err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{"id": id})
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": id, "status": cmt.Status})
 		return
 	}
 
@@ -74,6 +78,7 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	r = r.WithContext(service.WithSpamSignals(r.Context(), service.SpamSignals{Honeypot: r.FormValue(utils.HoneypotField)}))
 	postID, _ := strconv.ParseInt(r.FormValue("post_id"), 10, 64)
 	content := r.FormValue("content")
 
@@ -85,6 +90,14 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// quote_id — цитируемый комментарий (необязательно)
+	var quoteID *int64
+	if qid := r.FormValue("quote_id"); qid != "" {
+		if val, err := strconv.ParseInt(qid, 10, 64); err == nil {
+			quoteID = &val
+		}
+	}
+
 	// Обработка изображения
 	var imageData []byte
 	file, _, err := r.FormFile("image")
@@ -99,22 +112,27 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		Content:   content,
 		ImageData: imageData,
 		ParentID:  parentID,
+		QuoteID:   quoteID,
 	}
 	id, err := h.svc.CreateComment(r.Context(), cmt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		commentError(w, err)
 		return
 	}
 
 	// Если ожидается JSON (AJAX)
 	if r.Header.Get("Accept") == "application/json" {
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{"id": id})
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": id, "status": cmt.Status})
 		return
 	}
 
 	// Редирект обратно на пост
-	http.Redirect(w, r, "/post/"+strconv.FormatInt(postID, 10), http.StatusSeeOther)
+	back := "/post/" + strconv.FormatInt(postID, 10)
+	if cmt.Status == entity.StatusPending {
+		back += "?held=1"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
 }
 
 // DeleteComment allows delete by comment author or by post author
@@ -171,3 +189,14 @@ func (h *CommentHandler) WithPosts(p service.PostService) *CommentHandler {
 	h.posts = p
 	return h
 }
+
+// commentError: в неопубликованный, закрытый или архивный тред писать нельзя — 403, остальное — ошибка ввода
+func commentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPostUnpublished), errors.Is(err, service.ErrPostLocked),
+		errors.Is(err, service.ErrPostArchived), errors.Is(err, service.ErrKarmaTooLow):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
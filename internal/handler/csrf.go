@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSRFCookie holds the double-submit token: forms send it back as csrf_token, scripts as X-CSRF-Token
+const (
+	CSRFCookie    = "csrf_token"
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+	csrfTokenLen  = 43 // 32 байта в base64url без паддинга
+)
+
+type CSRFConfig struct {
+	// AllowedOrigins may send credentialed requests besides the site itself (the CORS allowlist)
+	AllowedOrigins []string
+	// Exempt path prefixes don't use the browser session: webhooks, one-click unsubscribe from mail clients
+	Exempt []string
+}
+
+// csrfWriter carries the request's token to utils.RenderTemplate, which puts it into every form
+type csrfWriter struct {
+	http.ResponseWriter
+	token string
+}
+
+func (w *csrfWriter) CSRFToken() string { return w.token }
+
+// Flush keeps Server-Sent Events working through the wrapper
+func (w *csrfWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *csrfWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func newCSRFToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions || m == http.MethodTrace
+}
+
+// CSRF rejects state-changing requests that didn't come from the forum's own pages. Forms and
+// scripts must echo the csrf_token cookie; JSON requests may instead prove their origin with an
+// Origin header, since browsers won't send JSON cross-site without a CORS preflight. Requests
+// authenticated with a Bearer token carry no ambient credentials and are let through.
+func CSRF(cfg CSRFConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if c, err := r.Cookie(CSRFCookie); err == nil && len(c.Value) == csrfTokenLen {
+				token = c.Value
+			}
+			if token == "" {
+				token = newCSRFToken()
+				http.SetCookie(w, &http.Cookie{
+					Name: CSRFCookie, Value: token, Path: "/", MaxAge: int((365 * 24 * time.Hour).Seconds()),
+					HttpOnly: true, Secure: secureCookies(r), SameSite: http.SameSiteLaxMode,
+				})
+			}
+			w = &csrfWriter{ResponseWriter: w, token: token}
+			if isSafeMethod(r.Method) || cfg.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				origin = refererOrigin(r)
+			}
+			if origin != "" && !cfg.originAllowed(r, origin) {
+				csrfReject(w, r, "cross-origin request")
+				return
+			}
+			if isJSON(r) && origin != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+					_ = r.ParseMultipartForm(10 << 20) // тот же лимит памяти, что и в обработчиках загрузок
+				}
+				sent = r.PostFormValue(csrfFormField)
+			}
+			// новый токен только что выдан — значит, старого у клиента не было и сверять не с чем
+			c, err := r.Cookie(CSRFCookie)
+			if err != nil || c.Value != token || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				csrfReject(w, r, "missing or invalid CSRF token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (cfg CSRFConfig) exempt(r *http.Request) bool {
+	// личный токен в заголовке: AuthMiddleware в этом случае игнорирует cookie сессии
+	if strings.HasPrefix(r.URL.Path, "/api/") && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	for _, p := range cfg.Exempt {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg CSRFConfig) originAllowed(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false // в том числе "null" из sandbox-iframe и file://
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return originInList(origin, cfg.AllowedOrigins)
+}
+
+func originInList(origin string, list []string) bool {
+	for _, o := range list {
+		if strings.EqualFold(strings.TrimRight(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// refererOrigin is scheme://host of the Referer; some browsers omit Origin on same-origin form posts
+func refererOrigin(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func csrfReject(w http.ResponseWriter, r *http.Request, reason string) {
+	if acceptsJSON(r) || strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONStatus(w, http.StatusForbidden, map[string]string{"error": "csrf", "reason": reason})
+		return
+	}
+	http.Error(w, "Запрос отклонён: устаревшая форма или чужой сайт. Обновите страницу и попробуйте снова", http.StatusForbidden)
+}
+
+// CORS answers cross-origin requests only for the allowlisted origins; the rest of the web gets no
+// CORS headers at all, so browsers keep the same-origin policy. It wraps the whole router so
+// preflight OPTIONS requests are answered even for routes that only accept POST.
+func CORS(allowed []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !originInList(origin, allowed) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+csrfHeader)
+				h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int((10 * time.Minute).Seconds())))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
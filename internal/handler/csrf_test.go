@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// csrfServe runs r through CSRF in front of a handler that answers 200
+func csrfServe(cfg CSRFConfig, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	CSRF(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, r)
+	return w
+}
+
+func TestCSRFIssuesTokenOnFirstVisit(t *testing.T) {
+	var seen string
+	w := httptest.NewRecorder()
+	CSRF(CSRFConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = w.(interface{ CSRFToken() string }).CSRFToken()
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == CSRFCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || len(cookie.Value) != csrfTokenLen || !cookie.HttpOnly {
+		t.Fatalf("csrf cookie %+v, want an HttpOnly %d-char token", cookie, csrfTokenLen)
+	}
+	if seen != cookie.Value {
+		t.Fatalf("templates get %q, cookie holds %q", seen, cookie.Value)
+	}
+
+	// с уже выданным токеном новый не нужен
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	if got := csrfServe(CSRFConfig{}, r).Result().Cookies(); len(got) != 0 {
+		t.Fatalf("token reissued: %v", got)
+	}
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	token := newCSRFToken()
+	form := func(cookie, field string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/post/create", strings.NewReader(url.Values{csrfFormField: {field}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: cookie})
+		}
+		return r
+	}
+	header := httptest.NewRequest(http.MethodPost, "/comment/create", nil)
+	header.AddCookie(&http.Cookie{Name: CSRFCookie, Value: token})
+	header.Header.Set(csrfHeader, token)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(csrfFormField, token)
+	mw.Close()
+	upload := httptest.NewRequest(http.MethodPost, "/post/create", &body)
+	upload.Header.Set("Content-Type", mw.FormDataContentType())
+	upload.AddCookie(&http.Cookie{Name: CSRFCookie, Value: token})
+
+	cases := []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{"form field matches cookie", form(token, token), http.StatusOK},
+		{"header matches cookie", header, http.StatusOK},
+		{"multipart field matches cookie", upload, http.StatusOK},
+		{"no field", form(token, ""), http.StatusForbidden},
+		{"field from another session", form(token, newCSRFToken()), http.StatusForbidden},
+		{"no cookie", form("", token), http.StatusForbidden},
+		{"malformed cookie", form("short", "short"), http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if got := csrfServe(CSRFConfig{}, c.r).Code; got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCSRFOriginCheck(t *testing.T) {
+	token := newCSRFToken()
+	cfg := CSRFConfig{AllowedOrigins: []string{"https://app.example.org/"}}
+	req := func(contentType, header, value string, withToken bool) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/post", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", contentType)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		if withToken {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: token})
+			r.Header.Set(csrfHeader, token)
+		}
+		return r
+	}
+	const jsonType, formType = "application/json", "application/x-www-form-urlencoded"
+	cases := []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{"JSON from the site itself", req(jsonType, "Origin", "http://example.com", false), http.StatusOK},
+		{"JSON from an allowlisted origin", req(jsonType, "Origin", "https://app.example.org", false), http.StatusOK},
+		{"JSON without an origin or token", req(jsonType, "", "", false), http.StatusForbidden},
+		{"JSON from another site", req(jsonType, "Origin", "https://evil.test", false), http.StatusForbidden},
+		{"JSON from another site with a token", req(jsonType, "Origin", "https://evil.test", true), http.StatusForbidden},
+		{"JSON from a sandboxed frame", req(jsonType, "Origin", "null", true), http.StatusForbidden},
+		{"form from the site without a token", req(formType, "Origin", "http://example.com", false), http.StatusForbidden},
+		{"form with a foreign Referer", req(formType, "Referer", "https://evil.test/page", true), http.StatusForbidden},
+		{"form with the site's Referer", req(formType, "Referer", "http://example.com/post/1", true), http.StatusOK},
+	}
+	for _, c := range cases {
+		if got := csrfServe(cfg, c.r).Code; got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	w := csrfServe(cfg, req(jsonType, "Origin", "https://evil.test", false))
+	if !strings.Contains(w.Header().Get("Content-Type"), "application/json") || !strings.Contains(w.Body.String(), `"csrf"`) {
+		t.Fatalf("API rejection: %q %q, want a JSON error", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestCSRFExemptions(t *testing.T) {
+	cfg := CSRFConfig{Exempt: []string{"/email/unsubscribe"}}
+	bearer := func(path string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Origin", "https://evil.test")
+		r.Header.Set("Authorization", "Bearer tok")
+		return r
+	}
+	// токен в заголовке браузер сам не подставит — на /api/ такой запрос не CSRF
+	if got := csrfServe(cfg, bearer("/api/post")).Code; got != http.StatusOK {
+		t.Fatalf("Bearer request to /api/: %d, want 200", got)
+	}
+	// вне /api/ Bearer не аутентифицирует, значит и от проверки не освобождает
+	if got := csrfServe(cfg, bearer("/post/create")).Code; got != http.StatusForbidden {
+		t.Fatalf("Bearer request off /api/: %d, want 403", got)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/email/unsubscribe?t=x", strings.NewReader("{}"))
+	if got := csrfServe(cfg, r).Code; got != http.StatusOK {
+		t.Fatalf("exempt one-click unsubscribe: %d, want 200", got)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/email/other", strings.NewReader("{}"))
+	if got := csrfServe(cfg, r).Code; got != http.StatusForbidden {
+		t.Fatalf("non-exempt path: %d, want 403", got)
+	}
+}
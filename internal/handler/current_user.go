@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// SessionCookie holds the raw session token
+const SessionCookie = "session"
+
+type ctxKey int
+
+const (
+	userCtxKey ctxKey = iota
+	sessionCtxKey
+	apiTokenCtxKey
+	rateLimiterCtxKey
+)
+
+// tokenAuth is a verified Bearer token waiting for Scoped to let it through
+type tokenAuth struct {
+	user  *entity.User
+	token *entity.APIToken
+}
+
+// AuthMiddleware resolves the session cookie and puts the user into the request context.
+// Older handlers still read the "user" cookie, so it is rebuilt from the session here:
+// whatever "user" cookie the client sent is dropped and can no longer be used to impersonate someone.
+//
+// /api requests may instead carry "Authorization: Bearer <personal token>". Such a request stays
+// anonymous until a Scoped wrapper checks the token's scopes, so routes that aren't scoped are
+// closed to tokens by default.
+func AuthMiddleware(sessions service.SessionService, tokens service.APITokenService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookies := r.Cookies()
+			r.Header.Del("Cookie")
+			bearer, hasBearer := bearerToken(r)
+			hasBearer = hasBearer && strings.HasPrefix(r.URL.Path, "/api/")
+			var token string
+			for _, c := range cookies {
+				switch c.Name {
+				case "user":
+					continue
+				case SessionCookie:
+					if hasBearer {
+						continue // скрипт с токеном не должен подхватить сессию браузера
+					}
+					token = c.Value
+				}
+				r.AddCookie(c)
+			}
+			if hasBearer {
+				u, t, err := tokens.Resolve(r.Context(), bearer, clientIP(r))
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, "invalid token", http.StatusUnauthorized)
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), apiTokenCtxKey, &tokenAuth{user: u, token: t}))
+			} else if token != "" {
+				if u, sess, err := sessions.Resolve(r.Context(), token); err == nil {
+					r = withUser(r, u)
+					r = r.WithContext(context.WithValue(r.Context(), sessionCtxKey, sess))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Scoped opens a route to personal API tokens that have scope; session users pass as before
+func Scoped(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ta, ok := r.Context().Value(apiTokenCtxKey).(*tokenAuth); ok {
+			if !ta.token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				http.Error(w, "token lacks scope "+scope, http.StatusForbidden)
+				return
+			}
+			r = withUser(r, ta.user)
+		}
+		h(w, r)
+	}
+}
+
+// withUser logs the request in as u, also for handlers that read the "user" cookie
+func withUser(r *http.Request, u *entity.User) *http.Request {
+	r.AddCookie(&http.Cookie{Name: "user", Value: u.Username})
+	return r.WithContext(context.WithValue(r.Context(), userCtxKey, u))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// currentUser returns the logged-in user put into the context by SessionMiddleware
+func currentUser(r *http.Request) (*entity.User, error) {
+	u, ok := r.Context().Value(userCtxKey).(*entity.User)
+	if !ok || u == nil {
+		return nil, errUnauthorized
+	}
+	return u, nil
+}
+
+func currentSession(r *http.Request) *entity.Session {
+	s, _ := r.Context().Value(sessionCtxKey).(*entity.Session)
+	return s
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func secureCookies(r *http.Request) bool {
+	return r.TLS != nil || os.Getenv("COOKIE_SECURE") == "true"
+}
+
+// clientIP is the peer address; X-Forwarded-For is only trusted behind a proxy (TRUST_PROXY=true)
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sessionStub знает одну сессию: "sess" принадлежит alice
+type sessionStub struct{ service.SessionService }
+
+func (sessionStub) Resolve(ctx context.Context, token string) (*entity.User, *entity.Session, error) {
+	if token != "sess" {
+		return nil, nil, errors.New("no session")
+	}
+	return &entity.User{ID: 1, Username: "alice", Role: entity.RoleUser}, &entity.Session{}, nil
+}
+
+// tokenStub знает один токен: "tok" у bob с правами read и post
+type tokenStub struct{ service.APITokenService }
+
+func (tokenStub) Resolve(ctx context.Context, raw, ip string) (*entity.User, *entity.APIToken, error) {
+	if raw != "tok" {
+		return nil, nil, errors.New("unknown token")
+	}
+	return &entity.User{ID: 2, Username: "bob", Role: entity.RoleUser},
+		&entity.APIToken{ID: 7, UserID: 2, Scopes: []string{entity.ScopeRead, entity.ScopePost}}, nil
+}
+
+// whoami answers with the user a handler sees, through the context and the legacy "user" cookie
+func whoami(w http.ResponseWriter, r *http.Request) {
+	name := "anonymous"
+	if u, err := currentUser(r); err == nil {
+		name = u.Username
+	}
+	legacy := ""
+	if c, err := r.Cookie("user"); err == nil {
+		legacy = c.Value
+	}
+	w.Write([]byte(name + "/" + legacy))
+}
+
+func serveAuth(h http.HandlerFunc, path, bearer string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	if bearer != "" {
+		r.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	AuthMiddleware(sessionStub{}, tokenStub{})(h).ServeHTTP(w, r)
+	return w
+}
+
+func TestScopedChecksTokenScopes(t *testing.T) {
+	w := serveAuth(Scoped(entity.ScopePost, whoami), "/api/post", "tok")
+	if w.Code != http.StatusOK || w.Body.String() != "bob/bob" {
+		t.Fatalf("token with the scope: %d %q, want bob", w.Code, w.Body.String())
+	}
+
+	w = serveAuth(Scoped(entity.ScopeModerate, whoami), "/api/mod/queue", "tok")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("token without the scope: %d, want 403", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, "insufficient_scope") || !strings.Contains(got, entity.ScopeModerate) {
+		t.Fatalf("WWW-Authenticate %q", got)
+	}
+
+	// маршрут без Scoped для токена закрыт: запрос остаётся анонимным
+	if w := serveAuth(whoami, "/api/tokens", "tok"); w.Body.String() != "anonymous/" {
+		t.Fatalf("unscoped route with a token: %q, want anonymous", w.Body.String())
+	}
+
+	w = serveAuth(Scoped(entity.ScopeRead, whoami), "/api/posts", "forged")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatalf("unknown token: %d %q, want 401 invalid_token", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestBearerOnlyCountsOnAPIPaths(t *testing.T) {
+	session := &http.Cookie{Name: SessionCookie, Value: "sess"}
+
+	// вне /api/ заголовок не аутентифицирует — ни токеном, ни ошибкой 401
+	if w := serveAuth(Scoped(entity.ScopePost, whoami), "/post/create", "tok"); w.Code != http.StatusOK || w.Body.String() != "anonymous/" {
+		t.Fatalf("token off /api/: %d %q, want anonymous", w.Code, w.Body.String())
+	}
+	if w := serveAuth(whoami, "/post/create", "forged", session); w.Code != http.StatusOK || w.Body.String() != "alice/alice" {
+		t.Fatalf("bad token off /api/ with a session: %d %q, want the session user", w.Code, w.Body.String())
+	}
+
+	// с токеном на /api/ сессия браузера не подхватывается, даже если токену не хватает прав
+	if w := serveAuth(Scoped(entity.ScopePost, whoami), "/api/post", "tok", session); w.Body.String() != "bob/bob" {
+		t.Fatalf("token and session: %q, want the token's user", w.Body.String())
+	}
+	if w := serveAuth(Scoped(entity.ScopeMessage, whoami), "/api/messages", "tok", session); w.Code != http.StatusForbidden {
+		t.Fatalf("token lacking the scope with a session: %d, want 403", w.Code)
+	}
+}
+
+func TestAuthMiddlewareDropsForgedUserCookie(t *testing.T) {
+	forged := &http.Cookie{Name: "user", Value: "admin"}
+	if w := serveAuth(whoami, "/profile", "", forged); w.Body.String() != "anonymous/" {
+		t.Fatalf("forged user cookie alone: %q, want anonymous", w.Body.String())
+	}
+	if w := serveAuth(whoami, "/profile", "", forged, &http.Cookie{Name: SessionCookie, Value: "sess"}); w.Body.String() != "alice/alice" {
+		t.Fatalf("forged user cookie with a session: %q, want the session user only", w.Body.String())
+	}
+}
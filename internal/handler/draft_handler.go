@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DraftHandler is the author's drafts and scheduled posts, and the autosave of the create post form
+type DraftHandler struct {
+	posts  service.PostService
+	boards service.BoardService
+}
+
+func NewDraftHandler(posts service.PostService, boards service.BoardService) *DraftHandler {
+	return &DraftHandler{posts: posts, boards: boards}
+}
+
+// formTime reads a datetime-local input in server time; nil for an empty one
+func formTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", v, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func draftError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "draft not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrPostScheduled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "draft error", http.StatusInternalServerError)
+	}
+}
+
+// GET /drafts
+func (h *DraftHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	drafts, err := h.posts.Drafts(r.Context(), u.ID)
+	if err != nil {
+		draftError(w, err)
+		return
+	}
+	boardTitles := map[int64]string{}
+	if boards, err := h.boards.List(r.Context()); err == nil {
+		for _, b := range boards {
+			boardTitles[b.ID] = b.Title
+		}
+	}
+	utils.RenderTemplate(w, "drafts_page.html", map[string]interface{}{
+		"Drafts":      drafts,
+		"BoardTitles": boardTitles,
+		"Scheduled":   r.URL.Query().Get("scheduled"), // только что запланированный пост
+	})
+}
+
+// GET /api/drafts
+func (h *DraftHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	drafts, err := h.posts.Drafts(r.Context(), u.ID)
+	if err != nil {
+		draftError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"drafts": drafts})
+}
+
+// POST /api/drafts — autosave. Takes the fields of the create post form (draft_id, board_id, title,
+// content, link_url, publish_at) or JSON {"id", "board_id", "title", "content", "link_url", "publish_at"};
+// without an id a new draft is created. Returns {"id", "saved_at"}.
+func (h *DraftHandler) Save(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var p entity.Post
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		p.ImageData = nil
+	} else {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			err = r.ParseMultipartForm(1 << 20)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		p.ID, _ = strconv.ParseInt(r.FormValue("draft_id"), 10, 64)
+		p.BoardID, _ = strconv.ParseInt(r.FormValue("board_id"), 10, 64)
+		p.Title = r.FormValue("title")
+		p.Content = r.FormValue("content")
+		p.LinkURL = strings.TrimSpace(r.FormValue("link_url"))
+		if p.PublishAt, err = formTime(r.FormValue("publish_at")); err != nil {
+			http.Error(w, "bad publish time", http.StatusBadRequest)
+			return
+		}
+	}
+	p.AuthorID = u.ID
+	id, err := h.posts.SaveDraft(r.Context(), &p)
+	if err != nil {
+		draftError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"id": id, "saved_at": time.Now()})
+}
+
+// POST /drafts/{id}/delete and /api/drafts/{id}/delete
+func (h *DraftHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.posts.DeleteDraft(r.Context(), u.ID, id); err != nil {
+		draftError(w, err)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") || isJSON(r) {
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+)
+
+type EmailHandler struct {
+	svc service.EmailService
+	// webhookSecret authenticates the bounce webhook; empty disables it
+	webhookSecret string
+}
+
+func NewEmailHandler(svc service.EmailService, webhookSecret string) *EmailHandler {
+	return &EmailHandler{svc: svc, webhookSecret: webhookSecret}
+}
+
+// GET /email/unsubscribe?email=&token= — confirmation page
+func (h *EmailHandler) UnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	utils.RenderTemplate(w, "email_unsubscribe.html", map[string]interface{}{
+		"Email": q.Get("email"),
+		"Token": q.Get("token"),
+	})
+}
+
+// POST /email/unsubscribe — from the confirmation form or a mail client's one-click unsubscribe (RFC 8058)
+func (h *EmailHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	// r.FormValue видит и тело формы, и параметры из ссылки в заголовке List-Unsubscribe
+	email, token := r.FormValue("email"), r.FormValue("token")
+	if err := h.svc.Unsubscribe(r.Context(), email, token); err != nil {
+		if err == service.ErrBadUnsubscribeToken {
+			http.Error(w, "Неверная ссылка для отписки", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Ошибка отписки", http.StatusInternalServerError)
+		return
+	}
+	utils.RenderTemplate(w, "email_unsubscribe.html", map[string]interface{}{
+		"Email": email,
+		"Done":  true,
+	})
+}
+
+// POST /api/email/bounce — webhook for the mail provider
+// Header X-Webhook-Token: <MAIL_WEBHOOK_SECRET>; JSON {"email": "...", "type": "bounce|complaint", "reason": "..."}
+func (h *EmailHandler) Bounce(w http.ResponseWriter, r *http.Request) {
+	if h.webhookSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Webhook-Token")), []byte(h.webhookSecret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in struct {
+		Email  string `json:"email"`
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Bounce(r.Context(), in.Email, in.Type, in.Reason); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
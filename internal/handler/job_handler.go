@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// JobHandler is the admin page of the background job queue
+type JobHandler struct {
+	svc service.JobAdmin
+}
+
+func NewJobHandler(svc service.JobAdmin) *JobHandler {
+	return &JobHandler{svc: svc}
+}
+
+// GET /admin/jobs
+func (h *JobHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	stats, err := h.svc.Stats(r.Context(), u)
+	if errors.Is(err, service.ErrForbidden) {
+		http.Error(w, "Недостаточно прав", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "jobs error", http.StatusInternalServerError)
+		return
+	}
+	failed, err := h.svc.Failed(r.Context(), u, 100)
+	if err != nil {
+		http.Error(w, "jobs error", http.StatusInternalServerError)
+		return
+	}
+	utils.RenderTemplate(w, "admin_jobs.html", map[string]interface{}{
+		"User":   u,
+		"Queues": stats,
+		"Failed": failed,
+	})
+}
+
+// GET /api/admin/jobs?limit=
+func (h *JobHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	stats, err := h.svc.Stats(r.Context(), u)
+	if errors.Is(err, service.ErrForbidden) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "jobs error", http.StatusInternalServerError)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	failed, err := h.svc.Failed(r.Context(), u, limit)
+	if err != nil {
+		http.Error(w, "jobs error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"queues": stats, "failed": failed})
+}
+
+// POST /admin/jobs/{id}/{action} (form) and /api/admin/jobs/{id}/{action}; action is retry or delete
+func (h *JobHandler) Apply(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if mux.Vars(r)["action"] == "retry" {
+		err = h.svc.Retry(r.Context(), u, id)
+	} else {
+		err = h.svc.Delete(r.Context(), u, id)
+	}
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "job not found or running", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, "jobs error", http.StatusInternalServerError)
+	case isJSON(r) || acceptsJSON(r):
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"forum1/internal/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// KarmaHandler serves user karma and guards routes of older handlers by karma thresholds
+type KarmaHandler struct {
+	svc service.KarmaService
+}
+
+func NewKarmaHandler(svc service.KarmaService) *KarmaHandler {
+	return &KarmaHandler{svc: svc}
+}
+
+// Require lets only users with enough karma for privilege through to h
+func (h *KarmaHandler) Require(privilege string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := currentUser(r)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := h.svc.Allow(r.Context(), u.ID, privilege); err != nil {
+			karmaError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func karmaError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrKarmaTooLow):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		moderationError(w, err)
+	}
+}
+
+// GET /api/users/{id}/karma — карма, репутация в клубах и открытые ей возможности
+func (h *KarmaHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	karma, err := h.svc.Get(r.Context(), id)
+	if err != nil {
+		karmaError(w, err)
+		return
+	}
+	// пороги считаются для того, чья карма, а не для смотрящего; модераторы тут не в счёт
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{
+		"user_id":    id,
+		"post":       karma.Post,
+		"comment":    karma.Comment,
+		"total":      karma.Total(),
+		"clubs":      karma.Clubs,
+		"privileges": h.svc.Privileges(nil, karma),
+	})
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// captchaResponse reads the CAPTCHA answer under the field names of the common widgets
+func captchaResponse(r *http.Request) string {
+	for _, f := range []string{"captcha", "h-captcha-response", "g-recaptcha-response", "cf-turnstile-response"} {
+		if v := r.FormValue(f); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// GET /login — the form, "Sign in with …" when SSO is configured and the CAPTCHA when the guard wants one
+func (h *UserHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	utils.RenderTemplate(w, "login_page.html", h.loginPageData(r, h.guard != nil && h.guard.CaptchaRequired(r.Context(), clientIP(r))))
+}
+
+func (h *UserHandler) loginPageData(r *http.Request, captcha bool) map[string]interface{} {
+	data := map[string]interface{}{}
+	if h.oidc != nil && h.oidc.Enabled() {
+		data["OIDCName"] = h.oidc.Name()
+	}
+	if captcha && h.captcha.SiteKey != "" {
+		data["Captcha"] = h.captcha
+	}
+	return data
+}
+
+// loginRejected answers a login refused before the password was checked
+func (h *UserHandler) loginRejected(w http.ResponseWriter, r *http.Request, err error) {
+	var throttled *service.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		secs := ceilSeconds(throttled.RetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		msg := "Слишком много попыток входа, попробуйте через " + (time.Duration(secs) * time.Second).String()
+		if throttled.Locked {
+			msg = "Вход временно заблокирован из-за неудачных попыток. Попробуйте позже или восстановите пароль"
+		}
+		if acceptsJSON(r) {
+			writeJSONStatus(w, http.StatusTooManyRequests, map[string]interface{}{
+				"error": throttled.Unwrap().Error(), "retry_after": secs, "locked": throttled.Locked,
+			})
+			return
+		}
+		http.Error(w, msg, http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrCaptchaRequired):
+		if acceptsJSON(r) {
+			writeJSONStatus(w, http.StatusForbidden, map[string]string{"error": "captcha_required"})
+			return
+		}
+		data := h.loginPageData(r, true)
+		data["Error"] = "Подтвердите, что вы не робот"
+		w.WriteHeader(http.StatusForbidden)
+		utils.RenderTemplate(w, "login_page.html", data)
+	default:
+		http.Error(w, "Ошибка входа", http.StatusInternalServerError)
+	}
+}
+
+// LoginAuditHandler is the admin view of failed logins and lockouts
+type LoginAuditHandler struct {
+	guard service.LoginGuard
+}
+
+func NewLoginAuditHandler(g service.LoginGuard) *LoginAuditHandler {
+	return &LoginAuditHandler{guard: g}
+}
+
+// GET /admin/logins
+func (h *LoginAuditHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	failures, err := h.guard.RecentFailures(r.Context(), u, 200)
+	if errors.Is(err, service.ErrForbidden) {
+		http.Error(w, "Недостаточно прав", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "login audit error", http.StatusInternalServerError)
+		return
+	}
+	lockouts, err := h.guard.Lockouts(r.Context(), u)
+	if err != nil {
+		http.Error(w, "login audit error", http.StatusInternalServerError)
+		return
+	}
+	utils.RenderTemplate(w, "admin_logins.html", map[string]interface{}{
+		"User":     u,
+		"Failures": failures,
+		"Lockouts": lockouts,
+	})
+}
+
+// GET /api/admin/logins?limit=
+func (h *LoginAuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	failures, err := h.guard.RecentFailures(r.Context(), u, limit)
+	if errors.Is(err, service.ErrForbidden) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "login audit error", http.StatusInternalServerError)
+		return
+	}
+	lockouts, err := h.guard.Lockouts(r.Context(), u)
+	if err != nil {
+		http.Error(w, "login audit error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"failures": failures, "lockouts": lockouts})
+}
+
+// POST /admin/logins/unlock (form) and /api/admin/logins/unlock ({"username"})
+func (h *LoginAuditHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in struct {
+		Username string `json:"username"`
+	}
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		in.Username = r.FormValue("username")
+	}
+	switch err := h.guard.Unlock(r.Context(), u, in.Username); {
+	case errors.Is(err, service.ErrForbidden):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not locked", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, "unlock error", http.StatusInternalServerError)
+	case isJSON(r):
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		http.Redirect(w, r, "/admin/logins", http.StatusSeeOther)
+	}
+}
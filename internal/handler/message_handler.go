@@ -0,0 +1,436 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/service"
+	"forum1/utils"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	conversationsPageSize = 50
+	messagesPageSize      = 50
+)
+
+type MessageHandler struct {
+	svc   service.MessageService
+	users repository.UserRepository
+}
+
+func NewMessageHandler(svc service.MessageService, users repository.UserRepository) *MessageHandler {
+	return &MessageHandler{svc: svc, users: users}
+}
+
+// GET /messages?c={conversation id}
+func (h *MessageHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	convs, err := h.svc.Conversations(r.Context(), u.ID, conversationsPageSize, 0)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки сообщений", http.StatusInternalServerError)
+		return
+	}
+	type convItem struct {
+		entity.Conversation
+		DisplayName string
+	}
+	items := make([]convItem, 0, len(convs))
+	for _, c := range convs {
+		items = append(items, convItem{Conversation: c, DisplayName: c.Name(u.ID)})
+	}
+
+	data := map[string]interface{}{
+		"Me":            u,
+		"Conversations": items,
+	}
+	if id, _ := strconv.ParseInt(r.URL.Query().Get("c"), 10, 64); id > 0 {
+		conv, err := h.svc.Conversation(r.Context(), u.ID, id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		msgs, err := h.svc.Messages(r.Context(), u.ID, id, 0, messagesPageSize)
+		if err != nil {
+			http.Error(w, "Ошибка загрузки сообщений", http.StatusInternalServerError)
+			return
+		}
+		// в ленте показываем от старых к новым
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+		if len(msgs) > 0 {
+			_ = h.svc.MarkRead(r.Context(), u.ID, id, msgs[len(msgs)-1].ID)
+		}
+		data["Active"] = conv
+		data["ActiveName"] = conv.Name(u.ID)
+		data["Messages"] = msgs
+		data["HasMore"] = len(msgs) == messagesPageSize
+	}
+	blocked, _ := h.svc.Blocked(r.Context(), u.ID)
+	data["Blocked"] = blocked
+	utils.RenderTemplate(w, "messages_page.html", data)
+}
+
+// GET /api/messages — conversations of the current user
+func (h *MessageHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = conversationsPageSize
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	convs, err := h.svc.Conversations(r.Context(), u.ID, limit, offset)
+	if err != nil {
+		http.Error(w, "messages error", http.StatusInternalServerError)
+		return
+	}
+	if convs == nil {
+		convs = []entity.Conversation{}
+	}
+	unread, _ := h.svc.UnreadTotal(r.Context(), u.ID)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"conversations": convs,
+		"unread":        unread,
+		"limit":         limit,
+		"offset":        offset,
+	})
+}
+
+// GET /api/messages/unread
+func (h *MessageHandler) Unread(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	unread, err := h.svc.UnreadTotal(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "messages error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"unread": unread})
+}
+
+// POST /api/messages — start a conversation
+// JSON: {"usernames": ["bob"], "user_ids": [2], "title": "...", "content": "..."};
+// форма: to=bob, alice (через запятую), title, content, image
+func (h *MessageHandler) Start(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in struct {
+		Usernames []string `json:"usernames"`
+		UserIDs   []int64  `json:"user_ids"`
+		Title     string   `json:"title"`
+		Content   string   `json:"content"`
+	}
+	var image []byte
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		content, img, err := parseMessageForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.Content, image = content, img
+		// проверяем вложение до создания диалога, чтобы не оставлять пустой диалог
+		if len(image) > 0 {
+			if _, err := service.MessageImageType(image); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		in.Title = r.FormValue("title")
+		for _, name := range strings.Split(r.FormValue("to"), ",") {
+			if name = strings.TrimPrefix(strings.TrimSpace(name), "@"); name != "" {
+				in.Usernames = append(in.Usernames, name)
+			}
+		}
+	}
+
+	ids := in.UserIDs
+	if len(in.Usernames) > 0 {
+		found, err := h.users.GetUsersByNames(r.Context(), in.Usernames)
+		if err != nil {
+			http.Error(w, "messages error", http.StatusInternalServerError)
+			return
+		}
+		if len(found) != len(in.Usernames) {
+			http.Error(w, "user not found", http.StatusBadRequest)
+			return
+		}
+		for _, f := range found {
+			ids = append(ids, f.ID)
+		}
+	}
+
+	convID, err := h.svc.StartConversation(r.Context(), u.ID, ids, in.Title)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	if strings.TrimSpace(in.Content) != "" || len(image) > 0 {
+		if _, err := h.svc.Send(r.Context(), &entity.Message{
+			ConversationID: convID, SenderID: u.ID, Content: in.Content, ImageData: image,
+		}); err != nil {
+			messageError(w, err)
+			return
+		}
+	}
+	if acceptsJSON(r) || r.Header.Get("Content-Type") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"conversation_id": convID})
+		return
+	}
+	http.Redirect(w, r, "/messages?c="+strconv.FormatInt(convID, 10), http.StatusSeeOther)
+}
+
+// GET /api/messages/{id}?before=&limit= — conversation with its messages, newest first
+func (h *MessageHandler) Get(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	conv, err := h.svc.Conversation(r.Context(), u.ID, id)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = messagesPageSize
+	}
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	msgs, err := h.svc.Messages(r.Context(), u.ID, id, before, limit)
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	if msgs == nil {
+		msgs = []entity.Message{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"conversation": conv,
+		"messages":     msgs,
+		"has_more":     len(msgs) == limit,
+	})
+}
+
+// POST /api/messages/{id} — send a message; JSON {"content": "..."} or multipart content + image
+func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	m := &entity.Message{ConversationID: id, SenderID: u.ID}
+	if r.Header.Get("Content-Type") == "application/json" {
+		var in struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		m.Content = in.Content
+	} else {
+		m.Content, m.ImageData, err = parseMessageForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := h.svc.Send(r.Context(), m); err != nil {
+		messageError(w, err)
+		return
+	}
+	if acceptsJSON(r) || r.Header.Get("Content-Type") == "application/json" {
+		m.SenderName = u.Username
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m)
+		return
+	}
+	http.Redirect(w, r, "/messages?c="+strconv.FormatInt(id, 10), http.StatusSeeOther)
+}
+
+// POST /api/messages/{id}/read — JSON {"message_id": N} or form message_id; without it everything is read
+func (h *MessageHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	var in struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		in.MessageID, _ = strconv.ParseInt(r.FormValue("message_id"), 10, 64)
+	}
+	if err := h.svc.MarkRead(r.Context(), u.ID, id, in.MessageID); err != nil {
+		messageError(w, err)
+		return
+	}
+	unread, _ := h.svc.UnreadTotal(r.Context(), u.ID)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"unread": unread})
+}
+
+// GET /messages/image/{id} — attachment, only for conversation members
+func (h *MessageHandler) Image(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	data, ct, err := h.svc.Image(r.Context(), u.ID, id)
+	if err != nil || len(data) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", "inline")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	_, _ = w.Write(data)
+}
+
+// GET /api/messages/blocks
+func (h *MessageHandler) Blocks(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	list, err := h.svc.Blocked(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "messages error", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []entity.User{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// POST /api/users/{id}/block
+func (h *MessageHandler) Block(w http.ResponseWriter, r *http.Request) {
+	h.setBlocked(w, r, true)
+}
+
+// POST /api/users/{id}/unblock
+func (h *MessageHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	h.setBlocked(w, r, false)
+}
+
+func (h *MessageHandler) setBlocked(w http.ResponseWriter, r *http.Request, block bool) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if block {
+		err = h.svc.Block(r.Context(), u.ID, id)
+	} else {
+		err = h.svc.Unblock(r.Context(), u.ID, id)
+	}
+	if err != nil {
+		messageError(w, err)
+		return
+	}
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/messages", http.StatusSeeOther)
+}
+
+// parseMessageForm reads content and an optional image the same way comments do
+func parseMessageForm(r *http.Request) (string, []byte, error) {
+	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			return "", nil, errors.New("bad multipart form")
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return "", nil, errors.New("bad form")
+	}
+	var image []byte
+	file, _, err := r.FormFile("image")
+	if err == nil && file != nil {
+		defer file.Close()
+		if image, err = io.ReadAll(file); err != nil {
+			return "", nil, errors.New("failed to read image")
+		}
+	}
+	return r.FormValue("content"), image, nil
+}
+
+func messageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrBlocked):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type stubMessageService struct {
+	service.MessageService
+	sent []entity.Message
+}
+
+func (s *stubMessageService) Send(ctx context.Context, m *entity.Message) (int64, error) {
+	if len(m.ImageData) > 0 {
+		if _, err := service.MessageImageType(m.ImageData); err != nil {
+			return 0, err
+		}
+	}
+	s.sent = append(s.sent, *m)
+	return int64(len(s.sent)), nil
+}
+
+func (s *stubMessageService) Image(ctx context.Context, userID, messageID int64) ([]byte, string, error) {
+	return []byte("GIF89a"), "image/gif", nil
+}
+
+func TestMessageImageUploadRejectsHTML(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("content", "look")
+	fw, _ := mw.CreateFormFile("image", "cat.png")
+	_, _ = fw.Write([]byte("<!doctype html><script>alert(1)</script>"))
+	_ = mw.Close()
+
+	svc := &stubMessageService{}
+	r := httptest.NewRequest(http.MethodPost, "/api/messages/1", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r = mux.SetURLVars(withUser(r, &entity.User{ID: 1}), map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	NewMessageHandler(svc, stubUserRepo{}).Send(w, r)
+	if w.Code != http.StatusBadRequest || len(svc.sent) != 0 {
+		t.Fatalf("HTML attachment: got %d, %d sent; want 400 and nothing sent", w.Code, len(svc.sent))
+	}
+}
+
+func TestMessageImageServedWithStoredType(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/messages/image/1", nil)
+	r = mux.SetURLVars(withUser(r, &entity.User{ID: 1}), map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	NewMessageHandler(&stubMessageService{}, stubUserRepo{}).Image(w, r)
+	want := map[string]string{
+		"Content-Type":           "image/gif",
+		"X-Content-Type-Options": "nosniff",
+		"Content-Disposition":    "inline",
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
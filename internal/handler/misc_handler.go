@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"forum1/db"
 	"forum1/internal/entity"
@@ -11,6 +13,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -20,6 +23,15 @@ type PageHandler struct {
 	boards   service.BoardService
 	comments service.CommentService
 	clubs    service.ClubService
+	previews service.LinkPreviewService
+	mentions service.MentionService
+	views    service.ViewService
+	polls    service.PollService
+	subs     service.SubscriptionService
+	saved    service.BookmarkService
+	reads    service.ReadService
+	karma    service.KarmaService
+	users    service.UserService
 }
 
 // WithComments allows injecting CommentService fluently after construction
@@ -34,13 +46,93 @@ func (h *PageHandler) WithClubs(c service.ClubService) *PageHandler {
 	return h
 }
 
+// WithPreviews allows injecting LinkPreviewService fluently after construction
+func (h *PageHandler) WithPreviews(p service.LinkPreviewService) *PageHandler {
+	h.previews = p
+	return h
+}
+
+// WithMentions allows injecting MentionService fluently after construction
+func (h *PageHandler) WithMentions(m service.MentionService) *PageHandler {
+	h.mentions = m
+	return h
+}
+
+// WithViews enables counting post views and the author's view history
+func (h *PageHandler) WithViews(v service.ViewService) *PageHandler {
+	h.views = v
+	return h
+}
+
+// WithPolls shows the post's poll on its page
+func (h *PageHandler) WithPolls(p service.PollService) *PageHandler {
+	h.polls = p
+	return h
+}
+
+// WithSubscriptions shows subscribe buttons on board and post pages
+func (h *PageHandler) WithSubscriptions(s service.SubscriptionService) *PageHandler {
+	h.subs = s
+	return h
+}
+
+// WithBookmarks shows bookmark buttons on post pages
+func (h *PageHandler) WithBookmarks(b service.BookmarkService) *PageHandler {
+	h.saved = b
+	return h
+}
+
+// WithReads marks new comments on post pages and counts unread ones in board lists
+func (h *PageHandler) WithReads(r service.ReadService) *PageHandler {
+	h.reads = r
+	return h
+}
+
+// WithKarma shows karma next to author names and on profiles
+func (h *PageHandler) WithKarma(k service.KarmaService) *PageHandler {
+	h.karma = k
+	return h
+}
+
+// WithUsers lets /profile/{id} show any user, not just the empty form
+func (h *PageHandler) WithUsers(u service.UserService) *PageHandler {
+	h.users = u
+	return h
+}
+
+// karmaOf is the karma of the users for the templates; nil without KarmaService, index gives 0 for the rest
+func (h *PageHandler) karmaOf(r *http.Request, ids []int64) map[int64]int {
+	if h.karma == nil {
+		return nil
+	}
+	totals, err := h.karma.Totals(r.Context(), ids)
+	if err != nil {
+		fmt.Println("karma:", err)
+		return nil
+	}
+	return totals
+}
+
+// subscription is the viewer's subscription to a target, nil for guests and non-subscribers
+func (h *PageHandler) subscription(r *http.Request, viewer *entity.User, target string, id int64) *entity.Subscription {
+	if h.subs == nil || viewer == nil {
+		return nil
+	}
+	sub, err := h.subs.Get(r.Context(), viewer.ID, target, id)
+	if err != nil {
+		fmt.Println("subscription:", err)
+	}
+	return sub
+}
+
 func NewPageHandler(p service.PostService, b service.BoardService) *PageHandler {
 	// Backwards-compatible constructor; comments can be injected later if needed
 	return &PageHandler{posts: p, boards: b}
 }
 
 func (h *PageHandler) HomePage(w http.ResponseWriter, r *http.Request) {
-	posts, err := h.posts.GetAllPosts(r.Context())
+	sort, window := entity.ParseFeed(r.URL.Query().Get("sort"), r.URL.Query().Get("t"))
+	posts, err := h.posts.ListPosts(r.Context(), 0, sort, window)
 	if err != nil {
 		http.Error(w, "Ошибка загрузки постов", http.StatusInternalServerError)
 		return
@@ -53,8 +145,12 @@ func (h *PageHandler) HomePage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Boards": boards,
-		"Posts":  posts,
+		"Boards":     boards,
+		"Posts":      posts,
+		"Sort":       sort,
+		"Window":     window,
+		"SortModes":  entity.SortModes,
+		"TopWindows": entity.TopWindows,
 	}
 
 	// Решаем формат
@@ -69,6 +165,34 @@ func (h *PageHandler) HomePage(w http.ResponseWriter, r *http.Request) {
 	utils.RenderTemplate(w, "home_page.html", data)
 }
 
+// GET /following — лента досок, клубов, тредов и авторов из подписок
+func (h *PageHandler) FollowingPage(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	sort, window := entity.ParseFeed(r.URL.Query().Get("sort"), r.URL.Query().Get("t"))
+	posts, err := h.posts.Following(r.Context(), u.ID, sort, window)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки постов", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"Following":  true,
+		"Posts":      posts,
+		"Sort":       sort,
+		"Window":     window,
+		"SortModes":  entity.SortModes,
+		"TopWindows": entity.TopWindows,
+	}
+	if acceptsJSON(r) {
+		writeJSONStatus(w, http.StatusOK, data)
+		return
+	}
+	utils.RenderTemplate(w, "home_page.html", data)
+}
+
 func (h *PageHandler) BoardsListPage(w http.ResponseWriter, r *http.Request) {
 	boards, err := h.boards.List(r.Context())
 	if err != nil {
@@ -98,18 +222,49 @@ func (h *PageHandler) BoardPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := h.posts.GetPostsByBoard(r.Context(), int64(board.ID))
+	sort, window := entity.ParseFeed(r.URL.Query().Get("sort"), r.URL.Query().Get("t"))
+	posts, err := h.posts.ListPosts(r.Context(), int64(board.ID), sort, window)
 	if err != nil {
 		http.Error(w, "Ошибка загрузки постов", http.StatusInternalServerError)
 		return
 	}
 
+	viewer, _ := currentUser(r)
+	ids := make([]int64, len(posts))
+	authors := make([]int64, len(posts))
+	for i, p := range posts {
+		ids[i], authors[i] = p.ID, p.AuthorID
+	}
+	var unread map[int64]entity.ReadState
+	if h.reads != nil && viewer != nil {
+		if unread, err = h.reads.Threads(r.Context(), viewer.ID, ids); err != nil {
+			fmt.Println("read marks:", err)
+		}
+	}
 	data := struct {
-		Board *entity.Board `json:"board"`
-		Posts []entity.Post `json:"posts"`
+		Board        *entity.Board              `json:"board"`
+		Posts        []entity.Post              `json:"posts"`
+		Sort         string                     `json:"sort"`
+		Window       string                     `json:"window"`
+		SortModes    []entity.SortMode          `json:"-"`
+		TopWindows   []entity.SortMode          `json:"-"`
+		CanSubscribe bool                       `json:"-"`
+		Subscription *entity.Subscription       `json:"subscription,omitempty"`
+		CanMarkRead  bool                       `json:"-"`
+		Unread       map[int64]entity.ReadState `json:"unread,omitempty"` // id поста -> что в нём нового
+		Karma        map[int64]int              `json:"karma,omitempty"`  // id автора -> карма
 	}{
-		Board: board,
-		Posts: posts,
+		Board:        board,
+		Posts:        posts,
+		Sort:         sort,
+		Window:       window,
+		SortModes:    entity.SortModes,
+		TopWindows:   entity.TopWindows,
+		CanSubscribe: viewer != nil && h.subs != nil,
+		Subscription: h.subscription(r, viewer, entity.SubscribeBoard, int64(board.ID)),
+		CanMarkRead:  viewer != nil && h.reads != nil,
+		Unread:       unread,
+		Karma:        h.karmaOf(r, authors),
 	}
 
 	// JSON API
@@ -138,6 +293,29 @@ func (h *PageHandler) PostPage(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	viewer, _ := currentUser(r)
+	if !canSee(post.Status, post.AuthorID, viewer) {
+		http.NotFound(w, r)
+		return
+	}
+	var viewHistory []entity.ViewDay
+	if h.views != nil && post.Status == entity.StatusPublished {
+		var viewerID int64
+		if viewer != nil {
+			viewerID = viewer.ID
+		}
+		h.views.Record(post.ID, viewerID, clientIP(r), r.UserAgent())
+		if viewer != nil && viewer.ID == post.AuthorID {
+			viewHistory, _ = h.views.History(r.Context(), viewer, post.ID, 14)
+		}
+	}
+	if h.polls != nil {
+		if poll, err := h.polls.Get(r.Context(), post.ID, viewer); err == nil {
+			post.Poll = poll
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			fmt.Println("poll:", err)
+		}
+	}
 
 	// Загружаем комментарии через сервис
 	var comments []entity.Comment
@@ -146,13 +324,96 @@ func (h *PageHandler) PostPage(w http.ResponseWriter, r *http.Request) {
 	}
 	post.Comments = comments
 
+	// Прочитанное: разделитель «новое» ставится по отметке прошлого визита, потом отмечаем этот
+	var firstUnread int64
+	unreadCount := 0
+	if h.reads != nil && viewer != nil && post.Status == entity.StatusPublished {
+		if st, err := h.reads.Thread(r.Context(), viewer.ID, post.ID); err != nil {
+			fmt.Println("read marks:", err)
+		} else if st.Visited {
+			for _, c := range comments {
+				if st.IsUnread(c, viewer.ID) {
+					if firstUnread == 0 {
+						firstUnread = c.ID
+					}
+					unreadCount++
+				}
+			}
+		}
+		var last int64
+		for _, c := range comments {
+			last = max(last, c.ID)
+		}
+		h.reads.Mark(viewer.ID, post.ID, last)
+	}
+
 	// Лайки/дизлайки поста
 	if likes, dislikes, err := h.posts.GetPostVotes(r.Context(), id); err == nil {
 		post.Likes, post.Dislikes = likes, dislikes
 	}
 
+	// Цитаты: комментарий -> цитируемый им комментарий
+	byID := make(map[int64]entity.Comment, len(comments))
+	authors := []int64{post.AuthorID}
+	for _, c := range comments {
+		byID[c.ID] = c
+		authors = append(authors, c.AuthorID)
+	}
+	quotes := map[int64]entity.Comment{}
+	for _, c := range comments {
+		if c.QuoteID == nil {
+			continue
+		}
+		if q, ok := byID[*c.QuoteID]; ok {
+			quotes[c.ID] = q
+		}
+	}
+
+	// @упоминания: username -> id для ссылок на профили
+	mentions := map[string]int64{}
+	if h.mentions != nil {
+		if m, err := h.mentions.UsernamesForPost(r.Context(), id); err == nil {
+			mentions = m
+		}
+	}
+
+	var bookmark *entity.Bookmark
+	var bookmarkedComments map[int64]int64
+	if h.saved != nil && viewer != nil {
+		if bookmark, bookmarkedComments, err = h.saved.ForPost(r.Context(), viewer.ID, post.ID); err != nil {
+			fmt.Println("bookmarks:", err)
+		}
+	}
+
 	data := map[string]interface{}{
-		"Post": post,
+		"Post":        post,
+		"Quotes":      quotes,
+		"Mentions":    mentions,
+		"CommentHeld": r.URL.Query().Get("held") == "1", // форма комментария без JS
+		"ViewHistory": viewHistory,                      // только автору
+		"CanModerate": viewer != nil && viewer.HasRole(entity.RoleModerator),
+		"PollClosed":  post.Poll != nil && post.Poll.Closed(time.Now()),
+
+		"CanSubscribe":       viewer != nil && h.subs != nil,
+		"CanFollowAuthor":    viewer != nil && h.subs != nil && viewer.ID != post.AuthorID,
+		"ThreadSubscription": h.subscription(r, viewer, entity.SubscribePost, post.ID),
+		"AuthorSubscription": h.subscription(r, viewer, entity.SubscribeUser, post.AuthorID),
+
+		"CanBookmark":        viewer != nil && h.saved != nil,
+		"Bookmark":           bookmark,
+		"BookmarkedComments": bookmarkedComments, // id комментария -> id закладки
+
+		"FirstUnreadID": firstUnread, // 0 — нового нет или тред открыт впервые
+		"UnreadCount":   unreadCount,
+
+		"Karma": h.karmaOf(r, authors), // id автора -> карма
+	}
+
+	// Карточка ссылки: если превью ещё нет, оно загрузится в фоне к следующему просмотру
+	if h.previews != nil && post.LinkURL != "" {
+		if preview, err := h.previews.Get(r.Context(), post.LinkURL); err == nil && preview != nil {
+			data["Preview"] = preview
+		}
 	}
 
 	accept := r.Header.Get("Accept")
@@ -165,12 +426,47 @@ func (h *PageHandler) PostPage(w http.ResponseWriter, r *http.Request) {
 	utils.RenderTemplate(w, "post_page.html", data)
 }
 
+// GET /profile/{id} — имя, карма и репутация в клубах; свой профиль ещё и с формой изменения
 func (h *PageHandler) ProfilePageHTML(w http.ResponseWriter, r *http.Request) {
-	utils.RenderTemplate(w, "profile_page.html", map[string]interface{}{})
-}
-
-func (h *PageHandler) LoginPageHTML(w http.ResponseWriter, r *http.Request) {
-	utils.RenderTemplate(w, "login_page.html", map[string]interface{}{})
+	if h.users == nil {
+		utils.RenderTemplate(w, "profile_page.html", map[string]interface{}{})
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	u, err := h.users.GetProfile(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	viewer, _ := currentUser(r)
+	own := viewer != nil && viewer.ID == u.ID
+	data := map[string]interface{}{
+		"UserID":   u.ID,
+		"Username": u.Username,
+		"Own":      own,
+	}
+	if own {
+		data["Email"] = u.Email
+	}
+	if h.karma != nil {
+		karma, err := h.karma.Get(r.Context(), u.ID)
+		if err != nil {
+			fmt.Println("karma:", err)
+		} else {
+			data["Karma"] = karma
+			data["KarmaTotal"] = karma.Total()
+			data["Privileges"] = h.karma.Privileges(u, karma)
+		}
+	}
+	if acceptsJSON(r) {
+		writeJSONStatus(w, http.StatusOK, data)
+		return
+	}
+	utils.RenderTemplate(w, "profile_page.html", data)
 }
 
 func (h *PageHandler) RegisterPageHTML(w http.ResponseWriter, r *http.Request) {
@@ -179,8 +475,16 @@ func (h *PageHandler) RegisterPageHTML(w http.ResponseWriter, r *http.Request) {
 
 func (h *PageHandler) CreatePostPageHTML(w http.ResponseWriter, r *http.Request) {
 	boards, _ := h.boards.List(r.Context())
-	// Template expects to range over root (.)
-	utils.RenderTemplate(w, "create_post_page.html", boards)
+	data := map[string]interface{}{"Boards": boards}
+	// ?draft= — продолжить черновик или поменять запланированный пост
+	if u, err := currentUser(r); err == nil {
+		if id, err := strconv.ParseInt(r.URL.Query().Get("draft"), 10, 64); err == nil {
+			if draft, err := h.posts.Draft(r.Context(), u.ID, id); err == nil {
+				data["Draft"] = draft
+			}
+		}
+	}
+	utils.RenderTemplate(w, "create_post_page.html", data)
 }
 
 func (h *PageHandler) BoardsSearchPageHTML(w http.ResponseWriter, r *http.Request) {
@@ -210,18 +514,6 @@ func (h *PageHandler) SearchPageHTML(w http.ResponseWriter, r *http.Request) {
 	utils.RenderTemplate(w, "search_page.html", data)
 }
 
-func (h *PageHandler) SettingsPageHTML(w http.ResponseWriter, r *http.Request) {
-	utils.RenderTemplate(w, "settings_page.html", map[string]interface{}{})
-}
-
-func (h *PageHandler) MessagesPageHTML(w http.ResponseWriter, r *http.Request) {
-	utils.RenderTemplate(w, "messages_page.html", map[string]interface{}{})
-}
-
-func (h *PageHandler) NotificationsPageHTML(w http.ResponseWriter, r *http.Request) {
-	utils.RenderTemplate(w, "notifications_page.html", map[string]interface{}{})
-}
-
 // Serve post image as /post/{id}/image
 func (h *PageHandler) PostImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -236,7 +528,8 @@ func (h *PageHandler) PostImage(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	if len(p.ImageData) == 0 {
+	viewer, _ := currentUser(r)
+	if !canSee(p.Status, p.AuthorID, viewer) || len(p.ImageData) == 0 {
 		http.NotFound(w, r)
 		return
 	}
@@ -262,6 +555,13 @@ func (h *PageHandler) CommentImage(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	// картинка видна там же, где сам комментарий: опубликованный комментарий в видимом посте
+	viewer, _ := currentUser(r)
+	post, err := h.posts.GetPostByID(r.Context(), comment.PostID)
+	if err != nil || post == nil || !canSee(post.Status, post.AuthorID, viewer) || !canSee(comment.Status, comment.AuthorID, viewer) {
+		http.NotFound(w, r)
+		return
+	}
 
 	if len(comment.ImageData) == 0 {
 		http.NotFound(w, r)
@@ -302,7 +602,7 @@ func (h *PageHandler) ClubImage(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(club.ImageData)
 }
 
-// Like/Dislike post via GET links
+// Like/Dislike post: POST /post/{id}/like from the page's vote forms, /api/post/{id}/like for scripts
 func (h *PageHandler) LikePost(w http.ResponseWriter, r *http.Request) {
 	h.votePost(w, r, 1)
 }
@@ -311,7 +611,7 @@ func (h *PageHandler) DislikePost(w http.ResponseWriter, r *http.Request) {
 	h.votePost(w, r, -1)
 }
 
-// Like/Dislike comment via GET links (?post_id= for redirect)
+// Like/Dislike comment via POST forms (?post_id= for redirect)
 func (h *PageHandler) LikeComment(w http.ResponseWriter, r *http.Request) {
 	h.voteComment(w, r, 1)
 }
@@ -321,6 +621,27 @@ func (h *PageHandler) DislikeComment(w http.ResponseWriter, r *http.Request) {
 }
 
 // Helpers
+
+// canSee: неопубликованное — задержанное антиспамом, черновик, отложенный пост — видят только автор и модераторы
+func canSee(status string, authorID int64, viewer *entity.User) bool {
+	if status == entity.StatusPublished {
+		return true
+	}
+	return viewer != nil && (viewer.ID == authorID || viewer.HasRole(entity.RoleModerator))
+}
+
+func voteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPostLocked), errors.Is(err, service.ErrPostArchived), errors.Is(err, service.ErrKarmaTooLow),
+		errors.Is(err, service.ErrPostUnpublished), errors.Is(err, service.ErrCommentUnpublished):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		http.Error(w, "vote error", http.StatusInternalServerError)
+	}
+}
+
 func (h *PageHandler) votePost(w http.ResponseWriter, r *http.Request, value int) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
@@ -336,11 +657,11 @@ func (h *PageHandler) votePost(w http.ResponseWriter, r *http.Request, value int
 	}
 	postID, _ := strconv.ParseInt(idStr, 10, 64)
 	if err := h.posts.SetPostVote(r.Context(), postID, userID, value); err != nil {
-		http.Error(w, "vote error", http.StatusInternalServerError)
+		voteError(w, err)
 		return
 	}
 	// If client expects JSON (AJAX), return new counters
-	if acceptsJSON(r) {
+	if acceptsJSON(r) || strings.HasPrefix(r.URL.Path, "/api/") {
 		likes, dislikes, _ := h.posts.GetPostVotes(r.Context(), postID)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -367,7 +688,7 @@ func (h *PageHandler) voteComment(w http.ResponseWriter, r *http.Request, value
 	cid, _ := strconv.ParseInt(commentIDStr, 10, 64)
 	if h.comments != nil {
 		if err := h.comments.SetCommentVote(r.Context(), cid, userID, value); err != nil {
-			http.Error(w, "vote error", http.StatusInternalServerError)
+			voteError(w, err)
 			return
 		}
 	} else if _, err := db.DB.Exec(`INSERT INTO comment_votes (comment_id, user_id, value) VALUES ($1,$2,$3)
@@ -375,7 +696,7 @@ func (h *PageHandler) voteComment(w http.ResponseWriter, r *http.Request, value
 		http.Error(w, "vote error", http.StatusInternalServerError)
 		return
 	}
-	if acceptsJSON(r) {
+	if acceptsJSON(r) || strings.HasPrefix(r.URL.Path, "/api/") {
 		var likes, dislikes int
 		if h.comments != nil {
 			likes, dislikes, _ = h.comments.GetCommentVotes(r.Context(), cid)
@@ -410,3 +731,59 @@ func acceptsJSON(r *http.Request) bool {
 	}
 	return strings.Contains(a, "application/json")
 }
+
+// POST /board/{slug}/read и /api/boards/{slug}/read — всё в доске прочитано
+func (h *PageHandler) MarkBoardRead(w http.ResponseWriter, r *http.Request) {
+	if h.reads == nil {
+		http.NotFound(w, r)
+		return
+	}
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	board, err := h.boards.GetBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := h.reads.MarkBoardRead(r.Context(), u, int64(board.ID)); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, map[string]interface{}{"board_id": board.ID, "read": true})
+		return
+	}
+	http.Redirect(w, r, "/board/"+board.Slug, http.StatusSeeOther)
+}
+
+// GET /api/post/{id}/views?days= — просмотры поста по дням, для автора и модераторов
+func (h *PageHandler) PostViews(w http.ResponseWriter, r *http.Request) {
+	if h.views == nil {
+		http.NotFound(w, r)
+		return
+	}
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	history, err := h.views.History(r.Context(), u, id, days)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	total := 0
+	for _, d := range history {
+		total += d.Views
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"post_id": id, "views": total, "days": history})
+}
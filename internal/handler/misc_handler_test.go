@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+var gifBytes = []byte("GIF89a\x01\x00\x01\x00")
+
+type stubPostService struct {
+	service.PostService
+	posts map[int64]*entity.Post
+}
+
+func (s stubPostService) GetPostByID(ctx context.Context, id int64) (*entity.Post, error) {
+	if p, ok := s.posts[id]; ok {
+		cp := *p
+		return &cp, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+type stubCommentService struct {
+	service.CommentService
+	comments map[int64]*entity.Comment
+}
+
+func (s stubCommentService) GetCommentByID(ctx context.Context, id int64) (*entity.Comment, error) {
+	if c, ok := s.comments[id]; ok {
+		cp := *c
+		return &cp, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func TestImagesFollowPostVisibility(t *testing.T) {
+	const author = 7
+	posts := map[int64]*entity.Post{}
+	comments := map[int64]*entity.Comment{}
+	for i, status := range []string{entity.StatusPublished, entity.StatusPending, entity.StatusDraft, entity.StatusScheduled} {
+		id := int64(i + 1)
+		posts[id] = &entity.Post{ID: id, AuthorID: author, Status: status, ImageData: gifBytes}
+		comments[id] = &entity.Comment{ID: id, PostID: id, AuthorID: author, Status: entity.StatusPublished, ImageData: gifBytes}
+	}
+	// задержанный комментарий в опубликованном посте
+	comments[10] = &entity.Comment{ID: 10, PostID: 1, AuthorID: author, Status: entity.StatusPending, ImageData: gifBytes}
+
+	h := NewPageHandler(stubPostService{posts: posts}, nil).WithComments(stubCommentService{comments: comments})
+	get := func(serve http.HandlerFunc, id string, u *entity.User) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if u != nil {
+			r = withUser(r, u)
+		}
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		serve(w, r)
+		return w.Code
+	}
+
+	stranger := &entity.User{ID: 8, Role: entity.RoleUser}
+	owner := &entity.User{ID: author, Role: entity.RoleUser}
+	mod := &entity.User{ID: 9, Role: entity.RoleModerator}
+	cases := []struct {
+		id   string
+		want map[*entity.User]int // nil — аноним
+	}{
+		{"1", map[*entity.User]int{nil: 200, stranger: 200, owner: 200, mod: 200}},
+		{"2", map[*entity.User]int{nil: 404, stranger: 404, owner: 200, mod: 200}},
+		{"3", map[*entity.User]int{nil: 404, stranger: 404, owner: 200, mod: 200}},
+		{"4", map[*entity.User]int{nil: 404, stranger: 404, owner: 200, mod: 200}},
+	}
+	for _, c := range cases {
+		for u, want := range c.want {
+			if got := get(h.PostImage, c.id, u); got != want {
+				t.Errorf("post %s image for %v: %d, want %d", c.id, u, got, want)
+			}
+			if got := get(h.CommentImage, c.id, u); got != want {
+				t.Errorf("comment image in post %s for %v: %d, want %d", c.id, u, got, want)
+			}
+		}
+	}
+	for u, want := range map[*entity.User]int{nil: 404, stranger: 404, owner: 200, mod: 200} {
+		if got := get(h.CommentImage, "10", u); got != want {
+			t.Errorf("held comment image for %v: %d, want %d", u, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ModerationHandler is the moderators' queue of posts and comments held by the spam filter,
+// and the banned word lists per board
+type ModerationHandler struct {
+	svc    service.ModerationService
+	boards service.BoardService
+}
+
+func NewModerationHandler(svc service.ModerationService) *ModerationHandler {
+	return &ModerationHandler{svc: svc}
+}
+
+// WithBoards lets the page offer boards for new rules
+func (h *ModerationHandler) WithBoards(b service.BoardService) *ModerationHandler {
+	h.boards = b
+	return h
+}
+
+// moderationAPI: одни и те же действия доступны формой со страницы и через /api
+func moderationAPI(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || isJSON(r)
+}
+
+func moderationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		http.Error(w, "Недостаточно прав", http.StatusForbidden)
+	case errors.Is(err, service.ErrAlreadyDecided):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		http.Error(w, "moderation error", http.StatusInternalServerError)
+	}
+}
+
+// GET /moderation
+func (h *ModerationHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	queue, err := h.svc.Queue(r.Context(), u, 100)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	rules, err := h.svc.Rules(r.Context(), u)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	boards := []entity.Board{}
+	boardTitles := map[int64]string{}
+	if h.boards != nil {
+		if list, err := h.boards.List(r.Context()); err == nil {
+			boards = list
+		}
+		for _, b := range boards {
+			boardTitles[b.ID] = b.Title
+		}
+	}
+	// правило -> название его доски; правила для всех досок в карте отсутствуют
+	ruleBoards := map[int64]string{}
+	for _, rule := range rules {
+		if rule.BoardID == nil {
+			continue
+		}
+		if t, ok := boardTitles[*rule.BoardID]; ok {
+			ruleBoards[rule.ID] = t
+		} else {
+			ruleBoards[rule.ID] = "#" + strconv.FormatInt(*rule.BoardID, 10)
+		}
+	}
+	utils.RenderTemplate(w, "moderation.html", map[string]interface{}{
+		"User":        u,
+		"Queue":       queue,
+		"Rules":       rules,
+		"Boards":      boards,
+		"BoardTitles": boardTitles,
+		"RuleBoards":  ruleBoards,
+	})
+}
+
+// GET /api/moderation/queue?limit=
+func (h *ModerationHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	queue, err := h.svc.Queue(r.Context(), u, limit)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"items": queue})
+}
+
+// POST /moderation/queue/{id}/{decision} and /api/moderation/queue/{id}/{decision}; decision is approved or spam
+func (h *ModerationHandler) Decide(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Decide(r.Context(), u, id, mux.Vars(r)["decision"]); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": mux.Vars(r)["decision"]})
+		return
+	}
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// GET /api/moderation/rules
+func (h *ModerationHandler) Rules(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rules, err := h.svc.Rules(r.Context(), u)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]interface{}{"rules": rules})
+}
+
+// POST /moderation/rules (form) and /api/moderation/rules ({"pattern", "regex", "board_id"})
+func (h *ModerationHandler) AddRule(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var rule entity.SpamRule
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		rule.Pattern = r.FormValue("pattern")
+		rule.Regex = r.FormValue("regex") != ""
+		if board, err := strconv.ParseInt(r.FormValue("board_id"), 10, 64); err == nil && board > 0 {
+			rule.BoardID = &board
+		}
+	}
+	if err := h.svc.AddRule(r.Context(), u, &rule); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusCreated, rule)
+		return
+	}
+	http.Redirect(w, r, "/moderation#rules", http.StatusSeeOther)
+}
+
+// POST /moderation/rules/{id}/delete and /api/moderation/rules/{id}/delete
+func (h *ModerationHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.DeleteRule(r.Context(), u, id); err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/moderation#rules", http.StatusSeeOther)
+}
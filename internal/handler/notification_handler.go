@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"encoding/json"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const notificationsPageSize = 20
+
+type NotificationHandler struct {
+	svc service.NotificationService
+}
+
+func NewNotificationHandler(svc service.NotificationService) *NotificationHandler {
+	return &NotificationHandler{svc: svc}
+}
+
+// GET /notifications
+func (h *NotificationHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	// берём на одну запись больше, чтобы понять, есть ли следующая страница
+	list, err := h.svc.List(r.Context(), u.ID, notificationsPageSize+1, (page-1)*notificationsPageSize)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки уведомлений", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(list) > notificationsPageSize
+	if hasMore {
+		list = list[:notificationsPageSize]
+	}
+	unread, _ := h.svc.UnreadCount(r.Context(), u.ID)
+	prefs, _ := h.svc.Preferences(r.Context(), u.ID)
+
+	utils.RenderTemplate(w, "notifications_page.html", map[string]interface{}{
+		"Notifications": list,
+		"Unread":        unread,
+		"Page":          page,
+		"PrevPage":      page - 1,
+		"NextPage":      page + 1,
+		"HasMore":       hasMore,
+		"Types":         entity.NotificationTypes,
+		"Preferences":   prefs,
+	})
+}
+
+// GET /api/notifications?limit=&offset=
+func (h *NotificationHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = notificationsPageSize
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	list, err := h.svc.List(r.Context(), u.ID, limit+1, offset)
+	if err != nil {
+		http.Error(w, "notifications error", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []entity.Notification{}
+	}
+	unread, _ := h.svc.UnreadCount(r.Context(), u.ID)
+
+	type item struct {
+		entity.Notification
+		Link string `json:"link"`
+	}
+	items := make([]item, 0, len(list))
+	for _, n := range list {
+		items = append(items, item{Notification: n, Link: n.Link()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"notifications": items,
+		"unread":        unread,
+		"limit":         limit,
+		"offset":        offset,
+		"has_more":      hasMore,
+	})
+}
+
+// GET /api/notifications/unread
+func (h *NotificationHandler) Unread(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	unread, err := h.svc.UnreadCount(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "notifications error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"unread": unread})
+}
+
+// POST /api/notifications/{id}/read
+func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.MarkRead(r.Context(), u.ID, id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.done(w, r)
+}
+
+// POST /api/notifications/read-all
+func (h *NotificationHandler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.svc.MarkAllRead(r.Context(), u.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.done(w, r)
+}
+
+// GET /api/notifications/preferences
+func (h *NotificationHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	prefs, err := h.svc.Preferences(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "notifications error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(prefs)
+}
+
+// POST /api/notifications/preferences
+// JSON: {"mention": false, ...}; форма: отмеченные чекбоксы name=<type> включены, остальные выключены
+func (h *NotificationHandler) SetPreferences(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	prefs := map[string]bool{}
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		for _, t := range entity.NotificationTypes {
+			prefs[t.Type] = r.FormValue(t.Type) != ""
+		}
+	}
+	for typ, enabled := range prefs {
+		if err := h.svc.SetPreference(r.Context(), u.ID, typ, enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.done(w, r)
+}
+
+// done answers JSON clients with ok and sends form submissions back to the page
+func (h *NotificationHandler) done(w http.ResponseWriter, r *http.Request) {
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
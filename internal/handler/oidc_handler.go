@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum1/internal/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// oidcFlowCookie keeps state, nonce and the PKCE verifier between the redirect and the callback
+const oidcFlowCookie = "oidc_flow"
+
+// GET /auth/oidc/login — sends the browser to the provider
+func (h *UserHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	h.beginOIDC(w, r, 0)
+}
+
+// POST /auth/oidc/link — links one more external account to the signed-in user
+func (h *UserHandler) OIDCLink(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	h.beginOIDC(w, r, u.ID)
+}
+
+func (h *UserHandler) beginOIDC(w http.ResponseWriter, r *http.Request, linkUserID int64) {
+	if h.oidc == nil || !h.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	redirect, flow, err := h.oidc.Begin(r.Context(), linkUserID)
+	if err != nil {
+		fmt.Println("oidc begin:", err)
+		http.Error(w, "Провайдер входа недоступен", http.StatusBadGateway)
+		return
+	}
+	// Lax: cookie должна прийти с top-level редиректом обратно от провайдера
+	http.SetCookie(w, &http.Cookie{
+		Name: oidcFlowCookie, Value: flow, Path: "/auth/oidc", MaxAge: 600,
+		HttpOnly: true, Secure: secureCookies(r), SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// GET /auth/oidc/callback?code=&state= — the provider sends the browser back here
+func (h *UserHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil || !h.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	c, err := r.Cookie(oidcFlowCookie)
+	// одноразовая: повтор callback'а с тем же code не нужен
+	http.SetCookie(w, &http.Cookie{
+		Name: oidcFlowCookie, Value: "", Path: "/auth/oidc", MaxAge: -1,
+		HttpOnly: true, Secure: secureCookies(r), SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil {
+		http.Error(w, service.ErrOIDCState.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		// пользователь нажал «отмена» у провайдера или тот отказал
+		fmt.Println("oidc callback:", e, q.Get("error_description"))
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	var sessionUserID int64
+	if u, err := currentUser(r); err == nil {
+		sessionUserID = u.ID
+	}
+	res, err := h.oidc.Complete(r.Context(), c.Value, q.Get("state"), q.Get("code"), sessionUserID)
+	switch {
+	case errors.Is(err, service.ErrOIDCState), errors.Is(err, service.ErrInvalidInput):
+		http.Error(w, service.ErrOIDCState.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrIdentityTaken):
+		http.Error(w, "Этот внешний аккаунт уже привязан к другому пользователю", http.StatusConflict)
+		return
+	case err != nil:
+		fmt.Println("oidc complete:", err)
+		http.Error(w, "Не удалось войти через "+h.oidc.Name(), http.StatusBadGateway)
+		return
+	}
+	if res.Linked {
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+		return
+	}
+	next := "/"
+	if res.Created {
+		next = "/settings" // новый аккаунт: пусть посмотрит настройки
+	}
+	h.finishLogin(w, r, res.User, next)
+}
+
+// POST /settings/identities/{id}/unlink
+func (h *UserHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	switch err := h.oidc.Unlink(r.Context(), u, id); {
+	case errors.Is(err, service.ErrLastSignIn):
+		http.Error(w, "Сначала задайте пароль: это единственный способ входа в аккаунт", http.StatusConflict)
+	case err != nil && err != sql.ErrNoRows:
+		http.Error(w, "unlink error", http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+	}
+}
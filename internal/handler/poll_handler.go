@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// PollHandler takes votes in the polls attached to posts
+type PollHandler struct {
+	svc service.PollService
+}
+
+func NewPollHandler(svc service.PollService) *PollHandler {
+	return &PollHandler{svc: svc}
+}
+
+// pollFromForm reads the optional poll of the create post form; nil when no question is given.
+// Options come one per line, the close time from a datetime-local input in server time.
+func pollFromForm(r *http.Request) (*entity.Poll, error) {
+	question := strings.TrimSpace(r.FormValue("poll_question"))
+	if question == "" {
+		return nil, nil
+	}
+	p := &entity.Poll{
+		Question:    question,
+		Multiple:    r.FormValue("poll_multiple") != "",
+		Anonymous:   r.FormValue("poll_anonymous") != "",
+		AllowChange: r.FormValue("poll_allow_change") != "",
+		HideResults: r.FormValue("poll_hide_results") != "",
+	}
+	for _, line := range strings.Split(r.FormValue("poll_options"), "\n") {
+		p.Options = append(p.Options, entity.PollOption{Text: line})
+	}
+	closesAt, err := formTime(r.FormValue("poll_closes_at"))
+	if err != nil {
+		return nil, errors.New("bad poll close time")
+	}
+	p.ClosesAt = closesAt
+	return p, nil
+}
+
+func pollError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPollClosed), errors.Is(err, service.ErrPollVoted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrPostLocked), errors.Is(err, service.ErrPostArchived), errors.Is(err, service.ErrPostUnpublished):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "poll not found", http.StatusNotFound)
+	default:
+		moderationError(w, err)
+	}
+}
+
+// GET /api/post/{id}/poll
+func (h *PollHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	viewer, _ := currentUser(r)
+	poll, err := h.svc.Get(r.Context(), id, viewer)
+	if err != nil {
+		pollError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, poll)
+}
+
+// POST /post/{id}/poll/vote (form, option=<id> per picked option) and
+// /api/post/{id}/poll/vote ({"options": [ids]})
+func (h *PollHandler) Vote(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Options []int64 `json:"options"`
+	}
+	if isJSON(r) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		for _, v := range r.Form["option"] {
+			option, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "bad option", http.StatusBadRequest)
+				return
+			}
+			body.Options = append(body.Options, option)
+		}
+	}
+	poll, err := h.svc.Vote(r.Context(), u, id, body.Options)
+	if err != nil {
+		pollError(w, err)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") || isJSON(r) {
+		writeJSONStatus(w, http.StatusOK, poll)
+		return
+	}
+	http.Redirect(w, r, "/post/"+strconv.FormatInt(id, 10)+"#poll", http.StatusSeeOther)
+}
@@ -1,24 +1,43 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"forum1/internal/entity"
 	"forum1/internal/repository"
 	"forum1/internal/service"
+	"forum1/utils"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type PostHandler struct {
-	svc   service.PostService
-	users repository.UserRepository
+	svc      service.PostService
+	users    repository.UserRepository
+	previews service.LinkPreviewService
 }
 
 func NewPostHandler(svc service.PostService, users repository.UserRepository) *PostHandler {
 	return &PostHandler{svc: svc, users: users}
 }
 
+// WithPreviews enables background link preview fetching for new posts
+func (h *PostHandler) WithPreviews(p service.LinkPreviewService) *PostHandler {
+	h.previews = p
+	return h
+}
+
+// prefetchPreview starts fetching the link card so it is usually ready by the first view
+func (h *PostHandler) prefetchPreview(p *entity.Post) {
+	if h.previews != nil && p.LinkURL != "" {
+		h.previews.Prefetch(p.LinkURL)
+	}
+}
+
 func (h *PostHandler) HomePage(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("home"))
@@ -30,28 +49,51 @@ func (h *PostHandler) GetPostPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
-	if ct := r.Header.Get("Content-Type"); ct != "" && (ct == "application/json" || ct[:16] == "application/json") {
-		var p entity.Post
-		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		// автор — владелец сессии или токена, а не author_id из тела
+		u, err := currentUser(r)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var in entity.Post
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 			http.Error(w, "bad json", http.StatusBadRequest)
 			return
 		}
+		p := entity.Post{
+			BoardID:   in.BoardID,
+			Title:     in.Title,
+			Content:   in.Content,
+			AuthorID:  u.ID,
+			ImageURL:  in.ImageURL,
+			LinkURL:   strings.TrimSpace(in.LinkURL),
+			Poll:      in.Poll,
+			PublishAt: in.PublishAt,
+		}
 		id, err := h.svc.CreatePost(r.Context(), &p)
+		if errors.Is(err, service.ErrKarmaTooLow) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		h.prefetchPreview(&p)
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{"id": id})
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": id, "status": p.Status})
 		return
 	}
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
 		http.Error(w, "bad form", http.StatusBadRequest)
 		return
 	}
+	r = r.WithContext(service.WithSpamSignals(r.Context(), service.SpamSignals{Honeypot: r.FormValue(utils.HoneypotField)}))
 	boardID, _ := strconv.ParseInt(r.FormValue("board_id"), 10, 64)
 	title := r.FormValue("title")
 	content := r.FormValue("content")
+	linkURL := strings.TrimSpace(r.FormValue("link_url"))
 	var imageData []byte
 	file, _, err := r.FormFile("image")
 	if err == nil && file != nil {
@@ -69,18 +111,46 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	poll, err := pollFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	publishAt, err := formTime(r.FormValue("publish_at"))
+	if err != nil {
+		http.Error(w, "bad publish time", http.StatusBadRequest)
+		return
+	}
 	p := &entity.Post{
 		BoardID:   boardID, // ✅ теперь int64
 		Title:     title,
 		Content:   content,
 		AuthorID:  u.ID, // тоже лучше хранить int64, как в entity.User
+		LinkURL:   linkURL,
 		ImageData: imageData,
+		Poll:      poll,
+		PublishAt: publishAt,
 	}
 	id, err := h.svc.CreatePost(r.Context(), p)
+	if errors.Is(err, service.ErrKarmaTooLow) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// пост создан из черновика — черновик больше не нужен
+	if draftID, _ := strconv.ParseInt(r.FormValue("draft_id"), 10, 64); draftID > 0 {
+		if err := h.svc.DeleteDraft(r.Context(), u.ID, draftID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			fmt.Println("delete draft:", err)
+		}
+	}
+	h.prefetchPreview(p)
+	if p.Status == entity.StatusScheduled {
+		http.Redirect(w, r, "/drafts?scheduled="+strconv.FormatInt(id, 10), http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/post/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
 }
 
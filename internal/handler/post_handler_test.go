@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/service"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type stubPostRepo struct {
+	repository.PostRepository
+	created []entity.Post
+}
+
+func (r *stubPostRepo) CreatePost(ctx context.Context, p *entity.Post) (int64, error) {
+	r.created = append(r.created, *p)
+	return int64(len(r.created)), nil
+}
+
+type stubKarmaRepo struct {
+	repository.KarmaRepository
+	karma map[int64]int
+}
+
+func (r *stubKarmaRepo) Totals(ctx context.Context, ids []int64) (map[int64]int, error) {
+	res := map[int64]int{}
+	for _, id := range ids {
+		if k, ok := r.karma[id]; ok {
+			res[id] = k
+		}
+	}
+	return res, nil
+}
+
+type stubUserRepo struct{ repository.UserRepository }
+
+func (stubUserRepo) GetUserByID(ctx context.Context, id int64) (*entity.User, error) {
+	return &entity.User{ID: id, Role: entity.RoleUser}, nil
+}
+
+// GetUserByName understands the names the tests give: "newbie" is user 1, "veteran" user 2
+func (stubUserRepo) GetUserByName(ctx context.Context, name string) (*entity.User, error) {
+	id := map[string]int64{"newbie": 1, "veteran": 2}[name]
+	return &entity.User{ID: id, Username: name, Role: entity.RoleUser}, nil
+}
+
+func TestCreatePostJSONLinkNeedsAuthorKarma(t *testing.T) {
+	const newbie, veteran = 1, 2
+	karma := service.NewKarmaService(&stubKarmaRepo{karma: map[int64]int{veteran: 100}}, stubUserRepo{}, service.DefaultKarmaConfig())
+
+	post := func(u *entity.User, body string) (*httptest.ResponseRecorder, *stubPostRepo) {
+		repo := &stubPostRepo{}
+		h := NewPostHandler(service.NewPostService(repo, service.WithPostKarma(karma)), stubUserRepo{})
+		r := httptest.NewRequest(http.MethodPost, "/api/post", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if u != nil {
+			r = withUser(r, u)
+		}
+		w := httptest.NewRecorder()
+		h.CreatePost(w, r)
+		return w, repo
+	}
+	// author_id в теле называет аккаунт с кармой — проверяться всё равно должен автор запроса
+	withLink := `{"board_id":1,"title":"t","content":"see https://example.com","author_id":2,"status":"published"}`
+
+	w, repo := post(&entity.User{ID: newbie, Username: "newbie"}, withLink)
+	if w.Code != http.StatusForbidden || len(repo.created) != 0 {
+		t.Fatalf("low-karma link post: got %d, %d stored; want 403 and nothing stored", w.Code, len(repo.created))
+	}
+
+	w, _ = post(nil, withLink)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous JSON post: got %d, want 401", w.Code)
+	}
+
+	w, repo = post(&entity.User{ID: veteran, Username: "veteran"}, strings.Replace(withLink, `"author_id":2`, `"author_id":1`, 1))
+	if w.Code != http.StatusOK || len(repo.created) != 1 {
+		t.Fatalf("veteran link post: got %d %q, want 200", w.Code, w.Body.String())
+	}
+	if got := repo.created[0]; got.AuthorID != veteran || got.Status != "" {
+		t.Fatalf("stored author %d status %q, want author %d and status chosen by the service", got.AuthorID, got.Status, veteran)
+	}
+}
+
+func TestCreatePostFormLinkNeedsAuthorKarma(t *testing.T) {
+	const veteran = 2
+	karma := service.NewKarmaService(&stubKarmaRepo{karma: map[int64]int{veteran: 100}}, stubUserRepo{}, service.DefaultKarmaConfig())
+
+	post := func(u *entity.User) (*httptest.ResponseRecorder, *stubPostRepo) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range map[string]string{"board_id": "1", "title": "t", "content": "c", "link_url": "https://example.com"} {
+			mw.WriteField(k, v)
+		}
+		mw.Close()
+		repo := &stubPostRepo{}
+		h := NewPostHandler(service.NewPostService(repo, service.WithPostKarma(karma)), stubUserRepo{})
+		r := httptest.NewRequest(http.MethodPost, "/post/create", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		h.CreatePost(w, withUser(r, u))
+		return w, repo
+	}
+
+	w, repo := post(&entity.User{ID: 1, Username: "newbie"})
+	if w.Code != http.StatusForbidden || len(repo.created) != 0 {
+		t.Fatalf("low-karma link post from the form: got %d, %d stored; want 403 and nothing stored", w.Code, len(repo.created))
+	}
+	w, repo = post(&entity.User{ID: veteran, Username: "veteran"})
+	if w.Code != http.StatusSeeOther || len(repo.created) != 1 || repo.created[0].AuthorID != veteran {
+		t.Fatalf("veteran link post from the form: got %d %q, want a redirect to the new post", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/ratelimit"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit makes the limiter available to Limited routes. It goes after AuthMiddleware,
+// so buckets can be keyed by user or token instead of IP.
+func RateLimit(l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimiterCtxKey, l)))
+		})
+	}
+}
+
+// Limited applies the named rate limit policy to a route. Buckets are per personal token, per user
+// or per IP for guests; moderators aren't limited. If the store fails, the request goes through.
+func Limited(policy string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, _ := r.Context().Value(rateLimiterCtxKey).(*ratelimit.Limiter)
+		if l == nil {
+			h(w, r)
+			return
+		}
+		var key string
+		var u *entity.User
+		if ta, ok := r.Context().Value(apiTokenCtxKey).(*tokenAuth); ok {
+			// у каждого токена свой бакет: скрипт не съедает лимит браузера владельца
+			u, key = ta.user, "t:"+strconv.FormatInt(ta.token.ID, 10)
+		} else if cu, err := currentUser(r); err == nil {
+			u, key = cu, "u:"+strconv.FormatInt(cu.ID, 10)
+		} else {
+			key = "ip:" + clientIP(r)
+		}
+		if u != nil && u.HasRole(entity.RoleModerator) {
+			h(w, r)
+			return
+		}
+		res, err := l.Allow(r.Context(), policy, key)
+		if err != nil {
+			fmt.Println("ratelimit:", err)
+			h(w, r)
+			return
+		}
+		if res.Limit > 0 {
+			// draft-ietf-httpapi-ratelimit-headers
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			w.Header().Set("RateLimit-Policy", res.Policy.String())
+		}
+		if !res.Allowed {
+			retry := ceilSeconds(res.RetryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			if acceptsJSON(r) || isJSON(r) || strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONStatus(w, http.StatusTooManyRequests, map[string]interface{}{
+					"error": "rate_limited", "policy": policy, "retry_after": retry,
+				})
+				return
+			}
+			http.Error(w, "Слишком часто, попробуйте через "+(time.Duration(retry)*time.Second).String(), http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitedKeysBucketsAndSetsHeaders(t *testing.T) {
+	l := ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Policy{"post": {Limit: 2, Window: time.Minute}})
+	h := RateLimit(l)(Limited("post", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	send := func(path, ip string, u *entity.User, token *entity.APIToken) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = ip + ":1234"
+		if u != nil {
+			r = withUser(r, u)
+		}
+		if token != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiTokenCtxKey, &tokenAuth{user: u, token: token}))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send("/api/post", "10.0.0.1", nil, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first guest request: %d", w.Code)
+	}
+	for header, want := range map[string]string{
+		"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "30", "RateLimit-Policy": "2;w=60",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: %q, want %q", header, got, want)
+		}
+	}
+	send("/api/post", "10.0.0.1", nil, nil)
+	w = send("/api/post", "10.0.0.1", nil, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("third guest request: %d, Retry-After %q; want 429 after 30s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(w.Body.String(), `"rate_limited"`) {
+		t.Fatalf("API 429 body: %q", w.Body.String())
+	}
+	if w := send("/post/create", "10.0.0.1", nil, nil); w.Code != http.StatusTooManyRequests || strings.Contains(w.Body.String(), "{") {
+		t.Fatalf("page 429: %d %q, want a plain text answer", w.Code, w.Body.String())
+	}
+	if w := send("/api/post", "10.0.0.2", nil, nil); w.Code != http.StatusCreated {
+		t.Fatalf("another IP: %d, want its own bucket", w.Code)
+	}
+
+	// у пользователя свой бакет, у каждого его токена — ещё один, независимо от IP
+	user := &entity.User{ID: 1, Username: "u", Role: entity.RoleUser}
+	for i := 0; i < 2; i++ {
+		if w := send("/api/post", "10.0.0.1", user, nil); w.Code != http.StatusCreated {
+			t.Fatalf("user request %d from a throttled IP: %d", i, w.Code)
+		}
+	}
+	if w := send("/api/post", "10.0.0.3", user, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("user over the limit from a new IP: %d, want 429", w.Code)
+	}
+	if w := send("/api/post", "10.0.0.3", user, &entity.APIToken{ID: 5}); w.Code != http.StatusCreated {
+		t.Fatalf("user's token: %d, want a bucket separate from the browser", w.Code)
+	}
+
+	mod := &entity.User{ID: 2, Username: "m", Role: entity.RoleModerator}
+	for i := 0; i < 5; i++ {
+		if w := send("/api/post", "10.0.0.1", mod, nil); w.Code != http.StatusCreated || w.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("moderator request %d: %d, want unlimited", i, w.Code)
+		}
+	}
+}
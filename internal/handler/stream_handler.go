@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"forum1/internal/pubsub"
+	"forum1/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStreamTopics limits how many topics one connection may listen to
+const maxStreamTopics = 20
+
+const streamHeartbeat = 25 * time.Second
+
+var errTopicNotFound = errors.New("topic not found")
+
+type StreamHandler struct {
+	hub   *pubsub.Hub
+	posts service.PostService
+}
+
+func NewStreamHandler(hub *pubsub.Hub) *StreamHandler {
+	return &StreamHandler{hub: hub}
+}
+
+// WithPosts hides events of posts the viewer can't open (pending, drafts, scheduled)
+func (h *StreamHandler) WithPosts(p service.PostService) *StreamHandler {
+	h.posts = p
+	return h
+}
+
+// Stream godoc
+// @Summary Live events (Server-Sent Events)
+// @Description topic=post:{id}, topic=board:{id} or topic=notifications (requires login); can be repeated
+// @Tags stream
+// @Produce text/event-stream
+// @Router /api/stream [get]
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	raw := r.URL.Query()["topic"]
+	if len(raw) == 0 || len(raw) > maxStreamTopics {
+		http.Error(w, "topic required", http.StatusBadRequest)
+		return
+	}
+	topics := make([]string, 0, len(raw))
+	for _, t := range raw {
+		topic, err := h.resolveTopic(r, t)
+		if err == errUnauthorized {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err == errTopicNotFound {
+			http.Error(w, "not found: "+t, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "bad topic: "+t, http.StatusBadRequest)
+			return
+		}
+		topics = append(topics, topic)
+	}
+
+	sub := h.hub.Subscribe(topics...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx не должен буферизовать поток
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Done():
+			return // сервер останавливается
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e := <-sub.Events():
+			fmt.Fprintf(w, "event: %s\ndata: {\"topic\":%q,\"data\":%s}\n\n", e.Type, e.Topic, e.Data)
+			flusher.Flush()
+		}
+	}
+}
+
+// resolveTopic validates a client topic and maps "notifications" to the caller's own user topic
+func (h *StreamHandler) resolveTopic(r *http.Request, t string) (string, error) {
+	if t == "notifications" {
+		u, err := currentUser(r)
+		if err != nil {
+			return "", errUnauthorized
+		}
+		return pubsub.UserTopic(u.ID), nil
+	}
+	kind, idStr, found := strings.Cut(t, ":")
+	if !found {
+		return "", fmt.Errorf("bad topic")
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		return "", fmt.Errorf("bad topic")
+	}
+	switch kind {
+	case "post":
+		// события поста видят те же, кто может открыть его страницу
+		if h.posts != nil {
+			post, err := h.posts.GetPostByID(r.Context(), id)
+			if err != nil || post == nil {
+				return "", errTopicNotFound
+			}
+			viewer, _ := currentUser(r)
+			if !canSee(post.Status, post.AuthorID, viewer) {
+				return "", errTopicNotFound
+			}
+		}
+		return pubsub.PostTopic(id), nil
+	case "board":
+		return pubsub.BoardTopic(id), nil
+	}
+	return "", fmt.Errorf("bad topic")
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/pubsub"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamPostTopicFollowsVisibility(t *testing.T) {
+	posts := stubPostService{posts: map[int64]*entity.Post{
+		1: {ID: 1, AuthorID: 7, Status: entity.StatusPublished},
+		2: {ID: 2, AuthorID: 7, Status: entity.StatusPending},
+		3: {ID: 3, AuthorID: 7, Status: entity.StatusDraft},
+		4: {ID: 4, AuthorID: 7, Status: entity.StatusScheduled},
+	}}
+	h := NewStreamHandler(pubsub.NewHub(pubsub.NewMemoryBackend())).WithPosts(posts)
+	for _, topic := range []string{"post:2", "post:3", "post:4", "post:99"} {
+		r := httptest.NewRequest(http.MethodGet, "/api/stream?topic="+topic, nil)
+		w := httptest.NewRecorder()
+		h.Stream(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("anonymous %s: %d, want 404", topic, w.Code)
+		}
+	}
+	// автор видит свой отложенный пост — поток открывается и живёт до отмены запроса
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/api/stream?topic=post:4&topic=post:1", nil).WithContext(ctx)
+	r = withUser(r, &entity.User{ID: 7, Role: entity.RoleUser})
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.Stream(w, r)
+		close(done)
+	}()
+	cancel()
+	<-done
+	if w.Code != http.StatusOK {
+		t.Fatalf("author's scheduled post: %d, want 200", w.Code)
+	}
+}
+
+func TestStreamEndsWhenHubStops(t *testing.T) {
+	hub := pubsub.NewHub(pubsub.NewMemoryBackend())
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := hub.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	h := NewStreamHandler(hub)
+	done := make(chan struct{})
+	go func() {
+		h.Stream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/stream?topic=board:1", nil))
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream kept running after the hub was stopped")
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SubscriptionHandler manages subscriptions to boards, clubs, threads and authors
+type SubscriptionHandler struct {
+	svc service.SubscriptionService
+}
+
+func NewSubscriptionHandler(svc service.SubscriptionService) *SubscriptionHandler {
+	return &SubscriptionHandler{svc: svc}
+}
+
+func subscriptionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "subscription target not found", http.StatusNotFound)
+	default:
+		moderationError(w, err)
+	}
+}
+
+// subscriptionInput is the body of POST and DELETE /api/subscriptions;
+// muted is only for threads and switches their notifications off and on
+type subscriptionInput struct {
+	Target string `json:"target"`
+	ID     int64  `json:"id"`
+	Muted  *bool  `json:"muted,omitempty"`
+}
+
+// GET /subscriptions
+func (h *SubscriptionHandler) Page(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	subs, err := h.svc.List(r.Context(), u)
+	if err != nil {
+		subscriptionError(w, err)
+		return
+	}
+	groups := map[string][]entity.Subscription{}
+	for _, s := range subs {
+		groups[s.Target] = append(groups[s.Target], s)
+	}
+	utils.RenderTemplate(w, "subscriptions_page.html", map[string]interface{}{
+		"User":    u,
+		"Targets": entity.SubscriptionTargets,
+		"Groups":  groups,
+	})
+}
+
+// POST /subscriptions — forms on board, club and post pages; action is subscribe, unsubscribe,
+// mute or unmute, next is where to go back to
+func (h *SubscriptionHandler) Form(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	target := r.FormValue("target")
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	switch r.FormValue("action") {
+	case "subscribe":
+		_, err = h.svc.Subscribe(r.Context(), u, target, id)
+	case "unsubscribe":
+		err = h.svc.Unsubscribe(r.Context(), u, target, id)
+	case "mute", "unmute":
+		_, err = h.svc.MuteThread(r.Context(), u, id, r.FormValue("action") == "mute")
+	default:
+		err = service.ErrInvalidInput
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		subscriptionError(w, err)
+		return
+	}
+	http.Redirect(w, r, localNext(r.FormValue("next"), "/subscriptions"), http.StatusSeeOther)
+}
+
+// localNext is where a form goes back to: next if it is a path on this site, otherwise fallback
+func localNext(next, fallback string) string {
+	// //host и /\host браузер понимает как другой сайт
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
+
+// GET /api/subscriptions
+func (h *SubscriptionHandler) List(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	subs, err := h.svc.List(r.Context(), u)
+	if err != nil {
+		subscriptionError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, subs)
+}
+
+// POST /api/subscriptions {"target": "board|club|post|user", "id": n, "muted": bool}
+func (h *SubscriptionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var in subscriptionInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	var sub *entity.Subscription
+	if in.Muted != nil {
+		if in.Target != entity.SubscribePost {
+			http.Error(w, "only threads can be muted", http.StatusBadRequest)
+			return
+		}
+		sub, err = h.svc.MuteThread(r.Context(), u, in.ID, *in.Muted)
+	} else {
+		sub, err = h.svc.Subscribe(r.Context(), u, in.Target, in.ID)
+	}
+	if err != nil {
+		subscriptionError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, sub)
+}
+
+// DELETE /api/subscriptions {"target", "id"} or ?target=&id=
+func (h *SubscriptionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	in := subscriptionInput{Target: r.URL.Query().Get("target")}
+	if v := r.URL.Query().Get("id"); v != "" {
+		if in.ID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Unsubscribe(r.Context(), u, in.Target, in.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not subscribed", http.StatusNotFound)
+			return
+		}
+		subscriptionError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"forum1/internal/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ThreadHandler lets moderators pin, lock and archive threads
+type ThreadHandler struct {
+	svc service.ThreadService
+}
+
+func NewThreadHandler(svc service.ThreadService) *ThreadHandler {
+	return &ThreadHandler{svc: svc}
+}
+
+// POST /post/{id}/{action} (form from the post page) and /api/post/{id}/{action};
+// action is pin, unpin, lock, unlock, archive or unarchive. pin takes an optional position
+// (form field or {"position": n}); without it the post goes after the already pinned ones.
+func (h *ThreadHandler) Apply(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	var position int
+	if isJSON(r) {
+		var body struct {
+			Position int `json:"position"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "bad json", http.StatusBadRequest)
+				return
+			}
+		}
+		position = body.Position
+	} else if v := r.FormValue("position"); v != "" {
+		if position, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "bad position", http.StatusBadRequest)
+			return
+		}
+	}
+	post, err := h.svc.Apply(r.Context(), u, id, mux.Vars(r)["action"], position)
+	if err != nil {
+		moderationError(w, err)
+		return
+	}
+	if moderationAPI(r) {
+		writeJSONStatus(w, http.StatusOK, post)
+		return
+	}
+	http.Redirect(w, r, "/post/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+}
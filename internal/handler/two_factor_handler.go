@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/service"
+	"forum1/utils"
+	"net/http"
+	"strings"
+)
+
+// twoFactorOpenPaths stay reachable for a user who must enroll in 2FA but hasn't yet
+var twoFactorOpenPaths = []string{"/settings/security", "/api/2fa", "/logout", "/login", "/static/"}
+
+type TwoFactorHandler struct {
+	svc  service.TwoFactorService
+	oidc service.OIDCService
+}
+
+func NewTwoFactorHandler(svc service.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{svc: svc}
+}
+
+// WithIdentities shows linked SSO accounts on the security page
+func (h *TwoFactorHandler) WithIdentities(o service.OIDCService) *TwoFactorHandler {
+	h.oidc = o
+	return h
+}
+
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrInvalidCode), errors.Is(err, service.ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrTwoFactorRequired):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrTwoFactorEnabled), errors.Is(err, service.ErrTwoFactorNotActive):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func twoFactorErrorText(err error) string {
+	switch {
+	case errors.Is(err, service.ErrRateLimited):
+		return "Слишком много попыток, попробуйте позже."
+	case errors.Is(err, service.ErrInvalidCode):
+		return "Неверный код."
+	case errors.Is(err, service.ErrInvalidToken):
+		return "Время на ввод кода истекло, войдите заново."
+	}
+	return "Что-то пошло не так, попробуйте позже."
+}
+
+// TwoFactorPolicy makes users whose role requires 2FA enroll before doing anything else.
+// Must run after SessionMiddleware.
+func TwoFactorPolicy(svc service.TwoFactorService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, err := currentUser(r)
+			if err != nil || u.TwoFactorEnabled() || !svc.Required(u) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, p := range twoFactorOpenPaths {
+				if strings.HasPrefix(r.URL.Path, p) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if r.Method == http.MethodGet && !acceptsJSON(r) {
+				http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "two-factor authentication required", http.StatusForbidden)
+		})
+	}
+}
+
+// GET /settings/security
+func (h *TwoFactorHandler) SecurityPage(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	st, err := h.svc.Status(r.Context(), u)
+	if err != nil {
+		http.Error(w, "2fa status error", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"User":   u,
+		"Status": st,
+	}
+	if h.oidc != nil && h.oidc.Enabled() {
+		ids, err := h.oidc.Identities(r.Context(), u.ID)
+		if err != nil {
+			http.Error(w, "identities error", http.StatusInternalServerError)
+			return
+		}
+		data["OIDCName"] = h.oidc.Name()
+		data["Identities"] = ids
+	}
+	utils.RenderTemplate(w, "security_page.html", data)
+}
+
+// GET /api/2fa
+func (h *TwoFactorHandler) Status(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	st, err := h.svc.Status(r.Context(), u)
+	if err != nil {
+		http.Error(w, "2fa status error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, st)
+}
+
+// POST /api/2fa/enroll — returns a new secret and the otpauth:// URI
+func (h *TwoFactorHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	e, err := h.svc.BeginEnroll(r.Context(), u)
+	if err != nil {
+		writeJSONStatus(w, twoFactorErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, e)
+}
+
+// POST /api/2fa/confirm {"code"} — enables 2FA, the response holds the recovery codes
+func (h *TwoFactorHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	h.withCode(w, r, h.svc.ConfirmEnroll)
+}
+
+// POST /api/2fa/recovery-codes {"code"} — replaces the recovery codes
+func (h *TwoFactorHandler) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	h.withCode(w, r, h.svc.RegenerateRecoveryCodes)
+}
+
+// POST /api/2fa/disable {"code"} — TOTP or recovery code
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+	if err := h.svc.Disable(r.Context(), u, code); err != nil {
+		writeJSONStatus(w, twoFactorErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// POST /api/2fa/devices/forget — every remembered browser will ask for a code again
+func (h *TwoFactorHandler) ForgetDevices(w http.ResponseWriter, r *http.Request) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := h.svc.ForgetDevices(r.Context(), u.ID); err != nil {
+		http.Error(w, "forget devices error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *TwoFactorHandler) withCode(w http.ResponseWriter, r *http.Request,
+	fn func(ctx context.Context, u *entity.User, code string) ([]string, error)) {
+	u, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+	codes, err := fn(r.Context(), u, code)
+	if err != nil {
+		writeJSONStatus(w, twoFactorErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, map[string]any{"status": "ok", "recovery_codes": codes})
+}
+
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return "", false
+	}
+	return in.Code, true
+}
@@ -1,19 +1,64 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/mail"
 	"forum1/internal/service"
+	"forum1/utils"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
 )
 
+// trustedDeviceCookie remembers a browser that passed the second factor; only login needs it
+const trustedDeviceCookie = "trusted_device"
+
 type UserHandler struct {
-	service service.UserService
+	service   service.UserService
+	sessions  service.SessionService
+	twoFactor service.TwoFactorService
+	oidc      service.OIDCService
+	guard     service.LoginGuard
+	captcha   CaptchaWidget
+}
+
+// CaptchaWidget is the front-end part of the CAPTCHA shown on the login page to suspicious sources
+type CaptchaWidget struct {
+	ScriptURL string // https://js.hcaptcha.com/1/api.js
+	Class     string // h-captcha, g-recaptcha, cf-turnstile
+	SiteKey   string
 }
 
 func NewUserHandler(s service.UserService) *UserHandler {
 	return &UserHandler{service: s}
 }
 
+func (h *UserHandler) WithSessions(s service.SessionService) *UserHandler {
+	h.sessions = s
+	return h
+}
+
+func (h *UserHandler) WithTwoFactor(tf service.TwoFactorService) *UserHandler {
+	h.twoFactor = tf
+	return h
+}
+
+func (h *UserHandler) WithOIDC(o service.OIDCService) *UserHandler {
+	h.oidc = o
+	return h
+}
+
+// WithLoginGuard throttles password attempts; the widget is rendered when the guard asks for a CAPTCHA
+func (h *UserHandler) WithLoginGuard(g service.LoginGuard, captcha CaptchaWidget) *UserHandler {
+	h.guard, h.captcha = g, captcha
+	return h
+}
+
 func (h *UserHandler) RegisterPage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.NotFound(w, r)
@@ -22,7 +67,12 @@ func (h *UserHandler) RegisterPage(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	email := r.FormValue("email")
 	password := r.FormValue("password")
-	_, err := h.service.Register(r.Context(), username, email, password)
+	locale := mail.PreferredLang(r.Header.Get("Accept-Language"))
+	_, err := h.service.Register(r.Context(), username, email, password, locale)
+	if err == service.ErrWeakPassword {
+		http.Error(w, "Пароль должен быть не короче 8 символов", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Ошибка регистрации", http.StatusBadRequest)
 		return
@@ -37,15 +87,182 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	ip := clientIP(r)
+	if h.guard != nil {
+		if err := h.guard.Check(r.Context(), username, ip, captchaResponse(r)); err != nil {
+			h.loginRejected(w, r, err)
+			return
+		}
+	}
 	u, err := h.service.Login(r.Context(), username, password)
 	if err != nil {
+		if h.guard != nil && errors.Is(err, service.ErrInvalidCredentials) {
+			h.guard.Failed(r.Context(), username, ip, r.UserAgent())
+		}
 		http.Error(w, "Неверные данные", http.StatusUnauthorized)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{Name: "user", Value: u.Username, Path: "/"})
+	if h.guard != nil {
+		h.guard.Succeeded(r.Context(), u, ip, r.UserAgent())
+	}
+	h.finishLogin(w, r, u, "/")
+}
+
+// finishLogin runs the steps after the first factor: the TOTP prompt when the user has 2FA and
+// the browser isn't trusted, otherwise a new session and a redirect to next
+func (h *UserHandler) finishLogin(w http.ResponseWriter, r *http.Request, u *entity.User, next string) {
+	if h.twoFactor != nil && u.TwoFactorEnabled() && !h.isTrustedDevice(r, u.ID) {
+		challenge, err := h.twoFactor.StartLogin(r.Context(), u)
+		if err != nil {
+			http.Error(w, "Ошибка входа", http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("Accept") == "application/json" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "2fa_required", "challenge": challenge})
+			return
+		}
+		utils.RenderTemplate(w, "login_2fa.html", map[string]interface{}{"Challenge": challenge})
+		return
+	}
+	if !h.startSession(w, r, u.ID) {
+		return
+	}
 	if r.Header.Get("Accept") == "application/json" {
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 		return
 	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// POST /login/2fa (form) and /api/login/2fa (JSON {"challenge", "code", "remember"}) — second login step
+func (h *UserHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+		Remember  bool   `json:"remember"`
+	}
+	jsonBody := isJSON(r)
+	if jsonBody {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		in.Challenge, in.Code, in.Remember = r.FormValue("challenge"), r.FormValue("code"), r.FormValue("remember") != ""
+	}
+	u, err := h.twoFactor.CompleteLogin(r.Context(), in.Challenge, in.Code, clientIP(r))
+	if err != nil {
+		status := twoFactorErrorStatus(err)
+		if jsonBody {
+			writeJSONStatus(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		data := map[string]interface{}{"Error": twoFactorErrorText(err)}
+		if errors.Is(err, service.ErrInvalidCode) {
+			data["Challenge"] = in.Challenge // можно ввести код ещё раз
+		}
+		w.WriteHeader(status)
+		utils.RenderTemplate(w, "login_2fa.html", data)
+		return
+	}
+	if in.Remember {
+		if token, expires, err := h.twoFactor.TrustDevice(r.Context(), u.ID, r.UserAgent()); err != nil {
+			fmt.Println("trust device:", err)
+		} else {
+			http.SetCookie(w, &http.Cookie{
+				Name: trustedDeviceCookie, Value: token, Path: "/login", Expires: expires,
+				HttpOnly: true, Secure: secureCookies(r), SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	if !h.startSession(w, r, u.ID) {
+		return
+	}
+	if jsonBody {
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *UserHandler) isTrustedDevice(r *http.Request, userID int64) bool {
+	c, err := r.Cookie(trustedDeviceCookie)
+	return err == nil && h.twoFactor.IsTrustedDevice(r.Context(), userID, c.Value)
+}
+
+// startSession logs the user in; on failure it has already written the error
+func (h *UserHandler) startSession(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	token, sess, err := h.sessions.Create(r.Context(), userID, clientIP(r), r.UserAgent())
+	if err != nil {
+		http.Error(w, "Ошибка входа", http.StatusInternalServerError)
+		return false
+	}
+	setSessionCookie(w, r, token, sess.ExpiresAt)
+	return true
+}
+
+// POST /logout — ends the current session only
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(SessionCookie); err == nil && c.Value != "" {
+		_ = h.sessions.Revoke(r.Context(), c.Value)
+	}
+	clearSessionCookie(w, r)
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// GET /api/users/autocomplete?q= — подсказки для @упоминаний
+func (h *UserHandler) Autocomplete(w http.ResponseWriter, r *http.Request) {
+	users, err := h.service.Autocomplete(r.Context(), r.URL.Query().Get("q"), 10)
+	if err != nil {
+		http.Error(w, "autocomplete error", http.StatusInternalServerError)
+		return
+	}
+	type item struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	}
+	res := make([]item, 0, len(users))
+	for _, u := range users {
+		res = append(res, item{ID: u.ID, Username: u.Username})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// POST /api/admin/users/{id}/role {"role": "moderator"} — admins only
+func (h *UserHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	actor, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	var in struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	switch err := h.service.SetRole(r.Context(), actor, id, in.Role); {
+	case errors.Is(err, service.ErrForbidden):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidInput):
+		http.Error(w, "bad role", http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, "set role error", http.StatusInternalServerError)
+	default:
+		writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok", "role": in.Role})
+	}
+}
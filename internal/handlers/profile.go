@@ -12,7 +12,7 @@ import (
 func ProfilePage(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("user")
 	if err != nil {
-		http.Redirect(w, r, "/login_page/", http.StatusSeeOther)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
@@ -23,7 +23,7 @@ func ProfilePage(w http.ResponseWriter, r *http.Request) {
 	err = db.DB.QueryRow("SELECT id, username, email, password FROM users WHERE username=$1", username).
 		Scan(&user.ID, &user.Username, &user.Email, &user.Password)
 	if err == sql.ErrNoRows {
-		http.Redirect(w, r, "/login_page/", http.StatusSeeOther)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	} else if err != nil {
 		http.Error(w, "Ошибка загрузки профиля: "+err.Error(), http.StatusInternalServerError)
@@ -55,7 +55,7 @@ func ProfilePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RenderTemplate(w, "profile_page.html", user)
+	utils.RenderTemplate(w, "profile_page.html", map[string]interface{}{"UserID": user.ID, "Username": user.Username, "Email": user.Email, "Own": true})
 }
 
 // Страница редактирования профиля
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	cases := []struct {
+		spec, from, want string
+	}{
+		{"@hourly", "2025-03-10 14:00:00", "2025-03-10 15:00:00"},
+		{"@daily", "2025-03-10 14:20:00", "2025-03-11 00:00:00"},
+		{"*/15 * * * *", "2025-03-10 14:07:30", "2025-03-10 14:15:00"},
+		{"30 3 * * 1-5", "2025-03-14 04:00:00", "2025-03-17 03:30:00"}, // пятница → понедельник
+		{"0 0 * * 7", "2025-03-10 00:00:00", "2025-03-16 00:00:00"},    // 7 — тоже воскресенье
+		{"0 0 31 * *", "2025-04-01 00:00:00", "2025-05-31 00:00:00"},
+		{"0 12 1 * 0", "2025-06-02 00:00:00", "2025-06-08 12:00:00"}, // день месяца или недели
+		{"@every 10m", "2025-03-10 14:07:00", "2025-03-10 14:10:00"},
+	}
+	for _, c := range cases {
+		s, err := parseSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", c.spec, err)
+		}
+		if got := s.Next(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q after %s: %s, want %s", c.spec, c.from, got.Format(time.DateTime), c.want)
+		}
+	}
+
+	// тик строго после t: из самого тика расписание идёт дальше, иначе cron поставил бы его дважды
+	s, _ := parseSchedule("@every 1m")
+	tick := at("2025-03-10 14:07:00")
+	if next := s.Next(tick); !next.Equal(tick.Add(time.Minute)) {
+		t.Fatalf("Next of a tick is %s", next)
+	}
+	never, _ := parseSchedule("0 0 30 2 *")
+	if next := never.Next(tick); !next.IsZero() {
+		t.Fatalf("30 February came at %s", next)
+	}
+}
+
+func TestParseScheduleRejectsBadSpecs(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "@every 10ms", "@yearly"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	r := &Runner{cfg: Config{Backoff: 10 * time.Second, MaxBackoff: time.Minute}}
+	for attempt, base := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: time.Minute, 9: time.Minute} {
+		for i := 0; i < 20; i++ {
+			d := r.backoff(attempt)
+			if d < base*8/10 || d > base*12/10 {
+				t.Fatalf("backoff after attempt %d: %v, want %v ±20%%", attempt, d, base)
+			}
+		}
+	}
+}
+
+func TestParseQueues(t *testing.T) {
+	queues := map[string]int{DefaultQueue: 4}
+	if err := ParseQueues(" default=2, mail=3 ,", queues); err != nil {
+		t.Fatal(err)
+	}
+	if queues[DefaultQueue] != 2 || queues["mail"] != 3 {
+		t.Fatalf("got %v", queues)
+	}
+	for _, spec := range []string{"mail", "mail=0", "=2", "mail=x"} {
+		if err := ParseQueues(spec, map[string]int{}); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package jobs is a durable background job queue in PostgreSQL. Workers claim jobs with
+// SELECT ... FOR UPDATE SKIP LOCKED, so any number of instances can work one queue without
+// taking a job twice. Failed jobs are retried with exponential backoff and end up dead after
+// their last attempt, where an admin can retry or drop them.
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusPending = "pending" // ждёт своего run_at
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusDead    = "dead" // попытки кончились, ждёт человека
+)
+
+const DefaultQueue = "default"
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Queue       string          `json:"queue"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	UniqueKey   string          `json:"unique_key,omitempty"` // второй такой же ключ не ставится в очередь
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// QueueStats is the state of one queue for the admin page
+type QueueStats struct {
+	Queue     string     `json:"queue"`
+	Ready     int        `json:"ready"`     // pending, run_at уже наступил
+	Scheduled int        `json:"scheduled"` // pending на будущее, в том числе повторы
+	Running   int        `json:"running"`
+	Dead      int        `json:"dead"`
+	DoneDay   int        `json:"done_day"`         // выполнено за сутки
+	OldestRun *time.Time `json:"oldest,omitempty"` // run_at самой старой готовой задачи: насколько очередь отстаёт
+	Workers   int        `json:"workers"`
+}
+
+// Options of a job kind, given with its handler
+type Options struct {
+	Queue       string        // DefaultQueue, если пусто
+	MaxAttempts int           // 5
+	Timeout     time.Duration // на одну попытку, 5 минут
+}
+
+func (o Options) withDefaults() Options {
+	if o.Queue == "" {
+		o.Queue = DefaultQueue
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Minute
+	}
+	return o
+}
+
+type Config struct {
+	Queues          map[string]int // воркеров на очередь; очереди без записи получают одного
+	PollInterval    time.Duration  // как часто спрашивать базу, когда новых задач не ставили
+	Backoff         time.Duration  // задержка перед первым повтором, дальше удваивается…
+	MaxBackoff      time.Duration  // …но не больше этого
+	StaleAfter      time.Duration  // running дольше — инстанс умер, задачу вернуть; больше любого Timeout
+	ShutdownTimeout time.Duration  // сколько ждать выполняющиеся задачи при остановке
+	Retention       time.Duration  // сколько хранить выполненные задачи
+	Worker          string         // имя инстанса в locked_by
+}
+
+func DefaultConfig() Config {
+	host, _ := os.Hostname()
+	return Config{
+		Queues:          map[string]int{DefaultQueue: 4},
+		PollInterval:    time.Second,
+		Backoff:         10 * time.Second,
+		MaxBackoff:      time.Hour,
+		StaleAfter:      15 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		Retention:       7 * 24 * time.Hour,
+		Worker:          fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}
+}
+
+// ParseQueues reads "default=4,mail=2" (the JOB_QUEUES variable) into queues
+func ParseQueues(spec string, queues map[string]int) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, n, ok := strings.Cut(part, "=")
+		workers, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || err != nil || workers <= 0 || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("bad queue %q, want name=workers", part)
+		}
+		queues[strings.TrimSpace(name)] = workers
+	}
+	return nil
+}
+
+// EnqueueOption adjusts one enqueued job
+type EnqueueOption func(j *Job)
+
+// At runs the job not before t
+func At(t time.Time) EnqueueOption {
+	return func(j *Job) { j.RunAt = t }
+}
+
+// After runs the job not before d from now
+func After(d time.Duration) EnqueueOption {
+	return func(j *Job) { j.RunAt = time.Now().Add(d) }
+}
+
+// Unique skips the job when one with the same key was already enqueued (until it is purged or dead)
+func Unique(key string) EnqueueOption {
+	return func(j *Job) { j.UniqueKey = key }
+}
+
+// ErrUnknownKind is returned by Enqueue for a kind without a registered handler
+var ErrUnknownKind = errors.New("unknown job kind")
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error that retrying will not fix: the job goes dead at once
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
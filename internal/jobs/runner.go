@@ -0,0 +1,417 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HandlerFunc runs one job. A returned error retries it later, unless it is Permanent.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+type kind struct {
+	handler HandlerFunc
+	opts    Options
+}
+
+type cronEntry struct {
+	kind    string
+	sched   *schedule
+	payload json.RawMessage
+}
+
+// Runner enqueues jobs and, while Run is going, works them off
+type Runner struct {
+	store *store
+	cfg   Config
+
+	mu    sync.RWMutex
+	kinds map[string]kind
+	crons []cronEntry
+	wake  map[string]chan struct{} // Enqueue будит воркеры своего инстанса, не дожидаясь опроса
+}
+
+func New(db *sql.DB, cfg Config) *Runner {
+	def := DefaultConfig()
+	if cfg.Queues == nil {
+		cfg.Queues = def.Queues
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = def.PollInterval
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = def.Backoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = def.MaxBackoff
+	}
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = def.StaleAfter
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = def.ShutdownTimeout
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = def.Retention
+	}
+	if cfg.Worker == "" {
+		cfg.Worker = def.Worker
+	}
+	return &Runner{store: &store{db: db}, cfg: cfg, kinds: map[string]kind{}, wake: map[string]chan struct{}{}}
+}
+
+// Register sets the handler of a job kind. Call it before Run.
+func (r *Runner) Register(name string, opts Options, h HandlerFunc) {
+	opts = opts.withDefaults()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kinds[name] = kind{handler: h, opts: opts}
+	if _, ok := r.wake[opts.Queue]; !ok {
+		r.wake[opts.Queue] = make(chan struct{}, 1)
+	}
+}
+
+// Handle registers a handler taking the job payload decoded into T.
+// A payload that does not decode fails the job permanently.
+func Handle[T any](r *Runner, name string, opts Options, fn func(ctx context.Context, payload T) error) {
+	r.Register(name, opts, func(ctx context.Context, job *Job) error {
+		var p T
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return Permanent(fmt.Errorf("decode payload: %w", err))
+		}
+		return fn(ctx, p)
+	})
+}
+
+// Cron enqueues a job of kind on every tick of spec: five cron fields, @hourly, @daily,
+// @weekly, @monthly or "@every 10m". Тик ставится с уникальным ключом, так что при нескольких
+// инстансах задача выполняется один раз; тики, пока ни один инстанс не работал, пропускаются.
+func (r *Runner) Cron(spec, name string, payload any) error {
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.kinds[name]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKind, name)
+	}
+	r.crons = append(r.crons, cronEntry{kind: name, sched: sched, payload: data})
+	return nil
+}
+
+// Enqueue stores a job of a registered kind. The id is 0 when Unique skipped it as a duplicate.
+func (r *Runner) Enqueue(ctx context.Context, name string, payload any, opts ...EnqueueOption) (int64, error) {
+	j, wake, err := r.job(name, payload, opts)
+	if err != nil {
+		return 0, err
+	}
+	id, err := r.store.insert(ctx, r.store.db, j)
+	if err == nil && id != 0 && !j.RunAt.After(time.Now()) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return id, err
+}
+
+// EnqueueTx is Enqueue inside tx: the job exists only if tx commits, so it cannot be lost
+// or run ahead of the change that caused it. Воркеры увидят задачу при следующем опросе.
+func (r *Runner) EnqueueTx(ctx context.Context, tx *sql.Tx, name string, payload any, opts ...EnqueueOption) (int64, error) {
+	j, _, err := r.job(name, payload, opts)
+	if err != nil {
+		return 0, err
+	}
+	return r.store.insert(ctx, tx, j)
+}
+
+// job builds a job of a registered kind together with the wake channel of its queue
+func (r *Runner) job(name string, payload any, opts []EnqueueOption) (*Job, chan struct{}, error) {
+	r.mu.RLock()
+	k, ok := r.kinds[name]
+	wake := r.wake[k.opts.Queue]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownKind, name)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	j := &Job{Queue: k.opts.Queue, Kind: name, Payload: data, MaxAttempts: k.opts.MaxAttempts, RunAt: time.Now()}
+	for _, o := range opts {
+		o(j)
+	}
+	return j, wake, nil
+}
+
+// Run works the queues until ctx is cancelled, then stops claiming and waits up to
+// ShutdownTimeout for running jobs; those still running after it are cancelled and retried later.
+func (r *Runner) Run(ctx context.Context) {
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	var running sync.WaitGroup
+
+	var loops sync.WaitGroup
+	for queue := range r.queues() {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			r.work(ctx, jobCtx, queue, &running)
+		}()
+	}
+	loops.Add(2)
+	go func() {
+		defer loops.Done()
+		r.cron(ctx)
+	}()
+	go func() {
+		defer loops.Done()
+		r.maintain(ctx)
+	}()
+	loops.Wait()
+
+	finished := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(r.cfg.ShutdownTimeout):
+		fmt.Println("jobs: shutdown timeout, cancelling running jobs")
+		cancelJobs()
+		<-finished
+	}
+}
+
+// queues are the registered queues with their worker counts
+func (r *Runner) queues() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := map[string]int{}
+	for queue := range r.wake {
+		n := r.cfg.Queues[queue]
+		if n <= 0 {
+			n = 1
+		}
+		res[queue] = n
+	}
+	return res
+}
+
+// work claims jobs of one queue while it has free workers
+func (r *Runner) work(ctx, jobCtx context.Context, queue string, running *sync.WaitGroup) {
+	workers := r.queues()[queue]
+	r.mu.RLock()
+	wake := r.wake[queue]
+	r.mu.RUnlock()
+	slots := make(chan struct{}, workers)
+	freed := make(chan struct{}, 1)
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if free := workers - len(slots); free > 0 {
+			claimed, err := r.store.claim(ctx, queue, r.cfg.Worker, free)
+			if err != nil && ctx.Err() == nil {
+				fmt.Println("jobs: claim", queue+":", err)
+			}
+			for i := range claimed {
+				slots <- struct{}{}
+				running.Add(1)
+				go func(j *Job) {
+					defer running.Done()
+					r.execute(jobCtx, j)
+					<-slots
+					select {
+					case freed <- struct{}{}:
+					default:
+					}
+				}(&claimed[i])
+			}
+			if len(claimed) == free {
+				continue // очередь, похоже, длиннее — берём ещё, как только освободится воркер
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		case <-freed:
+		}
+	}
+}
+
+func (r *Runner) execute(ctx context.Context, j *Job) {
+	r.mu.RLock()
+	k, ok := r.kinds[j.Kind]
+	r.mu.RUnlock()
+	var err error
+	if !ok {
+		// при выкатке задачу может взять инстанс старой версии — пусть повторит позже
+		err = fmt.Errorf("%w %q", ErrUnknownKind, j.Kind)
+	} else {
+		runCtx, cancel := context.WithTimeout(ctx, k.opts.Timeout)
+		err = call(runCtx, k.handler, j)
+		cancel()
+	}
+
+	// ctx при остановке уже может быть отменён, а результат записать нужно
+	saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err == nil {
+		if err := r.store.complete(saveCtx, j, r.cfg.Worker); errors.Is(err, errLeaseLost) {
+			fmt.Printf("jobs: %s #%d finished after its lease was lost, result dropped\n", j.Kind, j.ID)
+		} else if err != nil {
+			fmt.Println("jobs: complete", j.ID, err)
+		}
+		return
+	}
+	var retryAt time.Time
+	if !isPermanent(err) && j.Attempts < j.MaxAttempts {
+		retryAt = time.Now().Add(r.backoff(j.Attempts))
+	}
+	if retryAt.IsZero() {
+		fmt.Printf("jobs: %s #%d is dead after %d attempts: %v\n", j.Kind, j.ID, j.Attempts, err)
+	} else {
+		fmt.Printf("jobs: %s #%d failed (attempt %d of %d): %v\n", j.Kind, j.ID, j.Attempts, j.MaxAttempts, err)
+	}
+	if err := r.store.fail(saveCtx, j, r.cfg.Worker, err.Error(), retryAt); errors.Is(err, errLeaseLost) {
+		fmt.Printf("jobs: %s #%d failed after its lease was lost, result dropped\n", j.Kind, j.ID)
+	} else if err != nil {
+		fmt.Println("jobs: fail", j.ID, err)
+	}
+}
+
+// call runs the handler turning a panic into an error, so one bad job does not take the process down
+func call(ctx context.Context, h HandlerFunc, j *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h(ctx, j)
+}
+
+// backoff after the given attempt: Backoff·2^(attempt-1) up to MaxBackoff, ±20% so that
+// jobs failed together are not retried together
+func (r *Runner) backoff(attempt int) time.Duration {
+	d := r.cfg.Backoff
+	for i := 1; i < attempt && d < r.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, r.cfg.MaxBackoff)
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// cron enqueues the ticks of scheduled jobs
+func (r *Runner) cron(ctx context.Context) {
+	r.mu.RLock()
+	entries := append([]cronEntry(nil), r.crons...)
+	r.mu.RUnlock()
+	if len(entries) == 0 {
+		return
+	}
+	next := make([]time.Time, len(entries))
+	now := time.Now()
+	for i, e := range entries {
+		next[i] = e.sched.Next(now)
+	}
+	for {
+		i := -1
+		for k := range next {
+			// нулевое время — расписание, которое не наступит никогда
+			if !next[k].IsZero() && (i < 0 || next[k].Before(next[i])) {
+				i = k
+			}
+		}
+		if i < 0 {
+			return
+		}
+		timer := time.NewTimer(time.Until(next[i]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		e, tick := entries[i], next[i]
+		key := fmt.Sprintf("cron:%s:%d", e.kind, tick.Unix())
+		if _, err := r.Enqueue(ctx, e.kind, e.payload, At(tick), Unique(key)); err != nil && ctx.Err() == nil {
+			fmt.Println("jobs: cron", e.kind+":", err)
+		}
+		next[i] = e.sched.Next(tick)
+	}
+}
+
+// maintain returns jobs of dead instances and purges old finished ones
+func (r *Runner) maintain(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n, err := r.store.reclaim(ctx, r.cfg.StaleAfter); err != nil {
+			fmt.Println("jobs: reclaim:", err)
+		} else if n > 0 {
+			fmt.Println("jobs: returned", n, "jobs of lost workers")
+		}
+		if _, err := r.store.purge(ctx, r.cfg.Retention); err != nil {
+			fmt.Println("jobs: purge:", err)
+		}
+	}
+}
+
+// Stats is the state of every queue that has jobs or workers
+func (r *Runner) Stats(ctx context.Context) ([]QueueStats, error) {
+	stats, err := r.store.stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queues := r.queues()
+	seen := map[string]bool{}
+	for i := range stats {
+		stats[i].Workers = queues[stats[i].Queue]
+		seen[stats[i].Queue] = true
+	}
+	for queue, n := range queues {
+		if !seen[queue] {
+			stats = append(stats, QueueStats{Queue: queue, Workers: n})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Queue < stats[j].Queue })
+	return stats, nil
+}
+
+// Failed lists dead jobs and ones waiting for a retry, newest first
+func (r *Runner) Failed(ctx context.Context, limit int) ([]Job, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return r.store.failed(ctx, limit)
+}
+
+// Retry runs a dead or waiting job now with fresh attempts; sql.ErrNoRows if there is none
+func (r *Runner) Retry(ctx context.Context, id int64) error {
+	return r.store.retry(ctx, id)
+}
+
+// Delete drops a job that is not running; sql.ErrNoRows if there is none
+func (r *Runner) Delete(ctx context.Context, id int64) error {
+	return r.store.remove(ctx, id)
+}
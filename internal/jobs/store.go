@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// store is the jobs table
+type store struct {
+	db *sql.DB
+}
+
+const jobColumns = `id, queue, kind, payload, status, attempts, max_attempts, run_at,
+    COALESCE(unique_key, ''), last_error, created_at, updated_at`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var j Job
+	var payload []byte
+	err := row.Scan(&j.ID, &j.Queue, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+		&j.UniqueKey, &j.LastError, &j.CreatedAt, &j.UpdatedAt)
+	j.Payload = payload
+	return &j, err
+}
+
+func scanJobs(rows *sql.Rows, err error) ([]Job, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *j)
+	}
+	return res, rows.Err()
+}
+
+// querier is *sql.DB or *sql.Tx
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insert returns id 0 when a job with the same unique key already exists
+func (s *store) insert(ctx context.Context, q querier, j *Job) (int64, error) {
+	var id int64
+	err := q.QueryRowContext(ctx, `
+        INSERT INTO jobs (queue, kind, payload, max_attempts, run_at, unique_key)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+        ON CONFLICT (unique_key) DO NOTHING
+        RETURNING id`,
+		j.Queue, j.Kind, []byte(j.Payload), j.MaxAttempts, j.RunAt, j.UniqueKey).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// claim takes up to limit ready jobs of the queue. Строки, которые сейчас забирает другой
+// инстанс, SKIP LOCKED просто пропускает, поэтому воркеры не ждут друг друга.
+func (s *store) claim(ctx context.Context, queue, worker string, limit int) ([]Job, error) {
+	return scanJobs(s.db.QueryContext(ctx, `
+        UPDATE jobs SET status = 'running', attempts = attempts + 1,
+            locked_by = $3, locked_at = now(), updated_at = now()
+        WHERE id IN (
+            SELECT id FROM jobs
+            WHERE queue = $1 AND status = 'pending' AND run_at <= now()
+            ORDER BY run_at, id
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+jobColumns, queue, limit, worker))
+}
+
+// errLeaseLost: задачу, пока она выполнялась, уже вернул reclaim, и теперь она принадлежит другому запуску
+var errLeaseLost = errors.New("job lease lost")
+
+// complete marks j done if worker still holds this attempt of it; errLeaseLost otherwise.
+// attempts отличает текущий захват от прежнего, если задачу снова взял тот же инстанс.
+func (s *store) complete(ctx context.Context, j *Job, worker string) error {
+	res, err := s.db.ExecContext(ctx, `
+        UPDATE jobs SET status = 'done', locked_by = NULL, locked_at = NULL, updated_at = now()
+        WHERE id = $1 AND locked_by = $2 AND attempts = $3 AND status = 'running'`, j.ID, worker, j.Attempts)
+	return leased(res, err)
+}
+
+// fail returns j to pending at retryAt, or buries it when retryAt is zero, if worker still holds this
+// attempt of it. A dead job gives up its unique key, so the same job can be enqueued again.
+func (s *store) fail(ctx context.Context, j *Job, worker, msg string, retryAt time.Time) error {
+	status := StatusPending
+	var runAt any = retryAt
+	if retryAt.IsZero() {
+		status, runAt = StatusDead, nil
+	}
+	res, err := s.db.ExecContext(ctx, `
+        UPDATE jobs SET status = $4, last_error = $5, run_at = COALESCE($6, run_at),
+            unique_key = CASE WHEN $4 = 'dead' THEN NULL ELSE unique_key END,
+            locked_by = NULL, locked_at = NULL, updated_at = now()
+        WHERE id = $1 AND locked_by = $2 AND attempts = $3 AND status = 'running'`,
+		j.ID, worker, j.Attempts, status, msg, runAt)
+	return leased(res, err)
+}
+
+func leased(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// reclaim returns jobs of dead instances to the queue. Попытка уже засчитана при захвате,
+// поэтому задача, которая сама валит инстанс, рано или поздно станет dead.
+func (s *store) reclaim(ctx context.Context, staleAfter time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+        UPDATE jobs SET
+            status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+            unique_key = CASE WHEN attempts >= max_attempts THEN NULL ELSE unique_key END,
+            last_error = 'worker lost: ' || COALESCE(locked_by, ''),
+            locked_by = NULL, locked_at = NULL, updated_at = now()
+        WHERE status = 'running' AND locked_at < now() - $1 * interval '1 second'`, staleAfter.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// purge deletes finished jobs older than keep, together with their unique keys
+func (s *store) purge(ctx context.Context, keep time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM jobs WHERE status = 'done' AND updated_at < now() - $1 * interval '1 second'`, keep.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *store) stats(ctx context.Context) ([]QueueStats, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT queue,
+            count(*) FILTER (WHERE status = 'pending' AND run_at <= now()),
+            count(*) FILTER (WHERE status = 'pending' AND run_at > now()),
+            count(*) FILTER (WHERE status = 'running'),
+            count(*) FILTER (WHERE status = 'dead'),
+            count(*) FILTER (WHERE status = 'done' AND updated_at > now() - interval '1 day'),
+            min(run_at) FILTER (WHERE status = 'pending' AND run_at <= now())
+        FROM jobs GROUP BY queue ORDER BY queue`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []QueueStats{}
+	for rows.Next() {
+		var q QueueStats
+		var oldest sql.NullTime
+		if err := rows.Scan(&q.Queue, &q.Ready, &q.Scheduled, &q.Running, &q.Dead, &q.DoneDay, &oldest); err != nil {
+			return nil, err
+		}
+		if oldest.Valid {
+			q.OldestRun = &oldest.Time
+		}
+		res = append(res, q)
+	}
+	return res, rows.Err()
+}
+
+// failed lists dead jobs and pending ones waiting for a retry, newest first
+func (s *store) failed(ctx context.Context, limit int) ([]Job, error) {
+	return scanJobs(s.db.QueryContext(ctx, `
+        SELECT `+jobColumns+` FROM jobs
+        WHERE status = 'dead' OR (status = 'pending' AND last_error <> '')
+        ORDER BY updated_at DESC LIMIT $1`, limit))
+}
+
+// retry puts a dead or waiting job to run now with a fresh set of attempts
+func (s *store) retry(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `
+        UPDATE jobs SET status = 'pending', attempts = 0, run_at = now(), updated_at = now()
+        WHERE id = $1 AND status IN ('dead', 'pending')`, id)
+	return affected(res, err)
+}
+
+func (s *store) remove(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE id = $1 AND status <> 'running'`, id)
+	return affected(res, err)
+}
+
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/dbtest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRunner is a runner over a fresh jobs table with one kind, "noop", in the default queue
+func newTestRunner(t *testing.T, db *sql.DB, worker string) *Runner {
+	t.Helper()
+	r := New(db, Config{Queues: map[string]int{DefaultQueue: 2}, PollInterval: 20 * time.Millisecond, Worker: worker})
+	r.Register("noop", Options{MaxAttempts: 2}, func(ctx context.Context, j *Job) error { return nil })
+	return r
+}
+
+// expireLease makes a running job look like its instance died an hour ago
+func expireLease(t *testing.T, db *sql.DB, id int64) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE jobs SET locked_at = now() - interval '1 hour' WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func jobState(t *testing.T, db *sql.DB, id int64) (status string, attempts int, lockedBy, uniqueKey sql.NullString) {
+	t.Helper()
+	err := db.QueryRow(`SELECT status, attempts, locked_by, unique_key FROM jobs WHERE id = $1`, id).
+		Scan(&status, &attempts, &lockedBy, &uniqueKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestClaimHandsEachJobToOneWorker(t *testing.T) {
+	db := dbtest.Open(t, "020_jobs.sql")
+	r := newTestRunner(t, db, "a")
+	ctx := context.Background()
+	const total = 40
+	for i := 0; i < total; i++ {
+		if _, err := r.Enqueue(ctx, "noop", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// задача на будущее не должна уйти раньше срока
+	later, err := r.Enqueue(ctx, "noop", "later", After(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	seen := map[int64]string{}
+	var wg sync.WaitGroup
+	for _, worker := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				claimed, err := r.store.claim(ctx, DefaultQueue, worker, 3)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(claimed) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, j := range claimed {
+					if prev, dup := seen[j.ID]; dup {
+						t.Errorf("job %d claimed by %s and %s", j.ID, prev, worker)
+					}
+					seen[j.ID] = worker
+					if j.Status != StatusRunning || j.Attempts != 1 {
+						t.Errorf("claimed job %d: status %s, attempts %d", j.ID, j.Status, j.Attempts)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != total {
+		t.Fatalf("%d jobs claimed, want %d", len(seen), total)
+	}
+	if _, ok := seen[later]; ok {
+		t.Fatal("a job scheduled an hour ahead was claimed")
+	}
+	for id, worker := range seen {
+		if _, _, lockedBy, _ := jobState(t, db, id); lockedBy.String != worker {
+			t.Fatalf("job %d locked by %q, claimed by %q", id, lockedBy.String, worker)
+		}
+	}
+}
+
+func TestReclaimReturnsJobsWithExpiredLeases(t *testing.T) {
+	db := dbtest.Open(t, "020_jobs.sql")
+	r := newTestRunner(t, db, "a")
+	ctx := context.Background()
+	fresh, _ := r.Enqueue(ctx, "noop", 1)
+	stale, _ := r.Enqueue(ctx, "noop", 2, Unique("stale"))
+	if claimed, err := r.store.claim(ctx, DefaultQueue, "a", 10); err != nil || len(claimed) != 2 {
+		t.Fatalf("claim: %d jobs, %v", len(claimed), err)
+	}
+
+	expireLease(t, db, stale)
+	n, err := r.store.reclaim(ctx, 15*time.Minute)
+	if err != nil || n != 1 {
+		t.Fatalf("reclaim returned %d jobs (%v), want only the stale one", n, err)
+	}
+	if status, _, lockedBy, _ := jobState(t, db, fresh); status != StatusRunning || lockedBy.String != "a" {
+		t.Fatalf("job under a live lease: %s locked by %q, want it left running", status, lockedBy.String)
+	}
+	status, attempts, lockedBy, key := jobState(t, db, stale)
+	if status != StatusPending || lockedBy.Valid || key.String != "stale" {
+		t.Fatalf("reclaimed job: %s locked by %v key %v, want pending, unlocked, key kept", status, lockedBy, key)
+	}
+
+	// второй раз она уходит с последней попыткой: по истечении аренды задача мертва и ключ свободен
+	claimed, err := r.store.claim(ctx, DefaultQueue, "b", 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != stale || claimed[0].Attempts != attempts+1 {
+		t.Fatalf("re-claim: %+v, %v", claimed, err)
+	}
+	expireLease(t, db, stale)
+	if _, err := r.store.reclaim(ctx, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _, key := jobState(t, db, stale); status != StatusDead || key.Valid {
+		t.Fatalf("job out of attempts: %s with key %v, want dead without a key", status, key)
+	}
+}
+
+func TestFinishRequiresTheCurrentLease(t *testing.T) {
+	db := dbtest.Open(t, "020_jobs.sql")
+	r := newTestRunner(t, db, "a")
+	ctx := context.Background()
+	if _, err := r.Enqueue(ctx, "noop", nil); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := r.store.claim(ctx, DefaultQueue, "a", 1)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claim: %v", err)
+	}
+	first := claimed[0]
+
+	if err := r.store.complete(ctx, &first, "b"); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("complete by another worker: %v, want errLeaseLost", err)
+	}
+	if err := r.store.fail(ctx, &first, "b", "boom", time.Time{}); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("fail by another worker: %v, want errLeaseLost", err)
+	}
+
+	// аренда истекла, задачу снова взял тот же инстанс — старая попытка ничего не решает
+	expireLease(t, db, first.ID)
+	if _, err := r.store.reclaim(ctx, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = r.store.claim(ctx, DefaultQueue, "a", 1)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("re-claim: %v", err)
+	}
+	second := claimed[0]
+	if err := r.store.complete(ctx, &first, "a"); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("complete of a superseded attempt: %v, want errLeaseLost", err)
+	}
+	if err := r.store.fail(ctx, &first, "a", "late", time.Now()); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("fail of a superseded attempt: %v, want errLeaseLost", err)
+	}
+	if status, _, _, _ := jobState(t, db, first.ID); status != StatusRunning {
+		t.Fatalf("stale finishes changed the job to %s", status)
+	}
+	if err := r.store.complete(ctx, &second, "a"); err != nil {
+		t.Fatalf("complete under the current lease: %v", err)
+	}
+	if status, _, lockedBy, _ := jobState(t, db, first.ID); status != StatusDone || lockedBy.Valid {
+		t.Fatalf("finished job: %s locked by %v, want done and unlocked", status, lockedBy)
+	}
+	if err := r.store.complete(ctx, &second, "a"); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("second complete: %v, want errLeaseLost", err)
+	}
+}
+
+func TestUniqueKeyDeduplicatesUntilDead(t *testing.T) {
+	db := dbtest.Open(t, "020_jobs.sql")
+	r := newTestRunner(t, db, "a")
+	ctx := context.Background()
+	id, err := r.Enqueue(ctx, "noop", 1, Unique("digest:42"))
+	if err != nil || id == 0 {
+		t.Fatalf("first enqueue: %d, %v", id, err)
+	}
+	if dup, err := r.Enqueue(ctx, "noop", 2, Unique("digest:42")); err != nil || dup != 0 {
+		t.Fatalf("duplicate enqueue: id %d, %v; want 0", dup, err)
+	}
+	// без ключа дубликатов не бывает
+	a, _ := r.Enqueue(ctx, "noop", 3)
+	b, _ := r.Enqueue(ctx, "noop", 3)
+	if a == 0 || b == 0 || a == b {
+		t.Fatalf("jobs without a key: ids %d and %d", a, b)
+	}
+
+	// в транзакции ключ тоже держит, а откат ничего не оставляет
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dup, err := r.EnqueueTx(ctx, tx, "noop", 4, Unique("digest:42")); err != nil || dup != 0 {
+		t.Fatalf("duplicate EnqueueTx: id %d, %v; want 0", dup, err)
+	}
+	if _, err := r.EnqueueTx(ctx, tx, "noop", 5, Unique("digest:43")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if id, err := r.Enqueue(ctx, "noop", 5, Unique("digest:43")); err != nil || id == 0 {
+		t.Fatalf("key of a rolled back job is still taken: %d, %v", id, err)
+	}
+
+	// мёртвая задача отдаёт ключ
+	var claimed []Job
+	for _, j := range mustClaim(t, r, "a") {
+		if j.ID == id {
+			claimed = append(claimed, j)
+		}
+	}
+	if len(claimed) != 1 {
+		t.Fatal("keyed job was not claimed")
+	}
+	if err := r.store.fail(ctx, &claimed[0], "a", "gave up", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := r.Enqueue(ctx, "noop", 6, Unique("digest:42")); err != nil || again == 0 || again == id {
+		t.Fatalf("enqueue after the keyed job died: id %d, %v", again, err)
+	}
+}
+
+func mustClaim(t *testing.T, r *Runner, worker string) []Job {
+	t.Helper()
+	claimed, err := r.store.claim(context.Background(), DefaultQueue, worker, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claimed
+}
+
+func TestCronTickRunsOnceAcrossInstances(t *testing.T) {
+	db := dbtest.Open(t, "020_jobs.sql")
+	var runs atomic.Int32
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, worker := range []string{"a", "b", "c"} {
+		r := newTestRunner(t, db, worker)
+		r.Register("tick", Options{}, func(ctx context.Context, j *Job) error {
+			runs.Add(1)
+			return nil
+		})
+		if err := r.Cron("@every 1s", "tick", nil); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var ticks, keys, done int
+	err := db.QueryRow(`SELECT count(*), count(DISTINCT unique_key), count(*) FILTER (WHERE status = 'done')
+        FROM jobs WHERE kind = 'tick'`).Scan(&ticks, &keys, &done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticks != keys {
+		t.Fatalf("%d tick jobs for %d ticks: the instances enqueued the same tick more than once", ticks, keys)
+	}
+	if done < 2 {
+		t.Fatalf("%d ticks done in 3.5s of an @every 1s schedule", done)
+	}
+	// последний тик мог остаться в очереди к остановке, но выполненный — ровно один раз
+	if n := int(runs.Load()); n != done {
+		t.Fatalf("tick handler ran %d times for %d finished ticks", n, done)
+	}
+}
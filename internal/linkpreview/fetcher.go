@@ -0,0 +1,236 @@
+package linkpreview
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBadURL      = errors.New("linkpreview: unsupported url")
+	ErrBlockedHost = errors.New("linkpreview: host resolves to a blocked address")
+	ErrTooLarge    = errors.New("linkpreview: response body too large")
+	ErrNotHTML     = errors.New("linkpreview: response is not html")
+	ErrTooManyHops = errors.New("linkpreview: too many redirects")
+	ErrBadStatus   = errors.New("linkpreview: unexpected status code")
+	ErrNoMetadata  = errors.New("linkpreview: no metadata found")
+)
+
+const maxOEmbedBytes = 64 << 10
+
+var maxFieldLengths = map[string]int{"title": 300, "description": 1000, "site_name": 200, "image_url": 2048}
+
+type Config struct {
+	// Timeout bounds the whole fetch including redirects and the oEmbed lookup
+	Timeout time.Duration
+	// MaxBodyBytes is the most we read from a page; the <head> is almost always within it
+	MaxBodyBytes int64
+	MaxRedirects int
+	UserAgent    string
+	// AllowIP decides whether an address may be dialed. It is checked on every
+	// connection (including redirects), after DNS resolution, so rebinding
+	// tricks do not help. Defaults to PublicIP; tests against httptest servers
+	// on 127.0.0.1 replace it.
+	AllowIP func(ip net.IP) bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:      5 * time.Second,
+		MaxBodyBytes: 512 << 10,
+		MaxRedirects: 5,
+		UserAgent:    "forum-linkpreview/1.0",
+		AllowIP:      PublicIP,
+	}
+}
+
+// Fetcher downloads a page and extracts OpenGraph / Twitter card / oEmbed metadata
+type Fetcher struct {
+	cfg    Config
+	client *http.Client
+}
+
+func NewFetcher(cfg Config) *Fetcher {
+	def := DefaultConfig()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = def.MaxBodyBytes
+	}
+	if cfg.MaxRedirects <= 0 {
+		cfg.MaxRedirects = def.MaxRedirects
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = def.UserAgent
+	}
+	if cfg.AllowIP == nil {
+		cfg.AllowIP = def.AllowIP
+	}
+
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		// Control runs with the already resolved address, right before connect
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !cfg.AllowIP(ip) {
+				return ErrBlockedHost
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would dial on our behalf and bypass the address check
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= cfg.MaxRedirects {
+				return ErrTooManyHops
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrBadURL
+			}
+			return nil
+		},
+	}
+	return &Fetcher{cfg: cfg, client: client}
+}
+
+// ValidURL reports whether raw is an absolute http(s) url we are willing to fetch
+func ValidURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Hostname() != "" && u.User == nil
+}
+
+// Fetch downloads rawURL and returns the extracted preview. FetchedAt/ExpiresAt are left to the caller.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*entity.LinkPreview, error) {
+	if !ValidURL(rawURL) {
+		return nil, ErrBadURL
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.Timeout)
+	defer cancel()
+
+	body, finalURL, err := f.get(ctx, rawURL, "text/html,application/xhtml+xml", f.cfg.MaxBodyBytes, true)
+	if err != nil {
+		return nil, err
+	}
+	m := parseHTML(body, finalURL)
+
+	// oEmbed only fills the gaps left by the page itself
+	if m.oembedURL != "" && (m.title == "" || m.image == "") {
+		if oe, err := f.oembed(ctx, m.oembedURL); err == nil {
+			m.fill(oe)
+		}
+	}
+
+	p := &entity.LinkPreview{
+		URL:         rawURL,
+		Title:       clip(m.title, maxFieldLengths["title"]),
+		Description: clip(m.description, maxFieldLengths["description"]),
+		ImageURL:    clip(m.image, maxFieldLengths["image_url"]),
+		SiteName:    clip(m.siteName, maxFieldLengths["site_name"]),
+	}
+	if p.SiteName == "" {
+		p.SiteName = finalURL.Hostname()
+	}
+	if p.Empty() {
+		return p, ErrNoMetadata
+	}
+	return p, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, rawURL, accept string, limit int64, wantHTML bool) ([]byte, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, ErrBadURL
+	}
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+	req.Header.Set("Accept", accept)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		// *url.Error and *net.OpError unwrap, so errors.Is(err, ErrBlockedHost) still works for callers
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: %d", ErrBadStatus, resp.StatusCode)
+	}
+	if wantHTML {
+		mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mt != "text/html" && mt != "application/xhtml+xml" {
+			return nil, nil, ErrNotHTML
+		}
+	}
+	if resp.ContentLength > limit {
+		return nil, nil, ErrTooLarge
+	}
+	// Read one byte past the limit: for html a truncated document is still
+	// useful (metadata lives in <head>), anything else over the limit is rejected.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(body)) > limit {
+		if !wantHTML {
+			return nil, nil, ErrTooLarge
+		}
+		body = body[:limit]
+	}
+	return body, resp.Request.URL, nil
+}
+
+type oembedResponse struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (f *Fetcher) oembed(ctx context.Context, endpoint string) (*oembedResponse, error) {
+	if !ValidURL(endpoint) {
+		return nil, ErrBadURL
+	}
+	body, _, err := f.get(ctx, endpoint, "application/json", maxOEmbedBytes, false)
+	if err != nil {
+		return nil, err
+	}
+	var oe oembedResponse
+	if err := json.Unmarshal(body, &oe); err != nil {
+		return nil, err
+	}
+	return &oe, nil
+}
+
+func clip(s string, n int) string {
+	s = strings.TrimSpace(s)
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,97 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// localConfig lets the fetcher reach httptest servers on 127.0.0.1 and nothing else
+func localConfig() Config {
+	cfg := DefaultConfig()
+	cfg.AllowIP = func(ip net.IP) bool { return ip.Equal(net.IPv4(127, 0, 0, 1)) }
+	return cfg
+}
+
+const page = `<html><head><title>Hello</title><meta property="og:description" content="A page"></head><body>hi</body></html>`
+
+func servePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+func TestFetchBlocksLoopbackByDefault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(servePage))
+	defer srv.Close()
+
+	_, err := NewFetcher(DefaultConfig()).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrBlockedHost) {
+		t.Fatalf("fetch of %s: got %v, want ErrBlockedHost", srv.URL, err)
+	}
+
+	p, err := NewFetcher(localConfig()).Fetch(context.Background(), srv.URL)
+	if err != nil || p.Title != "Hello" {
+		t.Fatalf("fetch with 127.0.0.1 allowed: got %+v, %v", p, err)
+	}
+}
+
+func TestFetchRefusesRedirectToPrivateAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.0.0.1/admin", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, err := NewFetcher(localConfig()).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrBlockedHost) {
+		t.Fatalf("redirect to 10.0.0.1: got %v, want ErrBlockedHost", err)
+	}
+}
+
+func TestFetchTruncatesOversizedBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Big</title></head><body>`))
+		w.(http.Flusher).Flush() // без Content-Length: размер узнаётся только при чтении
+		w.Write([]byte(strings.Repeat("x", 64<<10)))
+	}))
+	defer srv.Close()
+
+	cfg := localConfig()
+	cfg.MaxBodyBytes = 1 << 10
+	p, err := NewFetcher(cfg).Fetch(context.Background(), srv.URL)
+	if err != nil || p.Title != "Big" {
+		t.Fatalf("oversized page: got %+v, %v; want the head of the truncated page", p, err)
+	}
+
+	body, _, err := NewFetcher(cfg).get(context.Background(), srv.URL, "text/html", cfg.MaxBodyBytes, true)
+	if err != nil || int64(len(body)) != cfg.MaxBodyBytes {
+		t.Fatalf("read %d bytes, %v; want exactly MaxBodyBytes", len(body), err)
+	}
+}
+
+func TestFetchTimesOutOnSlowServer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	cfg := localConfig()
+	cfg.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	_, err := NewFetcher(cfg).Fetch(context.Background(), srv.URL)
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("slow server: got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("slow server: gave up after %v, want about %v", elapsed, cfg.Timeout)
+	}
+}
@@ -0,0 +1,160 @@
+package linkpreview
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type metadata struct {
+	title       string
+	description string
+	image       string
+	siteName    string
+	oembedURL   string
+}
+
+// parseHTML walks the document head. OpenGraph wins over Twitter cards,
+// which win over plain <title>/<meta name="description">.
+func parseHTML(body []byte, base *url.URL) metadata {
+	var og, tw, plain metadata
+	z := html.NewTokenizer(bytes.NewReader(body))
+	inTitle := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return merge(base, og, tw, plain)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Head:
+				return merge(base, og, tw, plain)
+			case atom.Title:
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle && plain.title == "" {
+				plain.title = strings.TrimSpace(string(z.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				return merge(base, og, tw, plain)
+			case atom.Title:
+				inTitle = tt == html.StartTagToken
+			case atom.Meta:
+				if !hasAttr {
+					continue
+				}
+				attrs := readAttrs(z)
+				key := strings.ToLower(attrs["property"])
+				if key == "" {
+					key = strings.ToLower(attrs["name"])
+				}
+				content := strings.TrimSpace(attrs["content"])
+				if content == "" {
+					continue
+				}
+				switch key {
+				case "og:title":
+					og.title = content
+				case "og:description":
+					og.description = content
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if og.image == "" {
+						og.image = content
+					}
+				case "og:site_name":
+					og.siteName = content
+				case "twitter:title":
+					tw.title = content
+				case "twitter:description":
+					tw.description = content
+				case "twitter:image", "twitter:image:src":
+					if tw.image == "" {
+						tw.image = content
+					}
+				case "description":
+					plain.description = content
+				}
+			case atom.Link:
+				if !hasAttr {
+					continue
+				}
+				attrs := readAttrs(z)
+				if strings.EqualFold(attrs["rel"], "alternate") &&
+					strings.EqualFold(attrs["type"], "application/json+oembed") && attrs["href"] != "" {
+					plain.oembedURL = attrs["href"]
+				}
+			}
+		}
+	}
+}
+
+func readAttrs(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		k, v, more := z.TagAttr()
+		attrs[strings.ToLower(string(k))] = string(v)
+		if !more {
+			return attrs
+		}
+	}
+}
+
+func merge(base *url.URL, layers ...metadata) metadata {
+	var m metadata
+	for _, l := range layers {
+		if m.title == "" {
+			m.title = l.title
+		}
+		if m.description == "" {
+			m.description = l.description
+		}
+		if m.image == "" {
+			m.image = l.image
+		}
+		if m.siteName == "" {
+			m.siteName = l.siteName
+		}
+		if m.oembedURL == "" {
+			m.oembedURL = l.oembedURL
+		}
+	}
+	m.image = resolve(base, m.image)
+	m.oembedURL = resolve(base, m.oembedURL)
+	return m
+}
+
+// fill completes missing fields from an oEmbed response
+func (m *metadata) fill(oe *oembedResponse) {
+	if m.title == "" {
+		m.title = oe.Title
+	}
+	if m.image == "" && ValidURL(oe.ThumbnailURL) {
+		m.image = oe.ThumbnailURL
+	}
+	if m.siteName == "" {
+		m.siteName = oe.ProviderName
+	}
+	if m.description == "" && oe.AuthorName != "" {
+		m.description = oe.AuthorName
+	}
+}
+
+// resolve makes ref absolute against base and drops anything that is not http(s)
+func resolve(base *url.URL, ref string) string {
+	if ref == "" || base == nil {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
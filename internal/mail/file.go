@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file into a maildir (tmp/ then new/), handy in development
+type FileMailer struct {
+	dir string
+	seq atomic.Int64
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (f *FileMailer) Send(_ context.Context, m *Message) error {
+	if m.From == "" {
+		m.From = DefaultFrom()
+	}
+	raw, err := Build(m)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().Unix(), os.Getpid(), f.seq.Add(1), host)
+	tmp := filepath.Join(f.dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	// rename атомарен: читатель не увидит недописанный файл
+	return os.Rename(tmp, filepath.Join(f.dir, "new", name))
+}
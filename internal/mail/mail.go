@@ -0,0 +1,72 @@
+// Package mail sends e-mail through pluggable transports (SMTP, files on disk, memory)
+// and renders localised templates for them.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Message is a rendered e-mail ready to be sent
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are extra headers such as List-Unsubscribe
+	Headers map[string]string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// PermanentError means retrying will not help (mailbox does not exist, address rejected, ...)
+type PermanentError struct{ Err error }
+
+func (e *PermanentError) Error() string { return "permanent: " + e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
+
+// FromEnv builds a mailer from MAIL_TRANSPORT (smtp|file|memory, file by default):
+// SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASSWORD, SMTP_IMPLICIT_TLS for smtp; MAIL_DIR for file
+func FromEnv() (Mailer, error) {
+	switch os.Getenv("MAIL_TRANSPORT") {
+	case "smtp":
+		port, _ := strconv.Atoi(getenv("SMTP_PORT", "587"))
+		return NewSMTPMailer(SMTPConfig{
+			Host:        os.Getenv("SMTP_HOST"),
+			Port:        port,
+			Username:    os.Getenv("SMTP_USER"),
+			Password:    os.Getenv("SMTP_PASSWORD"),
+			ImplicitTLS: os.Getenv("SMTP_IMPLICIT_TLS") == "true",
+			Timeout:     30 * time.Second,
+		})
+	case "memory":
+		return NewMemoryMailer(), nil
+	case "", "file":
+		return NewFileMailer(getenv("MAIL_DIR", "maildir"))
+	}
+	return nil, fmt.Errorf("mail: unknown MAIL_TRANSPORT %q", os.Getenv("MAIL_TRANSPORT"))
+}
+
+// DefaultFrom is the sender address used when a message has none
+func DefaultFrom() string {
+	return getenv("MAIL_FROM", "Форум <no-reply@localhost>")
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer keeps sent messages in memory, for tests
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+	// Err, if set, is returned from Send instead of storing the message
+	Err error
+}
+
+func NewMemoryMailer() *MemoryMailer { return &MemoryMailer{} }
+
+func (m *MemoryMailer) Send(_ context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, *msg)
+	return nil
+}
+
+// Sent returns a copy of everything sent so far
+func (m *MemoryMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub is a minimal SMTP server on 127.0.0.1: no TLS, no AUTH. Recipients in reject get
+// the reply given for them; everything that reaches DATA is kept in messages.
+type smtpStub struct {
+	ln     net.Listener
+	reject map[string]string
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func newSMTPStub(t *testing.T, reject map[string]string) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{ln: ln, reject: reject}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-stub")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			addr := strings.Trim(strings.TrimSpace(line[len("RCPT TO:"):]), "<>")
+			if resp, ok := s.reject[addr]; ok {
+				reply(resp)
+			} else {
+				reply("250 ok")
+			}
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) mailer(t *testing.T) *SMTPMailer {
+	t.Helper()
+	addr := s.ln.Addr().(*net.TCPAddr)
+	m, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSMTPMailerSends(t *testing.T) {
+	stub := newSMTPStub(t, nil)
+	err := stub.mailer(t).Send(context.Background(), &Message{
+		From: "Форум <no-reply@example.com>", To: "alice@example.com", Subject: "Привет", Text: "hello",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/u>"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.messages) != 1 {
+		t.Fatalf("stub got %d messages, want 1", len(stub.messages))
+	}
+	msg := stub.messages[0]
+	for _, want := range []string{"To: <alice@example.com>", "List-Unsubscribe: <https://example.com/u>", "hello"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestSMTPMailerClassifiesRejections(t *testing.T) {
+	stub := newSMTPStub(t, map[string]string{
+		"gone@example.com": "550 no such mailbox",
+		"busy@example.com": "451 try again later",
+	})
+	m := stub.mailer(t)
+
+	err := m.Send(context.Background(), &Message{From: "a@example.com", To: "gone@example.com", Subject: "s", Text: "t"})
+	if !IsPermanent(err) {
+		t.Errorf("550: got %v, want a permanent error", err)
+	}
+	err = m.Send(context.Background(), &Message{From: "a@example.com", To: "busy@example.com", Subject: "s", Text: "t"})
+	if err == nil || IsPermanent(err) {
+		t.Errorf("451: got %v, want a temporary error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Langs are the languages e-mail templates exist for; the first one is the fallback
+var Langs = []string{"ru", "en"}
+
+// Renderer renders templates/email/{lang}/{name}.txt (defines "subject" and the text body)
+// and {name}.html (defines "content", wrapped into layout.html of the same language)
+type Renderer struct {
+	dir string
+}
+
+func NewRenderer(dir string) *Renderer {
+	if dir == "" {
+		dir = findTemplatesDir()
+	}
+	return &Renderer{dir: dir}
+}
+
+func findTemplatesDir() string {
+	for _, dir := range []string{
+		filepath.Join("templates", "email"),
+		filepath.Join("..", "templates", "email"),
+		filepath.Join("..", "..", "templates", "email"),
+	} {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return filepath.Join("templates", "email")
+}
+
+// NormalizeLang maps anything unsupported to the fallback language
+func NormalizeLang(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	for _, l := range Langs {
+		if lang == l {
+			return l
+		}
+	}
+	return Langs[0]
+}
+
+// PreferredLang picks a supported language from an Accept-Language header
+func PreferredLang(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		tag = strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		for _, l := range Langs {
+			if tag == l {
+				return l
+			}
+		}
+	}
+	return Langs[0]
+}
+
+// Render returns subject, text and HTML bodies of template name in lang
+func (r *Renderer) Render(name, lang string, data any) (subject, text, html string, err error) {
+	lang = NormalizeLang(lang)
+	if _, err := os.Stat(filepath.Join(r.dir, lang, name+".txt")); err != nil {
+		lang = Langs[0]
+	}
+
+	tt, err := texttemplate.ParseFiles(filepath.Join(r.dir, lang, name+".txt"))
+	if err != nil {
+		return "", "", "", err
+	}
+	var buf bytes.Buffer
+	if err := tt.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err := tt.Execute(&buf, data); err != nil {
+		return "", "", "", err
+	}
+	text = strings.TrimSpace(buf.String()) + "\n"
+
+	htmlPath := filepath.Join(r.dir, lang, name+".html")
+	if _, err := os.Stat(htmlPath); err != nil {
+		return subject, text, "", nil // HTML-версия необязательна
+	}
+	ht, err := htmltemplate.ParseFiles(filepath.Join(r.dir, lang, "layout.html"), htmlPath)
+	if err != nil {
+		return "", "", "", err
+	}
+	buf.Reset()
+	if err := ht.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, buf.String(), nil
+}
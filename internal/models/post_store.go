@@ -23,7 +23,7 @@ func GetPostByID(id int64) (*entity.Post, error) {
 		SELECT id, board_id, title, content, author_id,
 		       COALESCE(image_url, ''), COALESCE(link_url, ''), image_data,
 		       created_at, updated_at
-		FROM posts WHERE id=$1
+		FROM posts WHERE id=$1 AND status='published'
 	`
 	err := db.DB.QueryRow(query, id).Scan(
 		&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID,
@@ -38,7 +38,7 @@ func GetPostsByBoard(boardID int64) ([]entity.Post, error) {
 		SELECT id, board_id, title, content, author_id,
 		       created_at, updated_at, image_url, link_url
 		FROM posts
-		WHERE board_id = $1
+		WHERE board_id = $1 AND status = 'published'
 		ORDER BY created_at DESC
 	`, boardID)
 	if err != nil {
@@ -84,6 +84,7 @@ func GetAllPosts() ([]entity.Post, error) {
 		       COALESCE(image_url,''), COALESCE(link_url,''), image_data,
 		       created_at, updated_at
 		FROM posts
+		WHERE status = 'published'
 		ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -21,7 +21,7 @@ func SearchPosts(query string) ([]entity.Post, error) {
 		       COALESCE(SUM(CASE WHEN pv.value=-1 THEN 1 ELSE 0 END),0) AS dislikes
 		FROM posts p
 		LEFT JOIN post_votes pv ON pv.post_id = p.id
-		WHERE p.title ILIKE $1 OR p.content ILIKE $1
+		WHERE p.status = 'published' AND (p.title ILIKE $1 OR p.content ILIKE $1)
 		GROUP BY p.id, p.board_id, p.title, p.content, p.author_id, p.created_at, p.updated_at
 		ORDER BY 
 			CASE 
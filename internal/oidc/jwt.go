@@ -0,0 +1,257 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256/ES256
+	_ "crypto/sha512" // SHA-384/512 for RS384/RS512/ES384
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew tolerates small clock differences between us and the provider
+const clockSkew = 2 * time.Minute
+
+// keyRefreshEvery limits JWKS refetches triggered by unknown key ids
+const keyRefreshEvery = time.Minute
+
+// Claims are the ID token / userinfo claims the forum uses
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     flexBool `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Locale            string   `json:"locale"`
+}
+
+// audience is "aud": a single string or an array
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// flexBool accepts true and "true": some providers send email_verified as a string
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	switch strings.Trim(string(b), `"`) {
+	case "true":
+		*f = true
+	default:
+		*f = false
+	}
+	return nil
+}
+
+func constantEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384,
+}
+
+// verifyJWT checks the signature of a compact JWS and returns its claims. Only asymmetric
+// algorithms are accepted: "none" and HMAC would let anyone who knows the client secret mint tokens.
+func verifyJWT(ctx context.Context, raw string, keys *keySet) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id token: malformed")
+	}
+	var h jwtHeader
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("id token header: %w", err)
+	}
+	hash, ok := jwtHashes[h.Alg]
+	if !ok {
+		return nil, fmt.Errorf("id token: unsupported alg %q", h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("id token: bad signature encoding")
+	}
+	key, err := keys.get(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hasher.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(h.Alg, "RS") || rsa.VerifyPKCS1v15(k, hash, digest, sig) != nil {
+			return nil, errors.New("id token: bad signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(h.Alg, "ES") || len(sig) != 2*size {
+			return nil, errors.New("id token: bad signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return nil, errors.New("id token: bad signature")
+		}
+	default:
+		return nil, errors.New("id token: unsupported key")
+	}
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("id token claims: %w", err)
+	}
+	return &c, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet caches the provider's signing keys and refetches them when a token names an unknown kid
+// (key rotation), at most once per keyRefreshEvery
+type keySet struct {
+	uri     string
+	fetch   func(ctx context.Context, url string, v any) error
+	mu      sync.Mutex
+	keys    map[string]jwk
+	fetched time.Time
+}
+
+func newKeySet(uri string, fetch func(ctx context.Context, url string, v any) error) *keySet {
+	return &keySet{uri: uri, fetch: fetch}
+}
+
+func (ks *keySet) get(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	k, ok := ks.find(kid)
+	if !ok && time.Since(ks.fetched) > keyRefreshEvery {
+		var doc struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := ks.fetch(ctx, ks.uri, &doc); err != nil {
+			return nil, fmt.Errorf("oidc jwks: %w", err)
+		}
+		ks.keys = map[string]jwk{}
+		for _, key := range doc.Keys {
+			if key.Use == "" || key.Use == "sig" {
+				ks.keys[key.Kid] = key
+			}
+		}
+		ks.fetched = time.Now()
+		k, ok = ks.find(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("id token: unknown key %q", kid)
+	}
+	if k.Alg != "" && k.Alg != alg {
+		return nil, errors.New("id token: alg does not match key")
+	}
+	return k.publicKey()
+}
+
+// find looks kid up; a token without kid is accepted only when the set has exactly one key
+func (ks *keySet) find(kid string) (jwk, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			return nil, errors.New("jwks: bad RSA key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("jwks: RSA key too short")
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("jwks: unsupported curve %q", k.Crv)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("jwks: bad EC key")
+		}
+		// точку вне кривой отвергнет ecdsa.Verify
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("jwks: unsupported key type %q", k.Kty)
+}
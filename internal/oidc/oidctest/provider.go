@@ -0,0 +1,301 @@
+// Package oidctest is a minimal OpenID Connect provider for local development and manual testing
+// of "Sign in with…": discovery, JWKS, an authorize page where you type the identity you want,
+// the token endpoint with PKCE checks, and userinfo. Nothing here is fit for production.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity is what the provider asserts about the signed-in user
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    Identity
+	expires     time.Time
+}
+
+// Provider serves the endpoints under Issuer; mount Handler() at the issuer's path
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty accepts public clients
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	codes  map[string]grant
+	access map[string]Identity
+}
+
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          randomString()[:8],
+		codes:        map[string]grant{},
+		access:       map[string]Identity{},
+	}, nil
+}
+
+func (p *Provider) Handler() http.Handler {
+	prefix := ""
+	if u, err := url.Parse(p.Issuer); err == nil {
+		prefix = u.Path
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc(prefix+"/jwks", p.jwks)
+	mux.HandleFunc(prefix+"/authorize", p.authorize)
+	mux.HandleFunc(prefix+"/token", p.token)
+	mux.HandleFunc(prefix+"/userinfo", p.userinfo)
+	return mux
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"userinfo_endpoint":                     p.Issuer + "/userinfo",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA", "use": "sig", "alg": "RS256", "kid": p.kid,
+		"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!doctype html>
+<title>Mock OIDC</title>
+<h2>Mock OIDC provider</h2>
+<p>Signing in to <b>{{ .ClientID }}</b>. Any identity is accepted.</p>
+<form method="POST">
+	{{ range $k, $v := .Query }}<input type="hidden" name="{{ $k }}" value="{{ index $v 0 }}">{{ end }}
+	<p><label>sub <input name="sub" value="alice" required></label></p>
+	<p><label>email <input name="email" value="alice@example.com"></label>
+	<label><input type="checkbox" name="email_verified" checked> verified</label></p>
+	<p><label>preferred_username <input name="preferred_username" value="alice"></label></p>
+	<p><label>name <input name="name" value="Alice Example"></label></p>
+	<button>Sign in</button> <button name="deny" value="1">Deny</button>
+</form>`))
+
+// GET shows the form, POST "signs in" and redirects back with a code
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.Form
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE S256 is required", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet {
+		query := url.Values{}
+		for _, k := range []string{"client_id", "response_type", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			query.Set(k, q.Get(k))
+		}
+		_ = authorizePage.Execute(w, map[string]interface{}{"ClientID": p.ClientID, "Query": query})
+		return
+	}
+	back := url.Values{}
+	back.Set("state", q.Get("state"))
+	if q.Get("deny") != "" {
+		back.Set("error", "access_denied")
+	} else {
+		code := p.Authorize(q.Get("redirect_uri"), q.Get("code_challenge"), q.Get("nonce"), Identity{
+			Subject:           q.Get("sub"),
+			Email:             q.Get("email"),
+			EmailVerified:     q.Get("email_verified") != "",
+			Name:              q.Get("name"),
+			PreferredUsername: q.Get("preferred_username"),
+		})
+		back.Set("code", code)
+	}
+	sep := "?"
+	if strings.Contains(q.Get("redirect_uri"), "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, q.Get("redirect_uri")+sep+back.Encode(), http.StatusFound)
+}
+
+// Authorize issues a code for id directly, skipping the form (for scripted checks)
+func (p *Provider) Authorize(redirectURI, challenge, nonce string, id Identity) string {
+	code := randomString()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = grant{
+		redirectURI: redirectURI, challenge: challenge,
+		nonce: nonce, identity: id, expires: time.Now().Add(time.Minute),
+	}
+	return code
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || (p.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	p.mu.Lock()
+	g, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code")) // коды одноразовые
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(g.expires):
+		tokenError(w, "invalid_grant")
+		return
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant")
+		return
+	case b64(sum[:]) != g.challenge:
+		tokenError(w, "invalid_grant") // PKCE
+		return
+	}
+	idToken, err := p.IDToken(g.identity, g.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	access := randomString()
+	p.mu.Lock()
+	p.access[access] = g.identity
+	p.mu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": access, "token_type": "Bearer", "expires_in": 3600, "id_token": idToken,
+	})
+}
+
+// IDToken signs an RS256 ID token for id
+func (p *Provider) IDToken(id Identity, nonce string) (string, error) {
+	now := time.Now()
+	claims := claimsOf(id)
+	claims["iss"] = p.Issuer
+	claims["aud"] = p.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.kid})
+	payload, _ := json.Marshal(claims)
+	signing := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + b64(sig), nil
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	id, ok := p.access[token]
+	p.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, claimsOf(id))
+}
+
+func claimsOf(id Identity) map[string]interface{} {
+	c := map[string]interface{}{"sub": id.Subject}
+	if id.Email != "" {
+		c["email"] = id.Email
+		c["email_verified"] = id.EmailVerified
+	}
+	if id.Name != "" {
+		c["name"] = id.Name
+	}
+	if id.PreferredUsername != "" {
+		c["preferred_username"] = id.PreferredUsername
+	}
+	return c
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprint("oidctest: ", err))
+	}
+	return b64(buf)
+}
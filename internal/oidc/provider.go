@@ -0,0 +1,279 @@
+// Package oidc is a small OpenID Connect relying party: discovery, the authorization code flow
+// with PKCE, and ID token verification against the provider's JWKS. Only what the forum needs
+// for "Sign in with…" is implemented; no external dependencies.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Issuer       string // https://sso.example.com/realms/main
+	ClientID     string
+	ClientSecret string // empty for public clients, PKCE still protects the code
+	RedirectURL  string // https://forum.example.com/auth/oidc/callback
+	Scopes       []string
+}
+
+// metadata is the part of /.well-known/openid-configuration we use
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider talks to one OIDC issuer. Discovery happens lazily and is retried after a failure,
+// so the forum starts even while the SSO is down.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+func New(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	hasOpenID := false
+	for _, s := range cfg.Scopes {
+		hasOpenID = hasOpenID || s == "openid"
+	}
+	if !hasOpenID {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	return &Provider{cfg: cfg, client: client}
+}
+
+func (p *Provider) Issuer() string { return p.cfg.Issuer }
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var m metadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	// OpenID Connect Discovery §4.3: the issuer in the document must be the one we asked
+	if strings.TrimRight(m.Issuer, "/") != strings.TrimRight(p.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: incomplete provider metadata")
+	}
+	p.meta = &m
+	p.keys = newKeySet(m.JWKSURI, p.getJSON)
+	return p.meta, nil
+}
+
+// AuthCodeURL is where the browser is sent to sign in
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Tokens is the token endpoint response
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// Exchange trades the authorization code for tokens
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Tokens, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	basic := p.cfg.ClientSecret != "" && !p.prefersPost(m)
+	if !basic {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		// RFC 6749 §2.3.1: id and secret are form-encoded before Basic
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return nil, fmt.Errorf("oidc token: %s %s %s", resp.Status, e.Error, e.Description)
+	}
+	var t Tokens
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	if t.IDToken == "" {
+		return nil, errors.New("oidc token: no id_token in response")
+	}
+	return &t, nil
+}
+
+// prefersPost: use client_secret_post only when the provider doesn't support client_secret_basic
+func (p *Provider) prefersPost(m *metadata) bool {
+	if len(m.TokenAuthMethods) == 0 {
+		return false
+	}
+	for _, a := range m.TokenAuthMethods {
+		if a == "client_secret_basic" {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks the ID token signature and claims (OpenID Connect Core §3.1.3.7)
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := verifyJWT(ctx, rawIDToken, p.keys)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimRight(c.Issuer, "/") != strings.TrimRight(m.Issuer, "/"):
+		return nil, errors.New("id token: wrong issuer")
+	case !c.Audience.contains(p.cfg.ClientID):
+		return nil, errors.New("id token: wrong audience")
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID:
+		return nil, errors.New("id token: wrong authorized party")
+	case c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return nil, errors.New("id token: expired")
+	case c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, errors.New("id token: issued in the future")
+	case c.Subject == "":
+		return nil, errors.New("id token: no subject")
+	case nonce == "" || !constantEqual(c.Nonce, nonce):
+		return nil, errors.New("id token: nonce mismatch")
+	}
+	return c, nil
+}
+
+// UserInfo fetches claims from the userinfo endpoint; the subject must match the ID token
+func (p *Provider) UserInfo(ctx context.Context, accessToken, subject string) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.UserinfoEndpoint == "" {
+		return nil, errors.New("oidc: provider has no userinfo endpoint")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc userinfo: %s", resp.Status)
+	}
+	var c Claims
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&c); err != nil {
+		return nil, fmt.Errorf("oidc userinfo: %w", err)
+	}
+	if c.Subject != subject {
+		return nil, errors.New("oidc userinfo: subject mismatch")
+	}
+	return &c, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// RandomString returns a URL-safe random value for state, nonce and PKCE verifiers
+func RandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge is the PKCE S256 code challenge for verifier (RFC 7636 §4.2)
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Event is what subscribers receive. Data is already JSON so it can cross process boundaries as is.
+type Event struct {
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Backend carries published events to every instance of the app.
+// Start must call deliver once for every event published by any instance (including this one).
+type Backend interface {
+	Publish(ctx context.Context, e Event) error
+	Start(ctx context.Context, deliver func(Event)) error
+}
+
+func PostTopic(id int64) string  { return "post:" + strconv.FormatInt(id, 10) }
+func BoardTopic(id int64) string { return "board:" + strconv.FormatInt(id, 10) }
+func UserTopic(id int64) string  { return "user:" + strconv.FormatInt(id, 10) }
+
+// subscriptionBuffer is how many events a slow client may lag behind before events are dropped
+const subscriptionBuffer = 32
+
+// Hub fans events out to local subscribers
+type Hub struct {
+	backend Backend
+	done    chan struct{} // закрывается, когда контекст Start отменён
+
+	mu   sync.RWMutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+func NewHub(b Backend) *Hub {
+	return &Hub{backend: b, done: make(chan struct{}), subs: map[string]map[*Subscription]struct{}{}}
+}
+
+// Start connects the hub to its backend. When ctx is cancelled the backend stops and
+// every subscription's Done channel closes, so long-lived streams end before server shutdown waits on them.
+func (h *Hub) Start(ctx context.Context) error {
+	if err := h.backend.Start(ctx, h.dispatch); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		close(h.done)
+	}()
+	return nil
+}
+
+// Publish marshals data and sends it to every subscriber of topic on every instance
+func (h *Hub) Publish(ctx context.Context, topic, typ string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return h.backend.Publish(ctx, Event{Topic: topic, Type: typ, Data: raw})
+}
+
+// Subscribe returns a subscription to the given topics; the caller must Close it
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	s := &Subscription{hub: h, topics: topics, ch: make(chan Event, subscriptionBuffer)}
+	h.mu.Lock()
+	for _, t := range topics {
+		if h.subs[t] == nil {
+			h.subs[t] = map[*Subscription]struct{}{}
+		}
+		h.subs[t][s] = struct{}{}
+	}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *Hub) dispatch(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs[e.Topic] {
+		select {
+		case s.ch <- e:
+		default:
+			// клиент не успевает читать — пропускаем событие, а не блокируем всех
+			fmt.Println("pubsub: dropping event for slow subscriber on", e.Topic)
+		}
+	}
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range s.topics {
+		delete(h.subs[t], s)
+		if len(h.subs[t]) == 0 {
+			delete(h.subs, t)
+		}
+	}
+}
+
+type Subscription struct {
+	hub    *Hub
+	topics []string
+	ch     chan Event
+	once   sync.Once
+}
+
+func (s *Subscription) Events() <-chan Event { return s.ch }
+
+// Done is closed when the hub shuts down; the subscriber should stop reading and return
+func (s *Subscription) Done() <-chan struct{} { return s.hub.done }
+
+func (s *Subscription) Close() {
+	s.once.Do(func() { s.hub.unsubscribe(s) })
+}
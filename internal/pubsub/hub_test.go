@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHubDeliversToTopicSubscribers(t *testing.T) {
+	h := NewHub(NewMemoryBackend())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	post, board := h.Subscribe(PostTopic(1)), h.Subscribe(BoardTopic(1))
+	defer post.Close()
+	defer board.Close()
+
+	if err := h.Publish(ctx, PostTopic(1), "comment", map[string]int{"id": 5}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-post.Events():
+		if e.Type != "comment" || string(e.Data) != `{"id":5}` {
+			t.Fatalf("got %s %s", e.Type, e.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("post subscriber got nothing")
+	}
+	select {
+	case e := <-board.Events():
+		t.Fatalf("board subscriber got %s from %s", e.Type, e.Topic)
+	default:
+	}
+}
+
+func TestHubClosesSubscriptionsOnShutdown(t *testing.T) {
+	h := NewHub(NewMemoryBackend())
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := h.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sub := h.Subscribe(PostTopic(1))
+	defer sub.Close()
+	select {
+	case <-sub.Done():
+		t.Fatal("Done closed before shutdown")
+	default:
+	}
+	cancel()
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the hub context was cancelled")
+	}
+}
@@ -0,0 +1,31 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBackend delivers events within a single process
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	deliver func(Event)
+}
+
+func NewMemoryBackend() *MemoryBackend { return &MemoryBackend{} }
+
+func (b *MemoryBackend) Start(_ context.Context, deliver func(Event)) error {
+	b.mu.Lock()
+	b.deliver = deliver
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *MemoryBackend) Publish(_ context.Context, e Event) error {
+	b.mu.RLock()
+	deliver := b.deliver
+	b.mu.RUnlock()
+	if deliver != nil {
+		deliver(e)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxNotifyPayload stays under PostgreSQL's 8000 byte NOTIFY payload limit
+const maxNotifyPayload = 7900
+
+var ErrEventTooLarge = errors.New("pubsub: event too large for NOTIFY")
+
+// PostgresBackend relays events between instances with LISTEN/NOTIFY
+type PostgresBackend struct {
+	db      *sql.DB
+	dsn     string
+	channel string
+}
+
+func NewPostgresBackend(db *sql.DB, dsn, channel string) *PostgresBackend {
+	if channel == "" {
+		channel = "forum_events"
+	}
+	return &PostgresBackend{db: db, dsn: dsn, channel: channel}
+}
+
+func (b *PostgresBackend) Publish(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return ErrEventTooLarge
+	}
+	_, err = b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, b.channel, string(payload))
+	return err
+}
+
+func (b *PostgresBackend) Start(ctx context.Context, deliver func(Event)) error {
+	listener := pq.NewListener(b.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println("pubsub listener:", err)
+		}
+	})
+	if err := listener.Listen(b.channel); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		defer listener.Close()
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				if n == nil {
+					// соединение переподключилось, пропущенные события не восстановить
+					continue
+				}
+				var e Event
+				if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+					fmt.Println("pubsub: bad payload:", err)
+					continue
+				}
+				deliver(e)
+			case <-ping.C:
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,106 @@
+// Package ranking holds the formulas behind the hot, top and rising feeds. Scores are computed
+// by a background job from the counts in Signals and stored, so listing a board only sorts by a column.
+package ranking
+
+import (
+	"math"
+	"time"
+)
+
+// Signals are what a post's scores are computed from
+type Signals struct {
+	CreatedAt time.Time
+	Likes     int
+	Dislikes  int
+	Comments  int
+	Views     int
+	Bookmarks int
+
+	// activity within the rising window
+	RecentLikes     int
+	RecentComments  int
+	RecentViews     int
+	RecentBookmarks int
+}
+
+type Config struct {
+	CommentWeight  float64       // сколько «лайков» стоит комментарий
+	ViewWeight     float64       // и просмотр
+	BookmarkWeight float64       // и закладка: сохраняют то, к чему хотят вернуться
+	HotTimescale   time.Duration // время, за которое пост должен набрать в 10 раз больше, чтобы остаться наравне с новым
+	RisingWindow   time.Duration // «недавняя» активность для rising
+	RisingMaxAge   time.Duration // старше — в rising не попадает
+	WilsonZ        float64       // 1.96 — 95% доверия
+}
+
+func DefaultConfig() Config {
+	return Config{
+		CommentWeight:  0.5,
+		ViewWeight:     0.02,
+		BookmarkWeight: 2,
+		HotTimescale:   12*time.Hour + 30*time.Minute, // 45000 секунд, как у Reddit
+		RisingWindow:   6 * time.Hour,
+		RisingMaxAge:   48 * time.Hour,
+		WilsonZ:        1.96,
+	}
+}
+
+// Scores are the stored sort keys of a post
+type Scores struct {
+	Hot    float64
+	Top    float64
+	Rising float64
+}
+
+func (c Config) Score(s Signals, now time.Time) Scores {
+	return Scores{Hot: c.Hot(s), Top: c.Wilson(s.Likes, s.Dislikes), Rising: c.Rising(s, now)}
+}
+
+// engagement folds votes, comments, views and bookmarks into one number of "likes"
+func (c Config) engagement(likes, dislikes, comments, views, bookmarks int) float64 {
+	return float64(likes-dislikes) + c.CommentWeight*float64(comments) + c.ViewWeight*float64(views) +
+		c.BookmarkWeight*float64(bookmarks)
+}
+
+// Hot is Reddit's formula: the order of magnitude of engagement plus a bonus growing with the
+// creation time. It does not change as the post ages, only newer posts overtake it, so a score
+// only needs recomputing when the post's counts change.
+func (c Config) Hot(s Signals) float64 {
+	e := c.engagement(s.Likes, s.Dislikes, s.Comments, s.Views, s.Bookmarks)
+	order := math.Log10(math.Max(math.Abs(e), 1))
+	sign := 0.0
+	switch {
+	case e > 0:
+		sign = 1
+	case e < 0:
+		sign = -1
+	}
+	return sign*order + float64(s.CreatedAt.Unix())/c.HotTimescale.Seconds()
+}
+
+// Wilson is the lower bound of the Wilson score interval for the share of likes: 3 likes of 3
+// rank below 95 of 100, because three votes say little
+func (c Config) Wilson(likes, dislikes int) float64 {
+	n := float64(likes + dislikes)
+	if n == 0 {
+		return 0
+	}
+	z := c.WilsonZ
+	p := float64(likes) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// Rising is recent engagement per hour of the post's life within the window,
+// for posts younger than RisingMaxAge
+func (c Config) Rising(s Signals, now time.Time) float64 {
+	age := now.Sub(s.CreatedAt)
+	if age > c.RisingMaxAge {
+		return 0
+	}
+	e := c.engagement(s.RecentLikes, 0, s.RecentComments, s.RecentViews, s.RecentBookmarks)
+	if e <= 0 {
+		return 0
+	}
+	hours := math.Min(age.Hours(), c.RisingWindow.Hours())
+	return e / (hours + 1)
+}
@@ -0,0 +1,72 @@
+package ranking
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestHotTradesTenfoldEngagementForTheTimescale(t *testing.T) {
+	c := DefaultConfig()
+	old := Signals{CreatedAt: now.Add(-c.HotTimescale), Likes: 100}
+	fresh := Signals{CreatedAt: now, Likes: 10}
+	if d := c.Hot(old) - c.Hot(fresh); math.Abs(d) > 1e-6 {
+		t.Fatalf("10x the likes one timescale earlier: differ by %g, want a tie", d)
+	}
+	if c.Hot(Signals{CreatedAt: now, Likes: 11}) <= c.Hot(old) {
+		t.Fatal("a fresh post with more than a tenth of the likes must rank higher")
+	}
+
+	// комментарии, просмотры и закладки тоже считаются, минусы тянут вниз
+	base := Signals{CreatedAt: now, Likes: 10}
+	for name, more := range map[string]Signals{
+		"comments":  {CreatedAt: now, Likes: 10, Comments: 40},
+		"views":     {CreatedAt: now, Likes: 10, Views: 1000},
+		"bookmarks": {CreatedAt: now, Likes: 10, Bookmarks: 10},
+	} {
+		if c.Hot(more) <= c.Hot(base) {
+			t.Errorf("%s do not raise the hot score", name)
+		}
+	}
+	if c.Hot(Signals{CreatedAt: now, Dislikes: 50}) >= c.Hot(Signals{CreatedAt: now}) {
+		t.Fatal("a disliked post must rank below one without votes")
+	}
+}
+
+func TestWilsonPrefersConfidence(t *testing.T) {
+	c := DefaultConfig()
+	if c.Wilson(0, 0) != 0 {
+		t.Fatal("no votes must score 0")
+	}
+	if c.Wilson(3, 0) >= c.Wilson(95, 5) {
+		t.Fatalf("3 of 3 (%.3f) must rank below 95 of 100 (%.3f)", c.Wilson(3, 0), c.Wilson(95, 5))
+	}
+	if w := c.Wilson(95, 5); w <= 0.88 || w >= 0.95 {
+		t.Fatalf("Wilson(95, 5) = %.4f, want the lower bound just under the share", w)
+	}
+	if c.Wilson(10, 10) >= c.Wilson(10, 1) {
+		t.Fatal("more dislikes must lower the score")
+	}
+}
+
+func TestRisingIsRecentVelocityOfYoungPosts(t *testing.T) {
+	c := DefaultConfig()
+	young := Signals{CreatedAt: now.Add(-time.Hour), RecentLikes: 10}
+	older := Signals{CreatedAt: now.Add(-5 * time.Hour), RecentLikes: 10}
+	if c.Rising(young, now) <= c.Rising(older, now) {
+		t.Fatal("the same recent activity must count more on a younger post")
+	}
+	// дольше окна — делим на окно, а не на весь возраст
+	if a, b := c.Rising(Signals{CreatedAt: now.Add(-10 * time.Hour), RecentLikes: 10}, now),
+		c.Rising(Signals{CreatedAt: now.Add(-20 * time.Hour), RecentLikes: 10}, now); a != b {
+		t.Fatalf("posts older than the window: %g and %g, want the same", a, b)
+	}
+	if c.Rising(Signals{CreatedAt: now.Add(-c.RisingMaxAge - time.Minute), RecentLikes: 1000}, now) != 0 {
+		t.Fatal("a post older than RisingMaxAge must not rise")
+	}
+	if c.Rising(Signals{CreatedAt: now, Likes: 100}, now) != 0 {
+		t.Fatal("old votes must not count as recent")
+	}
+}
@@ -0,0 +1,178 @@
+// Package ratelimit is a token bucket rate limiter with named policies and pluggable stores:
+// in-memory for a single instance, PostgreSQL when several instances share the limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy allows Limit requests per Window on average and bursts of up to Burst (default Limit)
+type Policy struct {
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+func (p Policy) capacity() float64 {
+	if p.Burst > 0 {
+		return float64(p.Burst)
+	}
+	return float64(p.Limit)
+}
+
+// rate is the refill speed in tokens per second
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+// String is the RateLimit-Policy form: "20;w=60"
+func (p Policy) String() string {
+	s := fmt.Sprintf("%d;w=%d", p.Limit, int(p.Window.Seconds()))
+	if p.Burst > 0 && p.Burst != p.Limit {
+		s += fmt.Sprintf(";burst=%d", p.Burst)
+	}
+	return s
+}
+
+// Store keeps the buckets. Take refills the bucket for the elapsed time, then takes one token if
+// there is one, and returns whether it did and how many tokens are left.
+type Store interface {
+	Take(ctx context.Context, key string, capacity, rate float64) (allowed bool, tokens float64, err error)
+	// Sweep forgets buckets untouched for idle; they would be full again by now anyway
+	Sweep(ctx context.Context, idle time.Duration) error
+}
+
+// Result of one check, enough for the RateLimit-* and Retry-After headers
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until the next token, when not allowed
+	Reset      time.Duration // until the bucket is full again
+	Policy     Policy
+}
+
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+func New(store Store, policies map[string]Policy) *Limiter {
+	return &Limiter{store: store, policies: policies}
+}
+
+// DefaultPolicies are per user (or token, or IP for guests)
+func DefaultPolicies() map[string]Policy {
+	return map[string]Policy{
+		"post":         {Limit: 5, Window: time.Minute},
+		"comment":      {Limit: 20, Window: time.Minute},
+		"vote":         {Limit: 60, Window: time.Minute},
+		"search":       {Limit: 60, Window: time.Minute},
+		"club":         {Limit: 5, Window: time.Hour},
+		"invite":       {Limit: 20, Window: time.Hour},
+		"board":        {Limit: 10, Window: time.Hour},
+		"message":      {Limit: 30, Window: time.Minute},
+		"conversation": {Limit: 10, Window: time.Hour},
+		"register":     {Limit: 5, Window: time.Hour},
+	}
+}
+
+// ParsePolicies overrides policies from "name=limit/window[:burst],…", e.g. "post=10/1m,search=120/1m:200"
+func ParsePolicies(spec string, into map[string]Policy) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, rule, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("ratelimit: bad policy %q", item)
+		}
+		rule, burst, hasBurst := strings.Cut(rule, ":")
+		limit, window, ok := strings.Cut(rule, "/")
+		if !ok {
+			return fmt.Errorf("ratelimit: bad policy %q", item)
+		}
+		var p Policy
+		var err error
+		if p.Limit, err = strconv.Atoi(limit); err != nil || p.Limit <= 0 {
+			return fmt.Errorf("ratelimit: bad limit in %q", item)
+		}
+		if p.Window, err = time.ParseDuration(window); err != nil || p.Window <= 0 {
+			return fmt.Errorf("ratelimit: bad window in %q", item)
+		}
+		if hasBurst {
+			if p.Burst, err = strconv.Atoi(burst); err != nil || p.Burst <= 0 {
+				return fmt.Errorf("ratelimit: bad burst in %q", item)
+			}
+		}
+		into[strings.TrimSpace(name)] = p
+	}
+	return nil
+}
+
+// Policies lists the configured policies by name, for logs
+func (l *Limiter) Policies() string {
+	names := make([]string, 0, len(l.policies))
+	for n := range l.policies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for i, n := range names {
+		names[i] = n + "=" + l.policies[n].String()
+	}
+	return strings.Join(names, " ")
+}
+
+// Allow takes a token for key under the named policy; an unknown policy always allows
+func (l *Limiter) Allow(ctx context.Context, policy, key string) (Result, error) {
+	p, ok := l.policies[policy]
+	if !ok {
+		return Result{Allowed: true}, nil
+	}
+	capacity, rate := p.capacity(), p.rate()
+	allowed, tokens, err := l.store.Take(ctx, policy+":"+key, capacity, rate)
+	if err != nil {
+		return Result{Allowed: true}, err
+	}
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     time.Duration((capacity - tokens) / rate * float64(time.Second)),
+		Policy:    p,
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return res, nil
+}
+
+// Run sweeps idle buckets until ctx is cancelled
+func (l *Limiter) Run(ctx context.Context) {
+	// бакет, не тронутый дольше полного пополнения самой медленной политики, уже полон
+	var idle time.Duration
+	for _, p := range l.policies {
+		if d := time.Duration(p.capacity() / p.rate() * float64(time.Second)); d > idle {
+			idle = d
+		}
+	}
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := l.store.Sweep(ctx, idle); err != nil {
+				fmt.Println("ratelimit sweep:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock drives MemoryStore without sleeping
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time      { return c.t }
+func (c *fakeClock) add(d time.Duration) { c.t = c.t.Add(d) }
+func newClockedStore() (*MemoryStore, *fakeClock) {
+	c := &fakeClock{t: time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = c.now
+	return s, c
+}
+
+func TestTokenBucketRefillsAtThePolicyRate(t *testing.T) {
+	store, clock := newClockedStore()
+	l := New(store, map[string]Policy{"post": {Limit: 3, Window: 3 * time.Second}})
+	ctx := context.Background()
+
+	for i := 3; i > 0; i-- {
+		res, err := l.Allow(ctx, "post", "u:1")
+		if err != nil || !res.Allowed || res.Remaining != i-1 || res.Limit != 3 {
+			t.Fatalf("request %d: %+v, %v", 4-i, res, err)
+		}
+	}
+	res, _ := l.Allow(ctx, "post", "u:1")
+	if res.Allowed || res.Remaining != 0 {
+		t.Fatalf("fourth request in the window: %+v, want denied", res)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("RetryAfter %v, want up to one token's refill (1s)", res.RetryAfter)
+	}
+	if res.Reset != 3*time.Second {
+		t.Fatalf("Reset %v, want the whole window to refill an empty bucket", res.Reset)
+	}
+
+	// отказ токен не тратит: через секунду ровно один новый
+	clock.add(time.Second)
+	if res, _ := l.Allow(ctx, "post", "u:1"); !res.Allowed {
+		t.Fatalf("after one token's refill: %+v", res)
+	}
+	if res, _ := l.Allow(ctx, "post", "u:1"); res.Allowed {
+		t.Fatal("a second token appeared after one token's refill")
+	}
+
+	// простой не копит больше ёмкости
+	clock.add(time.Hour)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if res, _ := l.Allow(ctx, "post", "u:1"); res.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("%d requests after an idle hour, want the capacity of 3", allowed)
+	}
+}
+
+func TestBucketsArePerPolicyAndKey(t *testing.T) {
+	store, _ := newClockedStore()
+	l := New(store, map[string]Policy{
+		"post":   {Limit: 1, Window: time.Minute},
+		"search": {Limit: 60, Window: time.Minute, Burst: 2},
+	})
+	ctx := context.Background()
+	if res, _ := l.Allow(ctx, "post", "u:1"); !res.Allowed {
+		t.Fatal("first post denied")
+	}
+	if res, _ := l.Allow(ctx, "post", "u:1"); res.Allowed {
+		t.Fatal("second post allowed")
+	}
+	if res, _ := l.Allow(ctx, "post", "u:2"); !res.Allowed {
+		t.Fatal("another user shares the bucket")
+	}
+	if res, _ := l.Allow(ctx, "search", "u:1"); !res.Allowed || res.Limit != 2 {
+		t.Fatalf("search after post: %+v, want its own bucket of the burst size", res)
+	}
+	if res, _ := l.Allow(ctx, "search", "u:1"); !res.Allowed {
+		t.Fatal("second search within the burst denied")
+	}
+	if res, _ := l.Allow(ctx, "search", "u:1"); res.Allowed {
+		t.Fatal("search beyond the burst allowed")
+	}
+	for i := 0; i < 100; i++ {
+		if res, _ := l.Allow(ctx, "unknown", "u:1"); !res.Allowed {
+			t.Fatal("a policy that isn't configured limited the request")
+		}
+	}
+}
+
+func TestMemorySweepForgetsIdleBuckets(t *testing.T) {
+	store, clock := newClockedStore()
+	ctx := context.Background()
+	store.Take(ctx, "idle", 5, 1)
+	clock.add(10 * time.Minute)
+	store.Take(ctx, "busy", 5, 1)
+	if err := store.Sweep(ctx, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.buckets["idle"]; ok {
+		t.Fatal("idle bucket kept")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Fatal("recently used bucket swept")
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies := DefaultPolicies()
+	if err := ParsePolicies(" post=10/1m, search=120/1m:200 ,", policies); err != nil {
+		t.Fatal(err)
+	}
+	if p := policies["post"]; p != (Policy{Limit: 10, Window: time.Minute}) {
+		t.Fatalf("post: %+v", p)
+	}
+	if p := policies["search"]; p.String() != "120;w=60;burst=200" {
+		t.Fatalf("search: %s", p)
+	}
+	if policies["comment"] != DefaultPolicies()["comment"] {
+		t.Fatal("a policy missing from the spec changed")
+	}
+	for _, spec := range []string{"post", "post=10", "post=0/1m", "post=10/0s", "post=10/soon", "post=10/1m:0", "post=x/1m"} {
+		if err := ParsePolicies(spec, map[string]Policy{}); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore shares buckets between instances through the rate_limits table
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, capacity, rate float64) (bool, float64, error) {
+	// пополнение и списание одним UPSERT: строка блокируется на время запроса, гонок между инстансами нет.
+	// В SET все ссылки на rate_limits.* — это значения до обновления.
+	var allowed bool
+	var tokens float64
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at) VALUES ($1, $2::float8 - 1, true, now())
+        ON CONFLICT (key) DO UPDATE SET
+            allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1,
+            tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8)
+                - CASE WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1
+                  THEN 1 ELSE 0 END,
+            updated_at = now()
+        RETURNING allowed, tokens`, key, capacity, rate).Scan(&allowed, &tokens)
+	return allowed, tokens, err
+}
+
+func (s *PostgresStore) Sweep(ctx context.Context, idle time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM rate_limits WHERE updated_at < now() - $1 * interval '1 second'`, idle.Seconds())
+	return err
+}
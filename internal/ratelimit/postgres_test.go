@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"forum1/internal/dbtest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostgresTakeIsAtomicAcrossConnections(t *testing.T) {
+	db := dbtest.Open(t, "012_rate_limits.sql")
+	s := NewPostgresStore(db)
+	ctx := context.Background()
+
+	// 30 одновременных запросов к бакету на 10: пропустить должно ровно 10
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := s.Take(ctx, "post:u:1", 10, 0.001)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 10 {
+		t.Fatalf("%d of 30 concurrent requests allowed, want 10", n)
+	}
+	if ok, tokens, err := s.Take(ctx, "post:u:2", 10, 0.001); err != nil || !ok || tokens != 9 {
+		t.Fatalf("another key: allowed %v with %v tokens (%v), want a full bucket of its own", ok, tokens, err)
+	}
+}
+
+func TestPostgresTakeRefillsForElapsedTime(t *testing.T) {
+	db := dbtest.Open(t, "012_rate_limits.sql")
+	s := NewPostgresStore(db)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if ok, _, err := s.Take(ctx, "k", 2, 1); err != nil || !ok {
+			t.Fatalf("take %d: %v %v", i, ok, err)
+		}
+	}
+	if ok, _, _ := s.Take(ctx, "k", 2, 0.001); ok {
+		t.Fatal("empty bucket allowed a request")
+	}
+	// пять секунд простоя при 1 токене в секунду наполняют бакет, но не выше ёмкости
+	if _, err := db.Exec(`UPDATE rate_limits SET updated_at = now() - interval '5 seconds' WHERE key = 'k'`); err != nil {
+		t.Fatal(err)
+	}
+	ok, tokens, err := s.Take(ctx, "k", 2, 1)
+	if err != nil || !ok || tokens < 0.99 || tokens > 1.01 {
+		t.Fatalf("after refill: allowed %v with %v tokens (%v), want 1 left of a full bucket of 2", ok, tokens, err)
+	}
+}
+
+func TestPostgresSweepDropsIdleRows(t *testing.T) {
+	db := dbtest.Open(t, "012_rate_limits.sql")
+	s := NewPostgresStore(db)
+	ctx := context.Background()
+	s.Take(ctx, "idle", 5, 1)
+	s.Take(ctx, "busy", 5, 1)
+	if _, err := db.Exec(`UPDATE rate_limits SET updated_at = now() - interval '1 hour' WHERE key = 'idle'`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sweep(ctx, 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	rows, err := db.Query(`SELECT key FROM rate_limits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		rows.Scan(&k)
+		keys = append(keys, k)
+	}
+	if len(keys) != 1 || keys[0] != "busy" {
+		t.Fatalf("rows after sweep: %v, want only busy", keys)
+	}
+}
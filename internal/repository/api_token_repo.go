@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type APITokenRepository interface {
+	Create(ctx context.Context, tokenHash string, t *entity.APIToken) error
+	// GetUser returns the owner of a live (not expired, not revoked) token
+	GetUser(ctx context.Context, tokenHash string) (*entity.User, *entity.APIToken, error)
+	Touch(ctx context.Context, id int64, ip string) error
+	ListActive(ctx context.Context, userID int64) ([]entity.APIToken, error)
+	CountActive(ctx context.Context, userID int64) (int, error)
+	// Revoke only touches tokens of userID; sql.ErrNoRows if there was nothing to revoke
+	Revoke(ctx context.Context, userID, id int64) error
+}
+
+func NewAPITokenRepository(db *sql.DB) APITokenRepository {
+	return &apiTokenRepository{db: db}
+}
+
+type apiTokenRepository struct{ db *sql.DB }
+
+const apiTokenColumns = `t.id, t.user_id, t.name, t.token_prefix, t.scopes, t.created_at, t.expires_at, t.last_used_at, t.last_used_ip`
+
+const apiTokenLive = `t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > now())`
+
+func apiTokenDest(t *entity.APIToken, expires, lastUsed *sql.NullTime) []any {
+	return []any{&t.ID, &t.UserID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.CreatedAt, expires, lastUsed, &t.LastUsedIP}
+}
+
+func fillAPIToken(t *entity.APIToken, expires, lastUsed sql.NullTime) {
+	if expires.Valid {
+		t.ExpiresAt = &expires.Time
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+}
+
+func (r *apiTokenRepository) Create(ctx context.Context, tokenHash string, t *entity.APIToken) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+        VALUES ($1,$2,$3,$4,$5,$6)
+        RETURNING id, created_at`,
+		t.UserID, t.Name, t.Prefix, tokenHash, pq.Array(t.Scopes), t.ExpiresAt,
+	).Scan(&t.ID, &t.CreatedAt)
+}
+
+func (r *apiTokenRepository) GetUser(ctx context.Context, tokenHash string) (*entity.User, *entity.APIToken, error) {
+	var t entity.APIToken
+	var expires, lastUsed sql.NullTime
+	u, err := scanUser(r.db.QueryRowContext(ctx, `
+        SELECT `+apiTokenColumns+`, `+userColumns+`
+        FROM api_tokens t
+        JOIN users ON users.id = t.user_id
+        WHERE t.token_hash=$1 AND `+apiTokenLive, tokenHash),
+		apiTokenDest(&t, &expires, &lastUsed)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	fillAPIToken(&t, expires, lastUsed)
+	return u, &t, nil
+}
+
+func (r *apiTokenRepository) Touch(ctx context.Context, id int64, ip string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at=now(), last_used_ip=$2 WHERE id=$1`, id, ip)
+	return err
+}
+
+func (r *apiTokenRepository) ListActive(ctx context.Context, userID int64) ([]entity.APIToken, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+apiTokenColumns+` FROM api_tokens t
+        WHERE t.user_id=$1 AND `+apiTokenLive+`
+        ORDER BY t.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.APIToken{}
+	for rows.Next() {
+		var t entity.APIToken
+		var expires, lastUsed sql.NullTime
+		if err := rows.Scan(apiTokenDest(&t, &expires, &lastUsed)...); err != nil {
+			return nil, err
+		}
+		fillAPIToken(&t, expires, lastUsed)
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+func (r *apiTokenRepository) CountActive(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM api_tokens t WHERE t.user_id=$1 AND `+apiTokenLive, userID).Scan(&n)
+	return n, err
+}
+
+func (r *apiTokenRepository) Revoke(ctx context.Context, userID, id int64) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE api_tokens SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type AutomodRepository interface {
+	// Applicable returns the enabled rules that cover boardID: site-wide, its club's and its own
+	Applicable(ctx context.Context, boardID int64) ([]entity.AutomodRule, error)
+	// List returns every rule, site-wide first
+	List(ctx context.Context) ([]entity.AutomodRule, error)
+	Get(ctx context.Context, id int64) (*entity.AutomodRule, error)
+	Create(ctx context.Context, r *entity.AutomodRule) error
+	SetEnabled(ctx context.Context, id int64, enabled bool) error
+	Delete(ctx context.Context, id int64) error
+
+	// Karma is the sum of votes other users gave to the user's published posts and comments
+	Karma(ctx context.Context, userID int64) (int, error)
+	// RecentPosts returns up to limit newest published posts of a club or a board (both nil: all),
+	// for dry runs. ImageData is only non-empty, not loaded in full.
+	RecentPosts(ctx context.Context, clubID, boardID *int64, limit int) ([]entity.Post, error)
+}
+
+func NewAutomodRepository(db *sql.DB) AutomodRepository {
+	return &automodRepository{db: db}
+}
+
+type automodRepository struct{ db *sql.DB }
+
+const automodColumns = `r.id, r.name, r.club_id, r.board_id, r.target, r.pattern, r.max_account_age_hours, r.max_karma,
+        r.link_domains, r.attachment, r.action, r.tag, r.message, r.enabled, r.created_by, r.created_at`
+
+func scanAutomodRule(row rowScanner) (*entity.AutomodRule, error) {
+	var rule entity.AutomodRule
+	var club, board, karma, by sql.NullInt64
+	if err := row.Scan(&rule.ID, &rule.Name, &club, &board, &rule.Target, &rule.Pattern, &rule.MaxAccountAgeHours, &karma,
+		pq.Array(&rule.LinkDomains), &rule.Attachment, &rule.Action, &rule.Tag, &rule.Message, &rule.Enabled, &by,
+		&rule.CreatedAt); err != nil {
+		return nil, err
+	}
+	if club.Valid {
+		rule.ClubID = &club.Int64
+	}
+	if board.Valid {
+		rule.BoardID = &board.Int64
+	}
+	if karma.Valid {
+		k := int(karma.Int64)
+		rule.MaxKarma = &k
+	}
+	if by.Valid {
+		rule.CreatedBy = &by.Int64
+	}
+	return &rule, nil
+}
+
+func (r *automodRepository) query(ctx context.Context, q string, args ...any) ([]entity.AutomodRule, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.AutomodRule{}
+	for rows.Next() {
+		rule, err := scanAutomodRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *rule)
+	}
+	return res, rows.Err()
+}
+
+func (r *automodRepository) Applicable(ctx context.Context, boardID int64) ([]entity.AutomodRule, error) {
+	return r.query(ctx, `
+        SELECT `+automodColumns+` FROM automod_rules r
+        LEFT JOIN boards b ON b.id = $1
+        WHERE r.enabled AND (
+            (r.club_id IS NULL AND r.board_id IS NULL) OR r.board_id = $1 OR r.club_id = b.club_id)
+        ORDER BY r.id`, boardID)
+}
+
+func (r *automodRepository) List(ctx context.Context) ([]entity.AutomodRule, error) {
+	return r.query(ctx, `
+        SELECT `+automodColumns+` FROM automod_rules r
+        ORDER BY r.club_id NULLS FIRST, r.board_id NULLS FIRST, r.id`)
+}
+
+func (r *automodRepository) Get(ctx context.Context, id int64) (*entity.AutomodRule, error) {
+	return scanAutomodRule(r.db.QueryRowContext(ctx, `SELECT `+automodColumns+` FROM automod_rules r WHERE r.id=$1`, id))
+}
+
+func (r *automodRepository) Create(ctx context.Context, rule *entity.AutomodRule) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO automod_rules (name, club_id, board_id, target, pattern, max_account_age_hours, max_karma,
+            link_domains, attachment, action, tag, message, enabled, created_by)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+        RETURNING id, created_at`,
+		rule.Name, rule.ClubID, rule.BoardID, rule.Target, rule.Pattern, rule.MaxAccountAgeHours, rule.MaxKarma,
+		pq.Array(rule.LinkDomains), rule.Attachment, rule.Action, rule.Tag, rule.Message, rule.Enabled, rule.CreatedBy,
+	).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+func (r *automodRepository) SetEnabled(ctx context.Context, id int64, enabled bool) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE automod_rules SET enabled=$2 WHERE id=$1`, id, enabled)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *automodRepository) Delete(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM automod_rules WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *automodRepository) Karma(ctx context.Context, userID int64) (int, error) {
+	var karma int
+	// счётчики user_karma ведут голосования, см. karmaDelta
+	err := r.db.QueryRowContext(ctx, `
+        SELECT COALESCE((SELECT post_karma + comment_karma FROM user_karma WHERE user_id = $1), 0)`, userID).Scan(&karma)
+	return karma, err
+}
+
+func (r *automodRepository) RecentPosts(ctx context.Context, clubID, boardID *int64, limit int) ([]entity.Post, error) {
+	// substring вместо самой картинки: условию «есть вложение» хватает одного байта
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT p.id, p.board_id, p.title, COALESCE(p.content, ''), p.author_id, COALESCE(p.image_url, ''),
+               substring(p.image_data from 1 for 1), COALESCE(p.link_url, ''), p.created_at
+        FROM posts p JOIN boards b ON b.id = p.board_id
+        WHERE p.status = 'published' AND ($1::bigint IS NULL OR b.club_id = $1) AND ($2::bigint IS NULL OR p.board_id = $2)
+        ORDER BY p.created_at DESC LIMIT $3`, clubID, boardID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.Post{}
+	for rows.Next() {
+		var p entity.Post
+		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &p.ImageURL, &p.ImageData,
+			&p.LinkURL, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type BlockRepository interface {
+	Block(ctx context.Context, blockerID, blockedID int64) error
+	Unblock(ctx context.Context, blockerID, blockedID int64) error
+	// BlockedAmong returns which of userIDs blocked userID or were blocked by it
+	BlockedAmong(ctx context.Context, userID int64, userIDs []int64) ([]int64, error)
+	ListBlocked(ctx context.Context, blockerID int64) ([]entity.User, error)
+}
+
+func NewBlockRepository(db *sql.DB) BlockRepository {
+	return &blockRepository{db: db}
+}
+
+type blockRepository struct{ db *sql.DB }
+
+func (r *blockRepository) Block(ctx context.Context, blockerID, blockedID int64) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO user_blocks (blocker_id, blocked_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`, blockerID, blockedID)
+	return err
+}
+
+func (r *blockRepository) Unblock(ctx context.Context, blockerID, blockedID int64) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM user_blocks WHERE blocker_id=$1 AND blocked_id=$2`, blockerID, blockedID)
+	return err
+}
+
+func (r *blockRepository) BlockedAmong(ctx context.Context, userID int64, userIDs []int64) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT blocked_id FROM user_blocks WHERE blocker_id=$1 AND blocked_id = ANY($2)
+        UNION
+        SELECT blocker_id FROM user_blocks WHERE blocked_id=$1 AND blocker_id = ANY($2)`,
+		userID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+func (r *blockRepository) ListBlocked(ctx context.Context, blockerID int64) ([]entity.User, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT u.id, u.username FROM user_blocks b
+        JOIN users u ON u.id = b.blocked_id
+        WHERE b.blocker_id=$1
+        ORDER BY b.created_at DESC`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.User
+	for rows.Next() {
+		var u entity.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		res = append(res, u)
+	}
+	return res, rows.Err()
+}
@@ -8,6 +8,7 @@ import (
 
 type BoardRepository interface {
 	GetBySlug(ctx context.Context, slug string) (*entity.Board, error)
+	GetByID(ctx context.Context, id int64) (*entity.Board, error)
 	List(ctx context.Context) ([]entity.Board, error)
 	GetByClubID(ctx context.Context, clubID int64) ([]entity.Board, error)
 	Create(ctx context.Context, board *entity.Board) (int64, error)
@@ -31,6 +32,20 @@ func (r *boardRepository) GetBySlug(ctx context.Context, slug string) (*entity.B
 	}
 	return &b, nil
 }
+
+func (r *boardRepository) GetByID(ctx context.Context, id int64) (*entity.Board, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT id, slug, title, description, club_id FROM boards WHERE id=$1`, id)
+	var b entity.Board
+	var clubID sql.NullInt64
+	if err := row.Scan(&b.ID, &b.Slug, &b.Title, &b.Description, &clubID); err != nil {
+		return nil, err
+	}
+	if clubID.Valid {
+		b.ClubID = &clubID.Int64
+	}
+	return &b, nil
+}
+
 func (r *boardRepository) List(ctx context.Context) ([]entity.Board, error) {
 	rows, err := r.db.QueryContext(ctx, `SELECT id, slug, title, description, club_id FROM boards ORDER BY title`)
 	if err != nil {
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+)
+
+type BookmarkRepository interface {
+	// Save bookmarks b.PostID, or b.CommentID when it is set; bookmarking the same thing again
+	// moves it to b.FolderID and replaces the note. Fills b.ID and b.CreatedAt.
+	Save(ctx context.Context, b *entity.Bookmark) error
+	// Delete removes a bookmark of the user; sql.ErrNoRows when there is none
+	Delete(ctx context.Context, userID, id int64) error
+	// List is a page of the user's bookmarks, newest first, and how many there are in total
+	List(ctx context.Context, q entity.BookmarkQuery) ([]entity.Bookmark, int, error)
+	// ForPost is the user's bookmark of the post (nil if none) and the bookmark ids of its bookmarked comments
+	ForPost(ctx context.Context, userID, postID int64) (*entity.Bookmark, map[int64]int64, error)
+
+	Folders(ctx context.Context, userID int64) ([]entity.BookmarkFolder, error)
+	// FolderOwned reports whether the folder belongs to the user
+	FolderOwned(ctx context.Context, userID, folderID int64) (bool, error)
+	// CreateFolder returns the existing folder when the user already has one with this name
+	CreateFolder(ctx context.Context, userID int64, name string) (*entity.BookmarkFolder, error)
+	RenameFolder(ctx context.Context, userID, id int64, name string) error
+	// DeleteFolder keeps its bookmarks without a folder; sql.ErrNoRows when there is none
+	DeleteFolder(ctx context.Context, userID, id int64) error
+}
+
+func NewBookmarkRepository(db *sql.DB) BookmarkRepository {
+	return &bookmarkRepository{db: db}
+}
+
+type bookmarkRepository struct{ db *sql.DB }
+
+func (r *bookmarkRepository) Save(ctx context.Context, b *entity.Bookmark) error {
+	if b.CommentID != nil {
+		return r.db.QueryRowContext(ctx, `
+            INSERT INTO bookmarks (user_id, comment_id, folder_id, note) VALUES ($1, $2, $3, $4)
+            ON CONFLICT (user_id, comment_id) WHERE comment_id IS NOT NULL
+            DO UPDATE SET folder_id = EXCLUDED.folder_id, note = EXCLUDED.note
+            RETURNING id, created_at`, b.UserID, *b.CommentID, b.FolderID, b.Note).Scan(&b.ID, &b.CreatedAt)
+	}
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO bookmarks (user_id, post_id, folder_id, note) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, post_id) WHERE post_id IS NOT NULL
+        DO UPDATE SET folder_id = EXCLUDED.folder_id, note = EXCLUDED.note
+        RETURNING id, created_at`, b.UserID, b.PostID, b.FolderID, b.Note).Scan(&b.ID, &b.CreatedAt)
+}
+
+func (r *bookmarkRepository) Delete(ctx context.Context, userID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM bookmarks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *bookmarkRepository) List(ctx context.Context, q entity.BookmarkQuery) ([]entity.Bookmark, int, error) {
+	// total — оконной функцией в том же запросе, чтобы страница и счётчик не разъехались
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT b.id, COALESCE(b.post_id, c.post_id), b.comment_id, b.folder_id, COALESCE(f.name, ''), b.note,
+               b.created_at, p.title, COALESCE(left(c.content, 200), ''), count(*) OVER ()
+        FROM bookmarks b
+        LEFT JOIN comments c ON c.id = b.comment_id
+        JOIN posts p ON p.id = COALESCE(b.post_id, c.post_id)
+        LEFT JOIN bookmark_folders f ON f.id = b.folder_id
+        WHERE b.user_id = $1
+          AND ($2 = 0 OR ($2 = -1 AND b.folder_id IS NULL) OR b.folder_id = $2)
+        ORDER BY b.created_at DESC, b.id DESC
+        LIMIT NULLIF($3, 0) OFFSET $4`, q.UserID, q.FolderID, q.Limit, q.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	res := []entity.Bookmark{}
+	total := 0
+	for rows.Next() {
+		b := entity.Bookmark{UserID: q.UserID}
+		if err := rows.Scan(&b.ID, &b.PostID, &b.CommentID, &b.FolderID, &b.FolderName, &b.Note, &b.CreatedAt,
+			&b.PostTitle, &b.Excerpt, &total); err != nil {
+			return nil, 0, err
+		}
+		res = append(res, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(res) == 0 && q.Offset > 0 {
+		// за последней страницей строк нет, а с ними и total — считаем отдельно
+		err = r.db.QueryRowContext(ctx, `
+            SELECT count(*) FROM bookmarks b
+            WHERE b.user_id = $1 AND ($2 = 0 OR ($2 = -1 AND b.folder_id IS NULL) OR b.folder_id = $2)`,
+			q.UserID, q.FolderID).Scan(&total)
+	}
+	return res, total, err
+}
+
+func (r *bookmarkRepository) ForPost(ctx context.Context, userID, postID int64) (*entity.Bookmark, map[int64]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT b.id, b.comment_id, b.folder_id, COALESCE(f.name, ''), b.note, b.created_at
+        FROM bookmarks b
+        LEFT JOIN bookmark_folders f ON f.id = b.folder_id
+        WHERE b.user_id = $1 AND (b.post_id = $2 OR b.comment_id IN (SELECT id FROM comments WHERE post_id = $2))`,
+		userID, postID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var post *entity.Bookmark
+	comments := map[int64]int64{}
+	for rows.Next() {
+		b := entity.Bookmark{UserID: userID, PostID: postID}
+		if err := rows.Scan(&b.ID, &b.CommentID, &b.FolderID, &b.FolderName, &b.Note, &b.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		if b.CommentID != nil {
+			comments[*b.CommentID] = b.ID
+		} else {
+			post = &b
+		}
+	}
+	return post, comments, rows.Err()
+}
+
+func (r *bookmarkRepository) Folders(ctx context.Context, userID int64) ([]entity.BookmarkFolder, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT f.id, f.name, f.created_at, count(b.id)
+        FROM bookmark_folders f
+        LEFT JOIN bookmarks b ON b.folder_id = f.id
+        WHERE f.user_id = $1
+        GROUP BY f.id
+        ORDER BY f.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.BookmarkFolder{}
+	for rows.Next() {
+		var f entity.BookmarkFolder
+		if err := rows.Scan(&f.ID, &f.Name, &f.CreatedAt, &f.Count); err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+	return res, rows.Err()
+}
+
+func (r *bookmarkRepository) FolderOwned(ctx context.Context, userID, folderID int64) (bool, error) {
+	var ok bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM bookmark_folders WHERE id = $1 AND user_id = $2)`, folderID, userID).Scan(&ok)
+	return ok, err
+}
+
+func (r *bookmarkRepository) CreateFolder(ctx context.Context, userID int64, name string) (*entity.BookmarkFolder, error) {
+	f := entity.BookmarkFolder{Name: name}
+	// DO UPDATE с тем же значением, чтобы RETURNING вернул и существующую папку
+	err := r.db.QueryRowContext(ctx, `
+        INSERT INTO bookmark_folders (user_id, name) VALUES ($1, $2)
+        ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name
+        RETURNING id, created_at`, userID, name).Scan(&f.ID, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (r *bookmarkRepository) RenameFolder(ctx context.Context, userID, id int64, name string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE bookmark_folders SET name = $3 WHERE id = $1 AND user_id = $2`, id, userID, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *bookmarkRepository) DeleteFolder(ctx context.Context, userID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM bookmark_folders WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
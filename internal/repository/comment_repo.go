@@ -14,6 +14,10 @@ type CommentRepository interface {
 	ForceDeleteComment(ctx context.Context, id int64) error
 	SetCommentVote(ctx context.Context, commentID int64, userID int64, value int) error
 	GetCommentVotes(ctx context.Context, commentID int64) (likes int, dislikes int, err error)
+	// SetCommentStatus publishes, holds or rejects a comment; GetCommentsByPost only shows published ones
+	SetCommentStatus(ctx context.Context, id int64, status string) error
+	// PostClosed returns the post's status and whether the thread is locked or archived
+	PostClosed(ctx context.Context, postID int64) (status string, locked, archived bool, err error)
 }
 
 func NewCommentRepository(db *sql.DB) CommentRepository {
@@ -23,21 +27,21 @@ func NewCommentRepository(db *sql.DB) CommentRepository {
 type commentRepository struct{ db *sql.DB }
 
 func (r *commentRepository) CreateComment(ctx context.Context, c *entity.Comment) (int64, error) {
-	query := `INSERT INTO comments (post_id, author_id, content, image_data, parent_id) 
-	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	query := `INSERT INTO comments (post_id, author_id, content, image_data, parent_id, quote_id, status) 
+	          VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7,''),'published')) RETURNING id`
 	var id int64
-	err := r.db.QueryRowContext(ctx, query, c.PostID, c.AuthorID, c.Content, c.ImageData, c.ParentID).Scan(&id)
+	err := r.db.QueryRowContext(ctx, query, c.PostID, c.AuthorID, c.Content, c.ImageData, c.ParentID, c.QuoteID, c.Status).Scan(&id)
 	return id, err
 }
 
 func (r *commentRepository) GetCommentsByPost(ctx context.Context, postID int64) ([]entity.Comment, error) {
 	rows, err := r.db.QueryContext(ctx, `
-        SELECT c.id, c.post_id, c.author_id, c.content, c.image_data, c.parent_id, c.created_at, c.updated_at,
+        SELECT c.id, c.post_id, c.author_id, c.content, c.image_data, c.parent_id, c.quote_id, c.created_at, c.updated_at,
                COALESCE(SUM(CASE WHEN cv.value=1 THEN 1 ELSE 0 END),0) AS likes,
                COALESCE(SUM(CASE WHEN cv.value=-1 THEN 1 ELSE 0 END),0) AS dislikes
         FROM comments c
         LEFT JOIN comment_votes cv ON cv.comment_id = c.id
-        WHERE c.post_id = $1
+        WHERE c.post_id = $1 AND c.status = 'published'
         GROUP BY c.id, c.parent_id, c.image_data
         ORDER BY c.parent_id NULLS FIRST, c.created_at ASC`, postID)
 	if err != nil {
@@ -47,13 +51,16 @@ func (r *commentRepository) GetCommentsByPost(ctx context.Context, postID int64)
 	var out []entity.Comment
 	for rows.Next() {
 		var c entity.Comment
-		var parentID sql.NullInt64
-		if err := rows.Scan(&c.ID, &c.PostID, &c.AuthorID, &c.Content, &c.ImageData, &parentID, &c.CreatedAt, &c.UpdatedAt, &c.Likes, &c.Dislikes); err != nil {
+		var parentID, quoteID sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.PostID, &c.AuthorID, &c.Content, &c.ImageData, &parentID, &quoteID, &c.CreatedAt, &c.UpdatedAt, &c.Likes, &c.Dislikes); err != nil {
 			return nil, err
 		}
 		if parentID.Valid {
 			c.ParentID = &parentID.Int64
 		}
+		if quoteID.Valid {
+			c.QuoteID = &quoteID.Int64
+		}
 		out = append(out, c)
 	}
 	return out, nil
@@ -61,9 +68,9 @@ func (r *commentRepository) GetCommentsByPost(ctx context.Context, postID int64)
 func (r *commentRepository) GetCommentByID(ctx context.Context, id int64) (*entity.Comment, error) {
 	var c entity.Comment
 	err := r.db.QueryRowContext(ctx, `
-        SELECT id, post_id, author_id, content, created_at, updated_at
+        SELECT id, post_id, author_id, content, image_data, created_at, updated_at, status
         FROM comments WHERE id=$1`, id,
-	).Scan(&c.ID, &c.PostID, &c.AuthorID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	).Scan(&c.ID, &c.PostID, &c.AuthorID, &c.Content, &c.ImageData, &c.CreatedAt, &c.UpdatedAt, &c.Status)
 	if err != nil {
 		return nil, err
 	}
@@ -79,10 +86,21 @@ func (r *commentRepository) ForceDeleteComment(ctx context.Context, id int64) er
 }
 
 func (r *commentRepository) SetCommentVote(ctx context.Context, commentID int64, userID int64, value int) error {
+	// карма автора меняется в том же запросе на разницу между новым и прежним голосом
 	_, err := r.db.ExecContext(ctx, `
-        INSERT INTO comment_votes (comment_id, user_id, value)
-        VALUES ($1,$2,$3)
-        ON CONFLICT (comment_id,user_id) DO UPDATE SET value=EXCLUDED.value`, commentID, userID, value)
+        WITH old AS (
+            SELECT value FROM comment_votes WHERE comment_id=$1 AND user_id=$2
+        ), vote AS (
+            INSERT INTO comment_votes (comment_id, user_id, value)
+            VALUES ($1,$2,$3)
+            ON CONFLICT (comment_id,user_id) DO UPDATE SET value=EXCLUDED.value
+            WHERE comment_votes.value <> EXCLUDED.value
+            RETURNING value
+        ), delta AS (
+            SELECT c.author_id, b.club_id, v.value - COALESCE((SELECT value FROM old), 0) AS d
+            FROM vote v JOIN comments c ON c.id = $1 JOIN posts p ON p.id = c.post_id JOIN boards b ON b.id = p.board_id
+            WHERE c.author_id <> $2 AND c.status = 'published'
+        )`+karmaDelta("comment_karma"), commentID, userID, value)
 	return err
 }
 
@@ -93,3 +111,14 @@ func (r *commentRepository) GetCommentVotes(ctx context.Context, commentID int64
         FROM comment_votes WHERE comment_id=$1`, commentID).Scan(&likes, &dislikes)
 	return
 }
+
+func (r *commentRepository) SetCommentStatus(ctx context.Context, id int64, status string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE comments SET status=$2 WHERE id=$1`, id, status)
+	return err
+}
+
+func (r *commentRepository) PostClosed(ctx context.Context, postID int64) (status string, locked, archived bool, err error) {
+	err = r.db.QueryRowContext(ctx, `
+        SELECT p.status, p.locked_at IS NOT NULL, p.archived_at IS NOT NULL FROM posts p WHERE p.id=$1`, postID).Scan(&status, &locked, &archived)
+	return
+}
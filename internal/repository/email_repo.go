@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"strings"
+	"time"
+)
+
+type EmailRepository interface {
+	Enqueue(ctx context.Context, e *entity.Email) error
+	// Claim locks up to limit due messages for lease and bumps their attempt counter.
+	// Several workers (or app instances) can claim concurrently without getting the same rows.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]entity.Email, error)
+	MarkSent(ctx context.Context, id int64) error
+	// Retry schedules another attempt; MarkFailed gives up on the message
+	Retry(ctx context.Context, id int64, next time.Time, lastErr string) error
+	MarkFailed(ctx context.Context, id int64, status, lastErr string) error
+
+	// Suppression returns the reason the address must not be mailed, or "" if it may
+	Suppression(ctx context.Context, email string) (string, error)
+	Suppress(ctx context.Context, email, reason, details string) error
+}
+
+func NewEmailRepository(db *sql.DB) EmailRepository {
+	return &emailRepository{db: db}
+}
+
+type emailRepository struct{ db *sql.DB }
+
+func (r *emailRepository) Enqueue(ctx context.Context, e *entity.Email) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO email_outbox (user_id, to_address, template, lang, subject, text_body, html_body, transactional)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+        RETURNING id, status, next_attempt_at, created_at`,
+		e.UserID, e.To, e.Template, e.Lang, e.Subject, e.Text, e.HTML, e.Transactional,
+	).Scan(&e.ID, &e.Status, &e.NextAttemptAt, &e.CreatedAt)
+}
+
+func (r *emailRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]entity.Email, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        UPDATE email_outbox SET status='sending', attempts=attempts+1, locked_until=now() + $2 * interval '1 second'
+        WHERE id IN (
+            SELECT id FROM email_outbox
+            WHERE (status='pending' AND next_attempt_at <= now())
+               OR (status='sending' AND locked_until < now()) -- воркер упал посреди отправки
+            ORDER BY next_attempt_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, user_id, to_address, template, lang, subject, text_body, html_body, transactional,
+                  status, attempts, next_attempt_at, last_error, created_at`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.Email
+	for rows.Next() {
+		var e entity.Email
+		var userID sql.NullInt64
+		if err := rows.Scan(&e.ID, &userID, &e.To, &e.Template, &e.Lang, &e.Subject, &e.Text, &e.HTML, &e.Transactional,
+			&e.Status, &e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.UserID = nullInt64Ptr(userID)
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+func (r *emailRepository) MarkSent(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE email_outbox SET status='sent', sent_at=now(), locked_until=NULL, last_error='' WHERE id=$1`, id)
+	return err
+}
+
+func (r *emailRepository) Retry(ctx context.Context, id int64, next time.Time, lastErr string) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE email_outbox SET status='pending', next_attempt_at=$2, locked_until=NULL, last_error=$3 WHERE id=$1`,
+		id, next, lastErr)
+	return err
+}
+
+func (r *emailRepository) MarkFailed(ctx context.Context, id int64, status, lastErr string) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE email_outbox SET status=$2, locked_until=NULL, last_error=$3 WHERE id=$1`, id, status, lastErr)
+	return err
+}
+
+func (r *emailRepository) Suppression(ctx context.Context, email string) (string, error) {
+	var reason string
+	err := r.db.QueryRowContext(ctx, `SELECT reason FROM email_suppressions WHERE email=$1`,
+		strings.ToLower(strings.TrimSpace(email))).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return reason, err
+}
+
+func (r *emailRepository) Suppress(ctx context.Context, email, reason, details string) error {
+	// жалоба/bounce важнее отписки, поэтому перезаписываем причину
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO email_suppressions (email, reason, details) VALUES ($1,$2,$3)
+        ON CONFLICT (email) DO UPDATE SET reason=EXCLUDED.reason, details=EXCLUDED.details, created_at=now()
+        WHERE email_suppressions.reason = 'unsubscribe'`,
+		strings.ToLower(strings.TrimSpace(email)), reason, details)
+	return err
+}
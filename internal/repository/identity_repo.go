@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+)
+
+type IdentityRepository interface {
+	// Find returns the identity by provider and subject; sql.ErrNoRows if it isn't linked
+	Find(ctx context.Context, issuer, subject string) (*entity.Identity, error)
+	Create(ctx context.Context, i *entity.Identity) error
+	Touch(ctx context.Context, id int64, email string) error
+	ListByUser(ctx context.Context, userID int64) ([]entity.Identity, error)
+	// Delete only removes identities of userID; sql.ErrNoRows if there was nothing to remove
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+type identityRepository struct{ db *sql.DB }
+
+const identityColumns = `id, user_id, issuer, subject, email, created_at, last_login_at`
+
+func scanIdentity(row rowScanner) (*entity.Identity, error) {
+	var i entity.Identity
+	if err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (r *identityRepository) Find(ctx context.Context, issuer, subject string) (*entity.Identity, error) {
+	return scanIdentity(r.db.QueryRowContext(ctx,
+		`SELECT `+identityColumns+` FROM user_identities WHERE issuer=$1 AND subject=$2`, issuer, subject))
+}
+
+func (r *identityRepository) Create(ctx context.Context, i *entity.Identity) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1,$2,$3,$4)
+        RETURNING id, created_at, last_login_at`,
+		i.UserID, i.Issuer, i.Subject, i.Email,
+	).Scan(&i.ID, &i.CreatedAt, &i.LastLoginAt)
+}
+
+func (r *identityRepository) Touch(ctx context.Context, id int64, email string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE user_identities SET last_login_at=now(), email=$2 WHERE id=$1`, id, email)
+	return err
+}
+
+func (r *identityRepository) ListByUser(ctx context.Context, userID int64) ([]entity.Identity, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+identityColumns+` FROM user_identities WHERE user_id=$1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.Identity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *i)
+	}
+	return res, rows.Err()
+}
+
+func (r *identityRepository) Delete(ctx context.Context, userID, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM user_identities WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
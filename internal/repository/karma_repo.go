@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type KarmaRepository interface {
+	// Get is the karma of userID with reputation per club; zero for users nobody voted for
+	Get(ctx context.Context, userID int64) (*entity.Karma, error)
+	// Totals is the karma of each user, keyed by id; users without votes are left out
+	Totals(ctx context.Context, userIDs []int64) (map[int64]int, error)
+	// Recount rebuilds karma from the stored votes and returns how many users' karma changed
+	Recount(ctx context.Context) (int64, error)
+}
+
+func NewKarmaRepository(db *sql.DB) KarmaRepository {
+	return &karmaRepository{db: db}
+}
+
+type karmaRepository struct{ db *sql.DB }
+
+// karmaVotes are the votes that count: by other users, on published posts and comments
+const karmaVotes = `
+    SELECT p.author_id, b.club_id, v.value AS post, 0 AS comment
+    FROM post_votes v JOIN posts p ON p.id = v.post_id JOIN boards b ON b.id = p.board_id
+    WHERE v.user_id <> p.author_id AND p.status = 'published'
+    UNION ALL
+    SELECT c.author_id, b.club_id, 0, v.value
+    FROM comment_votes v JOIN comments c ON c.id = v.comment_id JOIN posts p ON p.id = c.post_id
+    JOIN boards b ON b.id = p.board_id
+    WHERE v.user_id <> c.author_id AND c.status = 'published'`
+
+// karmaDelta ends a vote statement whose "delta" CTE yields (author_id, club_id, d):
+// d is added to the author's column ("post_karma" or "comment_karma") and club reputation
+func karmaDelta(column string) string {
+	return `, karma AS (
+            INSERT INTO user_karma (user_id, ` + column + `) SELECT author_id, d FROM delta WHERE d <> 0
+            ON CONFLICT (user_id) DO UPDATE SET ` + column + ` = user_karma.` + column + ` + EXCLUDED.` + column + `,
+                updated_at = now()
+        )
+        INSERT INTO club_karma (user_id, club_id, karma)
+        SELECT author_id, club_id, d FROM delta WHERE d <> 0 AND club_id IS NOT NULL
+        ON CONFLICT (user_id, club_id) DO UPDATE SET karma = club_karma.karma + EXCLUDED.karma`
+}
+
+func (r *karmaRepository) Get(ctx context.Context, userID int64) (*entity.Karma, error) {
+	k := &entity.Karma{UserID: userID}
+	err := r.db.QueryRowContext(ctx, `SELECT post_karma, comment_karma FROM user_karma WHERE user_id=$1`, userID).
+		Scan(&k.Post, &k.Comment)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT k.club_id, c.name, k.karma FROM club_karma k JOIN clubs c ON c.id = k.club_id
+        WHERE k.user_id = $1 AND k.karma <> 0
+        ORDER BY k.karma DESC, c.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c entity.ClubKarma
+		if err := rows.Scan(&c.ClubID, &c.ClubName, &c.Karma); err != nil {
+			return nil, err
+		}
+		k.Clubs = append(k.Clubs, c)
+	}
+	return k, rows.Err()
+}
+
+func (r *karmaRepository) Totals(ctx context.Context, userIDs []int64) (map[int64]int, error) {
+	res := make(map[int64]int, len(userIDs))
+	if len(userIDs) == 0 {
+		return res, nil
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT user_id, post_karma + comment_karma FROM user_karma WHERE user_id = ANY($1)`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var karma int
+		if err := rows.Scan(&id, &karma); err != nil {
+			return nil, err
+		}
+		res[id] = karma
+	}
+	return res, rows.Err()
+}
+
+func (r *karmaRepository) Recount(ctx context.Context) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// голос, пришедший во время пересчёта, может потеряться — следующий пересчёт его вернёт
+	res, err := tx.ExecContext(ctx, `
+        WITH k AS (
+            SELECT author_id, sum(post) AS post, sum(comment) AS comment FROM (`+karmaVotes+`) v GROUP BY author_id
+        )
+        INSERT INTO user_karma (user_id, post_karma, comment_karma)
+        SELECT u.user_id, COALESCE(k.post, 0), COALESCE(k.comment, 0)
+        FROM (SELECT user_id FROM user_karma UNION SELECT author_id FROM k) u
+        LEFT JOIN k ON k.author_id = u.user_id
+        ON CONFLICT (user_id) DO UPDATE SET post_karma = EXCLUDED.post_karma, comment_karma = EXCLUDED.comment_karma,
+            updated_at = now()
+        WHERE (user_karma.post_karma, user_karma.comment_karma) IS DISTINCT FROM (EXCLUDED.post_karma, EXCLUDED.comment_karma)`)
+	if err != nil {
+		return 0, err
+	}
+	changed, _ := res.RowsAffected()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM club_karma`); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO club_karma (user_id, club_id, karma)
+        SELECT author_id, club_id, sum(post + comment) FROM (`+karmaVotes+`) v
+        WHERE club_id IS NOT NULL
+        GROUP BY author_id, club_id`); err != nil {
+		return 0, err
+	}
+	return changed, tx.Commit()
+}
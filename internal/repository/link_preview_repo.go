@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+)
+
+type LinkPreviewRepository interface {
+	Get(ctx context.Context, url string) (*entity.LinkPreview, error)
+	Upsert(ctx context.Context, p *entity.LinkPreview) error
+}
+
+func NewLinkPreviewRepository(db *sql.DB) LinkPreviewRepository {
+	return &linkPreviewRepository{db: db}
+}
+
+type linkPreviewRepository struct{ db *sql.DB }
+
+func (r *linkPreviewRepository) Get(ctx context.Context, url string) (*entity.LinkPreview, error) {
+	var p entity.LinkPreview
+	err := r.db.QueryRowContext(ctx, `
+        SELECT url, title, description, image_url, site_name, error, fetched_at, expires_at
+        FROM link_previews WHERE url=$1`, url,
+	).Scan(&p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName, &p.Error, &p.FetchedAt, &p.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *linkPreviewRepository) Upsert(ctx context.Context, p *entity.LinkPreview) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO link_previews (url, title, description, image_url, site_name, error, fetched_at, expires_at)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+        ON CONFLICT (url) DO UPDATE SET
+            title=EXCLUDED.title, description=EXCLUDED.description, image_url=EXCLUDED.image_url,
+            site_name=EXCLUDED.site_name, error=EXCLUDED.error,
+            fetched_at=EXCLUDED.fetched_at, expires_at=EXCLUDED.expires_at`,
+		p.URL, p.Title, p.Description, p.ImageURL, p.SiteName, p.Error, p.FetchedAt, p.ExpiresAt)
+	return err
+}
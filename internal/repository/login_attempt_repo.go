@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"time"
+)
+
+type LoginAttemptRepository interface {
+	Record(ctx context.Context, a *entity.LoginAttempt) error
+	// AccountFailures counts failures for username since `since`, the last success and the last lockout,
+	// whichever is later, and returns the time of the latest one
+	AccountFailures(ctx context.Context, username string, since time.Time) (int, time.Time, error)
+	// IPFailures counts failures from ip since `since`; successes don't reset it
+	IPFailures(ctx context.Context, ip string, since time.Time) (int, time.Time, error)
+	// Lock sets a lockout; false if the username was already locked
+	Lock(ctx context.Context, username string, until time.Time, failures int) (bool, error)
+	// LockedUntil is zero when username isn't locked
+	LockedUntil(ctx context.Context, username string) (time.Time, error)
+	Unlock(ctx context.Context, username string) error
+	RecentFailures(ctx context.Context, limit int) ([]entity.LoginAttempt, error)
+	ActiveLockouts(ctx context.Context) ([]entity.LoginLockout, error)
+	// Prune removes attempts and expired lockouts older than before
+	Prune(ctx context.Context, before time.Time) error
+}
+
+func NewLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+type loginAttemptRepository struct{ db *sql.DB }
+
+func (r *loginAttemptRepository) Record(ctx context.Context, a *entity.LoginAttempt) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO login_attempts (username, user_id, ip, user_agent, success, reason)
+        VALUES ($1,$2,$3,$4,$5,$6) RETURNING id, created_at`,
+		a.Username, a.UserID, a.IP, a.UserAgent, a.Success, a.Reason).Scan(&a.ID, &a.CreatedAt)
+}
+
+func (r *loginAttemptRepository) AccountFailures(ctx context.Context, username string, since time.Time) (int, time.Time, error) {
+	var n int
+	var last sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+        SELECT count(*), max(created_at) FROM login_attempts
+        WHERE username=$1 AND NOT success AND created_at > GREATEST($2,
+            COALESCE((SELECT max(created_at) FROM login_attempts WHERE username=$1 AND success), '-infinity'),
+            COALESCE((SELECT locked_at FROM login_lockouts WHERE username=$1), '-infinity'))`,
+		username, since).Scan(&n, &last)
+	return n, last.Time, err
+}
+
+func (r *loginAttemptRepository) IPFailures(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	var n int
+	var last sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+        SELECT count(*), max(created_at) FROM login_attempts
+        WHERE ip=$1 AND NOT success AND created_at > $2`, ip, since).Scan(&n, &last)
+	return n, last.Time, err
+}
+
+func (r *loginAttemptRepository) Lock(ctx context.Context, username string, until time.Time, failures int) (bool, error) {
+	// активную блокировку не трогаем: тогда RETURNING пуст и уведомление не уйдёт второй раз
+	var inserted bool
+	err := r.db.QueryRowContext(ctx, `
+        INSERT INTO login_lockouts (username, locked_until, failures) VALUES ($1,$2,$3)
+        ON CONFLICT (username) DO UPDATE SET locked_at=now(), locked_until=EXCLUDED.locked_until, failures=EXCLUDED.failures
+            WHERE login_lockouts.locked_until <= now()
+        RETURNING true`, username, until, failures).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return false, nil // уже заблокирован
+	}
+	return inserted, err
+}
+
+func (r *loginAttemptRepository) LockedUntil(ctx context.Context, username string) (time.Time, error) {
+	var until time.Time
+	err := r.db.QueryRowContext(ctx,
+		`SELECT locked_until FROM login_lockouts WHERE username=$1 AND locked_until > now()`, username).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return until, err
+}
+
+func (r *loginAttemptRepository) Unlock(ctx context.Context, username string) error {
+	// строку оставляем: счёт неудач начнётся заново с locked_at
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE login_lockouts SET locked_at=now(), locked_until=now()
+        WHERE username=$1 AND locked_until > now()`, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *loginAttemptRepository) RecentFailures(ctx context.Context, limit int) ([]entity.LoginAttempt, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, username, user_id, ip, user_agent, success, reason, created_at
+        FROM login_attempts WHERE NOT success
+        ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.LoginAttempt{}
+	for rows.Next() {
+		var a entity.LoginAttempt
+		var userID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.Username, &userID, &a.IP, &a.UserAgent, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			a.UserID = &userID.Int64
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+func (r *loginAttemptRepository) ActiveLockouts(ctx context.Context) ([]entity.LoginLockout, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT username, locked_at, locked_until, failures FROM login_lockouts
+        WHERE locked_until > now() ORDER BY locked_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.LoginLockout{}
+	for rows.Next() {
+		var l entity.LoginLockout
+		if err := rows.Scan(&l.Username, &l.LockedAt, &l.LockedUntil, &l.Failures); err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	return res, rows.Err()
+}
+
+func (r *loginAttemptRepository) Prune(ctx context.Context, before time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE created_at < $1`, before); err != nil {
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_lockouts WHERE locked_until < $1`, before)
+	return err
+}
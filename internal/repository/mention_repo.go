@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type MentionRepository interface {
+	// Replace sets the mentioned users of a post body (commentID == nil) or a comment
+	// and reports which users were added and which were dropped
+	Replace(ctx context.Context, postID int64, commentID *int64, authorID int64, userIDs []int64) (added, removed []int64, err error)
+	ListByPost(ctx context.Context, postID int64) ([]entity.Mention, error)
+}
+
+func NewMentionRepository(db *sql.DB) MentionRepository {
+	return &mentionRepository{db: db}
+}
+
+type mentionRepository struct{ db *sql.DB }
+
+func (r *mentionRepository) Replace(ctx context.Context, postID int64, commentID *int64, authorID int64, userIDs []int64) (added, removed []int64, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT user_id FROM mentions
+        WHERE post_id=$1 AND comment_id IS NOT DISTINCT FROM $2
+        FOR UPDATE`, postID, commentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	existing := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		existing[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	wanted := map[int64]bool{}
+	for _, id := range userIDs {
+		wanted[id] = true
+		if !existing[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range existing {
+		if !wanted[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	if len(removed) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+            DELETE FROM mentions
+            WHERE post_id=$1 AND comment_id IS NOT DISTINCT FROM $2 AND user_id = ANY($3)`,
+			postID, commentID, pq.Array(removed)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(added) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO mentions (post_id, comment_id, user_id, author_id)
+            SELECT $1::bigint, $2::bigint, unnest($3::bigint[]), $4::bigint
+            ON CONFLICT DO NOTHING`,
+			postID, commentID, pq.Array(added), authorID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+func (r *mentionRepository) ListByPost(ctx context.Context, postID int64) ([]entity.Mention, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT m.post_id, m.comment_id, m.user_id, u.username, m.author_id, m.created_at
+        FROM mentions m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.post_id=$1`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.Mention
+	for rows.Next() {
+		var m entity.Mention
+		var commentID sql.NullInt64
+		if err := rows.Scan(&m.PostID, &commentID, &m.UserID, &m.Username, &m.AuthorID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if commentID.Valid {
+			m.CommentID = &commentID.Int64
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
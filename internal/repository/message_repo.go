@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type MessageRepository interface {
+	// CreateConversation creates a conversation with the given members. For 1:1 chats directKey is set
+	// and an existing conversation with the same key is returned instead of a new one.
+	CreateConversation(ctx context.Context, c *entity.Conversation, directKey string, memberIDs []int64) (int64, error)
+	GetConversation(ctx context.Context, id int64) (*entity.Conversation, error)
+	ListConversations(ctx context.Context, userID int64, limit, offset int) ([]entity.Conversation, error)
+	MemberIDs(ctx context.Context, conversationID int64) ([]int64, error)
+	IsMember(ctx context.Context, conversationID, userID int64) (bool, error)
+
+	CreateMessage(ctx context.Context, m *entity.Message) (int64, error)
+	// ListMessages returns up to limit messages older than beforeID (0 = newest), newest first
+	ListMessages(ctx context.Context, conversationID, beforeID int64, limit int) ([]entity.Message, error)
+	GetMessage(ctx context.Context, id int64) (*entity.Message, error)
+
+	// MarkRead moves the member's read marker forward (never back)
+	MarkRead(ctx context.Context, conversationID, userID, messageID int64) error
+	UnreadTotal(ctx context.Context, userID int64) (int, error)
+}
+
+func NewMessageRepository(db *sql.DB) MessageRepository {
+	return &messageRepository{db: db}
+}
+
+type messageRepository struct{ db *sql.DB }
+
+func (r *messageRepository) CreateConversation(ctx context.Context, c *entity.Conversation, directKey string, memberIDs []int64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var key sql.NullString
+	if directKey != "" {
+		key = sql.NullString{String: directKey, Valid: true}
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO conversations (title, direct_key, created_by) VALUES ($1,$2,$3)
+        ON CONFLICT (direct_key) DO NOTHING
+        RETURNING id`, c.Title, key, c.CreatedBy).Scan(&id)
+	if err == sql.ErrNoRows && key.Valid {
+		// диалог между этими двумя уже есть
+		if err := tx.QueryRowContext(ctx, `SELECT id FROM conversations WHERE direct_key=$1`, key).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, tx.Commit()
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO conversation_members (conversation_id, user_id)
+        SELECT $1::bigint, unnest($2::bigint[])
+        ON CONFLICT DO NOTHING`, id, pq.Array(memberIDs)); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (r *messageRepository) GetConversation(ctx context.Context, id int64) (*entity.Conversation, error) {
+	var c entity.Conversation
+	var createdBy sql.NullInt64
+	var key sql.NullString
+	err := r.db.QueryRowContext(ctx, `
+        SELECT id, title, direct_key, created_by, created_at, last_message_at
+        FROM conversations WHERE id=$1`, id,
+	).Scan(&c.ID, &c.Title, &key, &createdBy, &c.CreatedAt, &c.LastMessageAt)
+	if err != nil {
+		return nil, err
+	}
+	c.IsGroup = !key.Valid
+	c.CreatedBy = nullInt64Ptr(createdBy)
+	members, err := r.members(ctx, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	c.Members = members[id]
+	return &c, nil
+}
+
+func (r *messageRepository) ListConversations(ctx context.Context, userID int64, limit, offset int) ([]entity.Conversation, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT c.id, c.title, c.direct_key IS NULL, c.created_by, c.created_at, c.last_message_at,
+               cm.last_read_message_id,
+               (SELECT count(*) FROM messages m
+                 WHERE m.conversation_id = c.id AND m.id > cm.last_read_message_id AND m.sender_id <> $1),
+               lm.id, lm.sender_id, COALESCE(lu.username, ''), lm.content, lm.image_data IS NOT NULL, lm.created_at
+        FROM conversation_members cm
+        JOIN conversations c ON c.id = cm.conversation_id
+        LEFT JOIN LATERAL (
+            SELECT id, sender_id, content, image_data, created_at FROM messages
+            WHERE conversation_id = c.id ORDER BY id DESC LIMIT 1
+        ) lm ON true
+        LEFT JOIN users lu ON lu.id = lm.sender_id
+        WHERE cm.user_id = $1
+        ORDER BY c.last_message_at DESC, c.id DESC
+        LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.Conversation
+	var ids []int64
+	for rows.Next() {
+		var c entity.Conversation
+		var createdBy, lmID, lmSender sql.NullInt64
+		var lmContent sql.NullString
+		var lmName string
+		var lmImage sql.NullBool
+		var lmCreated sql.NullTime
+		if err := rows.Scan(&c.ID, &c.Title, &c.IsGroup, &createdBy, &c.CreatedAt, &c.LastMessageAt,
+			&c.LastReadID, &c.Unread, &lmID, &lmSender, &lmName, &lmContent, &lmImage, &lmCreated); err != nil {
+			return nil, err
+		}
+		c.CreatedBy = nullInt64Ptr(createdBy)
+		if lmID.Valid {
+			c.LastMessage = &entity.Message{
+				ID: lmID.Int64, ConversationID: c.ID, SenderID: lmSender.Int64, SenderName: lmName,
+				Content: lmContent.String, HasImage: lmImage.Bool, CreatedAt: lmCreated.Time,
+			}
+		}
+		res = append(res, c)
+		ids = append(ids, c.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	members, err := r.members(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Members = members[res[i].ID]
+	}
+	return res, nil
+}
+
+// members loads members of several conversations in one query
+func (r *messageRepository) members(ctx context.Context, conversationIDs []int64) (map[int64][]entity.User, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT cm.conversation_id, u.id, u.username
+        FROM conversation_members cm
+        JOIN users u ON u.id = cm.user_id
+        WHERE cm.conversation_id = ANY($1)
+        ORDER BY cm.joined_at, u.id`, pq.Array(conversationIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[int64][]entity.User{}
+	for rows.Next() {
+		var convID int64
+		var u entity.User
+		if err := rows.Scan(&convID, &u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		res[convID] = append(res[convID], u)
+	}
+	return res, rows.Err()
+}
+
+func (r *messageRepository) MemberIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT user_id FROM conversation_members WHERE conversation_id=$1`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *messageRepository) IsMember(ctx context.Context, conversationID, userID int64) (bool, error) {
+	var ok bool
+	err := r.db.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id=$1 AND user_id=$2)`,
+		conversationID, userID).Scan(&ok)
+	return ok, err
+}
+
+func (r *messageRepository) CreateMessage(ctx context.Context, m *entity.Message) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var image interface{}
+	if len(m.ImageData) > 0 {
+		image = m.ImageData
+	}
+	if err := tx.QueryRowContext(ctx, `
+        INSERT INTO messages (conversation_id, sender_id, content, image_data, image_type) VALUES ($1,$2,$3,$4,$5)
+        RETURNING id, created_at`, m.ConversationID, m.SenderID, m.Content, image, m.ImageType,
+	).Scan(&m.ID, &m.CreatedAt); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE conversations SET last_message_at=$2 WHERE id=$1`, m.ConversationID, m.CreatedAt); err != nil {
+		return 0, err
+	}
+	// своё сообщение сразу считается прочитанным
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE conversation_members SET last_read_message_id=$3
+        WHERE conversation_id=$1 AND user_id=$2 AND last_read_message_id < $3`,
+		m.ConversationID, m.SenderID, m.ID); err != nil {
+		return 0, err
+	}
+	return m.ID, tx.Commit()
+}
+
+func (r *messageRepository) ListMessages(ctx context.Context, conversationID, beforeID int64, limit int) ([]entity.Message, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT m.id, m.conversation_id, m.sender_id, COALESCE(u.username, ''), m.content,
+               m.image_data IS NOT NULL, m.created_at
+        FROM messages m
+        LEFT JOIN users u ON u.id = m.sender_id
+        WHERE m.conversation_id=$1 AND ($2::bigint = 0 OR m.id < $2::bigint)
+        ORDER BY m.id DESC
+        LIMIT $3`, conversationID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.Message
+	for rows.Next() {
+		var m entity.Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderName, &m.Content, &m.HasImage, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+func (r *messageRepository) GetMessage(ctx context.Context, id int64) (*entity.Message, error) {
+	var m entity.Message
+	err := r.db.QueryRowContext(ctx, `
+        SELECT m.id, m.conversation_id, m.sender_id, COALESCE(u.username, ''), m.content, m.image_data, m.image_type, m.created_at
+        FROM messages m
+        LEFT JOIN users u ON u.id = m.sender_id
+        WHERE m.id=$1`, id,
+	).Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderName, &m.Content, &m.ImageData, &m.ImageType, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.HasImage = len(m.ImageData) > 0
+	return &m, nil
+}
+
+func (r *messageRepository) MarkRead(ctx context.Context, conversationID, userID, messageID int64) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE conversation_members SET last_read_message_id = LEAST($3, (SELECT COALESCE(max(id), 0) FROM messages WHERE conversation_id=$1))
+        WHERE conversation_id=$1 AND user_id=$2 AND last_read_message_id < $3`,
+		conversationID, userID, messageID)
+	return err
+}
+
+func (r *messageRepository) UnreadTotal(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+        SELECT count(*)
+        FROM conversation_members cm
+        JOIN messages m ON m.conversation_id = cm.conversation_id AND m.id > cm.last_read_message_id
+        WHERE cm.user_id=$1 AND m.sender_id <> $1`, userID).Scan(&n)
+	return n, err
+}
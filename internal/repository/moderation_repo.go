@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type ModerationRepository interface {
+	Enqueue(ctx context.Context, item *entity.ModerationItem) error
+	// Pending lists undecided items, oldest first, with the held text and its author
+	Pending(ctx context.Context, limit int) ([]entity.ModerationItem, error)
+	GetItem(ctx context.Context, id int64) (*entity.ModerationItem, error)
+	// Decide closes a pending item; sql.ErrNoRows if it was already decided
+	Decide(ctx context.Context, id int64, status string, byUserID int64) error
+}
+
+func NewModerationRepository(db *sql.DB) ModerationRepository {
+	return &moderationRepository{db: db}
+}
+
+type moderationRepository struct{ db *sql.DB }
+
+func (r *moderationRepository) Enqueue(ctx context.Context, item *entity.ModerationItem) error {
+	if item.Reasons == nil {
+		item.Reasons = []string{}
+	}
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO moderation_queue (post_id, comment_id, author_id, reasons, score)
+        VALUES ($1,$2,$3,$4,$5) RETURNING id, status, created_at`,
+		item.PostID, item.CommentID, item.AuthorID, pq.Array(item.Reasons), item.Score,
+	).Scan(&item.ID, &item.Status, &item.CreatedAt)
+}
+
+const moderationItemSelect = `
+        SELECT q.id, q.post_id, q.comment_id, q.author_id, u.username, p.board_id,
+               CASE WHEN q.comment_id IS NULL THEN p.title ELSE '' END,
+               COALESCE(c.content, p.content, ''),
+               q.reasons, q.score, q.status, q.decided_by, q.decided_at, q.created_at
+        FROM moderation_queue q
+        JOIN posts p ON p.id = q.post_id
+        JOIN users u ON u.id = q.author_id
+        LEFT JOIN comments c ON c.id = q.comment_id`
+
+func scanModerationItem(row interface{ Scan(...any) error }) (*entity.ModerationItem, error) {
+	var it entity.ModerationItem
+	var commentID, decidedBy sql.NullInt64
+	var decidedAt sql.NullTime
+	if err := row.Scan(&it.ID, &it.PostID, &commentID, &it.AuthorID, &it.AuthorName, &it.BoardID, &it.Title, &it.Content,
+		pq.Array(&it.Reasons), &it.Score, &it.Status, &decidedBy, &decidedAt, &it.CreatedAt); err != nil {
+		return nil, err
+	}
+	if commentID.Valid {
+		it.CommentID = &commentID.Int64
+	}
+	if decidedBy.Valid {
+		it.DecidedBy = &decidedBy.Int64
+	}
+	if decidedAt.Valid {
+		it.DecidedAt = &decidedAt.Time
+	}
+	return &it, nil
+}
+
+func (r *moderationRepository) Pending(ctx context.Context, limit int) ([]entity.ModerationItem, error) {
+	rows, err := r.db.QueryContext(ctx, moderationItemSelect+`
+        WHERE q.status = 'pending' ORDER BY q.created_at LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.ModerationItem{}
+	for rows.Next() {
+		it, err := scanModerationItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *it)
+	}
+	return res, rows.Err()
+}
+
+func (r *moderationRepository) GetItem(ctx context.Context, id int64) (*entity.ModerationItem, error) {
+	return scanModerationItem(r.db.QueryRowContext(ctx, moderationItemSelect+` WHERE q.id = $1`, id))
+}
+
+func (r *moderationRepository) Decide(ctx context.Context, id int64, status string, byUserID int64) error {
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE moderation_queue SET status=$2, decided_by=$3, decided_at=now()
+        WHERE id=$1 AND status='pending'`, id, status, byUserID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
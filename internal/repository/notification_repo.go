@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+)
+
+type NotificationRepository interface {
+	// Create stores n; created is false when a notification with the same dedupe key already exists
+	Create(ctx context.Context, n *entity.Notification) (created bool, err error)
+	List(ctx context.Context, userID int64, limit, offset int) ([]entity.Notification, error)
+	CountUnread(ctx context.Context, userID int64) (int, error)
+	MarkRead(ctx context.Context, userID, id int64) error
+	MarkAllRead(ctx context.Context, userID int64) error
+	GetPreferences(ctx context.Context, userID int64) (map[string]bool, error)
+	SetPreference(ctx context.Context, userID int64, typ string, enabled bool) error
+}
+
+func NewNotificationRepository(db *sql.DB) NotificationRepository {
+	return &notificationRepository{db: db}
+}
+
+type notificationRepository struct{ db *sql.DB }
+
+func (r *notificationRepository) Create(ctx context.Context, n *entity.Notification) (bool, error) {
+	var dedupe sql.NullString
+	if n.DedupeKey != "" {
+		dedupe = sql.NullString{String: n.DedupeKey, Valid: true}
+	}
+	err := r.db.QueryRowContext(ctx, `
+        INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, club_id, message, dedupe_key)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+        ON CONFLICT (user_id, dedupe_key) WHERE dedupe_key IS NOT NULL DO NOTHING
+        RETURNING id, created_at`,
+		n.UserID, n.Type, n.ActorID, n.PostID, n.CommentID, n.ClubID, n.Message, dedupe,
+	).Scan(&n.ID, &n.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *notificationRepository) List(ctx context.Context, userID int64, limit, offset int) ([]entity.Notification, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT n.id, n.user_id, n.type, n.actor_id, COALESCE(u.username, ''), n.post_id, n.comment_id, n.club_id,
+               n.message, n.read_at, n.created_at
+        FROM notifications n
+        LEFT JOIN users u ON u.id = n.actor_id
+        WHERE n.user_id = $1
+        ORDER BY n.id DESC
+        LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.Notification
+	for rows.Next() {
+		var n entity.Notification
+		var actorID, postID, commentID, clubID sql.NullInt64
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &actorID, &n.ActorName, &postID, &commentID, &clubID,
+			&n.Message, &readAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		n.ActorID = nullInt64Ptr(actorID)
+		n.PostID = nullInt64Ptr(postID)
+		n.CommentID = nullInt64Ptr(commentID)
+		n.ClubID = nullInt64Ptr(clubID)
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		res = append(res, n)
+	}
+	return res, rows.Err()
+}
+
+func (r *notificationRepository) CountUnread(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT count(*) FROM notifications WHERE user_id=$1 AND read_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+func (r *notificationRepository) MarkRead(ctx context.Context, userID, id int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE notifications SET read_at=now() WHERE id=$1 AND user_id=$2 AND read_at IS NULL`, id, userID)
+	return err
+}
+
+func (r *notificationRepository) MarkAllRead(ctx context.Context, userID int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE notifications SET read_at=now() WHERE user_id=$1 AND read_at IS NULL`, userID)
+	return err
+}
+
+func (r *notificationRepository) GetPreferences(ctx context.Context, userID int64) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT type, enabled FROM notification_preferences WHERE user_id=$1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[string]bool{}
+	for rows.Next() {
+		var typ string
+		var enabled bool
+		if err := rows.Scan(&typ, &enabled); err != nil {
+			return nil, err
+		}
+		res[typ] = enabled
+	}
+	return res, rows.Err()
+}
+
+func (r *notificationRepository) SetPreference(ctx context.Context, userID int64, typ string, enabled bool) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO notification_preferences (user_id, type, enabled) VALUES ($1,$2,$3)
+        ON CONFLICT (user_id, type) DO UPDATE SET enabled=EXCLUDED.enabled`, userID, typ, enabled)
+	return err
+}
+
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type PollRepository interface {
+	// Create stores the poll of a post with its options and fills the options' ids
+	Create(ctx context.Context, p *entity.Poll) error
+	// Get is the poll of a post with vote counts; sql.ErrNoRows when the post has none
+	Get(ctx context.Context, postID int64) (*entity.Poll, error)
+	// UserVotes are the options the user picked
+	UserVotes(ctx context.Context, postID, userID int64) ([]int64, error)
+	// VoterNames lists who picked each option, in voting order
+	VoterNames(ctx context.Context, postID int64) (map[int64][]string, error)
+	// Vote stores the user's choice. A user who has voted already keeps the old choice unless replace
+	// is set; stored is false then.
+	Vote(ctx context.Context, postID, userID int64, optionIDs []int64, replace bool) (stored bool, err error)
+}
+
+func NewPollRepository(db *sql.DB) PollRepository {
+	return &pollRepository{db: db}
+}
+
+type pollRepository struct{ db *sql.DB }
+
+func (r *pollRepository) Create(ctx context.Context, p *entity.Poll) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRowContext(ctx, `
+        INSERT INTO polls (post_id, question, multiple, anonymous, allow_change, hide_results, closes_at)
+        VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING created_at`,
+		p.PostID, p.Question, p.Multiple, p.Anonymous, p.AllowChange, p.HideResults, p.ClosesAt,
+	).Scan(&p.CreatedAt); err != nil {
+		return err
+	}
+	for i := range p.Options {
+		if err := tx.QueryRowContext(ctx, `
+            INSERT INTO poll_options (post_id, position, text) VALUES ($1,$2,$3) RETURNING id`,
+			p.PostID, i+1, p.Options[i].Text,
+		).Scan(&p.Options[i].ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *pollRepository) Get(ctx context.Context, postID int64) (*entity.Poll, error) {
+	p := &entity.Poll{PostID: postID}
+	err := r.db.QueryRowContext(ctx, `
+        SELECT question, multiple, anonymous, allow_change, hide_results, closes_at, created_at,
+               (SELECT count(DISTINCT user_id) FROM poll_votes WHERE post_id = polls.post_id)
+        FROM polls WHERE post_id=$1`, postID,
+	).Scan(&p.Question, &p.Multiple, &p.Anonymous, &p.AllowChange, &p.HideResults, &p.ClosesAt, &p.CreatedAt, &p.Voters)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT o.id, o.text, count(v.user_id)
+        FROM poll_options o LEFT JOIN poll_votes v ON v.option_id = o.id
+        WHERE o.post_id=$1
+        GROUP BY o.id ORDER BY o.position`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o entity.PollOption
+		if err := rows.Scan(&o.ID, &o.Text, &o.Votes); err != nil {
+			return nil, err
+		}
+		p.Options = append(p.Options, o)
+	}
+	return p, rows.Err()
+}
+
+func (r *pollRepository) UserVotes(ctx context.Context, postID, userID int64) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT option_id FROM poll_votes WHERE post_id=$1 AND user_id=$2 ORDER BY option_id`, postID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *pollRepository) VoterNames(ctx context.Context, postID int64) (map[int64][]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT v.option_id, u.username
+        FROM poll_votes v JOIN users u ON u.id = v.user_id
+        WHERE v.post_id=$1 ORDER BY v.created_at, u.username`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[int64][]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		res[id] = append(res[id], name)
+	}
+	return res, rows.Err()
+}
+
+func (r *pollRepository) Vote(ctx context.Context, postID, userID int64, optionIDs []int64, replace bool) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	// строка опроса — замок: два одновременных голоса одного человека не сложатся
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM polls WHERE post_id=$1 FOR UPDATE`, postID); err != nil {
+		return false, err
+	}
+	var voted bool
+	if err := tx.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM poll_votes WHERE post_id=$1 AND user_id=$2)`, postID, userID).Scan(&voted); err != nil {
+		return false, err
+	}
+	if voted {
+		if !replace {
+			return false, nil
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM poll_votes WHERE post_id=$1 AND user_id=$2`, postID, userID); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO poll_votes (post_id, option_id, user_id)
+        SELECT post_id, id, $2 FROM poll_options WHERE post_id=$1 AND id = ANY($3)`,
+		postID, userID, pq.Array(optionIDs)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
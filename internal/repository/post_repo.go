@@ -4,17 +4,46 @@ import (
 	"context"
 	"database/sql"
 	"forum1/internal/entity"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 type PostRepository interface {
 	GetAllPosts(ctx context.Context) ([]entity.Post, error)
 	GetPostByID(ctx context.Context, id int64) (*entity.Post, error)
 	GetPostsByBoard(ctx context.Context, boardID int64) ([]entity.Post, error)
+	// ListPosts is a sorted feed; hot, top and rising use the scores of the ranking job
+	ListPosts(ctx context.Context, q entity.PostQuery) ([]entity.Post, error)
 	CreatePost(ctx context.Context, p *entity.Post) (int64, error)
 	UpdatePost(ctx context.Context, p *entity.Post) error
 	DeletePost(ctx context.Context, id int64) error
 	SetPostVote(ctx context.Context, postID int64, userID int64, value int) error
 	GetPostVotes(ctx context.Context, postID int64) (likes int, dislikes int, err error)
+	// SetPostStatus publishes, holds or rejects a post; listings only show published ones
+	SetPostStatus(ctx context.Context, id int64, status string) error
+	// SetPostLocked closes a thread for new comments or reopens it
+	SetPostLocked(ctx context.Context, id int64, locked bool) error
+	AddPostTag(ctx context.Context, id int64, tag string) error
+	// PinPost pins a post to the top of its board at position (1 is first; 0 — after the pinned ones),
+	// UnpinPost returns it to the feed
+	PinPost(ctx context.Context, id int64, position int) error
+	UnpinPost(ctx context.Context, id int64) error
+	SetPostArchived(ctx context.Context, id int64, archived bool) error
+	// ArchiveInactive archives unpinned threads with neither the post nor any comment newer than before
+	ArchiveInactive(ctx context.Context, before time.Time) (int64, error)
+	// PostClosed returns a post's status and whether the thread is locked or archived; sql.ErrNoRows when there is no such post
+	PostClosed(ctx context.Context, id int64) (status string, locked, archived bool, err error)
+	// SaveDraft updates a draft of p.AuthorID; an empty p.ImageData keeps the stored image.
+	// sql.ErrNoRows when there is no such draft — scheduled posts are not drafts here.
+	SaveDraft(ctx context.Context, p *entity.Post) error
+	// Drafts are the author's drafts and scheduled posts, last edited first
+	Drafts(ctx context.Context, authorID int64) ([]entity.Post, error)
+	DeleteDraft(ctx context.Context, id, authorID int64) error
+	// PublishDue publishes up to limit scheduled posts whose time has come and returns their ids.
+	// Each post is returned by exactly one call, even with concurrent callers. announce, if not nil,
+	// runs for every post in the same transaction; its error rolls the whole batch back.
+	PublishDue(ctx context.Context, limit int, announce func(tx *sql.Tx, id int64) error) ([]int64, error)
 }
 
 func NewPostRepository(db *sql.DB) PostRepository {
@@ -27,8 +56,8 @@ type postRepository struct {
 
 func (r *postRepository) GetAllPosts(ctx context.Context) ([]entity.Post, error) {
 	rows, err := r.db.QueryContext(ctx, `
-        SELECT id, board_id, title, content, author_id, image_url, image_data, link_url, created_at, updated_at
-        FROM posts
+        SELECT id, board_id, title, content, author_id, image_url, image_data, link_url, created_at, updated_at, view_count
+        FROM posts WHERE status = 'published'
         ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
@@ -39,7 +68,7 @@ func (r *postRepository) GetAllPosts(ctx context.Context) ([]entity.Post, error)
 		var p entity.Post
 		var imageURL sql.NullString
 		var linkURL sql.NullString
-		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL, &p.CreatedAt, &p.UpdatedAt, &p.Views); err != nil {
 			return nil, err
 		}
 		if imageURL.Valid {
@@ -62,9 +91,12 @@ func (r *postRepository) GetPostByID(ctx context.Context, id int64) (*entity.Pos
 	var imageURL sql.NullString
 	var linkURL sql.NullString
 	err := r.db.QueryRowContext(ctx, `
-        SELECT id, board_id, title, content, author_id, image_url, image_data, link_url, created_at, updated_at
+        SELECT id, board_id, title, content, author_id, image_url, image_data, link_url, created_at, updated_at, status,
+               view_count, locked_at, ARRAY(SELECT tag FROM post_tags WHERE post_id = posts.id ORDER BY tag),
+               pin_position, archived_at, publish_at
         FROM posts WHERE id = $1`, id,
-	).Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL, &p.CreatedAt, &p.UpdatedAt)
+	).Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL, &p.CreatedAt, &p.UpdatedAt, &p.Status,
+		&p.Views, &p.LockedAt, pq.Array(&p.Tags), &p.PinPosition, &p.ArchivedAt, &p.PublishAt)
 	if err != nil {
 		return nil, err
 	}
@@ -83,8 +115,8 @@ func (r *postRepository) GetPostByID(ctx context.Context, id int64) (*entity.Pos
 
 func (r *postRepository) GetPostsByBoard(ctx context.Context, boardID int64) ([]entity.Post, error) {
 	rows, err := r.db.QueryContext(ctx, `
-        SELECT id, board_id, title, content, author_id, image_url, image_data, link_url, created_at, updated_at
-        FROM posts WHERE board_id = $1 ORDER BY created_at DESC`, boardID)
+        SELECT id, board_id, title, content, author_id, image_url, image_data, link_url, created_at, updated_at, view_count
+        FROM posts WHERE board_id = $1 AND status = 'published' ORDER BY created_at DESC`, boardID)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +126,7 @@ func (r *postRepository) GetPostsByBoard(ctx context.Context, boardID int64) ([]
 		var p entity.Post
 		var imageURL sql.NullString
 		var linkURL sql.NullString
-		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL, &p.CreatedAt, &p.UpdatedAt, &p.Views); err != nil {
 			return nil, err
 		}
 		if imageURL.Valid {
@@ -112,13 +144,67 @@ func (r *postRepository) GetPostsByBoard(ctx context.Context, boardID int64) ([]
 	return result, nil
 }
 
+// postOrder: посты без посчитанных очков (совсем новые) идут как с нулевыми
+var postOrder = map[string]string{
+	entity.SortNew:    `p.created_at DESC`,
+	entity.SortHot:    `COALESCE(s.hot, 0) DESC, p.created_at DESC`,
+	entity.SortTop:    `COALESCE(s.top, 0) DESC, p.created_at DESC`,
+	entity.SortRising: `COALESCE(s.rising, 0) DESC, p.created_at DESC`,
+}
+
+func (r *postRepository) ListPosts(ctx context.Context, q entity.PostQuery) ([]entity.Post, error) {
+	order, ok := postOrder[q.Sort]
+	if !ok {
+		order = postOrder[entity.SortNew]
+	}
+	if q.BoardID != 0 {
+		// закреплённые — над лентой доски при любой сортировке
+		order = `p.pin_position ASC NULLS LAST, ` + order
+	}
+	var since *time.Time
+	if !q.Since.IsZero() {
+		since = &q.Since
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT p.id, p.board_id, p.title, p.content, p.author_id, p.image_url, p.image_data, p.link_url, p.created_at,
+               p.updated_at, p.view_count, p.pin_position, p.locked_at, p.archived_at
+        FROM posts p LEFT JOIN post_scores s ON s.post_id = p.id
+        WHERE p.status = 'published' AND ($1 = 0 OR p.board_id = $1)
+          AND ($2::timestamptz IS NULL OR p.created_at >= $2 OR ($1 <> 0 AND p.pin_position IS NOT NULL))
+          AND ($5 = 0 OR (p.author_id <> $5 AND EXISTS (
+                SELECT 1 FROM subscriptions sub
+                WHERE sub.user_id = $5 AND NOT sub.muted AND (
+                       (sub.target = 'board' AND sub.target_id = p.board_id)
+                    OR (sub.target = 'club' AND sub.target_id = (SELECT club_id FROM boards WHERE id = p.board_id))
+                    OR (sub.target = 'post' AND sub.target_id = p.id)
+                    OR (sub.target = 'user' AND sub.target_id = p.author_id)))))
+        ORDER BY `+order+`
+        LIMIT NULLIF($3, 0) OFFSET $4`, q.BoardID, since, q.Limit, q.Offset, q.FollowerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []entity.Post
+	for rows.Next() {
+		var p entity.Post
+		var imageURL, linkURL sql.NullString
+		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &imageURL, &p.ImageData, &linkURL,
+			&p.CreatedAt, &p.UpdatedAt, &p.Views, &p.PinPosition, &p.LockedAt, &p.ArchivedAt); err != nil {
+			return nil, err
+		}
+		p.ImageURL, p.LinkURL = imageURL.String, linkURL.String
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
 func (r *postRepository) CreatePost(ctx context.Context, p *entity.Post) (int64, error) {
 	var id int64
 	err := r.db.QueryRowContext(ctx, `
-        INSERT INTO posts (board_id, title, content, author_id, image_url, image_data, link_url)
-        VALUES ($1,$2,$3,$4,$5,$6,$7)
+        INSERT INTO posts (board_id, title, content, author_id, image_url, image_data, link_url, status, publish_at)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,COALESCE(NULLIF($8,''),'published'),$9)
         RETURNING id`,
-		p.BoardID, p.Title, p.Content, p.AuthorID, p.ImageURL, p.ImageData, p.LinkURL,
+		p.BoardID, p.Title, p.Content, p.AuthorID, p.ImageURL, p.ImageData, p.LinkURL, p.Status, p.PublishAt,
 	).Scan(&id)
 	if err != nil {
 		return 0, err
@@ -142,10 +228,21 @@ func (r *postRepository) DeletePost(ctx context.Context, id int64) error {
 }
 
 func (r *postRepository) SetPostVote(ctx context.Context, postID int64, userID int64, value int) error {
+	// карма автора меняется в том же запросе на разницу между новым и прежним голосом
 	_, err := r.db.ExecContext(ctx, `
-        INSERT INTO post_votes (post_id, user_id, value)
-        VALUES ($1,$2,$3)
-        ON CONFLICT (post_id,user_id) DO UPDATE SET value=EXCLUDED.value`, postID, userID, value)
+        WITH old AS (
+            SELECT value FROM post_votes WHERE post_id=$1 AND user_id=$2
+        ), vote AS (
+            INSERT INTO post_votes (post_id, user_id, value)
+            VALUES ($1,$2,$3)
+            ON CONFLICT (post_id,user_id) DO UPDATE SET value=EXCLUDED.value, voted_at=now()
+            WHERE post_votes.value <> EXCLUDED.value
+            RETURNING value
+        ), delta AS (
+            SELECT p.author_id, b.club_id, v.value - COALESCE((SELECT value FROM old), 0) AS d
+            FROM vote v JOIN posts p ON p.id = $1 JOIN boards b ON b.id = p.board_id
+            WHERE p.author_id <> $2 AND p.status = 'published'
+        )`+karmaDelta("post_karma"), postID, userID, value)
 	return err
 }
 
@@ -156,3 +253,151 @@ func (r *postRepository) GetPostVotes(ctx context.Context, postID int64) (likes
         FROM post_votes WHERE post_id=$1`, postID).Scan(&likes, &dislikes)
 	return
 }
+
+func (r *postRepository) SetPostStatus(ctx context.Context, id int64, status string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE posts SET status=$2 WHERE id=$1`, id, status)
+	return err
+}
+
+func (r *postRepository) SetPostLocked(ctx context.Context, id int64, locked bool) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE posts SET locked_at = CASE WHEN $2 THEN COALESCE(locked_at, now()) END WHERE id=$1`, id, locked)
+	return err
+}
+
+func (r *postRepository) AddPostTag(ctx context.Context, id int64, tag string) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO post_tags (post_id, tag) VALUES ($1,$2) ON CONFLICT DO NOTHING`, id, tag)
+	return err
+}
+
+func (r *postRepository) PinPost(ctx context.Context, id int64, position int) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE posts p SET pin_position = COALESCE(NULLIF($2, 0),
+            (SELECT COALESCE(max(q.pin_position), 0) + 1 FROM posts q WHERE q.board_id = p.board_id AND q.id <> p.id))
+        WHERE p.id = $1`, id, position)
+	return err
+}
+
+func (r *postRepository) UnpinPost(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE posts SET pin_position = NULL WHERE id=$1`, id)
+	return err
+}
+
+func (r *postRepository) SetPostArchived(ctx context.Context, id int64, archived bool) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE posts SET archived_at = CASE WHEN $2 THEN COALESCE(archived_at, now()) END WHERE id=$1`, id, archived)
+	return err
+}
+
+func (r *postRepository) ArchiveInactive(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE posts p SET archived_at = now()
+        WHERE p.archived_at IS NULL AND p.pin_position IS NULL AND p.status = 'published' AND p.created_at < $1
+          AND NOT EXISTS (SELECT 1 FROM comments c WHERE c.post_id = p.id AND c.created_at >= $1)`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *postRepository) PostClosed(ctx context.Context, id int64) (status string, locked, archived bool, err error) {
+	err = r.db.QueryRowContext(ctx, `
+        SELECT status, locked_at IS NOT NULL, archived_at IS NOT NULL FROM posts WHERE id=$1`, id).Scan(&status, &locked, &archived)
+	return
+}
+
+func (r *postRepository) SaveDraft(ctx context.Context, p *entity.Post) error {
+	var image interface{} // NULL — оставить сохранённую картинку
+	if len(p.ImageData) > 0 {
+		image = p.ImageData
+	}
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE posts
+        SET board_id=$3, title=$4, content=$5, link_url=$6, image_data=COALESCE($7, image_data), publish_at=$8,
+            updated_at=now()
+        WHERE id=$1 AND author_id=$2 AND status='draft'`,
+		p.ID, p.AuthorID, p.BoardID, p.Title, p.Content, p.LinkURL, image, p.PublishAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *postRepository) Drafts(ctx context.Context, authorID int64) ([]entity.Post, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, board_id, title, content, author_id, COALESCE(link_url, ''), created_at, updated_at, status, publish_at
+        FROM posts WHERE author_id=$1 AND status IN ('draft', 'scheduled')
+        ORDER BY updated_at DESC`, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []entity.Post{}
+	for rows.Next() {
+		var p entity.Post
+		if err := rows.Scan(&p.ID, &p.BoardID, &p.Title, &p.Content, &p.AuthorID, &p.LinkURL, &p.CreatedAt, &p.UpdatedAt,
+			&p.Status, &p.PublishAt); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (r *postRepository) DeleteDraft(ctx context.Context, id, authorID int64) error {
+	res, err := r.db.ExecContext(ctx, `
+        DELETE FROM posts WHERE id=$1 AND author_id=$2 AND status IN ('draft', 'scheduled')`, id, authorID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *postRepository) PublishDue(ctx context.Context, limit int, announce func(tx *sql.Tx, id int64) error) ([]int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// SKIP LOCKED: другие экземпляры берут следующие посты, а не ждут; WHERE status повторно
+	// проверяется после блокировки, поэтому пост переходит в published ровно один раз
+	rows, err := tx.QueryContext(ctx, `
+        UPDATE posts SET status='published', created_at=now(), updated_at=now()
+        WHERE id IN (
+            SELECT id FROM posts
+            WHERE status='scheduled' AND publish_at <= now()
+            ORDER BY publish_at LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        ) AND status='scheduled'
+        RETURNING id`, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if announce != nil {
+		for _, id := range ids {
+			if err := announce(tx, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ids, tx.Commit()
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/ranking"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostSignals are the ranking inputs of one post
+type PostSignals struct {
+	PostID int64
+	ranking.Signals
+}
+
+type RankingRepository interface {
+	// Candidates are published posts whose scores may be stale: with votes, comments, views or bookmarks since
+	// `since`, or created after `youngerThan` (their rising score changes just with age). all lists every post.
+	Candidates(ctx context.Context, since, youngerThan time.Time, all bool) ([]int64, error)
+	// Signals counts votes, comments, views and bookmarks of the posts; the Recent* fields count those after recentSince
+	Signals(ctx context.Context, ids []int64, recentSince time.Time) ([]PostSignals, error)
+	// SaveScores upserts the scores; posts deleted in the meantime are skipped
+	SaveScores(ctx context.Context, ids []int64, scores []ranking.Scores) error
+}
+
+func NewRankingRepository(db *sql.DB) RankingRepository {
+	return &rankingRepository{db: db}
+}
+
+type rankingRepository struct{ db *sql.DB }
+
+func (r *rankingRepository) Candidates(ctx context.Context, since, youngerThan time.Time, all bool) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT p.id FROM posts p
+        WHERE p.status = 'published' AND (
+            $3 OR p.created_at >= $2
+            OR EXISTS (SELECT 1 FROM post_votes v WHERE v.post_id = p.id AND v.voted_at >= $1)
+            OR EXISTS (SELECT 1 FROM comments c WHERE c.post_id = p.id AND c.created_at >= $1)
+            OR EXISTS (SELECT 1 FROM post_views w WHERE w.post_id = p.id AND w.created_at >= $1)
+            OR EXISTS (SELECT 1 FROM bookmarks b WHERE b.post_id = p.id AND b.created_at >= $1)
+        )
+        ORDER BY p.id`, since, youngerThan, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *rankingRepository) Signals(ctx context.Context, ids []int64, recentSince time.Time) ([]PostSignals, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT p.id, p.created_at, p.view_count,
+               COALESCE(v.likes, 0), COALESCE(v.dislikes, 0), COALESCE(v.recent, 0),
+               COALESCE(c.n, 0), COALESCE(c.recent, 0),
+               (SELECT count(*) FROM post_views w WHERE w.post_id = p.id AND w.created_at >= $2),
+               COALESCE(b.n, 0), COALESCE(b.recent, 0)
+        FROM posts p
+        LEFT JOIN (
+            SELECT post_id, count(*) FILTER (WHERE value = 1) AS likes, count(*) FILTER (WHERE value = -1) AS dislikes,
+                   count(*) FILTER (WHERE value = 1 AND voted_at >= $2) AS recent
+            FROM post_votes WHERE post_id = ANY($1) GROUP BY post_id
+        ) v ON v.post_id = p.id
+        LEFT JOIN (
+            SELECT post_id, count(*) AS n, count(*) FILTER (WHERE created_at >= $2) AS recent
+            FROM comments WHERE post_id = ANY($1) AND status = 'published' GROUP BY post_id
+        ) c ON c.post_id = p.id
+        LEFT JOIN (
+            SELECT post_id, count(*) AS n, count(*) FILTER (WHERE created_at >= $2) AS recent
+            FROM bookmarks WHERE post_id = ANY($1) GROUP BY post_id
+        ) b ON b.post_id = p.id
+        WHERE p.id = ANY($1)`, pq.Array(ids), recentSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []PostSignals
+	for rows.Next() {
+		var s PostSignals
+		if err := rows.Scan(&s.PostID, &s.CreatedAt, &s.Views, &s.Likes, &s.Dislikes, &s.RecentLikes,
+			&s.Comments, &s.RecentComments, &s.RecentViews, &s.Bookmarks, &s.RecentBookmarks); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+func (r *rankingRepository) SaveScores(ctx context.Context, ids []int64, scores []ranking.Scores) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	hot := make([]float64, len(scores))
+	top := make([]float64, len(scores))
+	rising := make([]float64, len(scores))
+	for i, s := range scores {
+		hot[i], top[i], rising[i] = s.Hot, s.Top, s.Rising
+	}
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO post_scores (post_id, hot, top, rising, updated_at)
+        SELECT s.post_id, s.hot, s.top, s.rising, now()
+        FROM unnest($1::bigint[], $2::float8[], $3::float8[], $4::float8[]) AS s(post_id, hot, top, rising)
+        WHERE EXISTS (SELECT 1 FROM posts WHERE id = s.post_id)
+        ON CONFLICT (post_id) DO UPDATE SET hot = EXCLUDED.hot, top = EXCLUDED.top, rising = EXCLUDED.rising,
+            updated_at = EXCLUDED.updated_at`,
+		pq.Array(ids), pq.Array(hot), pq.Array(top), pq.Array(rising))
+	return err
+}
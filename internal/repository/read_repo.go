@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type ReadRepository interface {
+	// SaveMarks stores a batch of read marks, at most one per (user, post); a mark never moves back.
+	// Marks of deleted posts or users are skipped.
+	SaveMarks(ctx context.Context, marks []entity.ReadMark) error
+	// States is the read state of the threads for userID with unread comment counts, keyed by post id.
+	// LastCommentID of each mark is a position not saved yet (-1 if there is none); it wins over a lower stored one.
+	States(ctx context.Context, userID int64, threads []entity.ReadMark) (map[int64]entity.ReadState, error)
+	// MarkBoard marks everything in the board up to now as read
+	MarkBoard(ctx context.Context, userID, boardID int64) error
+}
+
+func NewReadRepository(db *sql.DB) ReadRepository {
+	return &readRepository{db: db}
+}
+
+type readRepository struct{ db *sql.DB }
+
+func (r *readRepository) SaveMarks(ctx context.Context, marks []entity.ReadMark) error {
+	if len(marks) == 0 {
+		return nil
+	}
+	users := make([]int64, len(marks))
+	posts := make([]int64, len(marks))
+	last := make([]int64, len(marks))
+	for i, m := range marks {
+		users[i], posts[i], last[i] = m.UserID, m.PostID, m.LastCommentID
+	}
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO post_reads (user_id, post_id, last_comment_id, read_at)
+        SELECT m.user_id, m.post_id, m.last_comment_id, now()
+        FROM unnest($1::bigint[], $2::bigint[], $3::bigint[]) AS m(user_id, post_id, last_comment_id)
+        WHERE EXISTS (SELECT 1 FROM posts WHERE id = m.post_id) AND EXISTS (SELECT 1 FROM users WHERE id = m.user_id)
+        ON CONFLICT (user_id, post_id) DO UPDATE SET
+            last_comment_id = GREATEST(post_reads.last_comment_id, EXCLUDED.last_comment_id),
+            read_at = EXCLUDED.read_at`,
+		pq.Array(users), pq.Array(posts), pq.Array(last))
+	return err
+}
+
+func (r *readRepository) States(ctx context.Context, userID int64, threads []entity.ReadMark) (map[int64]entity.ReadState, error) {
+	res := make(map[int64]entity.ReadState, len(threads))
+	if len(threads) == 0 {
+		return res, nil
+	}
+	ids := make([]int64, len(threads))
+	seen := make([]int64, len(threads))
+	for i, t := range threads {
+		ids[i], seen[i] = t.PostID, t.LastCommentID
+	}
+	// свои комментарии прочитаны всегда; отметка доски закрывает всё, что старше неё
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT p.id, COALESCE(l.last IS NOT NULL OR p.created_at <= br.read_at, false),
+               COALESCE(l.last, 0), br.read_at, u.n, COALESCE(u.first, 0)
+        FROM unnest($2::bigint[], $3::bigint[]) AS t(post_id, seen)
+        JOIN posts p ON p.id = t.post_id
+        LEFT JOIN post_reads pr ON pr.post_id = p.id AND pr.user_id = $1
+        LEFT JOIN board_reads br ON br.board_id = p.board_id AND br.user_id = $1
+        CROSS JOIN LATERAL (SELECT GREATEST(pr.last_comment_id, NULLIF(t.seen, -1)) AS last) l
+        CROSS JOIN LATERAL (
+            SELECT count(*) AS n, min(c.id) AS first FROM comments c
+            WHERE c.post_id = p.id AND c.status = 'published' AND c.author_id <> $1
+              AND c.id > COALESCE(l.last, 0) AND (br.read_at IS NULL OR c.created_at > br.read_at)
+        ) u`, userID, pq.Array(ids), pq.Array(seen))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s entity.ReadState
+		var boardReadAt sql.NullTime
+		if err := rows.Scan(&s.PostID, &s.Visited, &s.LastCommentID, &boardReadAt, &s.Unread, &s.FirstUnreadID); err != nil {
+			return nil, err
+		}
+		if boardReadAt.Valid {
+			s.BoardReadAt = &boardReadAt.Time
+		}
+		res[s.PostID] = s
+	}
+	return res, rows.Err()
+}
+
+func (r *readRepository) MarkBoard(ctx context.Context, userID, boardID int64) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO board_reads (user_id, board_id, read_at) VALUES ($1, $2, now())
+        ON CONFLICT (user_id, board_id) DO UPDATE SET read_at = EXCLUDED.read_at`, userID, boardID)
+	return err
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"time"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, tokenHash string, s *entity.Session) error
+	// GetUser returns the owner of a live (not expired, not revoked) session
+	GetUser(ctx context.Context, tokenHash string) (*entity.User, *entity.Session, error)
+	Touch(ctx context.Context, id int64, expiresAt time.Time) error
+	Revoke(ctx context.Context, tokenHash string) error
+	// RevokeAll ends every session of the user
+	RevokeAll(ctx context.Context, userID int64) error
+	ListActive(ctx context.Context, userID int64) ([]entity.Session, error)
+}
+
+func NewSessionRepository(db *sql.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+type sessionRepository struct{ db *sql.DB }
+
+func (r *sessionRepository) Create(ctx context.Context, tokenHash string, s *entity.Session) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO sessions (token_hash, user_id, ip, user_agent, expires_at) VALUES ($1,$2,$3,$4,$5)
+        RETURNING id, created_at, last_seen_at`,
+		tokenHash, s.UserID, s.IP, s.UserAgent, s.ExpiresAt,
+	).Scan(&s.ID, &s.CreatedAt, &s.LastSeenAt)
+}
+
+func (r *sessionRepository) GetUser(ctx context.Context, tokenHash string) (*entity.User, *entity.Session, error) {
+	var s entity.Session
+	u, err := scanUser(r.db.QueryRowContext(ctx, `
+        SELECT s.id, s.user_id, s.ip, s.user_agent, s.created_at, s.last_seen_at, s.expires_at, `+userColumns+`
+        FROM sessions s
+        JOIN users ON users.id = s.user_id
+        WHERE s.token_hash=$1 AND s.revoked_at IS NULL AND s.expires_at > now()`, tokenHash),
+		&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, &s, nil
+}
+
+func (r *sessionRepository) Touch(ctx context.Context, id int64, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE sessions SET last_seen_at=now(), expires_at=$2 WHERE id=$1`, id, expiresAt)
+	return err
+}
+
+func (r *sessionRepository) Revoke(ctx context.Context, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE token_hash=$1 AND revoked_at IS NULL`, tokenHash)
+	return err
+}
+
+func (r *sessionRepository) RevokeAll(ctx context.Context, userID int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+func (r *sessionRepository) ListActive(ctx context.Context, userID int64) ([]entity.Session, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > now()
+        ORDER BY last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.Session
+	for rows.Next() {
+		var s entity.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"forum1/internal/spam"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type SpamRepository interface {
+	// Rules returns the rules for boardID plus the ones for all boards; boardID 0 returns every rule
+	Rules(ctx context.Context, boardID int64) ([]entity.SpamRule, error)
+	AddRule(ctx context.Context, r *entity.SpamRule) error
+	DeleteRule(ctx context.Context, id int64) error
+
+	RecordFingerprint(ctx context.Context, fingerprint string, authorID int64) error
+	// CountFingerprint counts submissions of the same text since `since`, and by how many authors
+	CountFingerprint(ctx context.Context, fingerprint string, since time.Time) (copies, authors int, err error)
+	PruneFingerprints(ctx context.Context, before time.Time) error
+
+	// TokenCounts returns the trained counts of those tokens that were ever seen
+	TokenCounts(ctx context.Context, tokens []string) (map[string]spam.Counts, error)
+	Corpus(ctx context.Context) (spamDocs, hamDocs int, err error)
+	// Train adds one document with the given tokens to the spam or ham class
+	Train(ctx context.Context, tokens []string, isSpam bool) error
+}
+
+func NewSpamRepository(db *sql.DB) SpamRepository {
+	return &spamRepository{db: db}
+}
+
+type spamRepository struct{ db *sql.DB }
+
+func (r *spamRepository) Rules(ctx context.Context, boardID int64) ([]entity.SpamRule, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, board_id, pattern, is_regex, created_by, created_at FROM spam_rules
+        WHERE $1 = 0 OR board_id IS NULL OR board_id = $1
+        ORDER BY board_id NULLS FIRST, id`, boardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.SpamRule{}
+	for rows.Next() {
+		var rule entity.SpamRule
+		var board, by sql.NullInt64
+		if err := rows.Scan(&rule.ID, &board, &rule.Pattern, &rule.Regex, &by, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		if board.Valid {
+			rule.BoardID = &board.Int64
+		}
+		if by.Valid {
+			rule.CreatedBy = &by.Int64
+		}
+		res = append(res, rule)
+	}
+	return res, rows.Err()
+}
+
+func (r *spamRepository) AddRule(ctx context.Context, rule *entity.SpamRule) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO spam_rules (board_id, pattern, is_regex, created_by) VALUES ($1,$2,$3,$4)
+        RETURNING id, created_at`,
+		rule.BoardID, rule.Pattern, rule.Regex, rule.CreatedBy).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+func (r *spamRepository) DeleteRule(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM spam_rules WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *spamRepository) RecordFingerprint(ctx context.Context, fingerprint string, authorID int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO spam_fingerprints (fingerprint, author_id) VALUES ($1,$2)`, fingerprint, authorID)
+	return err
+}
+
+func (r *spamRepository) CountFingerprint(ctx context.Context, fingerprint string, since time.Time) (copies, authors int, err error) {
+	err = r.db.QueryRowContext(ctx, `
+        SELECT count(*), count(DISTINCT author_id) FROM spam_fingerprints
+        WHERE fingerprint=$1 AND created_at > $2`, fingerprint, since).Scan(&copies, &authors)
+	return
+}
+
+func (r *spamRepository) PruneFingerprints(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM spam_fingerprints WHERE created_at < $1`, before)
+	return err
+}
+
+func (r *spamRepository) TokenCounts(ctx context.Context, tokens []string) (map[string]spam.Counts, error) {
+	res := map[string]spam.Counts{}
+	if len(tokens) == 0 {
+		return res, nil
+	}
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT token, spam, ham FROM spam_tokens WHERE token = ANY($1)`, pq.Array(tokens))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t string
+		var c spam.Counts
+		if err := rows.Scan(&t, &c.Spam, &c.Ham); err != nil {
+			return nil, err
+		}
+		res[t] = c
+	}
+	return res, rows.Err()
+}
+
+func (r *spamRepository) Corpus(ctx context.Context) (spamDocs, hamDocs int, err error) {
+	err = r.db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(docs) FILTER (WHERE label='spam'),0), COALESCE(SUM(docs) FILTER (WHERE label='ham'),0)
+        FROM spam_corpus`).Scan(&spamDocs, &hamDocs)
+	return
+}
+
+func (r *spamRepository) Train(ctx context.Context, tokens []string, isSpam bool) error {
+	label, spamInc, hamInc := "ham", 0, 1
+	if isSpam {
+		label, spamInc, hamInc = "spam", 1, 0
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if len(tokens) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO spam_tokens (token, spam, ham) SELECT t, $2, $3 FROM unnest($1::text[]) AS t
+            ON CONFLICT (token) DO UPDATE SET spam = spam_tokens.spam + EXCLUDED.spam, ham = spam_tokens.ham + EXCLUDED.ham`,
+			pq.Array(tokens), spamInc, hamInc); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO spam_corpus (label, docs) VALUES ($1, 1)
+        ON CONFLICT (label) DO UPDATE SET docs = spam_corpus.docs + 1`, label); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
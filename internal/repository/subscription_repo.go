@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+)
+
+type SubscriptionRepository interface {
+	// Save creates or updates the user's subscription, setting its muted flag
+	Save(ctx context.Context, s *entity.Subscription) error
+	// Delete removes a subscription; sql.ErrNoRows when there was none
+	Delete(ctx context.Context, userID int64, target string, targetID int64) error
+	// Get is one subscription without title; sql.ErrNoRows when there is none
+	Get(ctx context.Context, userID int64, target string, targetID int64) (*entity.Subscription, error)
+	// List is every subscription of the user with the titles of live targets, newest first
+	List(ctx context.Context, userID int64) ([]entity.Subscription, error)
+	// PostSubscribers are users following the post's board, its club or its author, except the author
+	PostSubscribers(ctx context.Context, postID int64) ([]int64, error)
+	// ThreadWatchers are users subscribed to the thread and not muting it
+	ThreadWatchers(ctx context.Context, postID int64) ([]int64, error)
+	// ThreadMuted reports whether the user muted the thread
+	ThreadMuted(ctx context.Context, userID, postID int64) (bool, error)
+}
+
+func NewSubscriptionRepository(db *sql.DB) SubscriptionRepository {
+	return &subscriptionRepository{db: db}
+}
+
+type subscriptionRepository struct{ db *sql.DB }
+
+func (r *subscriptionRepository) Save(ctx context.Context, s *entity.Subscription) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO subscriptions (user_id, target, target_id, muted) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, target, target_id) DO UPDATE SET muted = EXCLUDED.muted
+        RETURNING created_at`, s.UserID, s.Target, s.TargetID, s.Muted).Scan(&s.CreatedAt)
+}
+
+func (r *subscriptionRepository) Delete(ctx context.Context, userID int64, target string, targetID int64) error {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM subscriptions WHERE user_id = $1 AND target = $2 AND target_id = $3`, userID, target, targetID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *subscriptionRepository) Get(ctx context.Context, userID int64, target string, targetID int64) (*entity.Subscription, error) {
+	s := entity.Subscription{UserID: userID, Target: target, TargetID: targetID}
+	err := r.db.QueryRowContext(ctx, `
+        SELECT muted, created_at FROM subscriptions WHERE user_id = $1 AND target = $2 AND target_id = $3`,
+		userID, target, targetID).Scan(&s.Muted, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *subscriptionRepository) List(ctx context.Context, userID int64) ([]entity.Subscription, error) {
+	// цели удаляются без каскада, поэтому строки без живой цели просто не показываем
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT s.target, s.target_id, s.muted, s.created_at,
+               COALESCE(b.title, c.name, p.title, u.username), COALESCE(b.slug, '')
+        FROM subscriptions s
+        LEFT JOIN boards b ON s.target = 'board' AND b.id = s.target_id
+        LEFT JOIN clubs c ON s.target = 'club' AND c.id = s.target_id
+        LEFT JOIN posts p ON s.target = 'post' AND p.id = s.target_id
+        LEFT JOIN users u ON s.target = 'user' AND u.id = s.target_id
+        WHERE s.user_id = $1 AND COALESCE(b.id, c.id, p.id, u.id) IS NOT NULL
+        ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.Subscription{}
+	for rows.Next() {
+		s := entity.Subscription{UserID: userID}
+		if err := rows.Scan(&s.Target, &s.TargetID, &s.Muted, &s.CreatedAt, &s.Title, &s.Slug); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+func (r *subscriptionRepository) PostSubscribers(ctx context.Context, postID int64) ([]int64, error) {
+	return queryIDs(r.db.QueryContext(ctx, `
+        SELECT DISTINCT s.user_id
+        FROM posts p
+        JOIN boards b ON b.id = p.board_id
+        JOIN subscriptions s ON NOT s.muted AND (
+               (s.target = 'board' AND s.target_id = p.board_id)
+            OR (s.target = 'club' AND s.target_id = b.club_id)
+            OR (s.target = 'user' AND s.target_id = p.author_id))
+        WHERE p.id = $1 AND s.user_id <> p.author_id`, postID))
+}
+
+func (r *subscriptionRepository) ThreadWatchers(ctx context.Context, postID int64) ([]int64, error) {
+	return queryIDs(r.db.QueryContext(ctx, `
+        SELECT user_id FROM subscriptions WHERE target = 'post' AND target_id = $1 AND NOT muted`, postID))
+}
+
+func (r *subscriptionRepository) ThreadMuted(ctx context.Context, userID, postID int64) (bool, error) {
+	var muted bool
+	err := r.db.QueryRowContext(ctx, `
+        SELECT muted FROM subscriptions WHERE user_id = $1 AND target = 'post' AND target_id = $2`,
+		userID, postID).Scan(&muted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return muted, err
+}
+
+func queryIDs(rows *sql.Rows, err error) ([]int64, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type ThrottleRepository interface {
+	// Hit records an attempt of action by key and returns how many attempts fall into the window, including this one
+	Hit(ctx context.Context, action, key string, window time.Duration) (int, error)
+}
+
+func NewThrottleRepository(db *sql.DB) ThrottleRepository {
+	return &throttleRepository{db: db}
+}
+
+type throttleRepository struct{ db *sql.DB }
+
+func (r *throttleRepository) Hit(ctx context.Context, action, key string, window time.Duration) (int, error) {
+	secs := window.Seconds()
+	// старые записи этого ключа больше не нужны
+	if _, err := r.db.ExecContext(ctx, `
+        DELETE FROM auth_throttle WHERE action=$1 AND key=$2 AND created_at < now() - $3 * interval '1 second'`,
+		action, key, secs); err != nil {
+		return 0, err
+	}
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+        WITH ins AS (INSERT INTO auth_throttle (action, key) VALUES ($1,$2) RETURNING 1)
+        SELECT count(*) + 1 FROM auth_throttle
+        WHERE action=$1 AND key=$2 AND created_at >= now() - $3 * interval '1 second'`,
+		action, key, secs).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"time"
+)
+
+type TokenRepository interface {
+	Create(ctx context.Context, userID int64, purpose, tokenHash, email string, expiresAt time.Time) error
+	// Consume marks a valid token used and returns it; sql.ErrNoRows if it is unknown, expired or already used
+	Consume(ctx context.Context, purpose, tokenHash string) (*entity.UserToken, error)
+	// Valid reports whether the token could still be consumed, without using it up
+	Valid(ctx context.Context, purpose, tokenHash string) (bool, error)
+	// Peek returns a token that could still be consumed, without using it up; sql.ErrNoRows otherwise
+	Peek(ctx context.Context, purpose, tokenHash string) (*entity.UserToken, error)
+	// InvalidateAll burns every unused token of the user for purpose
+	InvalidateAll(ctx context.Context, userID int64, purpose string) error
+}
+
+func NewTokenRepository(db *sql.DB) TokenRepository {
+	return &tokenRepository{db: db}
+}
+
+type tokenRepository struct{ db *sql.DB }
+
+func (r *tokenRepository) Create(ctx context.Context, userID int64, purpose, tokenHash, email string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at) VALUES ($1,$2,$3,$4,$5)`,
+		userID, purpose, tokenHash, email, expiresAt)
+	return err
+}
+
+func (r *tokenRepository) Consume(ctx context.Context, purpose, tokenHash string) (*entity.UserToken, error) {
+	// один UPDATE: два параллельных запроса не смогут использовать токен дважды
+	t := entity.UserToken{Purpose: purpose}
+	err := r.db.QueryRowContext(ctx, `
+        UPDATE user_tokens SET used_at=now()
+        WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now()
+        RETURNING user_id, email`, tokenHash, purpose,
+	).Scan(&t.UserID, &t.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *tokenRepository) Valid(ctx context.Context, purpose, tokenHash string) (bool, error) {
+	var ok bool
+	err := r.db.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM user_tokens
+                       WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now())`,
+		tokenHash, purpose).Scan(&ok)
+	return ok, err
+}
+
+func (r *tokenRepository) Peek(ctx context.Context, purpose, tokenHash string) (*entity.UserToken, error) {
+	t := entity.UserToken{Purpose: purpose}
+	err := r.db.QueryRowContext(ctx, `
+        SELECT user_id, email FROM user_tokens
+        WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now()`,
+		tokenHash, purpose,
+	).Scan(&t.UserID, &t.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *tokenRepository) InvalidateAll(ctx context.Context, userID int64, purpose string) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL`, userID, purpose)
+	return err
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+	"time"
+)
+
+type TwoFactorRepository interface {
+	// SetPendingSecret stores a new, not yet confirmed secret; it never replaces an enabled one
+	SetPendingSecret(ctx context.Context, userID int64, sealed string) error
+	// Secret returns the stored (sealed) secret; sql.ErrNoRows if there is none
+	Secret(ctx context.Context, userID int64) (sealed string, enabled bool, err error)
+	Enable(ctx context.Context, userID int64) error
+	// Disable removes the secret, recovery codes and remembered devices
+	Disable(ctx context.Context, userID int64) error
+	// UseStep records a TOTP step as used; false if it (or a later one) was used already
+	UseStep(ctx context.Context, userID, step int64) (bool, error)
+
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+
+	AddTrustedDevice(ctx context.Context, userID int64, tokenHash, userAgent string, expiresAt time.Time) error
+	// TrustedDevice reports whether the token is a live remembered device of the user
+	TrustedDevice(ctx context.Context, userID int64, tokenHash string) (bool, error)
+	ListTrustedDevices(ctx context.Context, userID int64) ([]entity.TrustedDevice, error)
+	ForgetTrustedDevices(ctx context.Context, userID int64) error
+}
+
+func NewTwoFactorRepository(db *sql.DB) TwoFactorRepository {
+	return &twoFactorRepository{db: db}
+}
+
+type twoFactorRepository struct{ db *sql.DB }
+
+func (r *twoFactorRepository) SetPendingSecret(ctx context.Context, userID int64, sealed string) error {
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE users SET totp_secret=$2, totp_last_step=0, updated_at=now()
+        WHERE id=$1 AND totp_enabled_at IS NULL`, userID, sealed)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *twoFactorRepository) Secret(ctx context.Context, userID int64) (string, bool, error) {
+	var sealed sql.NullString
+	var enabled bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id=$1`, userID,
+	).Scan(&sealed, &enabled)
+	if err != nil {
+		return "", false, err
+	}
+	if !sealed.Valid {
+		return "", false, sql.ErrNoRows
+	}
+	return sealed.String, enabled, nil
+}
+
+func (r *twoFactorRepository) Enable(ctx context.Context, userID int64) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE users SET totp_enabled_at=now(), updated_at=now()
+        WHERE id=$1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`, userID)
+	return err
+}
+
+func (r *twoFactorRepository) Disable(ctx context.Context, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE users SET totp_secret=NULL, totp_enabled_at=NULL, totp_last_step=0, updated_at=now()
+        WHERE id=$1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trusted_devices WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *twoFactorRepository) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	// условие в WHERE: два параллельных входа с одним кодом не пройдут оба
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE users SET totp_last_step=$2 WHERE id=$1 AND totp_last_step < $2`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *twoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1,$2)`, userID, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE recovery_codes SET used_at=now()
+        WHERE id = (SELECT id FROM recovery_codes
+                    WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL
+                    LIMIT 1 FOR UPDATE)`, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *twoFactorRepository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM recovery_codes WHERE user_id=$1 AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+func (r *twoFactorRepository) AddTrustedDevice(ctx context.Context, userID int64, tokenHash, userAgent string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO trusted_devices (user_id, token_hash, user_agent, expires_at) VALUES ($1,$2,$3,$4)`,
+		userID, tokenHash, userAgent, expiresAt)
+	return err
+}
+
+func (r *twoFactorRepository) TrustedDevice(ctx context.Context, userID int64, tokenHash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE trusted_devices SET last_used_at=now()
+        WHERE user_id=$1 AND token_hash=$2 AND expires_at > now()`, userID, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *twoFactorRepository) ListTrustedDevices(ctx context.Context, userID int64) ([]entity.TrustedDevice, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, user_agent, created_at, last_used_at, expires_at FROM trusted_devices
+        WHERE user_id=$1 AND expires_at > now() ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.TrustedDevice{}
+	for rows.Next() {
+		var d entity.TrustedDevice
+		if err := rows.Scan(&d.ID, &d.UserAgent, &d.CreatedAt, &d.LastUsedAt, &d.ExpiresAt); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+func (r *twoFactorRepository) ForgetTrustedDevices(ctx context.Context, userID int64) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM trusted_devices WHERE user_id=$1`, userID)
+	return err
+}
@@ -4,12 +4,50 @@ import (
 	"context"
 	"database/sql"
 	"forum1/internal/entity"
+	"strings"
+
+	"github.com/lib/pq"
 )
 
 type UserRepository interface {
 	CreateUser(ctx context.Context, u *entity.User) (int64, error)
 	GetUserByName(ctx context.Context, username string) (*entity.User, error)
 	GetUserByID(ctx context.Context, id int64) (*entity.User, error)
+	GetUsersByNames(ctx context.Context, usernames []string) ([]entity.User, error)
+	SearchByPrefix(ctx context.Context, prefix string, limit int) ([]entity.User, error)
+	// GetUsersByEmail matches case-insensitively; several accounts may share an address
+	GetUsersByEmail(ctx context.Context, email string) ([]entity.User, error)
+	SetPassword(ctx context.Context, id int64, hash string) error
+	// MarkEmailVerified confirms email only if it is still the user's current address
+	MarkEmailVerified(ctx context.Context, id int64, email string) (bool, error)
+	SetRole(ctx context.Context, id int64, role string) error
+	GetUsersByRoles(ctx context.Context, roles []string) ([]entity.User, error)
+}
+
+// userColumns is the column list scanned by scanUser; qualified so it also works in joins with users
+const userColumns = `users.id, users.username, COALESCE(users.email, ''), users.password, users.locale, users.role,
+        users.email_verified_at, users.totp_enabled_at, users.created_at, users.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads userColumns; extra destinations are scanned first, for queries that select more before them
+func scanUser(row rowScanner, extra ...any) (*entity.User, error) {
+	var u entity.User
+	var verified, twoFactor sql.NullTime
+	dest := append(extra, &u.ID, &u.Username, &u.Email, &u.Password, &u.Locale, &u.Role,
+		&verified, &twoFactor, &u.CreatedAt, &u.UpdatedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if verified.Valid {
+		u.EmailVerifiedAt = &verified.Time
+	}
+	if twoFactor.Valid {
+		u.TwoFactorEnabledAt = &twoFactor.Time
+	}
+	return &u, nil
 }
 
 type userRepository struct{ db *sql.DB }
@@ -19,8 +57,9 @@ func NewUserRepository(db *sql.DB) UserRepository { return &userRepository{db: d
 func (r *userRepository) CreateUser(ctx context.Context, u *entity.User) (int64, error) {
 	var id int64
 	if err := r.db.QueryRowContext(ctx,
-		`INSERT INTO users (username, email, password) VALUES ($1,$2,$3) RETURNING id`,
-		u.Username, u.Email, u.Password,
+		`INSERT INTO users (username, email, password, locale, role)
+         VALUES ($1,$2,$3,COALESCE(NULLIF($4, ''), 'ru'),COALESCE(NULLIF($5, ''), 'user')) RETURNING id`,
+		u.Username, u.Email, u.Password, u.Locale, u.Role,
 	).Scan(&id); err != nil {
 		return 0, err
 	}
@@ -29,24 +68,123 @@ func (r *userRepository) CreateUser(ctx context.Context, u *entity.User) (int64,
 
 func (r *userRepository) GetUserByName(ctx context.Context, username string) (*entity.User, error) {
 	row := r.db.QueryRowContext(ctx,
-		`SELECT id, username, email, password, created_at, updated_at FROM users WHERE username=$1`,
+		`SELECT `+userColumns+` FROM users WHERE username=$1`,
 		username,
 	)
-	var u entity.User
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.CreatedAt, &u.UpdatedAt); err != nil {
-		return nil, err
-	}
-	return &u, nil
+	return scanUser(row)
 }
 
 func (r *userRepository) GetUserByID(ctx context.Context, id int64) (*entity.User, error) {
 	row := r.db.QueryRowContext(ctx,
-		`SELECT id, username, email, password, created_at, updated_at FROM users WHERE id=$1`,
+		`SELECT `+userColumns+` FROM users WHERE id=$1`,
 		id,
 	)
-	var u entity.User
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	return scanUser(row)
+}
+
+func (r *userRepository) GetUsersByNames(ctx context.Context, usernames []string) ([]entity.User, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, username, email, created_at, updated_at FROM users WHERE username = ANY($1)`,
+		pq.Array(usernames),
+	)
+	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	defer rows.Close()
+	var res []entity.User
+	for rows.Next() {
+		var u entity.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, u)
+	}
+	return res, rows.Err()
+}
+
+func (r *userRepository) SearchByPrefix(ctx context.Context, prefix string, limit int) ([]entity.User, error) {
+	// экранируем спецсимволы LIKE, чтобы "_" в нике не работал как шаблон
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, username, created_at FROM users WHERE username ILIKE $1 || '%' ORDER BY length(username), username LIMIT $2`,
+		escaped, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.User
+	for rows.Next() {
+		var u entity.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, u)
+	}
+	return res, rows.Err()
+}
+
+func (r *userRepository) GetUsersByEmail(ctx context.Context, email string) ([]entity.User, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE lower(email) = lower($1) ORDER BY id`, strings.TrimSpace(email))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *u)
+	}
+	return res, rows.Err()
+}
+
+func (r *userRepository) SetPassword(ctx context.Context, id int64, hash string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE users SET password=$2, updated_at=now() WHERE id=$1`, id, hash)
+	return err
+}
+
+func (r *userRepository) MarkEmailVerified(ctx context.Context, id int64, email string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+        UPDATE users SET email_verified_at=now(), updated_at=now()
+        WHERE id=$1 AND lower(email)=lower($2) AND email_verified_at IS NULL`, id, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *userRepository) SetRole(ctx context.Context, id int64, role string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET role=$2, updated_at=now() WHERE id=$1`, id, role)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *userRepository) GetUsersByRoles(ctx context.Context, roles []string) ([]entity.User, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE role = ANY($1) ORDER BY id`, pq.Array(roles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []entity.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *u)
+	}
+	return res, rows.Err()
 }
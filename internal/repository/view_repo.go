@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"forum1/internal/entity"
+
+	"github.com/lib/pq"
+)
+
+type ViewRepository interface {
+	// RecordViews stores a batch of views; repeated (post, visitor) pairs are ignored and
+	// posts.view_count grows by the number of new ones
+	RecordViews(ctx context.Context, views []entity.PostView) error
+	// History counts unique views of a post per day, oldest first, for the last `days` days
+	History(ctx context.Context, postID int64, days int) ([]entity.ViewDay, error)
+}
+
+func NewViewRepository(db *sql.DB) ViewRepository {
+	return &viewRepository{db: db}
+}
+
+type viewRepository struct{ db *sql.DB }
+
+func (r *viewRepository) RecordViews(ctx context.Context, views []entity.PostView) error {
+	if len(views) == 0 {
+		return nil
+	}
+	posts := make([]int64, len(views))
+	users := make([]int64, len(views))
+	visitors := make([]string, len(views))
+	for i, v := range views {
+		posts[i], users[i], visitors[i] = v.PostID, v.UserID, v.Visitor
+	}
+	// один запрос: вставка и счётчик только для действительно новых просмотров
+	_, err := r.db.ExecContext(ctx, `
+        WITH ins AS (
+            INSERT INTO post_views (post_id, user_id, visitor)
+            SELECT v.post_id, NULLIF(v.user_id, 0), v.visitor
+            FROM unnest($1::bigint[], $2::bigint[], $3::text[]) AS v(post_id, user_id, visitor)
+            WHERE EXISTS (SELECT 1 FROM posts WHERE id = v.post_id)
+            ON CONFLICT (post_id, visitor) DO NOTHING
+            RETURNING post_id
+        )
+        UPDATE posts p SET view_count = p.view_count + c.n
+        FROM (SELECT post_id, count(*) AS n FROM ins GROUP BY post_id) c
+        WHERE p.id = c.post_id`,
+		pq.Array(posts), pq.Array(users), pq.Array(visitors))
+	return err
+}
+
+func (r *viewRepository) History(ctx context.Context, postID int64, days int) ([]entity.ViewDay, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT d::date, COALESCE(v.n, 0), COALESCE(v.users, 0)
+        FROM generate_series(current_date - ($2::int - 1), current_date, interval '1 day') AS d
+        LEFT JOIN (
+            SELECT created_at::date AS day, count(*) AS n, count(user_id) AS users
+            FROM post_views WHERE post_id = $1 AND created_at >= current_date - ($2::int - 1)
+            GROUP BY 1
+        ) v ON v.day = d::date
+        ORDER BY 1`, postID, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []entity.ViewDay{}
+	for rows.Next() {
+		var d entity.ViewDay
+		if err := rows.Scan(&d.Day, &d.Views, &d.Users); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"forum1/internal/entity"
 	h "forum1/internal/handler"
 	"net/http"
 
@@ -11,12 +12,13 @@ func NewRouter(post *h.PostHandler, opts ...Option) *mux.Router {
 	r := mux.NewRouter()
 	api := r.PathPrefix("/api").Subrouter()
 
-	api.HandleFunc("/", post.HomePage).Methods(http.MethodGet)
-	api.HandleFunc("/post/{id}", post.GetPostPage).Methods(http.MethodGet)
-	api.HandleFunc("/post", post.CreatePost).Methods(http.MethodPost)
-	api.HandleFunc("/post/{id}", post.UpdatePost).Methods(http.MethodPut)
-	api.HandleFunc("/post/{id}", post.DeletePost).Methods(http.MethodDelete)
-	api.HandleFunc("/posts", post.GetPostsJSON).Methods(http.MethodGet)
+	// h.Scoped: какое право нужно личному API-токену для маршрута; h.Limited: политика ограничения частоты
+	api.HandleFunc("/", h.Scoped(entity.ScopeRead, post.HomePage)).Methods(http.MethodGet)
+	api.HandleFunc("/post/{id}", h.Scoped(entity.ScopeRead, post.GetPostPage)).Methods(http.MethodGet)
+	api.HandleFunc("/post", h.Scoped(entity.ScopePost, h.Limited("post", post.CreatePost))).Methods(http.MethodPost)
+	api.HandleFunc("/post/{id}", h.Scoped(entity.ScopePost, post.UpdatePost)).Methods(http.MethodPut)
+	api.HandleFunc("/post/{id}", h.Scoped(entity.ScopePost, post.DeletePost)).Methods(http.MethodDelete)
+	api.HandleFunc("/posts", h.Scoped(entity.ScopeRead, post.GetPostsJSON)).Methods(http.MethodGet)
 
 	// HTML pages
 	// Will be added by app with PageHandler when composed
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/utils"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired link")
+	ErrWeakPassword = errors.New("password must be at least 8 characters")
+)
+
+const (
+	verifyTokenTTL    = 48 * time.Hour
+	resetTokenTTL     = time.Hour
+	minPasswordLength = 8
+)
+
+// throttle is a limit of attempts per window for one key (e-mail, IP, user)
+type throttle struct {
+	limit  int
+	window time.Duration
+}
+
+var (
+	throttleResetPerEmail   = throttle{3, time.Hour}
+	throttleResetPerIP      = throttle{10, time.Hour}
+	throttleVerifyPerUser   = throttle{3, time.Hour}
+	throttleTokenUsePerIP   = throttle{30, time.Hour} // попытки подобрать токен
+	throttleVerifySendPerIP = throttle{20, time.Hour}
+)
+
+type AccountService interface {
+	// SendVerification mails a confirmation link to the user's current address
+	SendVerification(ctx context.Context, u *entity.User, ip string) error
+	VerifyEmail(ctx context.Context, token, ip string) (*entity.User, error)
+	// RequestPasswordReset always succeeds for unknown addresses too, so it can't be used to probe accounts;
+	// only ErrRateLimited is reported
+	RequestPasswordReset(ctx context.Context, email, ip string) error
+	// CheckResetToken tells the reset page whether to show the form, without using the token up
+	CheckResetToken(ctx context.Context, token string) bool
+	// ResetPassword sets a new password and ends every session of the account
+	ResetPassword(ctx context.Context, token, password, ip string) error
+}
+
+func NewAccountService(users repository.UserRepository, tokens repository.TokenRepository, throttles repository.ThrottleRepository,
+	sessions SessionService, emails EmailService, secret []byte) AccountService {
+	return &accountService{
+		users:     users,
+		tokens:    tokens,
+		throttles: throttles,
+		sessions:  sessions,
+		emails:    emails,
+		signer:    tokenSigner{secret: secret},
+	}
+}
+
+type accountService struct {
+	users     repository.UserRepository
+	tokens    repository.TokenRepository
+	throttles repository.ThrottleRepository
+	sessions  SessionService
+	emails    EmailService
+	signer    tokenSigner
+}
+
+// allow counts an attempt against repo and reports ErrRateLimited once the limit is exceeded
+func (t throttle) allow(ctx context.Context, repo repository.ThrottleRepository, action, key string) error {
+	n, err := repo.Hit(ctx, action, key, t.window)
+	if err != nil {
+		return err
+	}
+	if n > t.limit {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+func (s *accountService) allow(ctx context.Context, action, key string, t throttle) error {
+	return t.allow(ctx, s.throttles, action, key)
+}
+
+func (s *accountService) SendVerification(ctx context.Context, u *entity.User, ip string) error {
+	if u == nil || strings.TrimSpace(u.Email) == "" {
+		return ErrInvalidInput
+	}
+	if u.EmailVerifiedAt != nil {
+		return nil
+	}
+	if err := s.allow(ctx, "verify_send", fmt.Sprint(u.ID), throttleVerifyPerUser); err != nil {
+		return err
+	}
+	if ip != "" {
+		if err := s.allow(ctx, "verify_send_ip", ip, throttleVerifySendPerIP); err != nil {
+			return err
+		}
+	}
+	token, hash, err := s.signer.New(entity.TokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	if err := s.tokens.Create(ctx, u.ID, entity.TokenVerifyEmail, hash, u.Email, time.Now().Add(verifyTokenTTL)); err != nil {
+		return err
+	}
+	return s.emails.Send(ctx, EmailRequest{
+		UserID:        &u.ID,
+		To:            u.Email,
+		Lang:          u.Locale,
+		Template:      "verify_email",
+		Data:          map[string]any{"Username": u.Username, "Link": s.link("/verify-email", token)},
+		Transactional: true,
+	})
+}
+
+func (s *accountService) VerifyEmail(ctx context.Context, token, ip string) (*entity.User, error) {
+	if err := s.allow(ctx, "token_use", ip, throttleTokenUsePerIP); err != nil {
+		return nil, err
+	}
+	hash, ok := s.signer.Check(entity.TokenVerifyEmail, token)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	t, err := s.tokens.Consume(ctx, entity.TokenVerifyEmail, hash)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// ссылка подтверждает только тот адрес, на который была отправлена
+	verified, err := s.users.MarkEmailVerified(ctx, t.UserID, t.Email)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.users.GetUserByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u.EmailVerifiedAt == nil {
+		return nil, ErrInvalidToken // адрес успели сменить
+	}
+	if verified {
+		if err := s.emails.Send(ctx, EmailRequest{
+			UserID: &u.ID, To: u.Email, Lang: u.Locale, Template: "welcome",
+			Data: map[string]any{"Username": u.Username},
+		}); err != nil {
+			fmt.Println("welcome email:", err)
+		}
+	}
+	return u, nil
+}
+
+func (s *accountService) RequestPasswordReset(ctx context.Context, email, ip string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return ErrInvalidInput
+	}
+	if err := s.allow(ctx, "reset_ip", ip, throttleResetPerIP); err != nil {
+		return err
+	}
+	if err := s.allow(ctx, "reset_email", email, throttleResetPerEmail); err != nil {
+		return err
+	}
+	// поиск аккаунта и отправка идут в фоне: время ответа не выдаёт, существует ли адрес
+	go s.sendResetLinks(context.Background(), email)
+	return nil
+}
+
+func (s *accountService) sendResetLinks(ctx context.Context, email string) {
+	users, err := s.users.GetUsersByEmail(ctx, email)
+	if err != nil {
+		fmt.Println("password reset:", err)
+		return
+	}
+	for _, u := range users {
+		token, hash, err := s.signer.New(entity.TokenResetPassword)
+		if err != nil {
+			fmt.Println("password reset:", err)
+			return
+		}
+		if err := s.tokens.Create(ctx, u.ID, entity.TokenResetPassword, hash, u.Email, time.Now().Add(resetTokenTTL)); err != nil {
+			fmt.Println("password reset:", err)
+			continue
+		}
+		if err := s.emails.Send(ctx, EmailRequest{
+			UserID:        &u.ID,
+			To:            u.Email,
+			Lang:          u.Locale,
+			Template:      "reset_password",
+			Data:          map[string]any{"Username": u.Username, "Link": s.link("/reset-password", token)},
+			Transactional: true,
+		}); err != nil {
+			fmt.Println("password reset:", err)
+		}
+	}
+}
+
+func (s *accountService) CheckResetToken(ctx context.Context, token string) bool {
+	hash, ok := s.signer.Check(entity.TokenResetPassword, token)
+	if !ok {
+		return false
+	}
+	valid, err := s.tokens.Valid(ctx, entity.TokenResetPassword, hash)
+	return err == nil && valid
+}
+
+func (s *accountService) ResetPassword(ctx context.Context, token, password, ip string) error {
+	if err := s.allow(ctx, "token_use", ip, throttleTokenUsePerIP); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	hash, ok := s.signer.Check(entity.TokenResetPassword, token)
+	if !ok {
+		return ErrInvalidToken
+	}
+	t, err := s.tokens.Consume(ctx, entity.TokenResetPassword, hash)
+	if err == sql.ErrNoRows {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	pwHash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.users.SetPassword(ctx, t.UserID, pwHash); err != nil {
+		return err
+	}
+	// остальные ссылки сброса и все сессии (в том числе у того, кто мог украсть пароль) больше не действуют
+	if err := s.tokens.InvalidateAll(ctx, t.UserID, entity.TokenResetPassword); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeAll(ctx, t.UserID); err != nil {
+		return err
+	}
+	// ссылка пришла на почту — значит адрес подтверждён
+	_, _ = s.users.MarkEmailVerified(ctx, t.UserID, t.Email)
+
+	if u, err := s.users.GetUserByID(ctx, t.UserID); err == nil && u.Email != "" {
+		if err := s.emails.Send(ctx, EmailRequest{
+			UserID: &u.ID, To: u.Email, Lang: u.Locale, Template: "password_changed",
+			Data:          map[string]any{"Username": u.Username, "Link": s.link("/forgot-password", "")},
+			Transactional: true,
+		}); err != nil {
+			fmt.Println("password changed email:", err)
+		}
+	}
+	return nil
+}
+
+// link builds an absolute URL for e-mails; the site URL is filled in by the e-mail service
+func (s *accountService) link(path, token string) string {
+	if token == "" {
+		return path
+	}
+	return path + "?token=" + token
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memToken struct {
+	entity.UserToken
+	hash    string
+	expires time.Time
+	used    bool
+}
+
+// memTokens ведёт себя как token_repo: токен гасится при использовании и по сроку
+type memTokens struct {
+	repository.TokenRepository
+	list []*memToken
+}
+
+func (m *memTokens) Create(ctx context.Context, userID int64, purpose, hash, email string, expiresAt time.Time) error {
+	m.list = append(m.list, &memToken{UserToken: entity.UserToken{UserID: userID, Purpose: purpose, Email: email}, hash: hash, expires: expiresAt})
+	return nil
+}
+
+func (m *memTokens) find(purpose, hash string) *memToken {
+	for _, t := range m.list {
+		if t.Purpose == purpose && t.hash == hash && !t.used && time.Now().Before(t.expires) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *memTokens) Consume(ctx context.Context, purpose, hash string) (*entity.UserToken, error) {
+	t := m.find(purpose, hash)
+	if t == nil {
+		return nil, sql.ErrNoRows
+	}
+	t.used = true
+	return &t.UserToken, nil
+}
+
+func (m *memTokens) Valid(ctx context.Context, purpose, hash string) (bool, error) {
+	return m.find(purpose, hash) != nil, nil
+}
+
+func (m *memTokens) InvalidateAll(ctx context.Context, userID int64, purpose string) error {
+	for _, t := range m.list {
+		if t.UserID == userID && t.Purpose == purpose {
+			t.used = true
+		}
+	}
+	return nil
+}
+
+type accountUsersStub struct {
+	repository.UserRepository
+	users map[int64]*entity.User
+}
+
+func (m *accountUsersStub) GetUserByID(ctx context.Context, id int64) (*entity.User, error) {
+	cp := *m.users[id]
+	return &cp, nil
+}
+
+func (m *accountUsersStub) GetUsersByEmail(ctx context.Context, email string) ([]entity.User, error) {
+	var res []entity.User
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			res = append(res, *u)
+		}
+	}
+	return res, nil
+}
+
+func (m *accountUsersStub) MarkEmailVerified(ctx context.Context, id int64, email string) (bool, error) {
+	u := m.users[id]
+	if u.Email != email || u.EmailVerifiedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	u.EmailVerifiedAt = &now
+	return true, nil
+}
+
+func (m *accountUsersStub) SetPassword(ctx context.Context, id int64, hash string) error {
+	m.users[id].Password = hash
+	return nil
+}
+
+type revokeStub struct {
+	SessionService
+	revoked []int64
+}
+
+func (s *revokeStub) RevokeAll(ctx context.Context, userID int64) error {
+	s.revoked = append(s.revoked, userID)
+	return nil
+}
+
+type mailStub struct {
+	EmailService
+	sent []EmailRequest
+}
+
+func (m *mailStub) Send(ctx context.Context, req EmailRequest) error {
+	m.sent = append(m.sent, req)
+	return nil
+}
+
+// lastLinkToken достаёт токен из ссылки последнего письма
+func (m *mailStub) lastLinkToken(t *testing.T, template string) string {
+	t.Helper()
+	req := m.sent[len(m.sent)-1]
+	if req.Template != template {
+		t.Fatalf("last email is %q, want %q", req.Template, template)
+	}
+	_, token, ok := strings.Cut(req.Data["Link"].(string), "token=")
+	if !ok {
+		t.Fatalf("link without a token: %v", req.Data["Link"])
+	}
+	return token
+}
+
+type accountFixture struct {
+	s        *accountService
+	users    *accountUsersStub
+	sessions *revokeStub
+	mail     *mailStub
+}
+
+func newAccountFixture() *accountFixture {
+	f := &accountFixture{
+		users:    &accountUsersStub{users: map[int64]*entity.User{1: {ID: 1, Username: "anna", Email: "anna@example.com"}}},
+		sessions: &revokeStub{},
+		mail:     &mailStub{},
+	}
+	f.s = NewAccountService(f.users, &memTokens{}, &throttleStub{hits: map[string]int{}}, f.sessions, f.mail,
+		[]byte("test secret")).(*accountService)
+	return f
+}
+
+func TestVerifyEmailConfirmsTheAddressTheLinkWentTo(t *testing.T) {
+	f := newAccountFixture()
+	ctx := context.Background()
+	if err := f.s.SendVerification(ctx, f.users.users[1], "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token := f.mail.lastLinkToken(t, "verify_email")
+
+	random, _, _ := strings.Cut(token, ".")
+	if _, err := f.s.VerifyEmail(ctx, random+".forged", "10.0.0.1"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("forged signature: %v, want ErrInvalidToken", err)
+	}
+	u, err := f.s.VerifyEmail(ctx, token, "10.0.0.1")
+	if err != nil || u.EmailVerifiedAt == nil {
+		t.Fatalf("verify: %+v, %v", u, err)
+	}
+	if f.mail.sent[len(f.mail.sent)-1].Template != "welcome" {
+		t.Fatal("no welcome email after the first verification")
+	}
+	if _, err := f.s.VerifyEmail(ctx, token, "10.0.0.1"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("link used twice: %v, want ErrInvalidToken", err)
+	}
+
+	// адрес сменили после отправки: старая ссылка новый адрес не подтверждает
+	f.users.users[1].EmailVerifiedAt = nil
+	if err := f.s.SendVerification(ctx, f.users.users[1], "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token = f.mail.lastLinkToken(t, "verify_email")
+	f.users.users[1].Email = "new@example.com"
+	if _, err := f.s.VerifyEmail(ctx, token, "10.0.0.1"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("link for the old address: %v, want ErrInvalidToken", err)
+	}
+	if f.users.users[1].EmailVerifiedAt != nil {
+		t.Fatal("the new address was verified by a link sent to the old one")
+	}
+}
+
+func TestResetPasswordEndsSessionsAndOtherLinks(t *testing.T) {
+	f := newAccountFixture()
+	ctx := context.Background()
+	f.s.sendResetLinks(ctx, "ANNA@example.com")
+	first := f.mail.lastLinkToken(t, "reset_password")
+	f.s.sendResetLinks(ctx, "anna@example.com")
+	second := f.mail.lastLinkToken(t, "reset_password")
+
+	if !f.s.CheckResetToken(ctx, second) || f.s.CheckResetToken(ctx, "nope.nope") {
+		t.Fatal("CheckResetToken does not tell valid links from bad ones")
+	}
+	if err := f.s.ResetPassword(ctx, second, "short", "10.0.0.1"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("short password: %v, want ErrWeakPassword", err)
+	}
+	if !f.s.CheckResetToken(ctx, second) {
+		t.Fatal("a rejected password used the link up")
+	}
+	if err := f.s.ResetPassword(ctx, second, "correct horse battery", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.CheckPasswordHash("correct horse battery", f.users.users[1].Password) {
+		t.Fatal("password not changed")
+	}
+	if len(f.sessions.revoked) != 1 || f.sessions.revoked[0] != 1 {
+		t.Fatalf("revoked sessions of %v, want user 1", f.sessions.revoked)
+	}
+	if f.users.users[1].EmailVerifiedAt == nil {
+		t.Fatal("a reset through the mailbox did not verify the address")
+	}
+	if f.mail.sent[len(f.mail.sent)-1].Template != "password_changed" {
+		t.Fatal("no password_changed notice")
+	}
+	for name, token := range map[string]string{"used link": second, "older link": first} {
+		if err := f.s.ResetPassword(ctx, token, "another password", "10.0.0.1"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestRequestPasswordResetIsThrottledButSilent(t *testing.T) {
+	f := newAccountFixture()
+	ctx := context.Background()
+	// неизвестный адрес отвечает так же, как известный
+	for i := 0; i < throttleResetPerEmail.limit; i++ {
+		if err := f.s.RequestPasswordReset(ctx, "ghost@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("request %d for an unknown address: %v", i+1, err)
+		}
+	}
+	if err := f.s.RequestPasswordReset(ctx, " Ghost@Example.com ", "10.0.0.2"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("request past the per-address limit: %v, want ErrRateLimited", err)
+	}
+	if err := f.s.RequestPasswordReset(ctx, "no-at-sign", "10.0.0.1"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("malformed address: %v, want ErrInvalidInput", err)
+	}
+}
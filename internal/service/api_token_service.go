@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrTooManyTokens = errors.New("too many active tokens, revoke some first")
+
+const (
+	// apiTokenPrefix makes leaked tokens easy to spot (secret scanners, logs)
+	apiTokenPrefix     = "fpat_"
+	maxActiveAPITokens = 25
+	maxAPITokenName    = 100
+	// apiTokenTouchEvery limits last-used writes for busy bots
+	apiTokenTouchEvery = time.Minute
+)
+
+type APITokenService interface {
+	// Create returns the raw token; it is shown to the user once and never stored
+	Create(ctx context.Context, u *entity.User, name string, scopes []string, ttl time.Duration) (string, *entity.APIToken, error)
+	// Resolve returns the owner of a live token and records its use
+	Resolve(ctx context.Context, raw, ip string) (*entity.User, *entity.APIToken, error)
+	List(ctx context.Context, userID int64) ([]entity.APIToken, error)
+	Revoke(ctx context.Context, userID, id int64) error
+}
+
+func NewAPITokenService(repo repository.APITokenRepository) APITokenService {
+	return &apiTokenService{repo: repo}
+}
+
+type apiTokenService struct {
+	repo repository.APITokenRepository
+}
+
+func (s *apiTokenService) Create(ctx context.Context, u *entity.User, name string, scopes []string, ttl time.Duration) (string, *entity.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPITokenName || len(scopes) == 0 || ttl < 0 {
+		return "", nil, ErrInvalidInput
+	}
+	seen := map[string]bool{}
+	clean := make([]string, 0, len(scopes))
+	for _, sc := range scopes {
+		if !entity.ValidScope(sc) {
+			return "", nil, ErrInvalidInput
+		}
+		if sc == entity.ScopeModerate && !u.HasRole(entity.RoleModerator) {
+			return "", nil, ErrForbidden
+		}
+		if !seen[sc] {
+			seen[sc] = true
+			clean = append(clean, sc)
+		}
+	}
+	n, err := s.repo.CountActive(ctx, u.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	if n >= maxActiveAPITokens {
+		return "", nil, ErrTooManyTokens
+	}
+
+	random, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := apiTokenPrefix + random
+	t := &entity.APIToken{UserID: u.ID, Name: name, Prefix: raw[:len(apiTokenPrefix)+6], Scopes: clean}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		t.ExpiresAt = &expires
+	}
+	if err := s.repo.Create(ctx, hashToken(raw), t); err != nil {
+		return "", nil, err
+	}
+	return raw, t, nil
+}
+
+func (s *apiTokenService) Resolve(ctx context.Context, raw, ip string) (*entity.User, *entity.APIToken, error) {
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+	u, t, err := s.repo.GetUser(ctx, hashToken(raw))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > apiTokenTouchEvery || t.LastUsedIP != ip {
+		_ = s.repo.Touch(ctx, t.ID, ip)
+	}
+	return u, t, nil
+}
+
+func (s *apiTokenService) List(ctx context.Context, userID int64) ([]entity.APIToken, error) {
+	return s.repo.ListActive(ctx, userID)
+}
+
+func (s *apiTokenService) Revoke(ctx context.Context, userID, id int64) error {
+	return s.repo.Revoke(ctx, userID, id)
+}
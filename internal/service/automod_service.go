@@ -0,0 +1,543 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/spam"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// AutomodService runs the rules moderators and club owners set up without code changes.
+// Reject and hold act before content is saved, as a check of the spam filter; tag, lock and
+// notify act on the thread once the post or comment is published.
+type AutomodService interface {
+	// Scopes tells what actor may write rules for: the whole forum (moderators), their clubs and those clubs' boards
+	Scopes(ctx context.Context, actor *entity.User) (*AutomodScopes, error)
+	// Rules lists the rules actor may manage
+	Rules(ctx context.Context, actor *entity.User) ([]entity.AutomodRule, error)
+	Rule(ctx context.Context, actor *entity.User, id int64) (*entity.AutomodRule, error)
+	CreateRule(ctx context.Context, actor *entity.User, r *entity.AutomodRule) error
+	SetRuleEnabled(ctx context.Context, actor *entity.User, id int64, enabled bool) error
+	DeleteRule(ctx context.Context, actor *entity.User, id int64) error
+	// DryRun reports which of the latest limit posts in the rule's scope it would have matched, changing nothing.
+	// Only posts are tried, whatever the rule's target.
+	DryRun(ctx context.Context, actor *entity.User, r *entity.AutomodRule, limit int) (*entity.AutomodDryRun, error)
+
+	SpamCheck       // reject, hold
+	PostListener    // tag, lock, notify
+	CommentListener // tag, lock, notify
+}
+
+// AutomodScopes are the places a user may write automoderator rules for
+type AutomodScopes struct {
+	Site   bool
+	Clubs  []entity.Club
+	Boards []entity.Board
+}
+
+// Covers reports whether r is within the scopes
+func (sc *AutomodScopes) Covers(r *entity.AutomodRule) bool {
+	switch r.Scope() {
+	case entity.AutomodScopeBoard:
+		for _, b := range sc.Boards {
+			if b.ID == *r.BoardID {
+				return true
+			}
+		}
+	case entity.AutomodScopeClub:
+		for _, c := range sc.Clubs {
+			if c.ID == *r.ClubID {
+				return true
+			}
+		}
+	default:
+		return sc.Site
+	}
+	return false
+}
+
+func NewAutomodService(repo repository.AutomodRepository, posts repository.PostRepository, boards repository.BoardRepository,
+	clubs repository.ClubRepository, users repository.UserRepository, notifications NotificationService) AutomodService {
+	return &automodService{repo: repo, posts: posts, boards: boards, clubs: clubs, users: users, notifications: notifications,
+		compiled: map[string]*regexp.Regexp{}}
+}
+
+type automodService struct {
+	repo          repository.AutomodRepository
+	posts         repository.PostRepository
+	boards        repository.BoardRepository
+	clubs         repository.ClubRepository
+	users         repository.UserRepository
+	notifications NotificationService
+
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}
+
+func (s *automodService) Scopes(ctx context.Context, actor *entity.User) (*AutomodScopes, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	clubs, err := s.clubs.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	boards, err := s.boards.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sc := &AutomodScopes{Site: isModerator(actor)}
+	owned := map[int64]bool{}
+	for _, c := range clubs {
+		if sc.Site || (c.OwnerID != nil && *c.OwnerID == actor.ID) {
+			owned[c.ID] = true
+			c.ImageData = nil
+			sc.Clubs = append(sc.Clubs, c)
+		}
+	}
+	for _, b := range boards {
+		if sc.Site || (b.ClubID != nil && owned[*b.ClubID]) {
+			sc.Boards = append(sc.Boards, b)
+		}
+	}
+	if !sc.Site && len(sc.Clubs) == 0 {
+		return nil, ErrForbidden
+	}
+	return sc, nil
+}
+
+func (s *automodService) canManage(ctx context.Context, actor *entity.User, r *entity.AutomodRule) error {
+	sc, err := s.Scopes(ctx, actor)
+	if err != nil {
+		return err
+	}
+	if !sc.Covers(r) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s *automodService) Rules(ctx context.Context, actor *entity.User) ([]entity.AutomodRule, error) {
+	sc, err := s.Scopes(ctx, actor)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := []entity.AutomodRule{}
+	for i := range rules {
+		if sc.Covers(&rules[i]) {
+			res = append(res, rules[i])
+		}
+	}
+	return res, nil
+}
+
+func (s *automodService) Rule(ctx context.Context, actor *entity.User, id int64) (*entity.AutomodRule, error) {
+	r, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.canManage(ctx, actor, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *automodService) CreateRule(ctx context.Context, actor *entity.User, r *entity.AutomodRule) error {
+	if err := normalizeAutomodRule(r); err != nil {
+		return err
+	}
+	if err := s.canManage(ctx, actor, r); err != nil {
+		return err
+	}
+	r.Enabled = true
+	r.CreatedBy = &actor.ID
+	return s.repo.Create(ctx, r)
+}
+
+func (s *automodService) SetRuleEnabled(ctx context.Context, actor *entity.User, id int64, enabled bool) error {
+	if _, err := s.Rule(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.SetEnabled(ctx, id, enabled)
+}
+
+func (s *automodService) DeleteRule(ctx context.Context, actor *entity.User, id int64) error {
+	if _, err := s.Rule(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, id)
+}
+
+func invalidRule(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidInput, msg)
+}
+
+// normalizeAutomodRule trims and validates what a user typed into a rule
+func normalizeAutomodRule(r *entity.AutomodRule) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || utf8.RuneCountInString(r.Name) > 100 {
+		return invalidRule("name must be 1..100 characters")
+	}
+	if r.ClubID != nil && *r.ClubID <= 0 {
+		r.ClubID = nil
+	}
+	if r.BoardID != nil && *r.BoardID <= 0 {
+		r.BoardID = nil
+	}
+	if r.ClubID != nil && r.BoardID != nil {
+		return invalidRule("a rule is for a club or for a board, not both")
+	}
+	switch r.Target {
+	case "":
+		r.Target = entity.AutomodTargetAll
+	case entity.AutomodTargetAll, entity.AutomodTargetPost, entity.AutomodTargetComment:
+	default:
+		return invalidRule("target must be all, post or comment")
+	}
+
+	r.Pattern = strings.TrimSpace(r.Pattern)
+	if r.Pattern != "" {
+		if _, err := spam.CompileRule(r.Pattern, true); err != nil {
+			return invalidRule(err.Error())
+		}
+	}
+	if r.MaxAccountAgeHours < 0 {
+		return invalidRule("account age must not be negative")
+	}
+	domains := []string{}
+	seen := map[string]bool{}
+	for _, d := range r.LinkDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		d = strings.TrimPrefix(strings.TrimPrefix(d, "https://"), "http://")
+		if i := strings.IndexAny(d, "/?#:"); i >= 0 {
+			d = d[:i]
+		}
+		d = strings.TrimPrefix(strings.Trim(d, "."), "www.")
+		if d != "" && !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) > 50 {
+		return invalidRule("at most 50 domains")
+	}
+	r.LinkDomains = domains
+	if r.Pattern == "" && r.MaxAccountAgeHours == 0 && r.MaxKarma == nil && len(r.LinkDomains) == 0 && !r.Attachment {
+		return invalidRule("a rule needs at least one condition")
+	}
+
+	switch r.Action {
+	case entity.AutomodReject, entity.AutomodHold, entity.AutomodLock, entity.AutomodNotify:
+		r.Tag = ""
+	case entity.AutomodTag:
+		r.Tag = strings.ToLower(strings.TrimSpace(r.Tag))
+		if r.Tag == "" || utf8.RuneCountInString(r.Tag) > 30 {
+			return invalidRule("tag must be 1..30 characters")
+		}
+	default:
+		return invalidRule("action must be reject, hold, tag, lock or notify")
+	}
+	r.Message = strings.TrimSpace(r.Message)
+	if utf8.RuneCountInString(r.Message) > 500 {
+		return invalidRule("message is limited to 500 characters")
+	}
+	return nil
+}
+
+// automodFacts caches what the conditions of several rules ask about one subject
+type automodFacts struct {
+	hosts []string
+	karma *int
+}
+
+func (s *automodService) matches(ctx context.Context, r *entity.AutomodRule, subj *SpamSubject, facts *automodFacts) (bool, error) {
+	if (r.Target == entity.AutomodTargetPost && subj.Comment) || (r.Target == entity.AutomodTargetComment && !subj.Comment) {
+		return false, nil
+	}
+	if r.Attachment && !subj.Attachment {
+		return false, nil
+	}
+	if r.MaxAccountAgeHours > 0 && time.Since(subj.Author.CreatedAt) >= time.Duration(r.MaxAccountAgeHours)*time.Hour {
+		return false, nil
+	}
+	if len(r.LinkDomains) > 0 {
+		if facts.hosts == nil {
+			facts.hosts = append([]string{}, spam.LinkHosts(subj.Text())...)
+		}
+		linked := false
+		for _, h := range facts.hosts {
+			linked = linked || spam.HostMatches(h, r.LinkDomains)
+		}
+		if !linked {
+			return false, nil
+		}
+	}
+	if r.Pattern != "" {
+		re := s.regexp(r)
+		if re == nil || !re.MatchString(subj.Text()) {
+			return false, nil
+		}
+	}
+	if r.MaxKarma != nil {
+		if facts.karma == nil {
+			k, err := s.repo.Karma(ctx, subj.AuthorID)
+			if err != nil {
+				return false, err
+			}
+			facts.karma = &k
+		}
+		if *facts.karma > *r.MaxKarma {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *automodService) regexp(r *entity.AutomodRule) *regexp.Regexp {
+	key := fmt.Sprintf("%d/%s", r.ID, r.Pattern)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if re, ok := s.compiled[key]; ok {
+		return re
+	}
+	re, err := spam.CompileRule(r.Pattern, true)
+	if err != nil {
+		fmt.Println("automod rule", r.ID, err)
+	}
+	if r.ID != 0 { // правила пробного прогона не кэшируем
+		s.compiled[key] = re
+	}
+	return re
+}
+
+func automodReason(r *entity.AutomodRule) string {
+	reason := fmt.Sprintf("rule #%d %q", r.ID, r.Name)
+	if r.Message != "" {
+		reason += ": " + r.Message
+	}
+	return reason
+}
+
+func (s *automodService) Name() string { return "automod" }
+
+func (s *automodService) Check(ctx context.Context, subj *SpamSubject) (*SpamHit, error) {
+	rules, err := s.repo.Applicable(ctx, subj.BoardID)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	facts := &automodFacts{}
+	var held []string
+	for i := range rules {
+		r := &rules[i]
+		if r.Action != entity.AutomodReject && r.Action != entity.AutomodHold {
+			continue
+		}
+		ok, err := s.matches(ctx, r, subj, facts)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if r.Action == entity.AutomodReject {
+			msg := r.Message
+			if msg == "" {
+				msg = "сообщение нарушает правила доски"
+			}
+			return &SpamHit{Reason: automodReason(r), Score: 1, Block: true, Message: msg}, nil
+		}
+		held = append(held, automodReason(r))
+	}
+	if len(held) == 0 {
+		return nil, nil
+	}
+	return &SpamHit{Reason: strings.Join(held, "; "), Score: 1, Hold: true}, nil
+}
+
+func (s *automodService) PostCreated(ctx context.Context, p *entity.Post) {
+	s.act(ctx, &SpamSubject{
+		AuthorID: p.AuthorID, BoardID: p.BoardID, PostID: p.ID, Title: p.Title, Content: p.Content, LinkURL: p.LinkURL,
+		Attachment: len(p.ImageData) > 0 || p.ImageURL != "",
+	}, nil, p)
+}
+
+func (s *automodService) CommentCreated(ctx context.Context, c *entity.Comment) {
+	post, err := s.posts.GetPostByID(ctx, c.PostID)
+	if err != nil {
+		fmt.Println("automod:", err)
+		return
+	}
+	s.act(ctx, &SpamSubject{
+		AuthorID: c.AuthorID, BoardID: post.BoardID, PostID: c.PostID, Comment: true, Content: c.Content,
+		Attachment: len(c.ImageData) > 0,
+	}, &c.ID, post)
+}
+
+func (s *automodService) CommentDeleted(ctx context.Context, c *entity.Comment, byUserID int64) {}
+
+// act carries out the tag, lock and notify rules matching published content; they all act on the thread
+func (s *automodService) act(ctx context.Context, subj *SpamSubject, commentID *int64, post *entity.Post) {
+	author, err := s.users.GetUserByID(ctx, subj.AuthorID)
+	if err != nil {
+		fmt.Println("automod:", err)
+		return
+	}
+	if author.HasRole(entity.RoleModerator) {
+		return
+	}
+	subj.Author = author
+	rules, err := s.repo.Applicable(ctx, subj.BoardID)
+	if err != nil {
+		fmt.Println("automod:", err)
+		return
+	}
+	facts := &automodFacts{}
+	for i := range rules {
+		r := &rules[i]
+		if r.Action != entity.AutomodTag && r.Action != entity.AutomodLock && r.Action != entity.AutomodNotify {
+			continue
+		}
+		ok, err := s.matches(ctx, r, subj, facts)
+		if err != nil {
+			fmt.Println("automod:", err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		fmt.Println("automod: rule", r.ID, r.Action, "post", subj.PostID)
+		switch r.Action {
+		case entity.AutomodTag:
+			err = s.posts.AddPostTag(ctx, subj.PostID, r.Tag)
+		case entity.AutomodLock:
+			if post.Locked() {
+				continue
+			}
+			if err = s.posts.SetPostLocked(ctx, subj.PostID, true); err == nil {
+				locked := *post
+				now := time.Now()
+				locked.LockedAt = &now
+				post = &locked
+				if s.notifications != nil {
+					s.notifications.ThreadChanged(ctx, post, ThreadLock, 0)
+				}
+			}
+		case entity.AutomodNotify:
+			s.notifyModerators(ctx, r, subj, commentID, post.Title)
+		}
+		if err != nil {
+			fmt.Println("automod:", err)
+		}
+	}
+}
+
+// notifyModerators tells site moderators, and the club owner for a club or board rule
+func (s *automodService) notifyModerators(ctx context.Context, r *entity.AutomodRule, subj *SpamSubject, commentID *int64, title string) {
+	if s.notifications == nil {
+		return
+	}
+	recipients := map[int64]bool{}
+	mods, err := s.users.GetUsersByRoles(ctx, []string{entity.RoleModerator, entity.RoleAdmin})
+	if err != nil {
+		fmt.Println("automod notify:", err)
+	}
+	for _, m := range mods {
+		recipients[m.ID] = true
+	}
+	if owner := s.clubOwner(ctx, r); owner != 0 {
+		recipients[owner] = true
+	}
+	msg := "Автомодератор, правило «" + r.Name + "»: «" + excerpt(title, 60) + "»"
+	if r.Message != "" {
+		msg += " — " + r.Message
+	}
+	key := fmt.Sprintf("automod:%d:%d", r.ID, subj.PostID)
+	if commentID != nil {
+		key += fmt.Sprintf(":%d", *commentID)
+	}
+	for id := range recipients {
+		err := s.notifications.Notify(ctx, &entity.Notification{
+			UserID: id, Type: entity.NotificationAutomod, ActorID: &subj.AuthorID, PostID: &subj.PostID, CommentID: commentID,
+			Message: msg, DedupeKey: key,
+		})
+		if err != nil {
+			fmt.Println("automod notify:", err)
+		}
+	}
+}
+
+func (s *automodService) clubOwner(ctx context.Context, r *entity.AutomodRule) int64 {
+	clubID := r.ClubID
+	if r.BoardID != nil {
+		if b, err := s.boards.GetByID(ctx, *r.BoardID); err == nil {
+			clubID = b.ClubID
+		}
+	}
+	if clubID == nil {
+		return 0
+	}
+	club, err := s.clubs.GetByID(ctx, *clubID)
+	if err != nil || club.OwnerID == nil {
+		return 0
+	}
+	return *club.OwnerID
+}
+
+func (s *automodService) DryRun(ctx context.Context, actor *entity.User, r *entity.AutomodRule, limit int) (*entity.AutomodDryRun, error) {
+	if err := normalizeAutomodRule(r); err != nil {
+		return nil, err
+	}
+	if err := s.canManage(ctx, actor, r); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	posts, err := s.repo.RecentPosts(ctx, r.ClubID, r.BoardID, limit)
+	if err != nil {
+		return nil, err
+	}
+	probe := *r
+	probe.Target = entity.AutomodTargetAll
+	authors := map[int64]*entity.User{}
+	karma := map[int64]*int{}
+	res := &entity.AutomodDryRun{Checked: len(posts), Matches: []entity.AutomodMatch{}}
+	for _, p := range posts {
+		author, ok := authors[p.AuthorID]
+		if !ok {
+			if author, err = s.users.GetUserByID(ctx, p.AuthorID); err != nil {
+				return nil, err
+			}
+			authors[p.AuthorID] = author
+		}
+		if author.HasRole(entity.RoleModerator) {
+			continue // на живых постах модераторов автомодератор тоже не трогает
+		}
+		subj := &SpamSubject{
+			AuthorID: p.AuthorID, Author: author, BoardID: p.BoardID, PostID: p.ID, Title: p.Title, Content: p.Content,
+			LinkURL: p.LinkURL, Attachment: len(p.ImageData) > 0 || p.ImageURL != "",
+		}
+		facts := &automodFacts{karma: karma[p.AuthorID]}
+		matched, err := s.matches(ctx, &probe, subj, facts)
+		if err != nil {
+			return nil, err
+		}
+		karma[p.AuthorID] = facts.karma
+		if matched {
+			res.Matches = append(res.Matches, entity.AutomodMatch{
+				PostID: p.ID, Title: p.Title, BoardID: p.BoardID, AuthorID: p.AuthorID, Author: author.Username, CreatedAt: p.CreatedAt,
+			})
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Клуб 1 принадлежит owner, клуб 2 — кому-то другому; доска 3 ни в одном клубе
+const (
+	automodOwner, automodStranger, automodNewbie, automodVeteran, automodMod = 10, 11, 20, 21, 30
+)
+
+var (
+	automodClubs = []entity.Club{
+		{ID: 1, Name: "Автолюбители", OwnerID: ptrInt64(automodOwner)},
+		{ID: 2, Name: "Рыбаки", OwnerID: ptrInt64(automodStranger)},
+	}
+	automodBoards = []entity.Board{
+		{ID: 1, Title: "Ремонт", ClubID: ptrInt64(1)},
+		{ID: 2, Title: "Снасти", ClubID: ptrInt64(2)},
+		{ID: 3, Title: "Общая"},
+	}
+)
+
+func ptrInt64(v int64) *int64 { return &v }
+
+// automodRepoStub хранит правила как automod_repo: Applicable отдаёт включённые правила форума, клуба доски и самой доски
+type automodRepoStub struct {
+	repository.AutomodRepository
+	rules  []entity.AutomodRule
+	karma  map[int64]int
+	recent []entity.Post
+}
+
+func (r *automodRepoStub) Applicable(ctx context.Context, boardID int64) ([]entity.AutomodRule, error) {
+	var clubID *int64
+	for _, b := range automodBoards {
+		if b.ID == boardID {
+			clubID = b.ClubID
+		}
+	}
+	var res []entity.AutomodRule
+	for _, rule := range r.rules {
+		switch {
+		case !rule.Enabled:
+		case rule.BoardID != nil:
+			if *rule.BoardID == boardID {
+				res = append(res, rule)
+			}
+		case rule.ClubID != nil:
+			if clubID != nil && *rule.ClubID == *clubID {
+				res = append(res, rule)
+			}
+		default:
+			res = append(res, rule)
+		}
+	}
+	return res, nil
+}
+
+func (r *automodRepoStub) List(ctx context.Context) ([]entity.AutomodRule, error) {
+	return r.rules, nil
+}
+
+func (r *automodRepoStub) Get(ctx context.Context, id int64) (*entity.AutomodRule, error) {
+	for _, rule := range r.rules {
+		if rule.ID == id {
+			return &rule, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *automodRepoStub) Create(ctx context.Context, rule *entity.AutomodRule) error {
+	rule.ID = int64(len(r.rules) + 1)
+	r.rules = append(r.rules, *rule)
+	return nil
+}
+
+func (r *automodRepoStub) Delete(ctx context.Context, id int64) error {
+	for i, rule := range r.rules {
+		if rule.ID == id {
+			r.rules = append(r.rules[:i], r.rules[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func (r *automodRepoStub) Karma(ctx context.Context, userID int64) (int, error) {
+	return r.karma[userID], nil
+}
+
+func (r *automodRepoStub) RecentPosts(ctx context.Context, clubID, boardID *int64, limit int) ([]entity.Post, error) {
+	return r.recent, nil
+}
+
+type automodClubsStub struct{ repository.ClubRepository }
+
+func (automodClubsStub) List(ctx context.Context) ([]entity.Club, error) { return automodClubs, nil }
+
+func (automodClubsStub) GetByID(ctx context.Context, id int64) (*entity.Club, error) {
+	for _, c := range automodClubs {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+type automodBoardsStub struct{ repository.BoardRepository }
+
+func (automodBoardsStub) List(ctx context.Context) ([]entity.Board, error) { return automodBoards, nil }
+
+func (automodBoardsStub) GetByID(ctx context.Context, id int64) (*entity.Board, error) {
+	for _, b := range automodBoards {
+		if b.ID == id {
+			return &b, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// automodPeople — владельцы клубов, новичок, старожил и модератор
+type automodPeople struct{ *memUsers }
+
+func (u automodPeople) GetUsersByRoles(ctx context.Context, roles []string) ([]entity.User, error) {
+	var res []entity.User
+	for _, x := range u.byID {
+		for _, r := range roles {
+			if x.Role == r {
+				res = append(res, *x)
+			}
+		}
+	}
+	return res, nil
+}
+
+func newAutomodPeople() automodPeople {
+	year := time.Now().AddDate(-1, 0, 0)
+	return automodPeople{&memUsers{byID: map[int64]*entity.User{
+		automodOwner:    {ID: automodOwner, Username: "owner", Role: entity.RoleUser, CreatedAt: year},
+		automodStranger: {ID: automodStranger, Username: "stranger", Role: entity.RoleUser, CreatedAt: year},
+		automodNewbie:   {ID: automodNewbie, Username: "newbie", Role: entity.RoleUser, CreatedAt: time.Now().Add(-time.Hour)},
+		automodVeteran:  {ID: automodVeteran, Username: "veteran", Role: entity.RoleUser, CreatedAt: year},
+		automodMod:      {ID: automodMod, Username: "mod", Role: entity.RoleModerator, CreatedAt: year},
+	}}}
+}
+
+type taggingPostsStub struct {
+	*threadPostsStub
+	tags []string
+}
+
+func (r *taggingPostsStub) AddPostTag(ctx context.Context, id int64, tag string) error {
+	r.tags = append(r.tags, tag)
+	return nil
+}
+
+type automodFixture struct {
+	s      AutomodService
+	repo   *automodRepoStub
+	people automodPeople
+	posts  *threadPostsStub
+	notes  *notificationRepoStub
+}
+
+func newAutomodFixture(rules ...entity.AutomodRule) *automodFixture {
+	for i := range rules {
+		rules[i].ID, rules[i].Enabled = int64(i+1), true
+	}
+	f := &automodFixture{
+		repo:   &automodRepoStub{rules: rules, karma: map[int64]int{automodNewbie: -3, automodVeteran: 50}},
+		people: newAutomodPeople(),
+		posts:  &threadPostsStub{posts: map[int64]*entity.Post{}},
+		notes:  &notificationRepoStub{},
+	}
+	f.s = NewAutomodService(f.repo, f.posts, automodBoardsStub{}, automodClubsStub{}, f.people,
+		NewNotificationService(f.notes, f.posts, nil, nil))
+	return f
+}
+
+func (f *automodFixture) check(t *testing.T, subj *SpamSubject) *SpamHit {
+	t.Helper()
+	subj.Author = f.people.byID[subj.AuthorID]
+	hit, err := f.s.Check(context.Background(), subj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hit
+}
+
+func TestAutomodCheckConditions(t *testing.T) {
+	lowKarma := 0
+	f := newAutomodFixture(
+		entity.AutomodRule{Name: "ставки", Pattern: `ставк[аи]`, Action: entity.AutomodReject, Message: "без ставок"},
+		entity.AutomodRule{Name: "новички со ссылками", MaxAccountAgeHours: 24, LinkDomains: []string{"shop.example"}, Action: entity.AutomodHold},
+		entity.AutomodRule{Name: "картинки без кармы", Attachment: true, MaxKarma: &lowKarma, Target: entity.AutomodTargetComment, Action: entity.AutomodHold},
+	)
+
+	hit := f.check(t, &SpamSubject{AuthorID: automodVeteran, BoardID: 3, Content: "Принимаю СТАВКИ"})
+	if hit == nil || !hit.Block || hit.Message != "без ставок" {
+		t.Fatalf("reject rule: %+v, want a block with the rule's message", hit)
+	}
+	if err := (&SpamVerdict{Hits: []SpamHit{*hit}}).Err(); !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "без ставок") {
+		t.Fatalf("verdict error %v, want ErrRejected with the message", err)
+	}
+
+	link := "заходите на https://cdn.shop.example/sale"
+	if hit := f.check(t, &SpamSubject{AuthorID: automodNewbie, BoardID: 3, Content: link}); hit == nil || !hit.Hold || hit.Block {
+		t.Fatalf("new account linking a listed domain: %+v, want held", hit)
+	}
+	if hit := f.check(t, &SpamSubject{AuthorID: automodVeteran, BoardID: 3, Content: link}); hit != nil {
+		t.Fatalf("old account: %+v, want no hit", hit)
+	}
+	if hit := f.check(t, &SpamSubject{AuthorID: automodNewbie, BoardID: 3, Content: "https://myshop.example"}); hit != nil {
+		t.Fatalf("a domain that only ends the same: %+v, want no hit", hit)
+	}
+
+	if hit := f.check(t, &SpamSubject{AuthorID: automodNewbie, BoardID: 3, Comment: true, Content: "фото", Attachment: true}); hit == nil || !hit.Hold {
+		t.Fatalf("attachment from low karma in a comment: %+v, want held", hit)
+	}
+	for name, subj := range map[string]*SpamSubject{
+		"in a post":     {AuthorID: automodNewbie, BoardID: 3, Content: "фото", Attachment: true},
+		"high karma":    {AuthorID: automodVeteran, BoardID: 3, Comment: true, Content: "фото", Attachment: true},
+		"no attachment": {AuthorID: automodNewbie, BoardID: 3, Comment: true, Content: "фото"},
+	} {
+		if hit := f.check(t, subj); hit != nil {
+			t.Errorf("attachment rule %s: %+v, want no hit", name, hit)
+		}
+	}
+}
+
+func TestAutomodRulesApplyWithinTheirScope(t *testing.T) {
+	f := newAutomodFixture(
+		entity.AutomodRule{Name: "клуб", ClubID: ptrInt64(1), Pattern: "мотор", Action: entity.AutomodHold},
+		entity.AutomodRule{Name: "доска", BoardID: ptrInt64(2), Pattern: "мотор", Action: entity.AutomodHold},
+	)
+	f.repo.rules[1].Enabled = false
+	for board, want := range map[int64]bool{1: true, 2: false, 3: false} {
+		hit := f.check(t, &SpamSubject{AuthorID: automodVeteran, BoardID: board, Content: "продам мотор"})
+		if (hit != nil) != want {
+			t.Errorf("board %d: hit %+v, want %v", board, hit, want)
+		}
+	}
+}
+
+func TestAutomodCreateRuleChecksScopeAndInput(t *testing.T) {
+	f := newAutomodFixture()
+	ctx := context.Background()
+	owner := f.people.byID[automodOwner]
+
+	for name, r := range map[string]entity.AutomodRule{
+		"site-wide":        {Name: "r", Pattern: "x", Action: entity.AutomodHold},
+		"another club":     {Name: "r", ClubID: ptrInt64(2), Pattern: "x", Action: entity.AutomodHold},
+		"another's board":  {Name: "r", BoardID: ptrInt64(2), Pattern: "x", Action: entity.AutomodHold},
+		"board of no club": {Name: "r", BoardID: ptrInt64(3), Pattern: "x", Action: entity.AutomodHold},
+	} {
+		if err := f.s.CreateRule(ctx, owner, &r); !errors.Is(err, ErrForbidden) {
+			t.Errorf("club owner, %s: %v, want ErrForbidden", name, err)
+		}
+	}
+	if err := f.s.CreateRule(ctx, f.people.byID[automodVeteran], &entity.AutomodRule{Name: "r", ClubID: ptrInt64(1), Pattern: "x", Action: entity.AutomodHold}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("user without a club: %v, want ErrForbidden", err)
+	}
+
+	for name, r := range map[string]entity.AutomodRule{
+		"no condition":    {Name: "r", ClubID: ptrInt64(1), Action: entity.AutomodHold},
+		"bad regex":       {Name: "r", ClubID: ptrInt64(1), Pattern: "(x", Action: entity.AutomodHold},
+		"club and board":  {Name: "r", ClubID: ptrInt64(1), BoardID: ptrInt64(1), Pattern: "x", Action: entity.AutomodHold},
+		"tag without tag": {Name: "r", ClubID: ptrInt64(1), Pattern: "x", Action: entity.AutomodTag},
+		"unknown action":  {Name: "r", ClubID: ptrInt64(1), Pattern: "x", Action: "ban"},
+	} {
+		if err := f.s.CreateRule(ctx, owner, &r); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: %v, want ErrInvalidInput", name, err)
+		}
+	}
+
+	r := entity.AutomodRule{Name: " Магазины ", BoardID: ptrInt64(1), Action: entity.AutomodTag, Tag: " Реклама ",
+		LinkDomains: []string{"https://WWW.Shop.Example/path", "shop.example", " "}}
+	if err := f.s.CreateRule(ctx, owner, &r); err != nil {
+		t.Fatal(err)
+	}
+	got := f.repo.rules[0]
+	if got.Name != "Магазины" || got.Tag != "реклама" || got.Target != entity.AutomodTargetAll || !got.Enabled ||
+		len(got.LinkDomains) != 1 || got.LinkDomains[0] != "shop.example" || got.CreatedBy == nil || *got.CreatedBy != automodOwner {
+		t.Fatalf("stored rule %+v", got)
+	}
+
+	// модератор видит все правила, владелец клуба — только свои
+	if err := f.s.CreateRule(ctx, f.people.byID[automodMod], &entity.AutomodRule{Name: "site", Pattern: "x", Action: entity.AutomodHold}); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ := f.s.Rules(ctx, owner); len(rules) != 1 || rules[0].ID != got.ID {
+		t.Fatalf("owner sees %+v, want only their rule", rules)
+	}
+	if err := f.s.DeleteRule(ctx, owner, 2); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("owner deleting a site rule: %v, want ErrForbidden", err)
+	}
+}
+
+func TestAutomodActsOnPublishedContent(t *testing.T) {
+	f := newAutomodFixture(
+		entity.AutomodRule{Name: "реклама", ClubID: ptrInt64(1), LinkDomains: []string{"shop.example"}, Action: entity.AutomodTag, Tag: "реклама"},
+		entity.AutomodRule{Name: "жалоба", ClubID: ptrInt64(1), Pattern: "мошенник", Action: entity.AutomodNotify, Message: "проверьте"},
+	)
+	tagged := &taggingPostsStub{threadPostsStub: f.posts}
+	f.s = NewAutomodService(f.repo, tagged, automodBoardsStub{}, automodClubsStub{}, f.people, NewNotificationService(f.notes, f.posts, nil, nil))
+	f.posts.posts[1] = &entity.Post{ID: 1, BoardID: 1, AuthorID: automodVeteran, Title: "Продам", Content: "https://shop.example мошенник"}
+	ctx := context.Background()
+
+	f.s.PostCreated(ctx, f.posts.posts[1])
+	f.s.PostCreated(ctx, f.posts.posts[1])
+	if len(tagged.tags) != 2 || tagged.tags[0] != "реклама" {
+		t.Fatalf("tags %v, want the rule's tag", tagged.tags)
+	}
+	// модератор форума и владелец клуба, по одному разу
+	got := map[int64]int{}
+	for _, n := range f.notes.created {
+		got[n.UserID]++
+		if n.Type != entity.NotificationAutomod || !strings.Contains(n.Message, "проверьте") {
+			t.Errorf("notification %+v", n)
+		}
+	}
+	if len(got) != 2 || got[automodMod] != 1 || got[automodOwner] != 1 {
+		t.Fatalf("notified %v, want the moderator and the club owner once each", got)
+	}
+
+	// сообщения модераторов автомодератор не трогает
+	f.posts.posts[2] = &entity.Post{ID: 2, BoardID: 1, AuthorID: automodMod, Title: "Пример", Content: "https://shop.example"}
+	f.s.PostCreated(ctx, f.posts.posts[2])
+	if len(tagged.tags) != 2 {
+		t.Fatal("a moderator's post was tagged")
+	}
+}
+
+func TestAutomodDryRunChangesNothing(t *testing.T) {
+	f := newAutomodFixture()
+	f.repo.recent = []entity.Post{
+		{ID: 1, BoardID: 1, AuthorID: automodNewbie, Title: "Казино", Content: "лучшее казино"},
+		{ID: 2, BoardID: 1, AuthorID: automodVeteran, Title: "Казино", Content: "и тут казино"},
+		{ID: 3, BoardID: 1, AuthorID: automodMod, Title: "Про казино", Content: "не пишите про казино"},
+		{ID: 4, BoardID: 1, AuthorID: automodNewbie, Title: "Мотор", Content: "продам"},
+	}
+	r := &entity.AutomodRule{Name: "казино", ClubID: ptrInt64(1), Target: entity.AutomodTargetComment, Pattern: "казино",
+		MaxKarma: new(int), Action: entity.AutomodReject}
+	res, err := f.s.DryRun(context.Background(), f.people.byID[automodOwner], r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Checked != 4 || len(res.Matches) != 1 || res.Matches[0].PostID != 1 || res.Matches[0].Author != "newbie" {
+		t.Fatalf("dry run %+v, want only the low-karma author's post, comment target notwithstanding", res)
+	}
+	if len(f.repo.rules) != 0 {
+		t.Fatal("a dry run stored the rule")
+	}
+	if _, err := f.s.DryRun(context.Background(), f.people.byID[automodStranger], r, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("dry run on another's club: %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	bookmarkMaxNote    = 2000
+	bookmarkMaxFolder  = 64
+	bookmarkMaxFolders = 100
+)
+
+var ErrFolderExists = errors.New("folder with this name already exists")
+
+// BookmarkInput is what to bookmark: a post or a comment, the folder (by id, or by name, created
+// when missing; neither — no folder) and a note
+type BookmarkInput struct {
+	PostID    int64  `json:"post_id"`
+	CommentID int64  `json:"comment_id"`
+	FolderID  int64  `json:"folder_id"`
+	Folder    string `json:"folder"`
+	Note      string `json:"note"`
+}
+
+// BookmarkService keeps users' saved posts and comments
+type BookmarkService interface {
+	// Save bookmarks a post or a comment; saving it again moves it and replaces the note
+	Save(ctx context.Context, actor *entity.User, in BookmarkInput) (*entity.Bookmark, error)
+	// Delete removes a bookmark; sql.ErrNoRows when actor has none with this id
+	Delete(ctx context.Context, actor *entity.User, id int64) error
+	// List is a page of bookmarks in a folder (0 — all, -1 — unfiled) and the total count
+	List(ctx context.Context, actor *entity.User, folderID int64, limit, offset int) ([]entity.Bookmark, int, error)
+	// Export is every bookmark of actor, newest first
+	Export(ctx context.Context, actor *entity.User) ([]entity.Bookmark, error)
+	// ForPost is the user's bookmark of a post (nil if none) and the bookmark ids of its bookmarked comments
+	ForPost(ctx context.Context, userID, postID int64) (*entity.Bookmark, map[int64]int64, error)
+
+	Folders(ctx context.Context, actor *entity.User) ([]entity.BookmarkFolder, error)
+	CreateFolder(ctx context.Context, actor *entity.User, name string) (*entity.BookmarkFolder, error)
+	RenameFolder(ctx context.Context, actor *entity.User, id int64, name string) error
+	// DeleteFolder keeps the folder's bookmarks unfiled
+	DeleteFolder(ctx context.Context, actor *entity.User, id int64) error
+}
+
+func NewBookmarkService(repo repository.BookmarkRepository, posts repository.PostRepository,
+	comments repository.CommentRepository) BookmarkService {
+	return &bookmarkService{repo: repo, posts: posts, comments: comments}
+}
+
+type bookmarkService struct {
+	repo     repository.BookmarkRepository
+	posts    repository.PostRepository
+	comments repository.CommentRepository
+}
+
+func (s *bookmarkService) Save(ctx context.Context, actor *entity.User, in BookmarkInput) (*entity.Bookmark, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	if (in.PostID == 0) == (in.CommentID == 0) {
+		return nil, fmt.Errorf("%w: give either post_id or comment_id", ErrInvalidInput)
+	}
+	in.Note = strings.TrimSpace(in.Note)
+	if utf8.RuneCountInString(in.Note) > bookmarkMaxNote {
+		return nil, fmt.Errorf("%w: note is longer than %d characters", ErrInvalidInput, bookmarkMaxNote)
+	}
+	b := &entity.Bookmark{UserID: actor.ID, PostID: in.PostID, Note: in.Note}
+	// сохранить можно только то, что пользователь видит
+	if in.CommentID != 0 {
+		c, err := s.comments.GetCommentByID(ctx, in.CommentID)
+		if err != nil {
+			return nil, err
+		}
+		if c.Status != "" && c.Status != entity.StatusPublished && c.AuthorID != actor.ID {
+			return nil, ErrForbidden
+		}
+		b.CommentID, b.PostID = &c.ID, c.PostID
+	}
+	post, err := s.posts.GetPostByID(ctx, b.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if post.Status != entity.StatusPublished && post.AuthorID != actor.ID {
+		return nil, ErrForbidden
+	}
+	b.PostTitle = post.Title
+
+	switch {
+	case in.FolderID != 0:
+		owned, err := s.repo.FolderOwned(ctx, actor.ID, in.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		if !owned {
+			return nil, fmt.Errorf("%w: no such folder", ErrInvalidInput)
+		}
+		b.FolderID = &in.FolderID
+	case strings.TrimSpace(in.Folder) != "":
+		f, err := s.CreateFolder(ctx, actor, in.Folder)
+		if err != nil {
+			return nil, err
+		}
+		b.FolderID, b.FolderName = &f.ID, f.Name
+	}
+	if err := s.repo.Save(ctx, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *bookmarkService) Delete(ctx context.Context, actor *entity.User, id int64) error {
+	if actor == nil {
+		return ErrForbidden
+	}
+	return s.repo.Delete(ctx, actor.ID, id)
+}
+
+func (s *bookmarkService) List(ctx context.Context, actor *entity.User, folderID int64, limit, offset int) ([]entity.Bookmark, int, error) {
+	if actor == nil {
+		return nil, 0, ErrForbidden
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.repo.List(ctx, entity.BookmarkQuery{UserID: actor.ID, FolderID: folderID, Limit: limit, Offset: offset})
+}
+
+func (s *bookmarkService) Export(ctx context.Context, actor *entity.User) ([]entity.Bookmark, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	list, _, err := s.repo.List(ctx, entity.BookmarkQuery{UserID: actor.ID})
+	return list, err
+}
+
+func (s *bookmarkService) ForPost(ctx context.Context, userID, postID int64) (*entity.Bookmark, map[int64]int64, error) {
+	if userID == 0 {
+		return nil, nil, nil
+	}
+	return s.repo.ForPost(ctx, userID, postID)
+}
+
+func (s *bookmarkService) Folders(ctx context.Context, actor *entity.User) ([]entity.BookmarkFolder, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	return s.repo.Folders(ctx, actor.ID)
+}
+
+func folderName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" || utf8.RuneCountInString(name) > bookmarkMaxFolder {
+		return "", fmt.Errorf("%w: folder name must be 1 to %d characters", ErrInvalidInput, bookmarkMaxFolder)
+	}
+	return name, nil
+}
+
+func (s *bookmarkService) CreateFolder(ctx context.Context, actor *entity.User, name string) (*entity.BookmarkFolder, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	name, err := folderName(name)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := s.repo.Folders(ctx, actor.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range folders {
+		if f.Name == name {
+			return &f, nil
+		}
+	}
+	if len(folders) >= bookmarkMaxFolders {
+		return nil, fmt.Errorf("%w: at most %d folders", ErrInvalidInput, bookmarkMaxFolders)
+	}
+	return s.repo.CreateFolder(ctx, actor.ID, name)
+}
+
+func (s *bookmarkService) RenameFolder(ctx context.Context, actor *entity.User, id int64, name string) error {
+	if actor == nil {
+		return ErrForbidden
+	}
+	name, err := folderName(name)
+	if err != nil {
+		return err
+	}
+	folders, err := s.repo.Folders(ctx, actor.ID)
+	if err != nil {
+		return err
+	}
+	for _, f := range folders {
+		if f.Name == name && f.ID != id {
+			return ErrFolderExists
+		}
+	}
+	return s.repo.RenameFolder(ctx, actor.ID, id, name)
+}
+
+func (s *bookmarkService) DeleteFolder(ctx context.Context, actor *entity.User, id int64) error {
+	if actor == nil {
+		return ErrForbidden
+	}
+	return s.repo.DeleteFolder(ctx, actor.ID, id)
+}
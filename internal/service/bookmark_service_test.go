@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"testing"
+)
+
+// memBookmarks хранит папки и закладки одного пользователя, как bookmark_repo
+type memBookmarks struct {
+	repository.BookmarkRepository
+	folders []entity.BookmarkFolder
+	saved   []entity.Bookmark
+	query   entity.BookmarkQuery
+}
+
+func (m *memBookmarks) Save(ctx context.Context, b *entity.Bookmark) error {
+	for i, old := range m.saved {
+		if old.PostID == b.PostID && (old.CommentID == nil) == (b.CommentID == nil) &&
+			(b.CommentID == nil || *old.CommentID == *b.CommentID) {
+			b.ID = old.ID
+			m.saved[i] = *b
+			return nil
+		}
+	}
+	b.ID = int64(len(m.saved) + 1)
+	m.saved = append(m.saved, *b)
+	return nil
+}
+
+func (m *memBookmarks) List(ctx context.Context, q entity.BookmarkQuery) ([]entity.Bookmark, int, error) {
+	m.query = q
+	return m.saved, len(m.saved), nil
+}
+
+func (m *memBookmarks) Folders(ctx context.Context, userID int64) ([]entity.BookmarkFolder, error) {
+	return m.folders, nil
+}
+
+func (m *memBookmarks) FolderOwned(ctx context.Context, userID, folderID int64) (bool, error) {
+	for _, f := range m.folders {
+		if f.ID == folderID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memBookmarks) CreateFolder(ctx context.Context, userID int64, name string) (*entity.BookmarkFolder, error) {
+	f := entity.BookmarkFolder{ID: int64(len(m.folders) + 1), Name: name}
+	m.folders = append(m.folders, f)
+	return &f, nil
+}
+
+func (m *memBookmarks) RenameFolder(ctx context.Context, userID, id int64, name string) error {
+	for i := range m.folders {
+		if m.folders[i].ID == id {
+			m.folders[i].Name = name
+		}
+	}
+	return nil
+}
+
+const bmOwner, bmReader = 1, 2
+
+func newBookmarkFixture() (BookmarkService, *memBookmarks) {
+	repo := &memBookmarks{}
+	posts := &threadPostsStub{posts: map[int64]*entity.Post{
+		1: {ID: 1, AuthorID: bmOwner, Title: "Полезный тред", Status: entity.StatusPublished},
+		2: {ID: 2, AuthorID: bmOwner, Title: "Черновик", Status: entity.StatusDraft},
+	}}
+	comments := &threadRepoStub{comments: []entity.Comment{
+		{ID: 1, PostID: 1, AuthorID: bmOwner, Content: "ответ", Status: entity.StatusPublished},
+		{ID: 2, PostID: 1, AuthorID: bmOwner, Content: "на модерации", Status: entity.StatusPending},
+	}}
+	return NewBookmarkService(repo, posts, comments), repo
+}
+
+func TestBookmarkSaveChecksWhatIsSaved(t *testing.T) {
+	s, repo := newBookmarkFixture()
+	ctx := context.Background()
+	reader := &entity.User{ID: bmReader, Role: entity.RoleUser}
+
+	if _, err := s.Save(ctx, nil, BookmarkInput{PostID: 1}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("guest: %v, want ErrForbidden", err)
+	}
+	for name, in := range map[string]BookmarkInput{
+		"nothing":          {},
+		"post and comment": {PostID: 1, CommentID: 1},
+		"long note":        {PostID: 1, Note: strings.Repeat("з", bookmarkMaxNote+1)},
+		"foreign folder":   {PostID: 1, FolderID: 42},
+	} {
+		if _, err := s.Save(ctx, reader, in); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: %v, want ErrInvalidInput", name, err)
+		}
+	}
+	for name, in := range map[string]BookmarkInput{"someone's draft": {PostID: 2}, "held comment": {CommentID: 2}} {
+		if _, err := s.Save(ctx, reader, in); !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s: %v, want ErrForbidden", name, err)
+		}
+	}
+	if len(repo.saved) != 0 {
+		t.Fatalf("refused bookmarks were stored: %+v", repo.saved)
+	}
+
+	b, err := s.Save(ctx, reader, BookmarkInput{CommentID: 1, Note: "  перечитать  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.PostID != 1 || b.CommentID == nil || *b.CommentID != 1 || b.Note != "перечитать" || b.PostTitle != "Полезный тред" {
+		t.Fatalf("comment bookmark %+v", b)
+	}
+	if _, err := s.Save(ctx, &entity.User{ID: bmOwner}, BookmarkInput{PostID: 2}); err != nil {
+		t.Fatalf("author saving their own draft: %v", err)
+	}
+}
+
+func TestBookmarkFolders(t *testing.T) {
+	s, repo := newBookmarkFixture()
+	ctx := context.Background()
+	reader := &entity.User{ID: bmReader, Role: entity.RoleUser}
+
+	// папка по имени создаётся один раз, пробелы в имени схлопываются
+	first, err := s.Save(ctx, reader, BookmarkInput{PostID: 1, Folder: "  Почитать   потом "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(ctx, reader, BookmarkInput{CommentID: 1, Folder: "Почитать потом"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.folders) != 1 || repo.folders[0].Name != "Почитать потом" || first.FolderName != "Почитать потом" {
+		t.Fatalf("folders %+v", repo.folders)
+	}
+
+	// повторное сохранение переносит закладку и меняет заметку
+	moved, err := s.Save(ctx, reader, BookmarkInput{PostID: 1, Note: "уже прочитал"})
+	if err != nil || moved.ID != first.ID || repo.saved[0].FolderID != nil || repo.saved[0].Note != "уже прочитал" {
+		t.Fatalf("saving again: %+v, %v; want the same bookmark unfiled with the new note", repo.saved[0], err)
+	}
+
+	other, _ := s.CreateFolder(ctx, reader, "Рецепты")
+	if err := s.RenameFolder(ctx, reader, other.ID, "Почитать потом"); !errors.Is(err, ErrFolderExists) {
+		t.Fatalf("rename onto an existing name: %v, want ErrFolderExists", err)
+	}
+	if err := s.RenameFolder(ctx, reader, other.ID, "  "); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("empty name: %v, want ErrInvalidInput", err)
+	}
+	if err := s.RenameFolder(ctx, reader, other.ID, "Кулинария"); err != nil || repo.folders[1].Name != "Кулинария" {
+		t.Fatalf("rename: %v, folders %+v", err, repo.folders)
+	}
+
+	for len(repo.folders) < bookmarkMaxFolders {
+		repo.folders = append(repo.folders, entity.BookmarkFolder{ID: int64(len(repo.folders) + 1), Name: strings.Repeat("п", len(repo.folders))})
+	}
+	if _, err := s.CreateFolder(ctx, reader, "Ещё одна"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("folder over the limit: %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestBookmarkListPages(t *testing.T) {
+	s, repo := newBookmarkFixture()
+	ctx := context.Background()
+	reader := &entity.User{ID: bmReader, Role: entity.RoleUser}
+
+	s.List(ctx, reader, -1, 1000, -5)
+	if repo.query != (entity.BookmarkQuery{UserID: bmReader, FolderID: -1, Limit: 20, Offset: 0}) {
+		t.Fatalf("query %+v, want the default page of unfiled bookmarks", repo.query)
+	}
+	s.Export(ctx, reader)
+	if repo.query.Limit != 0 || repo.query.FolderID != 0 {
+		t.Fatalf("export query %+v, want everything", repo.query)
+	}
+	if _, _, err := s.List(ctx, nil, 0, 0, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("guest: %v, want ErrForbidden", err)
+	}
+}
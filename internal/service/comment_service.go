@@ -3,10 +3,33 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"forum1/internal/entity"
 	"forum1/internal/repository"
+	"forum1/internal/spam"
 )
 
+var (
+	ErrPostLocked   = errors.New("thread is locked")
+	ErrPostArchived = errors.New("thread is archived")
+	// ErrPostUnpublished: черновик, отложенный или задержанный модерацией пост
+	ErrPostUnpublished    = errors.New("post is not published")
+	ErrCommentUnpublished = errors.New("comment is not published")
+)
+
+// threadClosed: в неопубликованный, закрытый или архивный тред нельзя ни писать, ни голосовать
+func threadClosed(status string, locked, archived bool) error {
+	switch {
+	case status != entity.StatusPublished:
+		return ErrPostUnpublished
+	case archived:
+		return ErrPostArchived
+	case locked:
+		return ErrPostLocked
+	}
+	return nil
+}
+
 type CommentService interface {
 	CreateComment(ctx context.Context, c *entity.Comment) (int64, error)
 	GetCommentsByPost(ctx context.Context, postID int64) ([]entity.Comment, error)
@@ -15,19 +38,141 @@ type CommentService interface {
 	ForceDeleteComment(ctx context.Context, id int64) error
 	SetCommentVote(ctx context.Context, commentID int64, userID int64, value int) error
 	GetCommentVotes(ctx context.Context, commentID int64) (likes int, dislikes int, err error)
+	// SetCommentStatus is for moderators; publishing a held comment runs what CreateComment skipped for it
+	SetCommentStatus(ctx context.Context, id int64, status string) error
+}
+
+// CommentOption configures optional collaborators of CommentService
+type CommentOption func(s *commentService)
+
+// WithCommentMentions makes CreateComment parse and store @mentions
+func WithCommentMentions(m MentionService) CommentOption {
+	return func(s *commentService) { s.mentions = m }
+}
+
+// WithCommentListener subscribes l to created and deleted comments
+func WithCommentListener(l CommentListener) CommentOption {
+	return func(s *commentService) { s.listeners = append(s.listeners, l) }
+}
+
+// WithCommentVoteListener subscribes l to comment votes
+func WithCommentVoteListener(l VoteListener) CommentOption {
+	return func(s *commentService) { s.voters = append(s.voters, l) }
 }
 
-func NewCommentService(repo repository.CommentRepository) CommentService {
-	return &commentService{repo: repo}
+// WithCommentSpamFilter sends new comments through the spam filter; held ones wait for a moderator
+func WithCommentSpamFilter(f SpamFilter) CommentOption {
+	return func(s *commentService) { s.spam = f }
 }
 
-type commentService struct{ repo repository.CommentRepository }
+// WithCommentKarma requires karma for links in comments and for downvotes
+func WithCommentKarma(g KarmaGate) CommentOption {
+	return func(s *commentService) { s.karma = g }
+}
+
+func NewCommentService(repo repository.CommentRepository, opts ...CommentOption) CommentService {
+	s := &commentService{repo: repo}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type commentService struct {
+	repo      repository.CommentRepository
+	mentions  MentionService
+	listeners []CommentListener
+	voters    []VoteListener
+	spam      SpamFilter
+	karma     KarmaGate
+}
 
 func (s *commentService) CreateComment(ctx context.Context, c *entity.Comment) (int64, error) {
 	if c.PostID == 0 || c.AuthorID == 0 || c.Content == "" {
 		return 0, errors.New("invalid input")
 	}
-	return s.repo.CreateComment(ctx, c)
+	if c.QuoteID != nil {
+		// цитировать можно только комментарий из того же поста
+		quoted, err := s.repo.GetCommentByID(ctx, *c.QuoteID)
+		if err != nil || quoted.PostID != c.PostID {
+			return 0, errors.New("quoted comment not found")
+		}
+	}
+	status, locked, archived, err := s.repo.PostClosed(ctx, c.PostID)
+	if err != nil {
+		return 0, err
+	}
+	if err := threadClosed(status, locked, archived); err != nil {
+		return 0, err
+	}
+	if s.karma != nil && spam.Links(c.Content) > 0 {
+		if err := s.karma.Allow(ctx, c.AuthorID, entity.PrivilegePostLinks); err != nil {
+			return 0, err
+		}
+	}
+	var verdict *SpamVerdict
+	if s.spam != nil {
+		verdict = s.spam.Review(ctx, &SpamSubject{
+			AuthorID: c.AuthorID, PostID: c.PostID, Comment: true, Content: c.Content, Attachment: len(c.ImageData) > 0,
+		})
+		if verdict.Blocked {
+			return 0, verdict.Err()
+		}
+		if verdict.Held {
+			c.Status = entity.StatusPending
+		}
+	}
+	id, err := s.repo.CreateComment(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	c.ID = id
+	if c.Status == entity.StatusPending {
+		err := s.spam.Hold(ctx, c.PostID, &c.ID, c.AuthorID, verdict)
+		if err == nil {
+			return id, nil
+		}
+		fmt.Println("moderation queue:", err)
+		if err := s.repo.SetCommentStatus(ctx, id, entity.StatusPublished); err != nil {
+			return 0, err
+		}
+	}
+	c.Status = entity.StatusPublished
+	s.published(ctx, c)
+	return id, nil
+}
+
+// published runs what follows a comment becoming visible
+func (s *commentService) published(ctx context.Context, c *entity.Comment) {
+	if s.mentions != nil {
+		if _, _, err := s.mentions.Sync(ctx, c.PostID, &c.ID, c.AuthorID, c.Content); err != nil {
+			fmt.Println("mentions sync:", err)
+		}
+	}
+	for _, l := range s.listeners {
+		l.CommentCreated(ctx, c)
+	}
+}
+
+func (s *commentService) SetCommentStatus(ctx context.Context, id int64, status string) error {
+	if id == 0 {
+		return errors.New("id required")
+	}
+	c, err := s.repo.GetCommentByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.Status == status {
+		return nil
+	}
+	if err := s.repo.SetCommentStatus(ctx, id, status); err != nil {
+		return err
+	}
+	if status == entity.StatusPublished && c.Status == entity.StatusPending {
+		c.Status = status
+		s.published(ctx, c)
+	}
+	return nil
 }
 func (s *commentService) GetCommentsByPost(ctx context.Context, postID int64) ([]entity.Comment, error) {
 	if postID == 0 {
@@ -45,7 +190,19 @@ func (s *commentService) DeleteComment(ctx context.Context, id int64, requesterI
 	if id == 0 {
 		return errors.New("id required")
 	}
-	return s.repo.DeleteComment(ctx, id)
+	var deleted *entity.Comment
+	if len(s.listeners) > 0 {
+		deleted, _ = s.repo.GetCommentByID(ctx, id)
+	}
+	if err := s.repo.DeleteComment(ctx, id); err != nil {
+		return err
+	}
+	if deleted != nil {
+		for _, l := range s.listeners {
+			l.CommentDeleted(ctx, deleted, requesterID)
+		}
+	}
+	return nil
 }
 
 func (s *commentService) ForceDeleteComment(ctx context.Context, id int64) error {
@@ -59,7 +216,32 @@ func (s *commentService) SetCommentVote(ctx context.Context, commentID int64, us
 	if commentID == 0 || userID == 0 || (value != -1 && value != 1) {
 		return errors.New("invalid input")
 	}
-	return s.repo.SetCommentVote(ctx, commentID, userID, value)
+	c, err := s.repo.GetCommentByID(ctx, commentID)
+	if err != nil {
+		return err
+	}
+	if c.Status != entity.StatusPublished {
+		return ErrCommentUnpublished
+	}
+	status, locked, archived, err := s.repo.PostClosed(ctx, c.PostID)
+	if err != nil {
+		return err
+	}
+	if err := threadClosed(status, locked, archived); err != nil {
+		return err
+	}
+	if value < 0 && s.karma != nil {
+		if err := s.karma.Allow(ctx, userID, entity.PrivilegeDownvote); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.SetCommentVote(ctx, commentID, userID, value); err != nil {
+		return err
+	}
+	for _, l := range s.voters {
+		l.CommentVoted(ctx, commentID, userID, value)
+	}
+	return nil
 }
 
 func (s *commentService) GetCommentVotes(ctx context.Context, commentID int64) (likes int, dislikes int, err error) {
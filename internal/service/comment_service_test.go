@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"testing"
+)
+
+// threadRepoStub — комментарии и состояние поста 1 в памяти, без базы
+type threadRepoStub struct {
+	repository.CommentRepository
+	postStatus string
+	locked     bool
+	comments   []entity.Comment
+	votes      int
+}
+
+func (r *threadRepoStub) PostClosed(ctx context.Context, postID int64) (string, bool, bool, error) {
+	return r.postStatus, r.locked, false, nil
+}
+
+func (r *threadRepoStub) CreateComment(ctx context.Context, c *entity.Comment) (int64, error) {
+	r.comments = append(r.comments, *c)
+	return int64(len(r.comments)), nil
+}
+
+func (r *threadRepoStub) GetCommentByID(ctx context.Context, id int64) (*entity.Comment, error) {
+	c := r.comments[id-1]
+	return &c, nil
+}
+
+func (r *threadRepoStub) SetCommentVote(ctx context.Context, commentID, userID int64, value int) error {
+	r.votes++
+	return nil
+}
+
+// threadEvents считает, что сервис успел разослать подписчикам
+type threadEvents struct{ created, voted int }
+
+func (e *threadEvents) CommentCreated(ctx context.Context, c *entity.Comment)                 { e.created++ }
+func (e *threadEvents) CommentDeleted(ctx context.Context, c *entity.Comment, byUserID int64) {}
+func (e *threadEvents) PostVoted(ctx context.Context, postID, voterID int64, value int) {
+	e.voted++
+}
+func (e *threadEvents) CommentVoted(ctx context.Context, commentID, voterID int64, value int) {
+	e.voted++
+}
+
+var unpublishedStatuses = []string{entity.StatusDraft, entity.StatusScheduled, entity.StatusPending, entity.StatusRejected}
+
+func TestCreateCommentRefusesUnpublishedPosts(t *testing.T) {
+	for _, status := range unpublishedStatuses {
+		repo, events := &threadRepoStub{postStatus: status}, &threadEvents{}
+		s := NewCommentService(repo, WithCommentListener(events))
+		_, err := s.CreateComment(context.Background(), &entity.Comment{PostID: 1, AuthorID: 2, Content: "hi @alice"})
+		if !errors.Is(err, ErrPostUnpublished) {
+			t.Errorf("comment on a %s post: %v, want ErrPostUnpublished", status, err)
+		}
+		if len(repo.comments) != 0 || events.created != 0 {
+			t.Errorf("comment on a %s post: %d stored, %d events; want none", status, len(repo.comments), events.created)
+		}
+	}
+
+	repo, events := &threadRepoStub{postStatus: entity.StatusPublished}, &threadEvents{}
+	s := NewCommentService(repo, WithCommentListener(events))
+	if _, err := s.CreateComment(context.Background(), &entity.Comment{PostID: 1, AuthorID: 2, Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.comments) != 1 || events.created != 1 {
+		t.Fatalf("comment on a published post: %d stored, %d events; want 1 and 1", len(repo.comments), events.created)
+	}
+
+	repo.locked = true
+	if _, err := s.CreateComment(context.Background(), &entity.Comment{PostID: 1, AuthorID: 2, Content: "hi"}); !errors.Is(err, ErrPostLocked) {
+		t.Fatalf("comment on a locked post: %v, want ErrPostLocked", err)
+	}
+}
+
+func TestSetCommentVoteRefusesUnpublished(t *testing.T) {
+	newRepo := func(postStatus, commentStatus string) *threadRepoStub {
+		return &threadRepoStub{postStatus: postStatus, comments: []entity.Comment{
+			{ID: 1, PostID: 1, AuthorID: 3, Content: "c", Status: commentStatus},
+		}}
+	}
+	for _, status := range unpublishedStatuses {
+		repo, events := newRepo(status, entity.StatusPublished), &threadEvents{}
+		s := NewCommentService(repo, WithCommentVoteListener(events))
+		if err := s.SetCommentVote(context.Background(), 1, 2, 1); !errors.Is(err, ErrPostUnpublished) {
+			t.Errorf("vote in a %s post: %v, want ErrPostUnpublished", status, err)
+		}
+		if repo.votes != 0 || events.voted != 0 {
+			t.Errorf("vote in a %s post was stored or announced", status)
+		}
+	}
+	for _, status := range []string{entity.StatusPending, entity.StatusRejected} {
+		repo, events := newRepo(entity.StatusPublished, status), &threadEvents{}
+		s := NewCommentService(repo, WithCommentVoteListener(events))
+		if err := s.SetCommentVote(context.Background(), 1, 2, 1); !errors.Is(err, ErrCommentUnpublished) {
+			t.Errorf("vote on a %s comment: %v, want ErrCommentUnpublished", status, err)
+		}
+		if repo.votes != 0 || events.voted != 0 {
+			t.Errorf("vote on a %s comment was stored or announced", status)
+		}
+	}
+
+	repo, events := newRepo(entity.StatusPublished, entity.StatusPublished), &threadEvents{}
+	s := NewCommentService(repo, WithCommentVoteListener(events))
+	if err := s.SetCommentVote(context.Background(), 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if repo.votes != 1 || events.voted != 1 {
+		t.Fatalf("vote on a published comment: %d stored, %d events; want 1 and 1", repo.votes, events.voted)
+	}
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/mail"
+	"forum1/internal/repository"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrBadUnsubscribeToken = errors.New("invalid unsubscribe link")
+
+// EmailRenderer is implemented by *mail.Renderer
+type EmailRenderer interface {
+	Render(name, lang string, data any) (subject, text, html string, err error)
+}
+
+// EmailRequest describes a templated e-mail to put into the outbox
+type EmailRequest struct {
+	UserID   *int64
+	To       string
+	Lang     string
+	Template string
+	Data     map[string]any
+	// Transactional mail (password reset, security alerts) ignores unsubscribes but not bounces
+	Transactional bool
+}
+
+type EmailService interface {
+	// Send renders the request and stores it in the outbox; delivery happens in Run
+	Send(ctx context.Context, req EmailRequest) error
+	// Run delivers due outbox messages until ctx is cancelled
+	Run(ctx context.Context)
+	UnsubscribeURL(email string) string
+	Unsubscribe(ctx context.Context, email, token string) error
+	// Bounce records a hard bounce or a spam complaint reported by the mail provider
+	Bounce(ctx context.Context, email, kind, details string) error
+}
+
+type EmailConfig struct {
+	SiteURL      string // e.g. https://forum.example.com, used for links in e-mails
+	Secret       []byte // signs unsubscribe links
+	From         string
+	PollInterval time.Duration
+	Batch        int
+	Lease        time.Duration // how long a claimed message stays locked to one worker
+	MaxAttempts  int
+	BaseBackoff  time.Duration // first retry delay, doubled on each attempt
+	MaxBackoff   time.Duration
+}
+
+func DefaultEmailConfig() EmailConfig {
+	return EmailConfig{
+		SiteURL:      "http://localhost:8080",
+		PollInterval: 5 * time.Second,
+		Batch:        20,
+		Lease:        2 * time.Minute,
+		MaxAttempts:  8,
+		BaseBackoff:  time.Minute,
+		MaxBackoff:   6 * time.Hour,
+	}
+}
+
+func NewEmailService(repo repository.EmailRepository, mailer mail.Mailer, renderer EmailRenderer, cfg EmailConfig) EmailService {
+	def := DefaultEmailConfig()
+	if cfg.SiteURL == "" {
+		cfg.SiteURL = def.SiteURL
+	}
+	cfg.SiteURL = strings.TrimRight(cfg.SiteURL, "/")
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = def.PollInterval
+	}
+	if cfg.Batch <= 0 {
+		cfg.Batch = def.Batch
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = def.Lease
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = def.BaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = def.MaxBackoff
+	}
+	if cfg.From == "" {
+		cfg.From = mail.DefaultFrom()
+	}
+	return &emailService{repo: repo, mailer: mailer, renderer: renderer, cfg: cfg}
+}
+
+type emailService struct {
+	repo     repository.EmailRepository
+	mailer   mail.Mailer
+	renderer EmailRenderer
+	cfg      EmailConfig
+}
+
+func (s *emailService) Send(ctx context.Context, req EmailRequest) error {
+	req.To = strings.TrimSpace(req.To)
+	if req.To == "" || req.Template == "" {
+		return ErrInvalidInput
+	}
+	if reason, err := s.repo.Suppression(ctx, req.To); err != nil {
+		return err
+	} else if reason != "" && !(req.Transactional && reason == entity.SuppressUnsubscribe) {
+		s.log(fmt.Sprintf("skip %s to %s: suppressed (%s)", req.Template, req.To, reason))
+		return nil
+	}
+
+	data := map[string]any{}
+	for k, v := range req.Data {
+		data[k] = v
+	}
+	data["SiteURL"] = s.cfg.SiteURL
+	data["UnsubscribeURL"] = ""
+	if !req.Transactional {
+		data["UnsubscribeURL"] = s.UnsubscribeURL(req.To)
+	}
+	lang := mail.NormalizeLang(req.Lang)
+	subject, text, html, err := s.renderer.Render(req.Template, lang, data)
+	if err != nil {
+		return err
+	}
+	return s.repo.Enqueue(ctx, &entity.Email{
+		UserID:        req.UserID,
+		To:            req.To,
+		Template:      req.Template,
+		Lang:          lang,
+		Subject:       subject,
+		Text:          text,
+		HTML:          html,
+		Transactional: req.Transactional,
+	})
+}
+
+func (s *emailService) Run(ctx context.Context) {
+	t := time.NewTicker(s.cfg.PollInterval)
+	defer t.Stop()
+	for {
+		s.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *emailService) deliverDue(ctx context.Context) {
+	for {
+		batch, err := s.repo.Claim(ctx, s.cfg.Batch, s.cfg.Lease)
+		if err != nil {
+			s.log("claim: " + err.Error())
+			return
+		}
+		for _, e := range batch {
+			s.deliver(ctx, e)
+		}
+		if len(batch) < s.cfg.Batch {
+			return
+		}
+	}
+}
+
+func (s *emailService) deliver(ctx context.Context, e entity.Email) {
+	// адрес могли заблокировать уже после постановки в очередь
+	if reason, err := s.repo.Suppression(ctx, e.To); err == nil && reason != "" &&
+		!(e.Transactional && reason == entity.SuppressUnsubscribe) {
+		_ = s.repo.MarkFailed(ctx, e.ID, entity.EmailSuppressed, "suppressed: "+reason)
+		return
+	}
+
+	msg := &mail.Message{From: s.cfg.From, To: e.To, Subject: e.Subject, Text: e.Text, HTML: e.HTML}
+	if !e.Transactional {
+		msg.Headers = map[string]string{
+			"List-Unsubscribe":      "<" + s.UnsubscribeURL(e.To) + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	err := s.mailer.Send(sendCtx, msg)
+	cancel()
+
+	switch {
+	case err == nil:
+		if err := s.repo.MarkSent(ctx, e.ID); err != nil {
+			s.log("mark sent: " + err.Error())
+		}
+	case mail.IsPermanent(err):
+		s.log(fmt.Sprintf("email %d to %s failed permanently: %v", e.ID, e.To, err))
+		_ = s.repo.MarkFailed(ctx, e.ID, entity.EmailFailed, err.Error())
+		_ = s.repo.Suppress(ctx, e.To, entity.SuppressBounce, err.Error())
+	case e.Attempts >= s.cfg.MaxAttempts:
+		s.log(fmt.Sprintf("email %d to %s: giving up after %d attempts: %v", e.ID, e.To, e.Attempts, err))
+		_ = s.repo.MarkFailed(ctx, e.ID, entity.EmailFailed, err.Error())
+	default:
+		next := time.Now().Add(s.backoff(e.Attempts))
+		_ = s.repo.Retry(ctx, e.ID, next, err.Error())
+	}
+}
+
+// backoff doubles the delay after every failed attempt: 1m, 2m, 4m ... capped at MaxBackoff
+func (s *emailService) backoff(attempts int) time.Duration {
+	d := s.cfg.BaseBackoff
+	for i := 1; i < attempts && d < s.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.cfg.MaxBackoff {
+		d = s.cfg.MaxBackoff
+	}
+	return d
+}
+
+func (s *emailService) unsubscribeToken(email string) string {
+	mac := hmac.New(sha256.New, s.cfg.Secret)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *emailService) UnsubscribeURL(email string) string {
+	q := url.Values{"email": {email}, "token": {s.unsubscribeToken(email)}}
+	return s.cfg.SiteURL + "/email/unsubscribe?" + q.Encode()
+}
+
+func (s *emailService) Unsubscribe(ctx context.Context, email, token string) error {
+	if email == "" || !hmac.Equal([]byte(token), []byte(s.unsubscribeToken(email))) {
+		return ErrBadUnsubscribeToken
+	}
+	return s.repo.Suppress(ctx, email, entity.SuppressUnsubscribe, "")
+}
+
+func (s *emailService) Bounce(ctx context.Context, email, kind, details string) error {
+	if email == "" {
+		return ErrInvalidInput
+	}
+	if kind != entity.SuppressComplaint {
+		kind = entity.SuppressBounce
+	}
+	return s.repo.Suppress(ctx, email, kind, details)
+}
+
+func (s *emailService) log(msg string) {
+	fmt.Println("email:", msg)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/mail"
+	"forum1/internal/repository"
+	"testing"
+	"time"
+)
+
+// outboxStub is an outbox with one message; Claim hands it out while it is pending, like the real one after next_attempt_at
+type outboxStub struct {
+	repository.EmailRepository
+	email      entity.Email
+	retries    []time.Duration // задержка каждого повтора относительно момента Retry
+	suppressed string
+}
+
+func (o *outboxStub) Claim(ctx context.Context, limit int, lease time.Duration) ([]entity.Email, error) {
+	if o.email.Status != entity.EmailPending {
+		return nil, nil
+	}
+	o.email.Attempts++
+	o.email.Status = entity.EmailSending
+	return []entity.Email{o.email}, nil
+}
+
+func (o *outboxStub) Retry(ctx context.Context, id int64, next time.Time, lastErr string) error {
+	o.retries = append(o.retries, time.Until(next))
+	o.email.Status, o.email.LastError = entity.EmailPending, lastErr
+	return nil
+}
+
+func (o *outboxStub) MarkSent(ctx context.Context, id int64) error {
+	o.email.Status = entity.EmailSent
+	return nil
+}
+
+func (o *outboxStub) MarkFailed(ctx context.Context, id int64, status, lastErr string) error {
+	o.email.Status, o.email.LastError = status, lastErr
+	return nil
+}
+
+func (o *outboxStub) Suppression(ctx context.Context, email string) (string, error) {
+	return o.suppressed, nil
+}
+
+func (o *outboxStub) Suppress(ctx context.Context, email, reason, details string) error {
+	o.suppressed = reason
+	return nil
+}
+
+func newOutboxTest(sendErr error) (*emailService, *outboxStub) {
+	repo := &outboxStub{email: entity.Email{ID: 1, To: "bob@example.com", Subject: "s", Text: "t", Status: entity.EmailPending}}
+	mailer := mail.NewMemoryMailer()
+	mailer.Err = sendErr
+	cfg := DefaultEmailConfig()
+	cfg.MaxAttempts, cfg.BaseBackoff, cfg.MaxBackoff = 4, time.Minute, 3*time.Minute
+	return NewEmailService(repo, mailer, nil, cfg).(*emailService), repo
+}
+
+func TestOutboxRetriesThenGivesUp(t *testing.T) {
+	s, repo := newOutboxTest(errors.New("421 service not available"))
+	for i := 0; i < 10 && repo.email.Status == entity.EmailPending; i++ {
+		s.deliverDue(context.Background())
+	}
+	if repo.email.Status != entity.EmailFailed || repo.email.Attempts != 4 {
+		t.Fatalf("after a failing transport: status %q after %d attempts, want %q after 4",
+			repo.email.Status, repo.email.Attempts, entity.EmailFailed)
+	}
+	// 1m, 2m, затем упор в MaxBackoff
+	want := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	if len(repo.retries) != len(want) {
+		t.Fatalf("got %d retries, want %d", len(repo.retries), len(want))
+	}
+	for i, d := range repo.retries {
+		if d < want[i]-time.Second || d > want[i] {
+			t.Errorf("retry %d in %v, want %v", i+1, d, want[i])
+		}
+	}
+	if repo.suppressed != "" {
+		t.Errorf("temporary failures suppressed the address (%s)", repo.suppressed)
+	}
+}
+
+func TestOutboxPermanentFailureIsNotRetried(t *testing.T) {
+	s, repo := newOutboxTest(&mail.PermanentError{Err: errors.New("550 no such mailbox")})
+	s.deliverDue(context.Background())
+	if repo.email.Status != entity.EmailFailed || len(repo.retries) != 0 {
+		t.Fatalf("permanent failure: status %q with %d retries, want %q and none", repo.email.Status, len(repo.retries), entity.EmailFailed)
+	}
+	if repo.suppressed != entity.SuppressBounce {
+		t.Errorf("address suppression %q, want %q", repo.suppressed, entity.SuppressBounce)
+	}
+}
+
+func TestOutboxDeliversAfterRetry(t *testing.T) {
+	s, repo := newOutboxTest(errors.New("timeout"))
+	s.deliverDue(context.Background())
+	s.mailer.(*mail.MemoryMailer).Err = nil
+	s.deliverDue(context.Background())
+	if repo.email.Status != entity.EmailSent || repo.email.Attempts != 2 {
+		t.Fatalf("status %q after %d attempts, want %q after 2", repo.email.Status, repo.email.Attempts, entity.EmailSent)
+	}
+	if sent := s.mailer.(*mail.MemoryMailer).Sent(); len(sent) != 1 || sent[0].To != "bob@example.com" {
+		t.Fatalf("sent %+v", sent)
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"forum1/internal/entity"
+)
+
+// CommentListener is told about comments being created or removed
+type CommentListener interface {
+	CommentCreated(ctx context.Context, c *entity.Comment)
+	// CommentDeleted is called after removal; byUserID is who removed it
+	CommentDeleted(ctx context.Context, c *entity.Comment, byUserID int64)
+}
+
+// VoteListener is told after a vote on a post or a comment was stored
+type VoteListener interface {
+	PostVoted(ctx context.Context, postID, voterID int64, value int)
+	CommentVoted(ctx context.Context, commentID, voterID int64, value int)
+}
+
+// PostListener is told about newly created posts
+type PostListener interface {
+	PostCreated(ctx context.Context, p *entity.Post)
+}
+
+// NotificationListener is told about every stored notification
+type NotificationListener interface {
+	Notified(ctx context.Context, n *entity.Notification)
+}
+
+// MessageListener is told about every sent private message; recipients excludes the sender
+type MessageListener interface {
+	MessageSent(ctx context.Context, m *entity.Message, recipientIDs []int64)
+}
+
+// ModerationListener is told about moderators' actions on someone's content; byUserID is who acted,
+// 0 for the automoderator
+type ModerationListener interface {
+	// ItemDecided is called after a held post or comment was approved or rejected
+	ItemDecided(ctx context.Context, item *entity.ModerationItem, decision string, byUserID int64)
+	// ThreadChanged is called after one of the Thread* actions changed p; p is the post after the change
+	ThreadChanged(ctx context.Context, p *entity.Post, action string, byUserID int64)
+}
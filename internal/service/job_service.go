@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/jobs"
+)
+
+// JobAdmin is the admin view of the background job queue
+type JobAdmin interface {
+	Stats(ctx context.Context, actor *entity.User) ([]jobs.QueueStats, error)
+	// Failed lists dead jobs and ones waiting for a retry
+	Failed(ctx context.Context, actor *entity.User, limit int) ([]jobs.Job, error)
+	Retry(ctx context.Context, actor *entity.User, id int64) error
+	Delete(ctx context.Context, actor *entity.User, id int64) error
+}
+
+func NewJobAdmin(runner *jobs.Runner) JobAdmin {
+	return &jobAdmin{runner: runner}
+}
+
+type jobAdmin struct {
+	runner *jobs.Runner
+}
+
+func jobAdminAllowed(actor *entity.User) error {
+	if actor == nil || !actor.HasRole(entity.RoleAdmin) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s *jobAdmin) Stats(ctx context.Context, actor *entity.User) ([]jobs.QueueStats, error) {
+	if err := jobAdminAllowed(actor); err != nil {
+		return nil, err
+	}
+	return s.runner.Stats(ctx)
+}
+
+func (s *jobAdmin) Failed(ctx context.Context, actor *entity.User, limit int) ([]jobs.Job, error) {
+	if err := jobAdminAllowed(actor); err != nil {
+		return nil, err
+	}
+	return s.runner.Failed(ctx, limit)
+}
+
+func (s *jobAdmin) Retry(ctx context.Context, actor *entity.User, id int64) error {
+	if err := jobAdminAllowed(actor); err != nil {
+		return err
+	}
+	return s.runner.Retry(ctx, id)
+}
+
+func (s *jobAdmin) Delete(ctx context.Context, actor *entity.User, id int64) error {
+	if err := jobAdminAllowed(actor); err != nil {
+		return err
+	}
+	return s.runner.Delete(ctx, id)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strconv"
+	"strings"
+)
+
+var ErrKarmaTooLow = errors.New("not enough karma")
+
+// KarmaGate checks karma thresholds before privileged actions
+type KarmaGate interface {
+	// Allow is nil when userID may use privilege: moderators always may, others need enough karma.
+	// Otherwise the error wraps ErrKarmaTooLow.
+	Allow(ctx context.Context, userID int64, privilege string) error
+}
+
+// KarmaService is user reputation. Votes keep it up to date as they are stored; Recount rebuilds it
+// from scratch for what votes don't see (deleted and re-moderated posts and comments).
+type KarmaService interface {
+	KarmaGate
+	Get(ctx context.Context, userID int64) (*entity.Karma, error)
+	// Totals is the karma of each user for showing next to names; users without votes are left out
+	Totals(ctx context.Context, userIDs []int64) (map[int64]int, error)
+	// Privileges are all privileges with their thresholds; the ones u has are unlocked
+	Privileges(u *entity.User, karma *entity.Karma) []entity.Privilege
+	Recount(ctx context.Context) (int64, error)
+}
+
+type KarmaConfig struct {
+	// Thresholds is the karma each privilege needs; 0 or no entry opens it to everyone
+	Thresholds map[string]int
+}
+
+func DefaultKarmaConfig() KarmaConfig {
+	return KarmaConfig{Thresholds: map[string]int{
+		entity.PrivilegePostLinks:   5,
+		entity.PrivilegeDownvote:    10,
+		entity.PrivilegeCreateBoard: 50,
+	}}
+}
+
+// ParseKarmaThresholds reads "downvote=20,create_board=0" (the KARMA_THRESHOLDS variable) into thresholds
+func ParseKarmaThresholds(spec string, thresholds map[string]int) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, n, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		karma, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || err != nil || karma < 0 || !validPrivilege(name) {
+			return fmt.Errorf("bad karma threshold %q, want privilege=karma", part)
+		}
+		thresholds[name] = karma
+	}
+	return nil
+}
+
+func validPrivilege(name string) bool {
+	for _, p := range entity.Privileges {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func NewKarmaService(repo repository.KarmaRepository, users repository.UserRepository, cfg KarmaConfig) KarmaService {
+	if cfg.Thresholds == nil {
+		cfg.Thresholds = map[string]int{}
+	}
+	return &karmaService{repo: repo, users: users, cfg: cfg}
+}
+
+type karmaService struct {
+	repo  repository.KarmaRepository
+	users repository.UserRepository
+	cfg   KarmaConfig
+}
+
+func (s *karmaService) Allow(ctx context.Context, userID int64, privilege string) error {
+	need := s.cfg.Thresholds[privilege]
+	if need <= 0 {
+		return nil
+	}
+	u, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isModerator(u) {
+		return nil
+	}
+	totals, err := s.repo.Totals(ctx, []int64{userID})
+	if err != nil {
+		return err
+	}
+	if have := totals[userID]; have < need {
+		return fmt.Errorf("%w: %d karma needed for %s, you have %d", ErrKarmaTooLow, need, strings.ReplaceAll(privilege, "_", " "), have)
+	}
+	return nil
+}
+
+func (s *karmaService) Get(ctx context.Context, userID int64) (*entity.Karma, error) {
+	if userID <= 0 {
+		return nil, ErrInvalidInput
+	}
+	return s.repo.Get(ctx, userID)
+}
+
+func (s *karmaService) Totals(ctx context.Context, userIDs []int64) (map[int64]int, error) {
+	return s.repo.Totals(ctx, userIDs)
+}
+
+func (s *karmaService) Privileges(u *entity.User, karma *entity.Karma) []entity.Privilege {
+	res := make([]entity.Privilege, len(entity.Privileges))
+	for i, p := range entity.Privileges {
+		p.Karma = max(s.cfg.Thresholds[p.Name], 0)
+		p.Unlocked = p.Karma == 0 || isModerator(u) || (karma != nil && karma.Total() >= p.Karma)
+		res[i] = p
+	}
+	return res
+}
+
+func (s *karmaService) Recount(ctx context.Context) (int64, error) {
+	return s.repo.Recount(ctx)
+}
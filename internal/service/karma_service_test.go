@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"testing"
+)
+
+type karmaRepoStub struct {
+	repository.KarmaRepository
+	totals map[int64]int
+}
+
+func (r karmaRepoStub) Totals(ctx context.Context, ids []int64) (map[int64]int, error) {
+	res := map[int64]int{}
+	for _, id := range ids {
+		if k, ok := r.totals[id]; ok {
+			res[id] = k
+		}
+	}
+	return res, nil
+}
+
+const karmaNewbie, karmaVeteran, karmaMod = 1, 2, 3
+
+func newKarmaForTest() KarmaService {
+	users := &memUsers{byID: map[int64]*entity.User{
+		karmaNewbie:  {ID: karmaNewbie, Role: entity.RoleUser},
+		karmaVeteran: {ID: karmaVeteran, Role: entity.RoleUser},
+		karmaMod:     {ID: karmaMod, Role: entity.RoleModerator},
+	}}
+	return NewKarmaService(karmaRepoStub{totals: map[int64]int{karmaNewbie: 3, karmaVeteran: 10}}, users, DefaultKarmaConfig())
+}
+
+func TestParseKarmaThresholds(t *testing.T) {
+	th := DefaultKarmaConfig().Thresholds
+	if err := ParseKarmaThresholds(" downvote = 20, create_board=0,", th); err != nil {
+		t.Fatal(err)
+	}
+	if th[entity.PrivilegeDownvote] != 20 || th[entity.PrivilegeCreateBoard] != 0 || th[entity.PrivilegePostLinks] != 5 {
+		t.Fatalf("thresholds %v", th)
+	}
+	for _, bad := range []string{"fly=5", "downvote", "downvote=-1", "downvote=много"} {
+		if err := ParseKarmaThresholds(bad, map[string]int{}); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestKarmaAllow(t *testing.T) {
+	s := newKarmaForTest()
+	ctx := context.Background()
+	cases := []struct {
+		user      int64
+		privilege string
+		allowed   bool
+	}{
+		{karmaNewbie, entity.PrivilegeDownvote, false},
+		{karmaNewbie, entity.PrivilegeCreateBoard, false},
+		{karmaVeteran, entity.PrivilegeDownvote, true}, // ровно порог
+		{karmaVeteran, entity.PrivilegeCreateBoard, false},
+		{karmaMod, entity.PrivilegeCreateBoard, true}, // у модератора кармы нет вовсе
+		{404, "unknown", true},                        // без порога пользователя даже не ищем
+	}
+	for _, c := range cases {
+		err := s.Allow(ctx, c.user, c.privilege)
+		if c.allowed && err != nil || !c.allowed && !errors.Is(err, ErrKarmaTooLow) {
+			t.Errorf("Allow(%d, %s) = %v, allowed %v", c.user, c.privilege, err, c.allowed)
+		}
+	}
+}
+
+func TestKarmaPrivileges(t *testing.T) {
+	cfg := DefaultKarmaConfig()
+	cfg.Thresholds[entity.PrivilegeCreateBoard] = 0
+	s := NewKarmaService(karmaRepoStub{}, nil, cfg)
+
+	got := s.Privileges(&entity.User{ID: karmaNewbie}, &entity.Karma{Post: 4, Comment: 2})
+	if len(got) != len(entity.Privileges) {
+		t.Fatalf("%d privileges, want %d", len(got), len(entity.Privileges))
+	}
+	for _, p := range got {
+		want := p.Name != entity.PrivilegeDownvote
+		if p.Unlocked != want {
+			t.Errorf("%s at %d karma: unlocked %v with 6 karma", p.Name, p.Karma, p.Unlocked)
+		}
+	}
+	for _, p := range s.Privileges(&entity.User{ID: karmaMod, Role: entity.RoleModerator}, nil) {
+		if !p.Unlocked {
+			t.Errorf("moderator lacks %s", p.Name)
+		}
+	}
+}
+
+func TestDownvotesNeedKarma(t *testing.T) {
+	ctx := context.Background()
+	karma := newKarmaForTest()
+
+	posts := &closedPostsStub{}
+	s := NewPostService(posts, WithPostKarma(karma))
+	if err := s.SetPostVote(ctx, 1, karmaNewbie, -1); !errors.Is(err, ErrKarmaTooLow) || posts.votes != 0 {
+		t.Fatalf("newbie downvote: %v, %d stored; want ErrKarmaTooLow", err, posts.votes)
+	}
+	if err := s.SetPostVote(ctx, 1, karmaNewbie, 1); err != nil {
+		t.Fatalf("newbie upvote: %v", err)
+	}
+	if err := s.SetPostVote(ctx, 1, karmaVeteran, -1); err != nil || posts.votes != 2 {
+		t.Fatalf("veteran downvote: %v, %d stored", err, posts.votes)
+	}
+
+	comments := &threadRepoStub{postStatus: entity.StatusPublished, comments: []entity.Comment{
+		{ID: 1, PostID: 1, AuthorID: karmaVeteran, Content: "c", Status: entity.StatusPublished},
+	}}
+	if err := NewCommentService(comments, WithCommentKarma(karma)).SetCommentVote(ctx, 1, karmaNewbie, -1); !errors.Is(err, ErrKarmaTooLow) || comments.votes != 0 {
+		t.Fatalf("newbie comment downvote: %v, want ErrKarmaTooLow", err)
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/linkpreview"
+	"forum1/internal/repository"
+	"sync"
+	"time"
+)
+
+// LinkPreviewFetcher is implemented by *linkpreview.Fetcher
+type LinkPreviewFetcher interface {
+	Fetch(ctx context.Context, url string) (*entity.LinkPreview, error)
+}
+
+type LinkPreviewService interface {
+	// Get returns the cached preview for url, or nil if there is none yet.
+	// Missing or expired entries are queued for a background refresh.
+	Get(ctx context.Context, url string) (*entity.LinkPreview, error)
+	// Prefetch queues url for a background fetch without waiting for it
+	Prefetch(url string)
+	// Run processes the fetch queue until ctx is cancelled
+	Run(ctx context.Context)
+}
+
+type LinkPreviewConfig struct {
+	TTL      time.Duration // how long a successful preview is served before refetching
+	ErrorTTL time.Duration // how long a failed fetch is remembered, so dead hosts are not hammered
+	Workers  int
+	Queue    int
+}
+
+func DefaultLinkPreviewConfig() LinkPreviewConfig {
+	return LinkPreviewConfig{TTL: 24 * time.Hour, ErrorTTL: time.Hour, Workers: 2, Queue: 100}
+}
+
+func NewLinkPreviewService(repo repository.LinkPreviewRepository, fetcher LinkPreviewFetcher, cfg LinkPreviewConfig) LinkPreviewService {
+	def := DefaultLinkPreviewConfig()
+	if cfg.TTL <= 0 {
+		cfg.TTL = def.TTL
+	}
+	if cfg.ErrorTTL <= 0 {
+		cfg.ErrorTTL = def.ErrorTTL
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = def.Workers
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = def.Queue
+	}
+	return &linkPreviewService{
+		repo:     repo,
+		fetcher:  fetcher,
+		cfg:      cfg,
+		queue:    make(chan string, cfg.Queue),
+		inflight: map[string]bool{},
+	}
+}
+
+type linkPreviewService struct {
+	repo    repository.LinkPreviewRepository
+	fetcher LinkPreviewFetcher
+	cfg     LinkPreviewConfig
+	queue   chan string
+
+	mu       sync.Mutex
+	inflight map[string]bool
+}
+
+func (s *linkPreviewService) Get(ctx context.Context, url string) (*entity.LinkPreview, error) {
+	if !linkpreview.ValidURL(url) {
+		return nil, nil
+	}
+	p, err := s.repo.Get(ctx, url)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.Prefetch(url)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(p.ExpiresAt) {
+		// stale previews are still served while the refresh runs
+		s.Prefetch(url)
+	}
+	if p.Error != "" || p.Empty() {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func (s *linkPreviewService) Prefetch(url string) {
+	if !linkpreview.ValidURL(url) {
+		return
+	}
+	s.mu.Lock()
+	if s.inflight[url] {
+		s.mu.Unlock()
+		return
+	}
+	s.inflight[url] = true
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- url:
+	default:
+		// queue is full: drop it, the next page view will ask again
+		s.done(url)
+	}
+}
+
+func (s *linkPreviewService) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case url := <-s.queue:
+					if err := s.refresh(ctx, url); err != nil {
+						fmt.Println("link preview:", err)
+					}
+					s.done(url)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *linkPreviewService) refresh(ctx context.Context, url string) error {
+	now := time.Now()
+	p, err := s.fetcher.Fetch(ctx, url)
+	if err != nil {
+		p = &entity.LinkPreview{URL: url, Error: err.Error(), FetchedAt: now, ExpiresAt: now.Add(s.cfg.ErrorTTL)}
+	} else {
+		p.URL = url
+		p.FetchedAt = now
+		p.ExpiresAt = now.Add(s.cfg.TTL)
+	}
+	return s.repo.Upsert(ctx, p)
+}
+
+func (s *linkPreviewService) done(url string) {
+	s.mu.Lock()
+	delete(s.inflight, url)
+	s.mu.Unlock()
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAccountLocked   = errors.New("too many failed logins, the account is temporarily locked")
+	ErrCaptchaRequired = errors.New("captcha required")
+)
+
+// LoginThrottledError says when the next password attempt will be accepted.
+// errors.Is matches ErrAccountLocked for a lockout and ErrRateLimited for a backoff.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%v (retry in %s)", e.Unwrap(), e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	if e.Locked {
+		return ErrAccountLocked
+	}
+	return ErrRateLimited
+}
+
+// CaptchaVerifier checks a CAPTCHA answer from the login form. It is only consulted for
+// suspicious sources, see LoginGuardConfig.CaptchaAfter.
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, response, ip string) (bool, error)
+}
+
+type LoginGuardConfig struct {
+	Window         time.Duration // failures older than this are forgotten
+	FreeAttempts   int           // failures per account before backoff starts
+	BaseDelay      time.Duration // first backoff, doubled on every further failure
+	MaxDelay       time.Duration
+	LockoutAfter   int // failures per account that lock it
+	LockoutFor     time.Duration
+	IPFreeAttempts int // failures per IP (over all accounts) before its own backoff
+	IPMaxDelay     time.Duration
+	CaptchaAfter   int           // failures per IP after which a CAPTCHA is asked, if a verifier is set
+	Retention      time.Duration // how long attempts are kept for the admin view
+}
+
+func DefaultLoginGuardConfig() LoginGuardConfig {
+	return LoginGuardConfig{
+		Window:         time.Hour,
+		FreeAttempts:   3,
+		BaseDelay:      2 * time.Second,
+		MaxDelay:       5 * time.Minute,
+		LockoutAfter:   10,
+		LockoutFor:     30 * time.Minute,
+		IPFreeAttempts: 10,
+		IPMaxDelay:     15 * time.Minute,
+		CaptchaAfter:   5,
+		Retention:      30 * 24 * time.Hour,
+	}
+}
+
+type LoginGuard interface {
+	// Check runs before the password is compared. captcha is the form's CAPTCHA answer, if any.
+	Check(ctx context.Context, username, ip, captcha string) error
+	// CaptchaRequired tells the login page to show the CAPTCHA for this source
+	CaptchaRequired(ctx context.Context, ip string) bool
+	// Failed records a wrong password; reaching LockoutAfter locks the account and mails its owner
+	Failed(ctx context.Context, username, ip, userAgent string)
+	Succeeded(ctx context.Context, u *entity.User, ip, userAgent string)
+
+	// для администраторов
+	RecentFailures(ctx context.Context, actor *entity.User, limit int) ([]entity.LoginAttempt, error)
+	Lockouts(ctx context.Context, actor *entity.User) ([]entity.LoginLockout, error)
+	Unlock(ctx context.Context, actor *entity.User, username string) error
+}
+
+type LoginGuardOption func(*loginGuard)
+
+func WithCaptcha(v CaptchaVerifier) LoginGuardOption {
+	return func(g *loginGuard) { g.captcha = v }
+}
+
+// WithLockoutNotices mails the account owner when their account gets locked
+func WithLockoutNotices(users repository.UserRepository, emails EmailService) LoginGuardOption {
+	return func(g *loginGuard) { g.users, g.emails = users, emails }
+}
+
+func NewLoginGuard(repo repository.LoginAttemptRepository, cfg LoginGuardConfig, opts ...LoginGuardOption) LoginGuard {
+	g := &loginGuard{repo: repo, cfg: cfg}
+	for _, o := range opts {
+		o(g)
+	}
+	return g
+}
+
+type loginGuard struct {
+	repo    repository.LoginAttemptRepository
+	cfg     LoginGuardConfig
+	captcha CaptchaVerifier
+	users   repository.UserRepository
+	emails  EmailService
+
+	pruneMu  sync.Mutex
+	prunedAt time.Time
+}
+
+// loginKey: "Alice" и "alice" — один счётчик, иначе перебор обходит лимит сменой регистра
+func loginKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// backoff is the wait after n failures when the first free ones are free: base, 2*base, 4*base… up to max
+func backoff(n, free int, base, max time.Duration) time.Duration {
+	if n < free {
+		return 0
+	}
+	d := base
+	for i := free; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func (g *loginGuard) Check(ctx context.Context, username, ip, captcha string) error {
+	key := loginKey(username)
+	now := time.Now()
+	until, err := g.repo.LockedUntil(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return &LoginThrottledError{RetryAfter: until.Sub(now), Locked: true}
+	}
+
+	since := now.Add(-g.cfg.Window)
+	ipFailures, ipLast, err := g.repo.IPFailures(ctx, ip, since)
+	if err != nil {
+		return err
+	}
+	solved := false
+	if g.captcha != nil && ipFailures >= g.cfg.CaptchaAfter {
+		if captcha == "" {
+			return ErrCaptchaRequired
+		}
+		ok, err := g.captcha.Verify(ctx, captcha, ip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrCaptchaRequired
+		}
+		solved = true
+	}
+
+	accFailures, accLast, err := g.repo.AccountFailures(ctx, key, since)
+	if err != nil {
+		return err
+	}
+	if wait := accLast.Add(backoff(accFailures, g.cfg.FreeAttempts, g.cfg.BaseDelay, g.cfg.MaxDelay)).Sub(now); wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	// решённая капча доказывает, что это человек, — задержку по IP (общий NAT, офис) снимаем
+	if !solved {
+		if wait := ipLast.Add(backoff(ipFailures, g.cfg.IPFreeAttempts, g.cfg.BaseDelay, g.cfg.IPMaxDelay)).Sub(now); wait > 0 {
+			return &LoginThrottledError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+func (g *loginGuard) CaptchaRequired(ctx context.Context, ip string) bool {
+	if g.captcha == nil {
+		return false
+	}
+	n, _, err := g.repo.IPFailures(ctx, ip, time.Now().Add(-g.cfg.Window))
+	return err == nil && n >= g.cfg.CaptchaAfter
+}
+
+func (g *loginGuard) Failed(ctx context.Context, username, ip, userAgent string) {
+	key := loginKey(username)
+	a := &entity.LoginAttempt{Username: key, IP: ip, UserAgent: userAgent, Reason: "bad_password"}
+	var owner *entity.User
+	if g.users != nil {
+		if u, err := g.users.GetUserByName(ctx, strings.TrimSpace(username)); err == nil {
+			owner, a.UserID = u, &u.ID
+		}
+	}
+	if err := g.repo.Record(ctx, a); err != nil {
+		fmt.Println("login attempt:", err)
+		return
+	}
+	g.prune(ctx)
+
+	n, _, err := g.repo.AccountFailures(ctx, key, time.Now().Add(-g.cfg.Window))
+	if err != nil || n < g.cfg.LockoutAfter {
+		return
+	}
+	until := time.Now().Add(g.cfg.LockoutFor)
+	locked, err := g.repo.Lock(ctx, key, until, n)
+	if err != nil {
+		fmt.Println("login lockout:", err)
+		return
+	}
+	fmt.Println("login lockout:", key, "after", n, "failures, last from", ip)
+	if locked && owner != nil && owner.Email != "" && g.emails != nil {
+		if err := g.emails.Send(ctx, EmailRequest{
+			UserID: &owner.ID, To: owner.Email, Lang: owner.Locale, Template: "login_locked",
+			Data: map[string]any{
+				"Username": owner.Username, "IP": ip, "Failures": n,
+				"Until": until.Format("02.01.2006 15:04 MST"), "Link": "/forgot-password",
+			},
+			Transactional: true,
+		}); err != nil {
+			fmt.Println("lockout email:", err)
+		}
+	}
+}
+
+func (g *loginGuard) Succeeded(ctx context.Context, u *entity.User, ip, userAgent string) {
+	if err := g.repo.Record(ctx, &entity.LoginAttempt{
+		Username: loginKey(u.Username), UserID: &u.ID, IP: ip, UserAgent: userAgent, Success: true,
+	}); err != nil {
+		fmt.Println("login attempt:", err)
+	}
+}
+
+// prune drops old attempts at most once an hour
+func (g *loginGuard) prune(ctx context.Context) {
+	g.pruneMu.Lock()
+	due := time.Since(g.prunedAt) > time.Hour
+	if due {
+		g.prunedAt = time.Now()
+	}
+	g.pruneMu.Unlock()
+	if due {
+		if err := g.repo.Prune(ctx, time.Now().Add(-g.cfg.Retention)); err != nil {
+			fmt.Println("login attempts prune:", err)
+		}
+	}
+}
+
+func (g *loginGuard) RecentFailures(ctx context.Context, actor *entity.User, limit int) ([]entity.LoginAttempt, error) {
+	if actor == nil || !actor.HasRole(entity.RoleAdmin) {
+		return nil, ErrForbidden
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return g.repo.RecentFailures(ctx, limit)
+}
+
+func (g *loginGuard) Lockouts(ctx context.Context, actor *entity.User) ([]entity.LoginLockout, error) {
+	if actor == nil || !actor.HasRole(entity.RoleAdmin) {
+		return nil, ErrForbidden
+	}
+	return g.repo.ActiveLockouts(ctx)
+}
+
+func (g *loginGuard) Unlock(ctx context.Context, actor *entity.User, username string) error {
+	if actor == nil || !actor.HasRole(entity.RoleAdmin) {
+		return ErrForbidden
+	}
+	return g.repo.Unlock(ctx, loginKey(username))
+}
+
+// NewSiteverifyCaptcha checks answers with a "siteverify" endpoint as used by reCAPTCHA, hCaptcha
+// and Turnstile: POST secret, response, remoteip → {"success": true}
+func NewSiteverifyCaptcha(verifyURL, secret string) CaptchaVerifier {
+	return &siteverifyCaptcha{url: verifyURL, secret: secret, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+type siteverifyCaptcha struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (c *siteverifyCaptcha) Verify(ctx context.Context, response, ip string) (bool, error) {
+	form := url.Values{"secret": {c.secret}, "response": {response}, "remoteip": {ip}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("captcha: %w", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, fmt.Errorf("captcha: %w", err)
+	}
+	return out.Success, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type memLock struct {
+	at, until time.Time
+}
+
+// memAttempts считает попытки так же, как login_attempt_repo: неудачи после последнего успеха и последней блокировки
+type memAttempts struct {
+	repository.LoginAttemptRepository
+	attempts []entity.LoginAttempt
+	locks    map[string]memLock
+}
+
+func newMemAttempts() *memAttempts { return &memAttempts{locks: map[string]memLock{}} }
+
+func (m *memAttempts) Record(ctx context.Context, a *entity.LoginAttempt) error {
+	a.CreatedAt = time.Now()
+	m.attempts = append(m.attempts, *a)
+	return nil
+}
+
+func (m *memAttempts) AccountFailures(ctx context.Context, username string, since time.Time) (int, time.Time, error) {
+	if l, ok := m.locks[username]; ok && l.at.After(since) {
+		since = l.at
+	}
+	for _, a := range m.attempts {
+		if a.Username == username && a.Success && a.CreatedAt.After(since) {
+			since = a.CreatedAt
+		}
+	}
+	n, last := 0, time.Time{}
+	for _, a := range m.attempts {
+		if a.Username == username && !a.Success && a.CreatedAt.After(since) {
+			n, last = n+1, a.CreatedAt
+		}
+	}
+	return n, last, nil
+}
+
+func (m *memAttempts) IPFailures(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	n, last := 0, time.Time{}
+	for _, a := range m.attempts {
+		if a.IP == ip && !a.Success && a.CreatedAt.After(since) {
+			n, last = n+1, a.CreatedAt
+		}
+	}
+	return n, last, nil
+}
+
+func (m *memAttempts) Lock(ctx context.Context, username string, until time.Time, failures int) (bool, error) {
+	if l, ok := m.locks[username]; ok && l.until.After(time.Now()) {
+		return false, nil
+	}
+	m.locks[username] = memLock{at: time.Now(), until: until}
+	return true, nil
+}
+
+func (m *memAttempts) LockedUntil(ctx context.Context, username string) (time.Time, error) {
+	if l, ok := m.locks[username]; ok && l.until.After(time.Now()) {
+		return l.until, nil
+	}
+	return time.Time{}, nil
+}
+
+func (m *memAttempts) Unlock(ctx context.Context, username string) error {
+	if l, ok := m.locks[username]; ok {
+		l.until = time.Now()
+		m.locks[username] = l
+	}
+	return nil
+}
+
+func (m *memAttempts) Prune(ctx context.Context, before time.Time) error { return nil }
+
+// age сдвигает все попытки в прошлое, как будто прошло d
+func (m *memAttempts) age(d time.Duration) {
+	for i := range m.attempts {
+		m.attempts[i].CreatedAt = m.attempts[i].CreatedAt.Add(-d)
+	}
+}
+
+type captchaStub struct{ answer string }
+
+func (c captchaStub) Verify(ctx context.Context, response, ip string) (bool, error) {
+	return response == c.answer, nil
+}
+
+func testGuardConfig() LoginGuardConfig {
+	cfg := DefaultLoginGuardConfig()
+	cfg.FreeAttempts, cfg.BaseDelay, cfg.MaxDelay = 2, time.Minute, 10*time.Minute
+	cfg.LockoutAfter, cfg.LockoutFor = 5, time.Hour
+	cfg.IPFreeAttempts, cfg.CaptchaAfter = 100, 100
+	return cfg
+}
+
+func TestBackoffDoublesFromTheFirstPaidAttempt(t *testing.T) {
+	for n, want := range map[int]time.Duration{0: 0, 2: 0, 3: time.Second, 4: 2 * time.Second, 6: 8 * time.Second, 20: 10 * time.Second} {
+		if got := backoff(n, 3, time.Second, 10*time.Second); got != want {
+			t.Errorf("backoff after %d failures: %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestLoginGuardBacksOffPerAccount(t *testing.T) {
+	repo := newMemAttempts()
+	g := NewLoginGuard(repo, testGuardConfig())
+	ctx := context.Background()
+
+	g.Failed(ctx, "Alice", "10.0.0.1", "ua")
+	if err := g.Check(ctx, "alice", "10.0.0.2", ""); err != nil {
+		t.Fatalf("after one free failure: %v", err)
+	}
+	g.Failed(ctx, " alice ", "10.0.0.2", "ua")
+	err := g.Check(ctx, "ALICE", "10.0.0.3", "")
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrRateLimited) || throttled.Locked {
+		t.Fatalf("after the free failures, in any letter case: %v, want a backoff", err)
+	}
+	if throttled.RetryAfter <= 0 || throttled.RetryAfter > time.Minute {
+		t.Fatalf("RetryAfter %v, want up to the base delay", throttled.RetryAfter)
+	}
+	if err := g.Check(ctx, "bob", "10.0.0.1", ""); err != nil {
+		t.Fatalf("another account: %v", err)
+	}
+
+	repo.age(time.Minute + time.Second)
+	if err := g.Check(ctx, "alice", "10.0.0.1", ""); err != nil {
+		t.Fatalf("after waiting out the backoff: %v", err)
+	}
+	g.Failed(ctx, "alice", "10.0.0.1", "ua")
+	if err := g.Check(ctx, "alice", "10.0.0.1", ""); !errors.As(err, &throttled) || throttled.RetryAfter <= time.Minute {
+		t.Fatalf("next failure: %v, want the delay doubled", err)
+	}
+
+	// успешный вход обнуляет счётчик аккаунта
+	repo.age(3 * time.Minute)
+	g.Succeeded(ctx, &entity.User{ID: 1, Username: "Alice"}, "10.0.0.1", "ua")
+	g.Failed(ctx, "alice", "10.0.0.1", "ua")
+	if err := g.Check(ctx, "alice", "10.0.0.1", ""); err != nil {
+		t.Fatalf("first failure after a success: %v", err)
+	}
+}
+
+func TestLoginGuardLocksAndMailsOnce(t *testing.T) {
+	repo := newMemAttempts()
+	mail := &mailStub{}
+	users := &memUsers{byID: map[int64]*entity.User{1: {ID: 1, Username: "alice", Email: "alice@example.com"}}}
+	g := NewLoginGuard(repo, testGuardConfig(), WithLockoutNotices(users, mail))
+	ctx := context.Background()
+
+	for i := 0; i < testGuardConfig().LockoutAfter; i++ {
+		g.Failed(ctx, "alice", "10.0.0.1", "ua")
+	}
+	err := g.Check(ctx, "alice", "10.0.0.9", "")
+	var throttled *LoginThrottledError
+	if !errors.Is(err, ErrAccountLocked) || !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("after %d failures: %v, want the account locked", testGuardConfig().LockoutAfter, err)
+	}
+	// попытка во время блокировки второго письма не шлёт
+	g.Failed(ctx, "alice", "10.0.0.1", "ua")
+	if len(mail.sent) != 1 || mail.sent[0].Template != "login_locked" || mail.sent[0].To != "alice@example.com" {
+		t.Fatalf("lockout emails: %+v, want one login_locked notice to the owner", mail.sent)
+	}
+
+	if err := g.Unlock(ctx, &entity.User{ID: 2, Role: entity.RoleModerator}, "alice"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("moderator unlocking: %v, want ErrForbidden", err)
+	}
+	if err := g.Unlock(ctx, &entity.User{ID: 3, Role: entity.RoleAdmin}, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(ctx, "alice", "10.0.0.1", ""); err != nil {
+		t.Fatalf("after an admin unlock: %v, want the failures before the lockout forgotten", err)
+	}
+}
+
+func TestLoginGuardAsksForCaptchaPerIP(t *testing.T) {
+	repo := newMemAttempts()
+	cfg := testGuardConfig()
+	cfg.CaptchaAfter, cfg.IPFreeAttempts = 3, 3
+	g := NewLoginGuard(repo, cfg, WithCaptcha(captchaStub{answer: "ok"}))
+	ctx := context.Background()
+
+	// подбор по разным аккаунтам с одного адреса
+	for _, name := range []string{"a", "b", "c"} {
+		g.Failed(ctx, name, "10.0.0.1", "ua")
+	}
+	if !g.CaptchaRequired(ctx, "10.0.0.1") || g.CaptchaRequired(ctx, "10.0.0.2") {
+		t.Fatal("CAPTCHA should be asked from the noisy IP only")
+	}
+	for _, answer := range []string{"", "wrong"} {
+		if err := g.Check(ctx, "d", "10.0.0.1", answer); !errors.Is(err, ErrCaptchaRequired) {
+			t.Fatalf("answer %q: %v, want ErrCaptchaRequired", answer, err)
+		}
+	}
+	// решённая капча снимает задержку по IP
+	if err := g.Check(ctx, "d", "10.0.0.1", "ok"); err != nil {
+		t.Fatalf("solved CAPTCHA: %v", err)
+	}
+	if err := g.Check(ctx, "d", "10.0.0.2", ""); err != nil {
+		t.Fatalf("another IP: %v", err)
+	}
+}
+
+func TestSiteverifyCaptcha(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("secret") != "s3cret" || r.Form.Get("remoteip") != "10.0.0.1" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.Form.Get("response") == "good" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer srv.Close()
+
+	v := NewSiteverifyCaptcha(srv.URL, "s3cret")
+	if ok, err := v.Verify(context.Background(), "good", "10.0.0.1"); err != nil || !ok {
+		t.Fatalf("good answer: %v %v", ok, err)
+	}
+	if ok, err := v.Verify(context.Background(), "bad", "10.0.0.1"); err != nil || ok {
+		t.Fatalf("bad answer: %v %v", ok, err)
+	}
+	if _, err := v.Verify(context.Background(), "good", "10.0.0.2"); err == nil {
+		t.Fatal("a non-JSON error page was taken for an answer")
+	}
+}
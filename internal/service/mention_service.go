@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/utils"
+)
+
+// maxMentionsPerText caps how many users a single post or comment can ping
+const maxMentionsPerText = 20
+
+// MentionListener is told about users that were newly mentioned (e.g. to notify them)
+type MentionListener interface {
+	Mentioned(ctx context.Context, m entity.Mention)
+}
+
+type MentionService interface {
+	// Sync parses text and stores its mentions, notifying only users that were not mentioned before,
+	// so editing a post does not ping everybody again
+	Sync(ctx context.Context, postID int64, commentID *int64, authorID int64, text string) (added, removed []entity.Mention, err error)
+	// UsernamesForPost maps username -> user id for everyone mentioned in a post or its comments
+	UsernamesForPost(ctx context.Context, postID int64) (map[string]int64, error)
+	AddListener(l MentionListener)
+}
+
+func NewMentionService(repo repository.MentionRepository, users repository.UserRepository) MentionService {
+	return &mentionService{repo: repo, users: users}
+}
+
+type mentionService struct {
+	repo      repository.MentionRepository
+	users     repository.UserRepository
+	listeners []MentionListener
+}
+
+func (s *mentionService) AddListener(l MentionListener) {
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *mentionService) Sync(ctx context.Context, postID int64, commentID *int64, authorID int64, text string) (added, removed []entity.Mention, err error) {
+	if postID == 0 || authorID == 0 {
+		return nil, nil, ErrInvalidInput
+	}
+	names := utils.FindMentions(text)
+	if len(names) > maxMentionsPerText {
+		names = names[:maxMentionsPerText]
+	}
+	users, err := s.users.GetUsersByNames(ctx, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := map[int64]string{}
+	var ids []int64
+	for _, u := range users {
+		if u.ID == authorID {
+			continue // упоминание самого себя не считается
+		}
+		byID[u.ID] = u.Username
+		ids = append(ids, u.ID)
+	}
+
+	addedIDs, removedIDs, err := s.repo.Replace(ctx, postID, commentID, authorID, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	mk := func(id int64) entity.Mention {
+		return entity.Mention{PostID: postID, CommentID: commentID, UserID: id, Username: byID[id], AuthorID: authorID}
+	}
+	for _, id := range addedIDs {
+		m := mk(id)
+		added = append(added, m)
+		for _, l := range s.listeners {
+			l.Mentioned(ctx, m)
+		}
+	}
+	for _, id := range removedIDs {
+		removed = append(removed, mk(id))
+	}
+	return added, removed, nil
+}
+
+func (s *mentionService) UsernamesForPost(ctx context.Context, postID int64) (map[string]int64, error) {
+	list, err := s.repo.ListByPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]int64, len(list))
+	for _, m := range list {
+		res[m.Username] = m.UserID
+	}
+	return res, nil
+}
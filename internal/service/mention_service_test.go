@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"testing"
+)
+
+// mentionRepoStub хранит упоминания одного текста, как Replace в базе
+type mentionRepoStub struct {
+	repository.MentionRepository
+	current map[int64]bool
+}
+
+func (r *mentionRepoStub) Replace(ctx context.Context, postID int64, commentID *int64, authorID int64, userIDs []int64) (added, removed []int64, err error) {
+	next := map[int64]bool{}
+	for _, id := range userIDs {
+		next[id] = true
+		if !r.current[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range r.current {
+		if !next[id] {
+			removed = append(removed, id)
+		}
+	}
+	r.current = next
+	return added, removed, nil
+}
+
+// namedUsersStub: пользователь userN существует для любого N
+type namedUsersStub struct {
+	repository.UserRepository
+	asked int
+}
+
+func (u *namedUsersStub) GetUsersByNames(ctx context.Context, names []string) ([]entity.User, error) {
+	u.asked = len(names)
+	var res []entity.User
+	for _, n := range names {
+		var id int64
+		if _, err := fmt.Sscanf(n, "user%d", &id); err == nil {
+			res = append(res, entity.User{ID: id, Username: n})
+		}
+	}
+	return res, nil
+}
+
+type mentionEvents struct{ got []entity.Mention }
+
+func (e *mentionEvents) Mentioned(ctx context.Context, m entity.Mention) { e.got = append(e.got, m) }
+
+func TestSyncNotifiesOnlyNewMentions(t *testing.T) {
+	repo, users, events := &mentionRepoStub{}, &namedUsersStub{}, &mentionEvents{}
+	s := NewMentionService(repo, users)
+	s.AddListener(events)
+	ctx := context.Background()
+	const author = 1
+
+	added, _, err := s.Sync(ctx, 10, nil, author, "@user2 @user3 @nobody и сам @user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 2 || len(events.got) != 2 {
+		t.Fatalf("first version: %d added, %d events; want user2 and user3", len(added), len(events.got))
+	}
+	if m := events.got[0]; m.PostID != 10 || m.AuthorID != author || m.Username != "user2" {
+		t.Fatalf("mention %+v", m)
+	}
+
+	// правка поста: user2 остался, user3 убран, user4 добавлен — уведомление только для user4
+	events.got = nil
+	added, removed, err := s.Sync(ctx, 10, nil, author, "@user2 и теперь @user4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].UserID != 4 || len(events.got) != 1 || events.got[0].UserID != 4 {
+		t.Fatalf("edit: added %+v, events %+v; want only user4", added, events.got)
+	}
+	if len(removed) != 1 || removed[0].UserID != 3 {
+		t.Fatalf("edit: removed %+v, want user3", removed)
+	}
+}
+
+func TestSyncCapsMentionsPerText(t *testing.T) {
+	users := &namedUsersStub{}
+	s := NewMentionService(&mentionRepoStub{}, users)
+	var text strings.Builder
+	for i := 2; i < 2+maxMentionsPerText+10; i++ {
+		fmt.Fprintf(&text, "@user%d ", i)
+	}
+	added, _, err := s.Sync(context.Background(), 10, nil, 1, text.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users.asked != maxMentionsPerText || len(added) != maxMentionsPerText {
+		t.Fatalf("looked up %d names, added %d; want both capped at %d", users.asked, len(added), maxMentionsPerText)
+	}
+}
+
+func TestCreateCommentQuotesOnlyTheSamePost(t *testing.T) {
+	repo := &threadRepoStub{postStatus: entity.StatusPublished, comments: []entity.Comment{
+		{ID: 1, PostID: 1, AuthorID: 3, Content: "в этом посте", Status: entity.StatusPublished},
+		{ID: 2, PostID: 2, AuthorID: 3, Content: "в другом посте", Status: entity.StatusPublished},
+	}}
+	mentions := &mentionRepoStub{}
+	s := NewCommentService(repo, WithCommentMentions(NewMentionService(mentions, &namedUsersStub{})))
+	ctx := context.Background()
+
+	other := int64(2)
+	if _, err := s.CreateComment(ctx, &entity.Comment{PostID: 1, AuthorID: 4, Content: "цитата", QuoteID: &other}); err == nil {
+		t.Fatal("quoted a comment from another post")
+	}
+	same := int64(1)
+	if _, err := s.CreateComment(ctx, &entity.Comment{PostID: 1, AuthorID: 4, Content: "@user3 согласен", QuoteID: &same}); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.comments) != 3 || !mentions.current[3] {
+		t.Fatalf("quote reply: %d comments, mentions %v; want it stored with @user3", len(repo.comments), mentions.current)
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrForbidden   = errors.New("forbidden")
+	ErrBlocked     = errors.New("user has blocked messages from you")
+	ErrRateLimited = errors.New("too many requests, try again later")
+	ErrBadImage    = errors.New("attachment must be a PNG, JPEG, GIF or WebP image")
+)
+
+// messageImageTypes — что можно приложить к сообщению; тип определяется по байтам, а не по имени файла
+var messageImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+const (
+	maxConversationMembers = 10 // включая создателя
+	maxMessageLength       = 4000
+	maxMessageImageBytes   = 5 << 20
+	maxConversationTitle   = 100
+)
+
+type MessageService interface {
+	// StartConversation opens a 1:1 chat (one member, reused if it already exists) or a group chat
+	StartConversation(ctx context.Context, creatorID int64, memberIDs []int64, title string) (int64, error)
+	Send(ctx context.Context, m *entity.Message) (int64, error)
+	Conversations(ctx context.Context, userID int64, limit, offset int) ([]entity.Conversation, error)
+	Conversation(ctx context.Context, userID, conversationID int64) (*entity.Conversation, error)
+	Messages(ctx context.Context, userID, conversationID, beforeID int64, limit int) ([]entity.Message, error)
+	MarkRead(ctx context.Context, userID, conversationID, messageID int64) error // messageID 0 marks everything
+	UnreadTotal(ctx context.Context, userID int64) (int, error)
+	// Image returns a message attachment and its stored MIME type if userID may see it
+	Image(ctx context.Context, userID, messageID int64) ([]byte, string, error)
+
+	Block(ctx context.Context, userID, blockedID int64) error
+	Unblock(ctx context.Context, userID, blockedID int64) error
+	Blocked(ctx context.Context, userID int64) ([]entity.User, error)
+
+	AddListener(l MessageListener)
+}
+
+func NewMessageService(repo repository.MessageRepository, blocks repository.BlockRepository) MessageService {
+	return &messageService{repo: repo, blocks: blocks}
+}
+
+type messageService struct {
+	repo      repository.MessageRepository
+	blocks    repository.BlockRepository
+	listeners []MessageListener
+}
+
+func (s *messageService) AddListener(l MessageListener) {
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *messageService) StartConversation(ctx context.Context, creatorID int64, memberIDs []int64, title string) (int64, error) {
+	if creatorID == 0 {
+		return 0, ErrInvalidInput
+	}
+	seen := map[int64]bool{creatorID: true}
+	var others []int64
+	for _, id := range memberIDs {
+		if id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		others = append(others, id)
+	}
+	if len(others) == 0 {
+		return 0, errors.New("at least one recipient required")
+	}
+	if len(others)+1 > maxConversationMembers {
+		return 0, errors.New("too many members")
+	}
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxConversationTitle {
+		return 0, errors.New("title too long")
+	}
+
+	blocked, err := s.blocks.BlockedAmong(ctx, creatorID, others)
+	if err != nil {
+		return 0, err
+	}
+	if len(blocked) > 0 {
+		return 0, ErrBlocked
+	}
+	createdBy := creatorID
+	c := &entity.Conversation{CreatedBy: &createdBy}
+	directKey := ""
+	if len(others) == 1 {
+		directKey = directConversationKey(creatorID, others[0])
+	} else {
+		c.Title = title
+	}
+	return s.repo.CreateConversation(ctx, c, directKey, append(others, creatorID))
+}
+
+// directConversationKey identifies the 1:1 chat between two users regardless of who started it
+func directConversationKey(a, b int64) string {
+	ids := []int64{a, b}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return strconv.FormatInt(ids[0], 10) + ":" + strconv.FormatInt(ids[1], 10)
+}
+
+func (s *messageService) Send(ctx context.Context, m *entity.Message) (int64, error) {
+	if m == nil || m.ConversationID == 0 || m.SenderID == 0 {
+		return 0, ErrInvalidInput
+	}
+	m.Content = strings.TrimSpace(m.Content)
+	if m.Content == "" && len(m.ImageData) == 0 {
+		return 0, errors.New("empty message")
+	}
+	if utf8.RuneCountInString(m.Content) > maxMessageLength {
+		return 0, errors.New("message too long")
+	}
+	if len(m.ImageData) > maxMessageImageBytes {
+		return 0, errors.New("image too large")
+	}
+	m.ImageType = ""
+	if len(m.ImageData) > 0 {
+		ct, err := MessageImageType(m.ImageData)
+		if err != nil {
+			return 0, err
+		}
+		m.ImageType = ct
+	}
+
+	conv, err := s.Conversation(ctx, m.SenderID, m.ConversationID)
+	if err != nil {
+		return 0, err
+	}
+	var recipients []int64
+	for _, u := range conv.Members {
+		if u.ID != m.SenderID {
+			recipients = append(recipients, u.ID)
+		}
+	}
+	// в личном диалоге блокировка с любой стороны запрещает писать
+	if !conv.IsGroup && len(recipients) > 0 {
+		blocked, err := s.blocks.BlockedAmong(ctx, m.SenderID, recipients)
+		if err != nil {
+			return 0, err
+		}
+		if len(blocked) > 0 {
+			return 0, ErrBlocked
+		}
+	}
+
+	id, err := s.repo.CreateMessage(ctx, m)
+	if err != nil {
+		return 0, err
+	}
+	m.HasImage = len(m.ImageData) > 0
+	for _, l := range s.listeners {
+		l.MessageSent(ctx, m, recipients)
+	}
+	return id, nil
+}
+
+// MessageImageType sniffs an attachment and returns its MIME type, or ErrBadImage for anything but the allowed images
+func MessageImageType(data []byte) (string, error) {
+	ct := http.DetectContentType(data)
+	if !messageImageTypes[ct] {
+		return "", ErrBadImage
+	}
+	return ct, nil
+}
+
+func (s *messageService) Conversations(ctx context.Context, userID int64, limit, offset int) ([]entity.Conversation, error) {
+	if userID == 0 {
+		return nil, ErrInvalidInput
+	}
+	return s.repo.ListConversations(ctx, userID, limit, offset)
+}
+
+func (s *messageService) Conversation(ctx context.Context, userID, conversationID int64) (*entity.Conversation, error) {
+	c, err := s.repo.GetConversation(ctx, conversationID)
+	if err == sql.ErrNoRows {
+		return nil, ErrForbidden // чужой и несуществующий диалог неотличимы
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range c.Members {
+		if u.ID == userID {
+			return c, nil
+		}
+	}
+	return nil, ErrForbidden
+}
+
+func (s *messageService) Messages(ctx context.Context, userID, conversationID, beforeID int64, limit int) ([]entity.Message, error) {
+	if err := s.checkMember(ctx, userID, conversationID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListMessages(ctx, conversationID, beforeID, limit)
+}
+
+func (s *messageService) MarkRead(ctx context.Context, userID, conversationID, messageID int64) error {
+	if messageID <= 0 {
+		messageID = math.MaxInt64 // всё, что есть
+	}
+	if err := s.checkMember(ctx, userID, conversationID); err != nil {
+		return err
+	}
+	return s.repo.MarkRead(ctx, conversationID, userID, messageID)
+}
+
+func (s *messageService) UnreadTotal(ctx context.Context, userID int64) (int, error) {
+	return s.repo.UnreadTotal(ctx, userID)
+}
+
+func (s *messageService) Image(ctx context.Context, userID, messageID int64) ([]byte, string, error) {
+	m, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.checkMember(ctx, userID, m.ConversationID); err != nil {
+		return nil, "", err
+	}
+	// вложения до проверки типов или с неизвестным типом не отдаём
+	if !messageImageTypes[m.ImageType] {
+		return nil, "", ErrBadImage
+	}
+	return m.ImageData, m.ImageType, nil
+}
+
+func (s *messageService) checkMember(ctx context.Context, userID, conversationID int64) error {
+	ok, err := s.repo.IsMember(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s *messageService) Block(ctx context.Context, userID, blockedID int64) error {
+	if userID == 0 || blockedID == 0 || userID == blockedID {
+		return ErrInvalidInput
+	}
+	return s.blocks.Block(ctx, userID, blockedID)
+}
+
+func (s *messageService) Unblock(ctx context.Context, userID, blockedID int64) error {
+	return s.blocks.Unblock(ctx, userID, blockedID)
+}
+
+func (s *messageService) Blocked(ctx context.Context, userID int64) ([]entity.User, error) {
+	return s.blocks.ListBlocked(ctx, userID)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"testing"
+)
+
+// messageRepoStub — один диалог 1:1 между пользователями 1 и 2; сохранённые сообщения лежат в памяти
+type messageRepoStub struct {
+	repository.MessageRepository
+	saved []entity.Message
+}
+
+func (r *messageRepoStub) GetConversation(ctx context.Context, id int64) (*entity.Conversation, error) {
+	return &entity.Conversation{ID: id, Members: []entity.User{{ID: 1}, {ID: 2}}}, nil
+}
+
+func (r *messageRepoStub) IsMember(ctx context.Context, conversationID, userID int64) (bool, error) {
+	return userID == 1 || userID == 2, nil
+}
+
+func (r *messageRepoStub) CreateMessage(ctx context.Context, m *entity.Message) (int64, error) {
+	m.ID = int64(len(r.saved) + 1)
+	r.saved = append(r.saved, *m)
+	return m.ID, nil
+}
+
+func (r *messageRepoStub) GetMessage(ctx context.Context, id int64) (*entity.Message, error) {
+	m := r.saved[id-1]
+	return &m, nil
+}
+
+type blockRepoStub struct{ repository.BlockRepository }
+
+func (blockRepoStub) BlockedAmong(ctx context.Context, userID int64, userIDs []int64) ([]int64, error) {
+	return nil, nil
+}
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+func TestSendRejectsNonImageAttachments(t *testing.T) {
+	repo := &messageRepoStub{}
+	s := NewMessageService(repo, blockRepoStub{})
+	for _, data := range [][]byte{
+		[]byte("<html><script>alert(document.cookie)</script></html>"),
+		[]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"><script>alert(1)</script></svg>"),
+		[]byte("%PDF-1.4\n"),
+	} {
+		_, err := s.Send(context.Background(), &entity.Message{ConversationID: 1, SenderID: 1, ImageData: data})
+		if !errors.Is(err, ErrBadImage) {
+			t.Errorf("Send(%.20q) = %v, want ErrBadImage", data, err)
+		}
+	}
+	if len(repo.saved) != 0 {
+		t.Fatalf("%d messages stored, want none", len(repo.saved))
+	}
+}
+
+func TestSendStoresSniffedImageType(t *testing.T) {
+	repo := &messageRepoStub{}
+	s := NewMessageService(repo, blockRepoStub{})
+	id, err := s.Send(context.Background(), &entity.Message{
+		ConversationID: 1, SenderID: 1, ImageData: pngHeader, ImageType: "text/html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ct, err := s.Image(context.Background(), 2, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != "image/png" || len(data) != len(pngHeader) {
+		t.Fatalf("Image = %d bytes of %q, want the PNG as image/png", len(data), ct)
+	}
+	if _, _, err := s.Image(context.Background(), 3, id); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Image for a non-member = %v, want ErrForbidden", err)
+	}
+}
+
+func TestImageRefusesAttachmentsWithoutStoredType(t *testing.T) {
+	repo := &messageRepoStub{saved: []entity.Message{{ID: 1, ConversationID: 1, SenderID: 1, ImageData: []byte("<html></html>")}}}
+	s := NewMessageService(repo, blockRepoStub{})
+	if _, _, err := s.Image(context.Background(), 2, 1); !errors.Is(err, ErrBadImage) {
+		t.Fatalf("Image of an untyped attachment = %v, want ErrBadImage", err)
+	}
+}
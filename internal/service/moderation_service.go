@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/spam"
+	"strings"
+)
+
+var ErrAlreadyDecided = errors.New("already decided by another moderator")
+
+// ModerationService is the moderators' side of the spam filter: the queue of held posts and
+// comments, and the banned word lists. All methods require the moderator role.
+type ModerationService interface {
+	Queue(ctx context.Context, actor *entity.User, limit int) ([]entity.ModerationItem, error)
+	// Decide publishes a held item (entity.ModerationApproved) or rejects it (entity.ModerationSpam);
+	// either way the classifier learns from the decision
+	Decide(ctx context.Context, actor *entity.User, itemID int64, decision string) error
+
+	Rules(ctx context.Context, actor *entity.User) ([]entity.SpamRule, error)
+	AddRule(ctx context.Context, actor *entity.User, r *entity.SpamRule) error
+	DeleteRule(ctx context.Context, actor *entity.User, id int64) error
+}
+
+type ModerationOption func(*moderationService)
+
+// WithModerationListener tells l about every decision on the queue
+func WithModerationListener(l ModerationListener) ModerationOption {
+	return func(s *moderationService) { s.listeners = append(s.listeners, l) }
+}
+
+func NewModerationService(queue repository.ModerationRepository, rules repository.SpamRepository, filter SpamFilter,
+	posts PostService, comments CommentService, opts ...ModerationOption) ModerationService {
+	s := &moderationService{queue: queue, rules: rules, filter: filter, posts: posts, comments: comments}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+type moderationService struct {
+	queue     repository.ModerationRepository
+	rules     repository.SpamRepository
+	filter    SpamFilter
+	posts     PostService
+	comments  CommentService
+	listeners []ModerationListener
+}
+
+func isModerator(u *entity.User) bool {
+	return u != nil && u.HasRole(entity.RoleModerator)
+}
+
+func (s *moderationService) Queue(ctx context.Context, actor *entity.User, limit int) ([]entity.ModerationItem, error) {
+	if !isModerator(actor) {
+		return nil, ErrForbidden
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	return s.queue.Pending(ctx, limit)
+}
+
+func (s *moderationService) Decide(ctx context.Context, actor *entity.User, itemID int64, decision string) error {
+	if !isModerator(actor) {
+		return ErrForbidden
+	}
+	status := entity.StatusPublished
+	switch decision {
+	case entity.ModerationApproved:
+	case entity.ModerationSpam:
+		status = entity.StatusRejected
+	default:
+		return ErrInvalidInput
+	}
+	item, err := s.queue.GetItem(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if item.Status != entity.ModerationPending {
+		return ErrAlreadyDecided
+	}
+	// сначала закрываем пункт: два модератора не опубликуют и не отклонят одно и то же дважды
+	if err := s.queue.Decide(ctx, itemID, decision, actor.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrAlreadyDecided
+		}
+		return err
+	}
+	if item.CommentID != nil {
+		err = s.comments.SetCommentStatus(ctx, *item.CommentID, status)
+	} else {
+		err = s.posts.SetPostStatus(ctx, item.PostID, status)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.filter.Train(ctx, item.Title+"\n"+item.Content, decision == entity.ModerationSpam); err != nil {
+		fmt.Println("spam training:", err)
+	}
+	for _, l := range s.listeners {
+		l.ItemDecided(ctx, item, decision, actor.ID)
+	}
+	return nil
+}
+
+func (s *moderationService) Rules(ctx context.Context, actor *entity.User) ([]entity.SpamRule, error) {
+	if !isModerator(actor) {
+		return nil, ErrForbidden
+	}
+	return s.rules.Rules(ctx, 0)
+}
+
+func (s *moderationService) AddRule(ctx context.Context, actor *entity.User, r *entity.SpamRule) error {
+	if !isModerator(actor) {
+		return ErrForbidden
+	}
+	r.Pattern = strings.TrimSpace(r.Pattern)
+	if _, err := spam.CompileRule(r.Pattern, r.Regex); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if r.BoardID != nil && *r.BoardID <= 0 {
+		r.BoardID = nil
+	}
+	r.CreatedBy = &actor.ID
+	return s.rules.AddRule(ctx, r)
+}
+
+func (s *moderationService) DeleteRule(ctx context.Context, actor *entity.User, id int64) error {
+	if !isModerator(actor) {
+		return ErrForbidden
+	}
+	return s.rules.DeleteRule(ctx, id)
+}
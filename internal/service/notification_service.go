@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strconv"
+	"strings"
+)
+
+// voteMilestones are like counts that are worth telling the author about
+var voteMilestones = []int{10, 50, 100, 500, 1000}
+
+type NotificationService interface {
+	Notify(ctx context.Context, n *entity.Notification) error
+	List(ctx context.Context, userID int64, limit, offset int) ([]entity.Notification, error)
+	UnreadCount(ctx context.Context, userID int64) (int, error)
+	MarkRead(ctx context.Context, userID, id int64) error
+	MarkAllRead(ctx context.Context, userID int64) error
+	Preferences(ctx context.Context, userID int64) (map[string]bool, error)
+	SetPreference(ctx context.Context, userID int64, typ string, enabled bool) error
+	// InviteToClub is for the club owner and moderators; ErrForbidden for everyone else
+	InviteToClub(ctx context.Context, clubID int64, from *entity.User, toID int64) error
+	AddListener(l NotificationListener)
+
+	// events that produce notifications
+	MentionListener
+	CommentListener
+	VoteListener
+	ModerationListener
+}
+
+// ThreadMutes tells whether a user muted a thread
+type ThreadMutes interface {
+	ThreadMuted(ctx context.Context, userID, postID int64) (bool, error)
+}
+
+// threadNotifications are silenced in muted threads; mentions and moderation still come through
+var threadNotifications = map[string]bool{
+	entity.NotificationReplyPost:     true,
+	entity.NotificationReplyComment:  true,
+	entity.NotificationThreadReply:   true,
+	entity.NotificationVoteMilestone: true,
+}
+
+type NotificationOption func(*notificationService)
+
+// WithNotificationMutes drops thread notifications of threads the recipient muted
+func WithNotificationMutes(m ThreadMutes) NotificationOption {
+	return func(s *notificationService) { s.mutes = m }
+}
+
+func NewNotificationService(repo repository.NotificationRepository, posts repository.PostRepository,
+	comments repository.CommentRepository, clubs repository.ClubRepository, opts ...NotificationOption) NotificationService {
+	s := &notificationService{repo: repo, posts: posts, comments: comments, clubs: clubs}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+type notificationService struct {
+	repo     repository.NotificationRepository
+	posts    repository.PostRepository
+	comments repository.CommentRepository
+	clubs    repository.ClubRepository
+	mutes    ThreadMutes
+
+	listeners []NotificationListener
+}
+
+func (s *notificationService) AddListener(l NotificationListener) {
+	s.listeners = append(s.listeners, l)
+}
+
+func knownNotificationType(typ string) bool {
+	for _, t := range entity.NotificationTypes {
+		if t.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *notificationService) Notify(ctx context.Context, n *entity.Notification) error {
+	if n.UserID == 0 || !knownNotificationType(n.Type) {
+		return ErrInvalidInput
+	}
+	// о своих действиях не уведомляем
+	if n.ActorID != nil && *n.ActorID == n.UserID {
+		return nil
+	}
+	prefs, err := s.repo.GetPreferences(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if enabled, ok := prefs[n.Type]; ok && !enabled {
+		return nil
+	}
+	if s.mutes != nil && n.PostID != nil && threadNotifications[n.Type] {
+		muted, err := s.mutes.ThreadMuted(ctx, n.UserID, *n.PostID)
+		if err != nil || muted {
+			return err
+		}
+	}
+	created, err := s.repo.Create(ctx, n)
+	if err != nil || !created {
+		return err
+	}
+	for _, l := range s.listeners {
+		l.Notified(ctx, n)
+	}
+	return nil
+}
+
+func (s *notificationService) List(ctx context.Context, userID int64, limit, offset int) ([]entity.Notification, error) {
+	if userID == 0 {
+		return nil, ErrInvalidInput
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.repo.List(ctx, userID, limit, offset)
+}
+
+func (s *notificationService) UnreadCount(ctx context.Context, userID int64) (int, error) {
+	return s.repo.CountUnread(ctx, userID)
+}
+
+func (s *notificationService) MarkRead(ctx context.Context, userID, id int64) error {
+	if userID == 0 || id == 0 {
+		return ErrInvalidInput
+	}
+	return s.repo.MarkRead(ctx, userID, id)
+}
+
+func (s *notificationService) MarkAllRead(ctx context.Context, userID int64) error {
+	if userID == 0 {
+		return ErrInvalidInput
+	}
+	return s.repo.MarkAllRead(ctx, userID)
+}
+
+// Preferences returns every known type with its enabled flag (default true)
+func (s *notificationService) Preferences(ctx context.Context, userID int64) (map[string]bool, error) {
+	stored, err := s.repo.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]bool, len(entity.NotificationTypes))
+	for _, t := range entity.NotificationTypes {
+		enabled, ok := stored[t.Type]
+		res[t.Type] = !ok || enabled
+	}
+	return res, nil
+}
+
+func (s *notificationService) SetPreference(ctx context.Context, userID int64, typ string, enabled bool) error {
+	if userID == 0 || !knownNotificationType(typ) {
+		return ErrInvalidInput
+	}
+	return s.repo.SetPreference(ctx, userID, typ, enabled)
+}
+
+func (s *notificationService) InviteToClub(ctx context.Context, clubID int64, from *entity.User, toID int64) error {
+	if from == nil {
+		return ErrForbidden
+	}
+	fromID := from.ID
+	if clubID == 0 || fromID == 0 || toID == 0 {
+		return ErrInvalidInput
+	}
+	if fromID == toID {
+		return fmt.Errorf("%w: cannot invite yourself", ErrInvalidInput)
+	}
+	club, err := s.clubs.GetByID(ctx, clubID)
+	if err != nil {
+		return err
+	}
+	// приглашает тот же, кто управляет клубом: владелец и модераторы
+	if !isModerator(from) && (club.OwnerID == nil || *club.OwnerID != fromID) {
+		return ErrForbidden
+	}
+	return s.Notify(ctx, &entity.Notification{
+		UserID:    toID,
+		Type:      entity.NotificationClubInvite,
+		ActorID:   &fromID,
+		ClubID:    &clubID,
+		Message:   "Приглашение в клуб «" + club.Name + "»",
+		DedupeKey: "club_invite:" + strconv.FormatInt(clubID, 10) + ":" + strconv.FormatInt(fromID, 10),
+	})
+}
+
+func (s *notificationService) Mentioned(ctx context.Context, m entity.Mention) {
+	n := &entity.Notification{
+		UserID:  m.UserID,
+		Type:    entity.NotificationMention,
+		ActorID: &m.AuthorID,
+		PostID:  &m.PostID,
+		Message: "Вас упомянули",
+	}
+	if m.CommentID != nil {
+		n.CommentID = m.CommentID
+		n.Message = "Вас упомянули в комментарии"
+	} else if p, err := s.posts.GetPostByID(ctx, m.PostID); err == nil {
+		n.Message = "Вас упомянули в посте «" + p.Title + "»"
+	}
+	s.log(s.Notify(ctx, n))
+}
+
+func (s *notificationService) CommentCreated(ctx context.Context, c *entity.Comment) {
+	post, err := s.posts.GetPostByID(ctx, c.PostID)
+	if err != nil {
+		s.log(err)
+		return
+	}
+	excerpt := excerpt(c.Content, 120)
+	notified := map[int64]bool{}
+	if c.ParentID != nil {
+		if parent, err := s.comments.GetCommentByID(ctx, *c.ParentID); err == nil {
+			s.log(s.Notify(ctx, &entity.Notification{
+				UserID: parent.AuthorID, Type: entity.NotificationReplyComment, ActorID: &c.AuthorID,
+				PostID: &c.PostID, CommentID: &c.ID, Message: excerpt,
+			}))
+			notified[parent.AuthorID] = true
+		}
+	}
+	if !notified[post.AuthorID] {
+		s.log(s.Notify(ctx, &entity.Notification{
+			UserID: post.AuthorID, Type: entity.NotificationReplyPost, ActorID: &c.AuthorID,
+			PostID: &c.PostID, CommentID: &c.ID, Message: "«" + post.Title + "»: " + excerpt,
+		}))
+	}
+}
+
+func (s *notificationService) CommentDeleted(ctx context.Context, c *entity.Comment, byUserID int64) {
+	if c.AuthorID == byUserID {
+		return
+	}
+	s.log(s.Notify(ctx, &entity.Notification{
+		UserID: c.AuthorID, Type: entity.NotificationModeration, ActorID: &byUserID, PostID: &c.PostID,
+		Message: "Ваш комментарий удалён: " + excerpt(c.Content, 80),
+	}))
+}
+
+func (s *notificationService) ItemDecided(ctx context.Context, item *entity.ModerationItem, decision string, byUserID int64) {
+	verdict := "отклонён модератором"
+	if decision == entity.ModerationApproved {
+		verdict = "прошёл проверку и опубликован"
+	}
+	msg := "Ваш пост «" + item.Title + "» " + verdict
+	if item.CommentID != nil {
+		msg = "Ваш комментарий " + verdict + ": " + excerpt(item.Content, 80)
+	}
+	s.log(s.Notify(ctx, &entity.Notification{
+		UserID: item.AuthorID, Type: entity.NotificationModeration, ActorID: &byUserID, PostID: &item.PostID,
+		CommentID: item.CommentID, Message: msg, DedupeKey: "moderation:item:" + strconv.FormatInt(item.ID, 10),
+	}))
+}
+
+// threadActionMessages finish "Ваш пост «…» " for each thread action
+var threadActionMessages = map[string]string{
+	ThreadPin:       "закреплён наверху доски",
+	ThreadUnpin:     "откреплён",
+	ThreadLock:      "закрыт для комментариев",
+	ThreadUnlock:    "снова открыт для комментариев",
+	ThreadArchive:   "перенесён в архив",
+	ThreadUnarchive: "возвращён из архива",
+}
+
+func (s *notificationService) ThreadChanged(ctx context.Context, p *entity.Post, action string, byUserID int64) {
+	what, ok := threadActionMessages[action]
+	if !ok {
+		return
+	}
+	n := &entity.Notification{
+		UserID: p.AuthorID, Type: entity.NotificationModeration, PostID: &p.ID,
+		Message: "Ваш пост «" + p.Title + "» " + what,
+	}
+	if byUserID != 0 {
+		n.ActorID = &byUserID
+	} else {
+		n.Message += " автомодератором"
+	}
+	s.log(s.Notify(ctx, n))
+}
+
+func (s *notificationService) PostVoted(ctx context.Context, postID, voterID int64, value int) {
+	if value != 1 {
+		return
+	}
+	likes, _, err := s.posts.GetPostVotes(ctx, postID)
+	if err != nil || !isMilestone(likes) {
+		return
+	}
+	post, err := s.posts.GetPostByID(ctx, postID)
+	if err != nil {
+		s.log(err)
+		return
+	}
+	s.log(s.Notify(ctx, &entity.Notification{
+		UserID: post.AuthorID, Type: entity.NotificationVoteMilestone, PostID: &postID,
+		Message:   fmt.Sprintf("Ваш пост «%s» продвинули %d раз", post.Title, likes),
+		DedupeKey: fmt.Sprintf("vote:post:%d:%d", postID, likes),
+	}))
+}
+
+func (s *notificationService) CommentVoted(ctx context.Context, commentID, voterID int64, value int) {
+	if value != 1 {
+		return
+	}
+	likes, _, err := s.comments.GetCommentVotes(ctx, commentID)
+	if err != nil || !isMilestone(likes) {
+		return
+	}
+	c, err := s.comments.GetCommentByID(ctx, commentID)
+	if err != nil {
+		s.log(err)
+		return
+	}
+	s.log(s.Notify(ctx, &entity.Notification{
+		UserID: c.AuthorID, Type: entity.NotificationVoteMilestone, PostID: &c.PostID, CommentID: &commentID,
+		Message:   fmt.Sprintf("Ваш комментарий продвинули %d раз", likes),
+		DedupeKey: fmt.Sprintf("vote:comment:%d:%d", commentID, likes),
+	}))
+}
+
+// log reports failures of event hooks: the triggering action already succeeded
+func (s *notificationService) log(err error) {
+	if err != nil {
+		fmt.Println("notifications:", err)
+	}
+}
+
+func isMilestone(likes int) bool {
+	for _, m := range voteMilestones {
+		if likes == m {
+			return true
+		}
+	}
+	return false
+}
+
+func excerpt(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
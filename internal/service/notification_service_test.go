@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notificationRepoStub хранит уведомления в памяти и, как база, не создаёт второе с тем же ключом
+type notificationRepoStub struct {
+	repository.NotificationRepository
+	prefs   map[int64]map[string]bool
+	created []entity.Notification
+}
+
+func (r *notificationRepoStub) GetPreferences(ctx context.Context, userID int64) (map[string]bool, error) {
+	return r.prefs[userID], nil
+}
+
+func (r *notificationRepoStub) Create(ctx context.Context, n *entity.Notification) (bool, error) {
+	for _, c := range r.created {
+		if n.DedupeKey != "" && c.UserID == n.UserID && c.DedupeKey == n.DedupeKey {
+			return false, nil
+		}
+	}
+	r.created = append(r.created, *n)
+	return true, nil
+}
+
+type moderationQueueStub struct {
+	repository.ModerationRepository
+	items map[int64]*entity.ModerationItem
+}
+
+func (r *moderationQueueStub) GetItem(ctx context.Context, id int64) (*entity.ModerationItem, error) {
+	item, ok := r.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	cp := *item
+	return &cp, nil
+}
+
+func (r *moderationQueueStub) Decide(ctx context.Context, id int64, status string, byUserID int64) error {
+	if r.items[id].Status != entity.ModerationPending {
+		return sql.ErrNoRows
+	}
+	r.items[id].Status = status
+	return nil
+}
+
+type spamFilterStub struct{ SpamFilter }
+
+func (spamFilterStub) Train(ctx context.Context, text string, isSpam bool) error { return nil }
+
+type postStatusStub struct{ PostService }
+
+func (postStatusStub) SetPostStatus(ctx context.Context, id int64, status string) error { return nil }
+
+type commentStatusStub struct{ CommentService }
+
+func (commentStatusStub) SetCommentStatus(ctx context.Context, id int64, status string) error {
+	return nil
+}
+
+// threadPostsStub — посты в памяти с закреплением, закрытием и архивом
+type threadPostsStub struct {
+	repository.PostRepository
+	posts map[int64]*entity.Post
+}
+
+func (r *threadPostsStub) GetPostByID(ctx context.Context, id int64) (*entity.Post, error) {
+	p, ok := r.posts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (r *threadPostsStub) PinPost(ctx context.Context, id int64, position int) error {
+	r.posts[id].PinPosition = &position
+	return nil
+}
+
+func (r *threadPostsStub) UnpinPost(ctx context.Context, id int64) error {
+	r.posts[id].PinPosition = nil
+	return nil
+}
+
+func (r *threadPostsStub) SetPostLocked(ctx context.Context, id int64, locked bool) error {
+	r.posts[id].LockedAt = timeIf(locked)
+	return nil
+}
+
+func (r *threadPostsStub) SetPostArchived(ctx context.Context, id int64, archived bool) error {
+	r.posts[id].ArchivedAt = timeIf(archived)
+	return nil
+}
+
+func timeIf(set bool) *time.Time {
+	if !set {
+		return nil
+	}
+	now := time.Now()
+	return &now
+}
+
+func TestModerationDecisionsNotifyAuthors(t *testing.T) {
+	const postAuthor, commentAuthor, quietAuthor = 5, 6, 7
+	commentID := int64(40)
+	queue := &moderationQueueStub{items: map[int64]*entity.ModerationItem{
+		1: {ID: 1, PostID: 10, AuthorID: postAuthor, Title: "Куплю гараж", Status: entity.ModerationPending},
+		2: {ID: 2, PostID: 10, CommentID: &commentID, AuthorID: commentAuthor, Content: "звоните", Status: entity.ModerationPending},
+		3: {ID: 3, PostID: 11, AuthorID: quietAuthor, Title: "Тихо", Status: entity.ModerationPending},
+	}}
+	repo := &notificationRepoStub{prefs: map[int64]map[string]bool{quietAuthor: {entity.NotificationModeration: false}}}
+	notifications := NewNotificationService(repo, nil, nil, nil)
+	s := NewModerationService(queue, nil, spamFilterStub{}, postStatusStub{}, commentStatusStub{},
+		WithModerationListener(notifications))
+	mod := &entity.User{ID: 9, Role: entity.RoleModerator}
+	ctx := context.Background()
+
+	for id, decision := range map[int64]string{1: entity.ModerationApproved, 2: entity.ModerationSpam, 3: entity.ModerationApproved} {
+		if err := s.Decide(ctx, mod, id, decision); err != nil {
+			t.Fatalf("decide %d: %v", id, err)
+		}
+	}
+	if err := s.Decide(ctx, mod, 1, entity.ModerationSpam); !errors.Is(err, ErrAlreadyDecided) {
+		t.Fatalf("second decision: %v, want ErrAlreadyDecided", err)
+	}
+	if len(repo.created) != 2 {
+		t.Fatalf("%d notifications, want one per author who has them on: %+v", len(repo.created), repo.created)
+	}
+	for _, n := range repo.created {
+		if n.Type != entity.NotificationModeration || n.ActorID == nil || *n.ActorID != mod.ID {
+			t.Errorf("notification %+v: want a moderation notification from the moderator", n)
+		}
+		switch n.UserID {
+		case postAuthor:
+			if n.CommentID != nil || !strings.Contains(n.Message, "Куплю гараж") || !strings.Contains(n.Message, "опубликован") {
+				t.Errorf("approved post: %q", n.Message)
+			}
+		case commentAuthor:
+			if n.CommentID == nil || *n.CommentID != commentID || !strings.Contains(n.Message, "отклонён") {
+				t.Errorf("rejected comment: %+v", n)
+			}
+		default:
+			t.Errorf("notified user %d", n.UserID)
+		}
+	}
+}
+
+func TestThreadActionsNotifyTheAuthorOnce(t *testing.T) {
+	const author = 5
+	posts := &threadPostsStub{posts: map[int64]*entity.Post{
+		1: {ID: 1, AuthorID: author, Title: "Тред"},
+		2: {ID: 2, AuthorID: 9, Title: "Пост модератора"},
+	}}
+	repo := &notificationRepoStub{}
+	s := NewThreadService(posts, DefaultThreadConfig(), WithThreadListener(NewNotificationService(repo, nil, nil, nil)))
+	mod := &entity.User{ID: 9, Role: entity.RoleModerator}
+	ctx := context.Background()
+
+	steps := []struct {
+		post     int64
+		action   string
+		position int
+		notifies bool
+	}{
+		{1, ThreadLock, 0, true},
+		{1, ThreadLock, 0, false}, // уже закрыт
+		{1, ThreadPin, 1, true},
+		{1, ThreadPin, 3, false}, // только сменилась позиция
+		{1, ThreadArchive, 0, true},
+		{1, ThreadUnlock, 0, true},
+		{2, ThreadLock, 0, false}, // свой пост
+	}
+	for _, st := range steps {
+		before := len(repo.created)
+		if _, err := s.Apply(ctx, mod, st.post, st.action, st.position); err != nil {
+			t.Fatalf("%s post %d: %v", st.action, st.post, err)
+		}
+		if got := len(repo.created) - before; got != map[bool]int{true: 1, false: 0}[st.notifies] {
+			t.Fatalf("%s post %d: %d notifications", st.action, st.post, got)
+		}
+		if st.notifies {
+			n := repo.created[len(repo.created)-1]
+			if n.UserID != author || n.Type != entity.NotificationModeration || n.PostID == nil || *n.PostID != st.post {
+				t.Fatalf("%s: notification %+v", st.action, n)
+			}
+		}
+	}
+
+	user := &entity.User{ID: author, Role: entity.RoleUser}
+	if _, err := s.Apply(ctx, user, 1, ThreadUnarchive, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("author unarchiving: %v, want ErrForbidden", err)
+	}
+}
+
+type automodRulesStub struct {
+	repository.AutomodRepository
+	rules []entity.AutomodRule
+}
+
+func (r automodRulesStub) Applicable(ctx context.Context, boardID int64) ([]entity.AutomodRule, error) {
+	return r.rules, nil
+}
+
+type automodUsersStub struct{ repository.UserRepository }
+
+func (automodUsersStub) GetUserByID(ctx context.Context, id int64) (*entity.User, error) {
+	return &entity.User{ID: id, Role: entity.RoleUser, CreatedAt: time.Now().Add(-time.Hour)}, nil
+}
+
+func TestAutomodLockNotifiesThePostAuthor(t *testing.T) {
+	const author, commenter = 5, 6
+	posts := &threadPostsStub{posts: map[int64]*entity.Post{1: {ID: 1, BoardID: 1, AuthorID: author, Title: "Тред"}}}
+	lock := entity.AutomodRule{Name: "флуд", Target: entity.AutomodTargetComment, Pattern: "флуд", Action: entity.AutomodLock, Enabled: true}
+	rules := automodRulesStub{rules: []entity.AutomodRule{lock, lock}}
+	rules.rules[0].ID, rules.rules[1].ID = 1, 2
+	repo := &notificationRepoStub{}
+	s := NewAutomodService(rules, posts, nil, nil, automodUsersStub{}, NewNotificationService(repo, posts, nil, nil))
+
+	s.CommentCreated(context.Background(), &entity.Comment{ID: 3, PostID: 1, AuthorID: commenter, Content: "обычный ответ"})
+	if posts.posts[1].Locked() || len(repo.created) != 0 {
+		t.Fatalf("a comment matching no rule locked the thread or notified %d", len(repo.created))
+	}
+	s.CommentCreated(context.Background(), &entity.Comment{ID: 4, PostID: 1, AuthorID: commenter, Content: "флуд флуд"})
+	if !posts.posts[1].Locked() {
+		t.Fatal("thread not locked")
+	}
+	if len(repo.created) != 1 {
+		t.Fatalf("%d notifications for one lock by two rules, want 1", len(repo.created))
+	}
+	if n := repo.created[0]; n.UserID != author || n.ActorID != nil || !strings.Contains(n.Message, "автомодератором") {
+		t.Fatalf("notification %+v: want the post author told the automoderator closed the thread", n)
+	}
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/mail"
+	"forum1/internal/oidc"
+	"forum1/internal/repository"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrOIDCDisabled  = errors.New("single sign-on is not configured")
+	ErrOIDCState     = errors.New("sign-in expired or was started in another browser")
+	ErrIdentityTaken = errors.New("this external account is linked to another user")
+	ErrLastSignIn    = errors.New("can't unlink the only way to sign in")
+)
+
+// oidcFlowTTL is how long the user has to sign in at the provider
+const oidcFlowTTL = 10 * time.Minute
+
+// OIDCResult is what a finished provider round trip led to
+type OIDCResult struct {
+	User    *entity.User
+	Created bool // a new forum account was made on first login
+	Linked  bool // the identity was linked to an already signed-in user
+}
+
+type OIDCService interface {
+	Enabled() bool
+	// Name is the provider name for "Sign in with …" buttons
+	Name() string
+	// Begin starts a login (linkUserID == 0) or linking to linkUserID. It returns the provider
+	// URL to redirect to and the flow value to keep in a cookie until the callback.
+	Begin(ctx context.Context, linkUserID int64) (redirect, flow string, err error)
+	// Complete checks the callback against the flow cookie, exchanges the code and maps the identity
+	// to a user. sessionUserID is who is signed in now (0 for nobody); a linking flow must match it.
+	Complete(ctx context.Context, flow, state, code string, sessionUserID int64) (*OIDCResult, error)
+	Identities(ctx context.Context, userID int64) ([]entity.Identity, error)
+	Unlink(ctx context.Context, u *entity.User, id int64) error
+}
+
+// oidcFlow is kept in a signed cookie between Begin and Complete
+type oidcFlow struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	LinkUser int64  `json:"u,omitempty"`
+	Expires  int64  `json:"e"`
+}
+
+func NewOIDCService(provider *oidc.Provider, name string, users repository.UserRepository,
+	identities repository.IdentityRepository, secret []byte) OIDCService {
+	if name == "" {
+		name = "SSO"
+	}
+	return &oidcService{
+		provider:   provider,
+		name:       name,
+		users:      users,
+		identities: identities,
+		signer:     tokenSigner{secret: secret},
+	}
+}
+
+type oidcService struct {
+	provider   *oidc.Provider // nil when OIDC_ISSUER isn't set
+	name       string
+	users      repository.UserRepository
+	identities repository.IdentityRepository
+	signer     tokenSigner
+}
+
+func (s *oidcService) Enabled() bool { return s.provider != nil }
+
+func (s *oidcService) Name() string { return s.name }
+
+func (s *oidcService) Begin(ctx context.Context, linkUserID int64) (string, string, error) {
+	if s.provider == nil {
+		return "", "", ErrOIDCDisabled
+	}
+	f := oidcFlow{LinkUser: linkUserID, Expires: time.Now().Add(oidcFlowTTL).Unix()}
+	var err error
+	if f.State, err = oidc.RandomString(); err != nil {
+		return "", "", err
+	}
+	if f.Nonce, err = oidc.RandomString(); err != nil {
+		return "", "", err
+	}
+	if f.Verifier, err = oidc.RandomString(); err != nil {
+		return "", "", err
+	}
+	redirect, err := s.provider.AuthCodeURL(ctx, f.State, f.Nonce, f.Verifier)
+	if err != nil {
+		return "", "", err
+	}
+	raw, _ := json.Marshal(f)
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return redirect, payload + "." + s.signer.sign("oidc_flow", payload), nil
+}
+
+func (s *oidcService) openFlow(flow string) (*oidcFlow, error) {
+	payload, sig, ok := strings.Cut(flow, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.signer.sign("oidc_flow", payload))) {
+		return nil, ErrOIDCState
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrOIDCState
+	}
+	var f oidcFlow
+	if err := json.Unmarshal(raw, &f); err != nil || time.Now().Unix() > f.Expires {
+		return nil, ErrOIDCState
+	}
+	return &f, nil
+}
+
+func (s *oidcService) Complete(ctx context.Context, flow, state, code string, sessionUserID int64) (*OIDCResult, error) {
+	if s.provider == nil {
+		return nil, ErrOIDCDisabled
+	}
+	f, err := s.openFlow(flow)
+	if err != nil {
+		return nil, err
+	}
+	// state привязывает ответ провайдера к браузеру, который начал вход (защита от CSRF при логине)
+	if state == "" || !hmac.Equal([]byte(state), []byte(f.State)) {
+		return nil, ErrOIDCState
+	}
+	// привязку начал один пользователь, а вернулся в браузер уже другой — не привязываем
+	if f.LinkUser != 0 && f.LinkUser != sessionUserID {
+		return nil, ErrOIDCState
+	}
+	if code == "" {
+		return nil, ErrInvalidInput
+	}
+	tokens, err := s.provider.Exchange(ctx, code, f.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := s.provider.Verify(ctx, tokens.IDToken, f.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	// часть провайдеров кладёт email и имя только в userinfo
+	if claims.Email == "" && tokens.AccessToken != "" {
+		if info, err := s.provider.UserInfo(ctx, tokens.AccessToken, claims.Subject); err == nil {
+			claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+			if claims.PreferredUsername == "" {
+				claims.PreferredUsername = info.PreferredUsername
+			}
+			if claims.Name == "" {
+				claims.Name = info.Name
+			}
+		}
+	}
+
+	issuer := s.provider.Issuer()
+	ident, err := s.identities.Find(ctx, issuer, claims.Subject)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if f.LinkUser != 0 {
+		if ident != nil && ident.UserID != f.LinkUser {
+			return nil, ErrIdentityTaken
+		}
+		if ident == nil {
+			if err := s.identities.Create(ctx, &entity.Identity{
+				UserID: f.LinkUser, Issuer: issuer, Subject: claims.Subject, Email: claims.Email,
+			}); err != nil {
+				return nil, err
+			}
+		}
+		u, err := s.users.GetUserByID(ctx, f.LinkUser)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{User: u, Linked: true}, nil
+	}
+
+	if ident != nil {
+		_ = s.identities.Touch(ctx, ident.ID, claims.Email)
+		u, err := s.users.GetUserByID(ctx, ident.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{User: u}, nil
+	}
+
+	// первый вход: новый аккаунт. С существующими по email не склеиваем — это путь к захвату чужого
+	// аккаунта; привязать SSO к своему аккаунту можно из настроек.
+	u, err := s.createUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.identities.Create(ctx, &entity.Identity{
+		UserID: u.ID, Issuer: issuer, Subject: claims.Subject, Email: claims.Email,
+	}); err != nil {
+		return nil, err
+	}
+	return &OIDCResult{User: u, Created: true}, nil
+}
+
+// createUser makes an account from the provider's claims. It has no password; one can be set
+// later through "forgot password" if the e-mail is known.
+func (s *oidcService) createUser(ctx context.Context, c *oidc.Claims) (*entity.User, error) {
+	base := usernameFromClaims(c)
+	u := &entity.User{
+		Email:  strings.TrimSpace(c.Email),
+		Locale: mail.NormalizeLang(c.Locale),
+		Role:   entity.RoleUser,
+	}
+	for i := 1; i <= 50; i++ {
+		u.Username = base
+		if i > 1 {
+			u.Username = fmt.Sprintf("%s%d", base, i)
+		}
+		if _, err := s.users.GetUserByName(ctx, u.Username); err != sql.ErrNoRows {
+			if err != nil {
+				return nil, err
+			}
+			continue // занято
+		}
+		id, err := s.users.CreateUser(ctx, u)
+		if err != nil {
+			continue // имя успели занять параллельно
+		}
+		u.ID = id
+		if c.EmailVerified && u.Email != "" {
+			_, _ = s.users.MarkEmailVerified(ctx, id, u.Email)
+		}
+		return s.users.GetUserByID(ctx, id)
+	}
+	return nil, errors.New("oidc: no free username for " + base)
+}
+
+// usernameFromClaims picks a readable username: preferred_username, the e-mail's local part or the name
+func usernameFromClaims(c *oidc.Claims) string {
+	candidates := []string{c.PreferredUsername, strings.SplitN(c.Email, "@", 2)[0], c.Name}
+	for _, cand := range candidates {
+		var b strings.Builder
+		for _, r := range cand {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-':
+				b.WriteRune(r)
+			case unicode.IsSpace(r):
+				b.WriteRune('_')
+			}
+		}
+		name := strings.Trim(b.String(), ".-_")
+		if len([]rune(name)) > 24 {
+			name = string([]rune(name)[:24])
+		}
+		if len([]rune(name)) >= 3 {
+			return name
+		}
+	}
+	return "user"
+}
+
+func (s *oidcService) Identities(ctx context.Context, userID int64) ([]entity.Identity, error) {
+	return s.identities.ListByUser(ctx, userID)
+}
+
+func (s *oidcService) Unlink(ctx context.Context, u *entity.User, id int64) error {
+	list, err := s.identities.ListByUser(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	// аккаунт без пароля и без других привязок потерял бы единственный способ входа
+	if u.Password == "" && len(list) <= 1 {
+		return ErrLastSignIn
+	}
+	return s.identities.Delete(ctx, u.ID, id)
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/oidc"
+	"forum1/internal/oidc/oidctest"
+	"forum1/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memUsers struct {
+	repository.UserRepository
+	byID map[int64]*entity.User
+}
+
+func (m *memUsers) CreateUser(ctx context.Context, u *entity.User) (int64, error) {
+	c := *u
+	c.ID = int64(len(m.byID) + 1)
+	m.byID[c.ID] = &c
+	return c.ID, nil
+}
+
+func (m *memUsers) GetUserByName(ctx context.Context, name string) (*entity.User, error) {
+	for _, u := range m.byID {
+		if u.Username == name {
+			return u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memUsers) GetUserByID(ctx context.Context, id int64) (*entity.User, error) {
+	if u, ok := m.byID[id]; ok {
+		return u, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memUsers) MarkEmailVerified(ctx context.Context, id int64, email string) (bool, error) {
+	now := time.Now()
+	m.byID[id].EmailVerifiedAt = &now
+	return true, nil
+}
+
+type memIdentities struct {
+	repository.IdentityRepository
+	list []entity.Identity
+}
+
+func (m *memIdentities) Find(ctx context.Context, issuer, subject string) (*entity.Identity, error) {
+	for _, i := range m.list {
+		if i.Issuer == issuer && i.Subject == subject {
+			return &i, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memIdentities) Create(ctx context.Context, i *entity.Identity) error {
+	i.ID = int64(len(m.list) + 1)
+	m.list = append(m.list, *i)
+	return nil
+}
+
+func (m *memIdentities) Touch(ctx context.Context, id int64, email string) error { return nil }
+
+// oidcFixture is the forum's OIDC service wired to a mock provider on an httptest server
+type oidcFixture struct {
+	idp    *oidctest.Provider
+	rp     *oidc.Provider
+	svc    *oidcService
+	users  *memUsers
+	idents *memIdentities
+	client *http.Client
+}
+
+func newOIDCFixture(t *testing.T) *oidcFixture {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	idp, err := oidctest.New(srv.URL, "forum", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/", idp.Handler())
+
+	rp := oidc.New(oidc.Config{
+		Issuer: srv.URL, ClientID: "forum", ClientSecret: "secret", RedirectURL: "http://forum.test/auth/oidc/callback",
+	}, srv.Client())
+	f := &oidcFixture{
+		idp: idp, rp: rp,
+		users:  &memUsers{byID: map[int64]*entity.User{}},
+		idents: &memIdentities{},
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }},
+	}
+	f.svc = NewOIDCService(rp, "Mock", f.users, f.idents, []byte("test secret")).(*oidcService)
+	return f
+}
+
+// signIn follows the redirect from Begin to the provider's authorize form, submits id there
+// and returns state and code from the redirect back to the forum
+func (f *oidcFixture) signIn(t *testing.T, redirect string, id oidctest.Identity) (state, code string) {
+	t.Helper()
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := u.Query()
+	form.Set("sub", id.Subject)
+	form.Set("email", id.Email)
+	if id.EmailVerified {
+		form.Set("email_verified", "on")
+	}
+	form.Set("preferred_username", id.PreferredUsername)
+	u.RawQuery = ""
+	resp, err := f.client.PostForm(u.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil || !strings.HasPrefix(back.String(), "http://forum.test/auth/oidc/callback?") {
+		t.Fatalf("authorize answered %s, Location %q", resp.Status, resp.Header.Get("Location"))
+	}
+	return back.Query().Get("state"), back.Query().Get("code")
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	ctx := context.Background()
+	f := newOIDCFixture(t)
+	alice := oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"}
+
+	// discovery: Begin sends the browser to the authorize endpoint from the provider metadata
+	redirect, flow, err := f.svc.Begin(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(redirect, f.idp.Issuer+"/authorize?") {
+		t.Fatalf("redirect %q is not the discovered authorize endpoint", redirect)
+	}
+
+	// PKCE: the challenge is S256 of the verifier kept in the flow cookie, and only that verifier redeems the code
+	st, err := f.svc.openFlow(flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := url.Parse(redirect)
+	if q.Query().Get("code_challenge_method") != "S256" || q.Query().Get("code_challenge") != oidc.Challenge(st.Verifier) {
+		t.Fatalf("authorize request %q lacks the S256 challenge of the flow verifier", redirect)
+	}
+	stray := f.idp.Authorize("http://forum.test/auth/oidc/callback", oidc.Challenge(st.Verifier), st.Nonce, alice)
+	if _, err := f.rp.Exchange(ctx, stray, "not-the-verifier"); err == nil {
+		t.Fatal("code redeemed with a wrong PKCE verifier")
+	}
+
+	// bad state: a callback that does not match the flow cookie is refused before the code is used
+	state, code := f.signIn(t, redirect, alice)
+	if _, err := f.svc.Complete(ctx, flow, "forged-"+state, code, 0); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("forged state: got %v, want ErrOIDCState", err)
+	}
+
+	// bad nonce: an ID token minted for another login is refused
+	replayed := f.idp.Authorize("http://forum.test/auth/oidc/callback", oidc.Challenge(st.Verifier), "other-nonce", alice)
+	if _, err := f.svc.Complete(ctx, flow, state, replayed, 0); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("foreign nonce: got %v, want a nonce mismatch", err)
+	}
+
+	// the real callback creates the account on first login
+	res, err := f.svc.Complete(ctx, flow, state, code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Created || res.User.Username != "alice" || res.User.EmailVerifiedAt == nil {
+		t.Fatalf("first login: got %+v, want a new verified user alice", res)
+	}
+
+	// the same identity signs in to the same account afterwards
+	redirect, flow, _ = f.svc.Begin(ctx, 0)
+	state, code = f.signIn(t, redirect, alice)
+	again, err := f.svc.Complete(ctx, flow, state, code, 0)
+	if err != nil || again.Created || again.User.ID != res.User.ID {
+		t.Fatalf("second login: got %+v, %v; want the account from the first login", again, err)
+	}
+}
+
+func TestOIDCLinkingVersusNewAccount(t *testing.T) {
+	ctx := context.Background()
+	f := newOIDCFixture(t)
+	now := time.Now()
+	bobID, _ := f.users.CreateUser(ctx, &entity.User{Username: "bob", Email: "bob@example.com", Role: entity.RoleUser, EmailVerifiedAt: &now})
+	bobSSO := oidctest.Identity{Subject: "bob-1", Email: "bob@example.com", EmailVerified: true, PreferredUsername: "bob"}
+
+	// вход чужой SSO-учёткой с тем же подтверждённым email не приклеивается к bob: создаётся новый аккаунт
+	redirect, flow, _ := f.svc.Begin(ctx, 0)
+	state, code := f.signIn(t, redirect, bobSSO)
+	res, err := f.svc.Complete(ctx, flow, state, code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Created || res.User.ID == bobID {
+		t.Fatalf("login with bob's email: got %+v, want a separate new account", res.User)
+	}
+
+	// bob links a second identity from his settings: it joins his account
+	work := oidctest.Identity{Subject: "bob-work", Email: "bob@example.com", EmailVerified: true}
+	redirect, flow, _ = f.svc.Begin(ctx, bobID)
+	state, code = f.signIn(t, redirect, work)
+	if _, err := f.svc.Complete(ctx, flow, state, code, 0); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("link finished by another session: got %v, want ErrOIDCState", err)
+	}
+	redirect, flow, _ = f.svc.Begin(ctx, bobID)
+	state, code = f.signIn(t, redirect, work)
+	res, err = f.svc.Complete(ctx, flow, state, code, bobID)
+	if err != nil || !res.Linked || res.User.ID != bobID {
+		t.Fatalf("link: got %+v, %v; want bob's account linked", res, err)
+	}
+	redirect, flow, _ = f.svc.Begin(ctx, 0)
+	state, code = f.signIn(t, redirect, work)
+	if res, err = f.svc.Complete(ctx, flow, state, code, 0); err != nil || res.User.ID != bobID {
+		t.Fatalf("login with the linked identity: got %+v, %v; want bob", res, err)
+	}
+
+	// an identity already on another account can't be linked to bob
+	redirect, flow, _ = f.svc.Begin(ctx, bobID)
+	state, code = f.signIn(t, redirect, bobSSO)
+	if _, err := f.svc.Complete(ctx, flow, state, code, bobID); !errors.Is(err, ErrIdentityTaken) {
+		t.Fatalf("linking a taken identity: got %v, want ErrIdentityTaken", err)
+	}
+}
+
+func TestOIDCDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	idp, err := oidctest.New("https://sso.example.com", "forum", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(idp.Handler())
+	defer srv.Close()
+	rp := oidc.New(oidc.Config{Issuer: srv.URL, ClientID: "forum", RedirectURL: "http://forum.test/cb"}, srv.Client())
+	if _, err := rp.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Fatalf("metadata of another issuer: got %v, want an issuer mismatch", err)
+	}
+}
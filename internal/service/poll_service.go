@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrPollClosed = errors.New("poll is closed")
+	ErrPollVoted  = errors.New("already voted")
+)
+
+const (
+	pollMaxOptions  = 20
+	pollMaxQuestion = 300
+	pollMaxOption   = 200
+)
+
+// PollService runs the polls attached to posts
+type PollService interface {
+	// Validate trims a new poll and checks it before its post is stored
+	Validate(p *entity.Poll) error
+	Create(ctx context.Context, postID int64, p *entity.Poll) error
+	// Get is the poll of a post as viewer (nil for guests) sees it; sql.ErrNoRows when there is none
+	Get(ctx context.Context, postID int64, viewer *entity.User) (*entity.Poll, error)
+	// Vote picks options for actor; a repeated vote replaces the old one if the poll allows that
+	Vote(ctx context.Context, actor *entity.User, postID int64, optionIDs []int64) (*entity.Poll, error)
+}
+
+func NewPollService(repo repository.PollRepository, posts repository.PostRepository) PollService {
+	return &pollService{repo: repo, posts: posts}
+}
+
+type pollService struct {
+	repo  repository.PollRepository
+	posts repository.PostRepository
+}
+
+func (s *pollService) Validate(p *entity.Poll) error {
+	p.Question = strings.TrimSpace(p.Question)
+	if p.Question == "" || utf8.RuneCountInString(p.Question) > pollMaxQuestion {
+		return fmt.Errorf("%w: poll question must be 1-%d characters", ErrInvalidInput, pollMaxQuestion)
+	}
+	seen := map[string]bool{}
+	options := make([]entity.PollOption, 0, len(p.Options))
+	for _, o := range p.Options {
+		text := strings.TrimSpace(o.Text)
+		if text == "" || seen[strings.ToLower(text)] {
+			continue
+		}
+		if utf8.RuneCountInString(text) > pollMaxOption {
+			return fmt.Errorf("%w: poll option is longer than %d characters", ErrInvalidInput, pollMaxOption)
+		}
+		seen[strings.ToLower(text)] = true
+		options = append(options, entity.PollOption{Text: text})
+	}
+	if len(options) < 2 || len(options) > pollMaxOptions {
+		return fmt.Errorf("%w: poll needs 2-%d different options", ErrInvalidInput, pollMaxOptions)
+	}
+	p.Options = options
+	if p.ClosesAt != nil && !p.ClosesAt.After(time.Now()) {
+		return fmt.Errorf("%w: poll close time is in the past", ErrInvalidInput)
+	}
+	return nil
+}
+
+func (s *pollService) Create(ctx context.Context, postID int64, p *entity.Poll) error {
+	if postID <= 0 {
+		return ErrInvalidInput
+	}
+	p.PostID = postID
+	return s.repo.Create(ctx, p)
+}
+
+func (s *pollService) Get(ctx context.Context, postID int64, viewer *entity.User) (*entity.Poll, error) {
+	p, err := s.repo.Get(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	closed := p.Closed(time.Now())
+	p.ResultsVisible = !p.HideResults || closed
+	if p.ResultsVisible {
+		for i := range p.Options {
+			if p.Voters > 0 {
+				// при множественном выборе — доля проголосовавших, сумма может быть больше 100
+				p.Options[i].Percent = p.Options[i].Votes * 100 / p.Voters
+			}
+		}
+		if !p.Anonymous {
+			names, err := s.repo.VoterNames(ctx, postID)
+			if err != nil {
+				return nil, err
+			}
+			for i := range p.Options {
+				p.Options[i].VoterNames = names[p.Options[i].ID]
+			}
+		}
+	} else {
+		// до закрытия не видно и явки: по ней с голосами других можно было бы вычислить результат
+		p.Voters = 0
+		for i := range p.Options {
+			p.Options[i].Votes = 0
+		}
+	}
+	p.MyVotes = []int64{}
+	if viewer == nil {
+		return p, nil
+	}
+	if p.MyVotes, err = s.repo.UserVotes(ctx, postID, viewer.ID); err != nil {
+		return nil, err
+	}
+	if !closed && (len(p.MyVotes) == 0 || p.AllowChange) {
+		post, err := s.posts.GetPostByID(ctx, postID)
+		if err != nil {
+			return nil, err
+		}
+		p.CanVote = pollOpenFor(post) == nil
+	}
+	return p, nil
+}
+
+// pollOpenFor: голосовать можно только в опубликованном и не закрытом треде — не в черновике,
+// отложенном посте или посте, который ждёт модератора
+func pollOpenFor(post *entity.Post) error {
+	return threadClosed(post.Status, post.Locked(), post.Archived())
+}
+
+func (s *pollService) Vote(ctx context.Context, actor *entity.User, postID int64, optionIDs []int64) (*entity.Poll, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	p, err := s.repo.Get(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Closed(time.Now()) {
+		return nil, ErrPollClosed
+	}
+	post, err := s.posts.GetPostByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pollOpenFor(post); err != nil {
+		return nil, err
+	}
+	valid := map[int64]bool{}
+	for _, o := range p.Options {
+		valid[o.ID] = true
+	}
+	picked := map[int64]bool{}
+	var ids []int64
+	for _, id := range optionIDs {
+		if !valid[id] {
+			return nil, fmt.Errorf("%w: unknown poll option", ErrInvalidInput)
+		}
+		if !picked[id] {
+			picked[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || (!p.Multiple && len(ids) > 1) {
+		return nil, fmt.Errorf("%w: pick one option", ErrInvalidInput)
+	}
+	stored, err := s.repo.Vote(ctx, postID, actor.ID, ids, p.AllowChange)
+	if err != nil {
+		return nil, err
+	}
+	if !stored {
+		return nil, ErrPollVoted
+	}
+	return s.Get(ctx, postID, actor)
+}
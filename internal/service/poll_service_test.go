@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memPolls — один опрос на пост, голоса по пользователям, как poll_repo
+type memPolls struct {
+	repository.PollRepository
+	polls map[int64]*entity.Poll
+	votes map[int64]map[int64][]int64 // пост -> пользователь -> варианты
+}
+
+func newMemPolls() *memPolls {
+	return &memPolls{polls: map[int64]*entity.Poll{}, votes: map[int64]map[int64][]int64{}}
+}
+
+func (m *memPolls) Create(ctx context.Context, p *entity.Poll) error {
+	for i := range p.Options {
+		p.Options[i].ID = p.PostID*100 + int64(i+1)
+	}
+	cp := *p
+	cp.Options = append([]entity.PollOption(nil), p.Options...)
+	m.polls[p.PostID], m.votes[p.PostID] = &cp, map[int64][]int64{}
+	return nil
+}
+
+func (m *memPolls) Get(ctx context.Context, postID int64) (*entity.Poll, error) {
+	stored, ok := m.polls[postID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	p := *stored
+	p.Options = append([]entity.PollOption(nil), stored.Options...)
+	p.Voters = len(m.votes[postID])
+	for _, picked := range m.votes[postID] {
+		for _, id := range picked {
+			for i := range p.Options {
+				if p.Options[i].ID == id {
+					p.Options[i].Votes++
+				}
+			}
+		}
+	}
+	return &p, nil
+}
+
+func (m *memPolls) UserVotes(ctx context.Context, postID, userID int64) ([]int64, error) {
+	return m.votes[postID][userID], nil
+}
+
+func (m *memPolls) VoterNames(ctx context.Context, postID int64) (map[int64][]string, error) {
+	names := map[int64][]string{}
+	for user, picked := range m.votes[postID] {
+		for _, id := range picked {
+			names[id] = append(names[id], fmt.Sprintf("user%d", user))
+		}
+	}
+	return names, nil
+}
+
+func (m *memPolls) Vote(ctx context.Context, postID, userID int64, optionIDs []int64, replace bool) (bool, error) {
+	if _, voted := m.votes[postID][userID]; voted && !replace {
+		return false, nil
+	}
+	m.votes[postID][userID] = optionIDs
+	return true, nil
+}
+
+type pollFixture struct {
+	s     PollService
+	polls *memPolls
+	posts *threadPostsStub
+}
+
+// newPollFixture создаёт опрос «Куда едем?» на три варианта у опубликованного поста 1
+func newPollFixture(t *testing.T, p entity.Poll) *pollFixture {
+	t.Helper()
+	f := &pollFixture{polls: newMemPolls(), posts: &threadPostsStub{posts: map[int64]*entity.Post{
+		1: {ID: 1, AuthorID: 1, Status: entity.StatusPublished},
+	}}}
+	f.s = NewPollService(f.polls, f.posts)
+	p.Question = "Куда едем?"
+	p.Options = []entity.PollOption{{Text: "Горы"}, {Text: "Море"}, {Text: "Дача"}}
+	if err := f.s.Validate(&p); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.s.Create(context.Background(), 1, &p); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func pollVoter(id int64) *entity.User { return &entity.User{ID: id, Role: entity.RoleUser} }
+
+func TestPollValidate(t *testing.T) {
+	s := NewPollService(nil, nil)
+	p := &entity.Poll{Question: "  Когда встречаемся? ", Options: []entity.PollOption{
+		{Text: " Суббота "}, {Text: "суббота"}, {Text: ""}, {Text: "Воскресенье"},
+	}}
+	if err := s.Validate(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Question != "Когда встречаемся?" || len(p.Options) != 2 || p.Options[0].Text != "Суббота" {
+		t.Fatalf("validated poll %+v, want trimmed question and options without repeats", p)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	for name, bad := range map[string]*entity.Poll{
+		"one option":     {Question: "?", Options: []entity.PollOption{{Text: "да"}, {Text: "Да"}}},
+		"no question":    {Question: " ", Options: []entity.PollOption{{Text: "да"}, {Text: "нет"}}},
+		"closes in past": {Question: "?", Options: []entity.PollOption{{Text: "да"}, {Text: "нет"}}, ClosesAt: &past},
+		"long option":    {Question: "?", Options: []entity.PollOption{{Text: "да"}, {Text: strings.Repeat("о", pollMaxOption+1)}}},
+	} {
+		if err := s.Validate(bad); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: %v, want ErrInvalidInput", name, err)
+		}
+	}
+}
+
+func TestPollVoteSingleChoice(t *testing.T) {
+	f := newPollFixture(t, entity.Poll{})
+	ctx := context.Background()
+	mountains, sea := int64(101), int64(102)
+
+	if _, err := f.s.Vote(ctx, nil, 1, []int64{mountains}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("guest: %v, want ErrForbidden", err)
+	}
+	for name, ids := range map[string][]int64{"two options": {mountains, sea}, "unknown option": {999}, "nothing": nil} {
+		if _, err := f.s.Vote(ctx, pollVoter(2), 1, ids); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: %v, want ErrInvalidInput", name, err)
+		}
+	}
+	p, err := f.s.Vote(ctx, pollVoter(2), 1, []int64{mountains, mountains})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Voters != 1 || p.Options[0].Votes != 1 || p.Options[0].Percent != 100 || !p.Voted(mountains) || p.CanVote {
+		t.Fatalf("after voting: %+v", p)
+	}
+	if len(p.Options[0].VoterNames) != 1 {
+		t.Fatalf("open poll: voter names %v, want the voter", p.Options[0].VoterNames)
+	}
+	if _, err := f.s.Vote(ctx, pollVoter(2), 1, []int64{sea}); !errors.Is(err, ErrPollVoted) {
+		t.Fatalf("second vote: %v, want ErrPollVoted", err)
+	}
+}
+
+func TestPollVoteChangeAndMultiple(t *testing.T) {
+	f := newPollFixture(t, entity.Poll{Multiple: true, AllowChange: true, Anonymous: true})
+	ctx := context.Background()
+	if _, err := f.s.Vote(ctx, pollVoter(2), 1, []int64{101, 102}); err != nil {
+		t.Fatal(err)
+	}
+	f.s.Vote(ctx, pollVoter(3), 1, []int64{102})
+	p, err := f.s.Vote(ctx, pollVoter(2), 1, []int64{103})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Voters != 2 || p.Options[0].Votes != 0 || p.Options[1].Votes != 1 || p.Options[2].Percent != 50 {
+		t.Fatalf("after changing a vote: %+v", p.Options)
+	}
+	if !p.CanVote {
+		t.Fatal("a poll that allows changes must stay open to the voter")
+	}
+	for _, o := range p.Options {
+		if len(o.VoterNames) != 0 {
+			t.Fatalf("anonymous poll shows voters %v", o.VoterNames)
+		}
+	}
+}
+
+func TestPollHiddenResultsAndClosing(t *testing.T) {
+	closes := time.Now().Add(time.Hour)
+	f := newPollFixture(t, entity.Poll{HideResults: true, ClosesAt: &closes})
+	ctx := context.Background()
+	f.s.Vote(ctx, pollVoter(2), 1, []int64{101})
+
+	p, err := f.s.Get(ctx, 1, pollVoter(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ResultsVisible || p.Voters != 0 || p.Options[0].Votes != 0 || !p.CanVote {
+		t.Fatalf("open poll with hidden results: %+v", p)
+	}
+
+	past := time.Now().Add(-time.Second)
+	f.polls.polls[1].ClosesAt = &past
+	if _, err := f.s.Vote(ctx, pollVoter(3), 1, []int64{102}); !errors.Is(err, ErrPollClosed) {
+		t.Fatalf("vote after closing: %v, want ErrPollClosed", err)
+	}
+	p, _ = f.s.Get(ctx, 1, nil)
+	if !p.ResultsVisible || p.Voters != 1 || p.Options[0].Votes != 1 || p.CanVote {
+		t.Fatalf("closed poll: %+v, want results shown", p)
+	}
+}
+
+func TestPollVoteNeedsAnOpenThread(t *testing.T) {
+	f := newPollFixture(t, entity.Poll{})
+	ctx := context.Background()
+	for _, status := range []string{entity.StatusDraft, entity.StatusScheduled, entity.StatusPending} {
+		f.posts.posts[1].Status = status
+		if _, err := f.s.Vote(ctx, pollVoter(2), 1, []int64{101}); !errors.Is(err, ErrPostUnpublished) {
+			t.Errorf("vote in a %s post: %v, want ErrPostUnpublished", status, err)
+		}
+	}
+	f.posts.posts[1].Status = entity.StatusPublished
+	f.posts.posts[1].LockedAt = timeIf(true)
+	if _, err := f.s.Vote(ctx, pollVoter(2), 1, []int64{101}); !errors.Is(err, ErrPostLocked) {
+		t.Fatalf("vote in a locked thread: %v, want ErrPostLocked", err)
+	}
+	if p, _ := f.s.Get(ctx, 1, pollVoter(2)); p.CanVote {
+		t.Fatal("a locked thread offers to vote")
+	}
+}
+
+type pollPostsStub struct{ repository.PostRepository }
+
+func (pollPostsStub) CreatePost(ctx context.Context, p *entity.Post) (int64, error) { return 1, nil }
+
+func TestCreatePostWithPoll(t *testing.T) {
+	ctx := context.Background()
+	// отложенный пост: публикация и её уведомления тут не нужны
+	later := time.Now().Add(time.Hour)
+	post := func() *entity.Post {
+		return &entity.Post{AuthorID: 1, BoardID: 1, Title: "Опрос", Content: "голосуем", PublishAt: &later,
+			Poll: &entity.Poll{Question: "Да?", Options: []entity.PollOption{{Text: "да"}, {Text: "нет"}}}}
+	}
+	if _, err := NewPostService(pollPostsStub{}).CreatePost(ctx, post()); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("poll without a poll service: %v, want ErrInvalidInput", err)
+	}
+
+	polls := newMemPolls()
+	s := NewPostService(pollPostsStub{}, WithPostPolls(NewPollService(polls, nil)))
+	bad := post()
+	bad.Poll.Options = bad.Poll.Options[:1]
+	if _, err := s.CreatePost(ctx, bad); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("poll with one option: %v, want ErrInvalidInput", err)
+	}
+	if _, err := s.CreatePost(ctx, post()); err != nil {
+		t.Fatal(err)
+	}
+	if p := polls.polls[1]; p == nil || len(p.Options) != 2 {
+		t.Fatalf("stored poll %+v", p)
+	}
+}
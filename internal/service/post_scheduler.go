@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PostScheduler publishes scheduled posts when their time comes. Every instance may run one:
+// PostService.PublishDue hands each post to exactly one of them.
+type PostScheduler interface {
+	Run(ctx context.Context)
+}
+
+func NewPostScheduler(posts PostService, interval time.Duration) PostScheduler {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &postScheduler{posts: posts, interval: interval}
+}
+
+const scheduledBatch = 100
+
+type postScheduler struct {
+	posts    PostService
+	interval time.Duration
+}
+
+func (s *postScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		// пачками, пока есть что публиковать
+		for {
+			n, err := s.posts.PublishDue(ctx, scheduledBatch)
+			if err != nil {
+				fmt.Println("scheduled posts:", err)
+				break
+			}
+			if n > 0 {
+				fmt.Println("published scheduled posts:", n)
+			}
+			if n < scheduledBatch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,12 +2,22 @@ package service
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"forum1/internal/entity"
+	"forum1/internal/jobs"
 	"forum1/internal/repository"
+	"forum1/internal/spam"
+	"strconv"
+	"time"
 )
 
-var ErrInvalidInput = errors.New("invalid input")
+var (
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrPostScheduled: запланированный пост не правится автосохранением — его меняют, отправив форму заново
+	ErrPostScheduled = errors.New("post is scheduled, submit it again to change it")
+)
 
 type PostService interface {
 	GetAllPosts(ctx context.Context) ([]entity.Post, error)
@@ -16,13 +26,96 @@ type PostService interface {
 	UpdatePost(ctx context.Context, post *entity.Post) error
 	DeletePost(ctx context.Context, id int64) error
 	GetPostsByBoard(ctx context.Context, boardID int64) ([]entity.Post, error)
+	// ListPosts is the feed of a board (0 for all boards) in one of the entity.Sort* orders;
+	// window limits the top feed to recent posts
+	ListPosts(ctx context.Context, boardID int64, sort, window string) ([]entity.Post, error)
+	// Following is the same feed over the boards, clubs, threads and authors the user subscribed to
+	Following(ctx context.Context, userID int64, sort, window string) ([]entity.Post, error)
 	SetPostVote(ctx context.Context, postID int64, userID int64, value int) error
 	GetPostVotes(ctx context.Context, postID int64) (likes int, dislikes int, err error)
+	// SetPostStatus is for moderators; publishing a held post runs what CreatePost skipped for it
+	SetPostStatus(ctx context.Context, id int64, status string) error
+
+	// SaveDraft stores a draft of post.AuthorID (post.ID 0 creates one) without checks or notifications;
+	// ErrPostScheduled for a scheduled post
+	SaveDraft(ctx context.Context, post *entity.Post) (int64, error)
+	// Drafts are the author's drafts and scheduled posts
+	Drafts(ctx context.Context, authorID int64) ([]entity.Post, error)
+	// Draft is one of them; sql.ErrNoRows for anything else
+	Draft(ctx context.Context, authorID, id int64) (*entity.Post, error)
+	DeleteDraft(ctx context.Context, authorID, id int64) error
+	// PublishDue publishes up to limit scheduled posts whose time has come and runs what follows publishing
+	PublishDue(ctx context.Context, limit int) (int, error)
+}
+
+type postService struct {
+	repo      repository.PostRepository
+	mentions  MentionService
+	voters    []VoteListener
+	listeners []PostListener
+	spam      SpamFilter
+	polls     PollService
+	karma     KarmaGate
+	jobs      *jobs.Runner
+}
+
+// PostOption configures optional collaborators of PostService
+type PostOption func(s *postService)
+
+// WithPostMentions makes CreatePost/UpdatePost parse and store @mentions
+func WithPostMentions(m MentionService) PostOption {
+	return func(s *postService) { s.mentions = m }
 }
 
-type postService struct{ repo repository.PostRepository }
+// WithPostVoteListener subscribes l to post votes
+func WithPostVoteListener(l VoteListener) PostOption {
+	return func(s *postService) { s.voters = append(s.voters, l) }
+}
+
+// WithPostKarma requires karma for links in posts and for downvotes
+func WithPostKarma(g KarmaGate) PostOption {
+	return func(s *postService) { s.karma = g }
+}
+
+// WithPostJobs announces scheduled posts through the job queue: the job is stored together with
+// the status change, so a post published by PublishDue is announced even if the process dies
+func WithPostJobs(runner *jobs.Runner) PostOption {
+	return func(s *postService) {
+		s.jobs = runner
+		jobs.Handle(runner, jobPostPublished, jobs.Options{}, func(ctx context.Context, p postJob) error {
+			return s.announce(ctx, p.ID)
+		})
+	}
+}
 
-func NewPostService(repo repository.PostRepository) PostService { return &postService{repo: repo} }
+const jobPostPublished = "post.published"
+
+type postJob struct {
+	ID int64 `json:"id"`
+}
+
+// WithPostListener subscribes l to created posts
+func WithPostListener(l PostListener) PostOption {
+	return func(s *postService) { s.listeners = append(s.listeners, l) }
+}
+
+// WithPostSpamFilter sends new posts through the spam filter; held ones wait for a moderator
+func WithPostSpamFilter(f SpamFilter) PostOption {
+	return func(s *postService) { s.spam = f }
+}
+
+// WithPostPolls lets posts carry a poll (entity.Post.Poll), stored along with the post
+func WithPostPolls(p PollService) PostOption {
+	return func(s *postService) { s.polls = p }
+}
+
+func NewPostService(repo repository.PostRepository, opts ...PostOption) PostService {
+	s := &postService{repo: repo}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
 
 func (s *postService) GetAllPosts(ctx context.Context) ([]entity.Post, error) {
 	return s.repo.GetAllPosts(ctx)
@@ -39,14 +132,230 @@ func (s *postService) CreatePost(ctx context.Context, post *entity.Post) (int64,
 	if post.Title == "" || post.Content == "" || post.AuthorID == 0 || post.BoardID == 0 {
 		return 0, ErrInvalidInput
 	}
-	return s.repo.CreatePost(ctx, post)
+	// статус решает сервис, а не клиент; время в прошлом — публикуем сразу
+	post.Status = ""
+	if post.PublishAt != nil && !post.PublishAt.After(time.Now()) {
+		post.PublishAt = nil
+	}
+	if post.PublishAt != nil {
+		post.Status = entity.StatusScheduled
+	}
+	if s.karma != nil && postHasLinks(post) {
+		if err := s.karma.Allow(ctx, post.AuthorID, entity.PrivilegePostLinks); err != nil {
+			return 0, err
+		}
+	}
+	if post.Poll != nil {
+		if s.polls == nil {
+			return 0, fmt.Errorf("%w: polls are not supported", ErrInvalidInput)
+		}
+		if err := s.polls.Validate(post.Poll); err != nil {
+			return 0, err
+		}
+	}
+	var verdict *SpamVerdict
+	if s.spam != nil {
+		verdict = s.spam.Review(ctx, &SpamSubject{
+			AuthorID: post.AuthorID, BoardID: post.BoardID, Title: post.Title, Content: post.Content, LinkURL: post.LinkURL,
+			Attachment: len(post.ImageData) > 0 || post.ImageURL != "",
+		})
+		if verdict.Blocked {
+			return 0, verdict.Err()
+		}
+		if verdict.Held {
+			post.Status = entity.StatusPending
+		}
+	}
+	id, err := s.repo.CreatePost(ctx, post)
+	if err != nil {
+		return 0, err
+	}
+	post.ID = id
+	if post.Poll != nil {
+		if err := s.polls.Create(ctx, id, post.Poll); err != nil {
+			// пост без своего опроса не нужен автору — убираем целиком
+			if derr := s.repo.DeletePost(ctx, id); derr != nil {
+				fmt.Println("delete post without poll:", derr)
+			}
+			return 0, err
+		}
+	}
+	if post.Status == entity.StatusPending {
+		err := s.spam.Hold(ctx, id, nil, post.AuthorID, verdict)
+		if err == nil {
+			return id, nil // упоминания и уведомления — после одобрения модератором
+		}
+		// без записи в очереди пост так и остался бы скрытым — публикуем
+		fmt.Println("moderation queue:", err)
+		post.Status = entity.StatusPublished
+		if post.PublishAt != nil {
+			post.Status = entity.StatusScheduled
+		}
+		if err := s.repo.SetPostStatus(ctx, id, post.Status); err != nil {
+			return 0, err
+		}
+	}
+	if post.Status == entity.StatusScheduled {
+		return id, nil // уведомления уйдут, когда пост опубликует планировщик
+	}
+	post.Status = entity.StatusPublished
+	s.published(ctx, post)
+	return id, nil
+}
+
+func (s *postService) SaveDraft(ctx context.Context, post *entity.Post) (int64, error) {
+	if post.AuthorID == 0 || post.BoardID == 0 {
+		return 0, ErrInvalidInput
+	}
+	post.Status = entity.StatusDraft
+	if post.ID == 0 {
+		return s.repo.CreatePost(ctx, post)
+	}
+	err := s.repo.SaveDraft(ctx, post)
+	if errors.Is(err, sql.ErrNoRows) {
+		// правка молча сняла бы пост с расписания — отказываем, если черновик на самом деле запланирован
+		if cur, gerr := s.repo.GetPostByID(ctx, post.ID); gerr == nil && cur.AuthorID == post.AuthorID && cur.Status == entity.StatusScheduled {
+			return 0, ErrPostScheduled
+		}
+	}
+	return post.ID, err
+}
+
+func (s *postService) Drafts(ctx context.Context, authorID int64) ([]entity.Post, error) {
+	if authorID == 0 {
+		return nil, ErrInvalidInput
+	}
+	return s.repo.Drafts(ctx, authorID)
+}
+
+func (s *postService) Draft(ctx context.Context, authorID, id int64) (*entity.Post, error) {
+	post, err := s.repo.GetPostByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if post.AuthorID != authorID || (post.Status != entity.StatusDraft && post.Status != entity.StatusScheduled) {
+		return nil, sql.ErrNoRows
+	}
+	return post, nil
+}
+
+func (s *postService) DeleteDraft(ctx context.Context, authorID, id int64) error {
+	if authorID == 0 || id <= 0 {
+		return ErrInvalidInput
+	}
+	return s.repo.DeleteDraft(ctx, id, authorID)
+}
+
+func (s *postService) PublishDue(ctx context.Context, limit int) (int, error) {
+	if s.jobs != nil {
+		ids, err := s.repo.PublishDue(ctx, limit, func(tx *sql.Tx, id int64) error {
+			_, err := s.jobs.EnqueueTx(ctx, tx, jobPostPublished, postJob{ID: id},
+				jobs.Unique(jobPostPublished+":"+strconv.FormatInt(id, 10)))
+			return err
+		})
+		return len(ids), err
+	}
+	ids, err := s.repo.PublishDue(ctx, limit, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := s.announce(ctx, id); err != nil {
+			fmt.Println("scheduled post", id, "published, but not announced:", err)
+		}
+	}
+	return len(ids), nil
+}
+
+// announce runs what follows publishing for a scheduled post; a post deleted or hidden since then is skipped
+func (s *postService) announce(ctx context.Context, id int64) error {
+	post, err := s.repo.GetPostByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if post.Status != entity.StatusPublished {
+		return nil
+	}
+	s.published(ctx, post)
+	return nil
+}
+
+// published runs what follows a post becoming visible
+func (s *postService) published(ctx context.Context, post *entity.Post) {
+	s.syncMentions(ctx, post)
+	for _, l := range s.listeners {
+		l.PostCreated(ctx, post)
+	}
+}
+
+func (s *postService) SetPostStatus(ctx context.Context, id int64, status string) error {
+	if id <= 0 {
+		return ErrInvalidInput
+	}
+	post, err := s.repo.GetPostByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status == entity.StatusPublished && post.PublishAt != nil && post.PublishAt.After(time.Now()) {
+		status = entity.StatusScheduled // одобренный отложенный пост ждёт своего времени
+	}
+	if post.Status == status {
+		return nil
+	}
+	if err := s.repo.SetPostStatus(ctx, id, status); err != nil {
+		return err
+	}
+	if status == entity.StatusPublished && post.Status == entity.StatusPending {
+		post.Status = status
+		s.published(ctx, post)
+	}
+	return nil
 }
 
 func (s *postService) UpdatePost(ctx context.Context, post *entity.Post) error {
 	if post.ID == 0 {
 		return ErrInvalidInput
 	}
-	return s.repo.UpdatePost(ctx, post)
+	if s.karma != nil && postHasLinks(post) {
+		if post.AuthorID == 0 {
+			stored, err := s.repo.GetPostByID(ctx, post.ID)
+			if err != nil {
+				return err
+			}
+			post.AuthorID = stored.AuthorID
+		}
+		if err := s.karma.Allow(ctx, post.AuthorID, entity.PrivilegePostLinks); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.UpdatePost(ctx, post); err != nil {
+		return err
+	}
+	if post.AuthorID == 0 {
+		if stored, err := s.repo.GetPostByID(ctx, post.ID); err == nil {
+			post.AuthorID = stored.AuthorID
+		}
+	}
+	s.syncMentions(ctx, post)
+	return nil
+}
+
+// postHasLinks: ссылка-вложение или ссылки в тексте
+func postHasLinks(post *entity.Post) bool {
+	return post.LinkURL != "" || spam.Links(post.Title+"\n"+post.Content) > 0
+}
+
+// syncMentions stores @mentions of the post body; failures are logged, the post itself is already saved
+func (s *postService) syncMentions(ctx context.Context, post *entity.Post) {
+	if s.mentions == nil || post.AuthorID == 0 {
+		return
+	}
+	if _, _, err := s.mentions.Sync(ctx, post.ID, nil, post.AuthorID, post.Title+"\n"+post.Content); err != nil {
+		fmt.Println("mentions sync:", err)
+	}
 }
 
 func (s *postService) DeletePost(ctx context.Context, id int64) error {
@@ -63,11 +372,52 @@ func (s *postService) GetPostsByBoard(ctx context.Context, boardID int64) ([]ent
 	return s.repo.GetPostsByBoard(ctx, boardID)
 }
 
+func (s *postService) ListPosts(ctx context.Context, boardID int64, sort, window string) ([]entity.Post, error) {
+	if boardID < 0 {
+		return nil, ErrInvalidInput
+	}
+	return s.listFeed(ctx, entity.PostQuery{BoardID: boardID}, sort, window)
+}
+
+func (s *postService) Following(ctx context.Context, userID int64, sort, window string) ([]entity.Post, error) {
+	if userID <= 0 {
+		return nil, ErrInvalidInput
+	}
+	return s.listFeed(ctx, entity.PostQuery{FollowerID: userID}, sort, window)
+}
+
+func (s *postService) listFeed(ctx context.Context, q entity.PostQuery, sort, window string) ([]entity.Post, error) {
+	sort, window = entity.ParseFeed(sort, window)
+	q.Sort = sort
+	if sort == entity.SortTop {
+		q.Since = entity.WindowSince(window, time.Now())
+	}
+	return s.repo.ListPosts(ctx, q)
+}
+
 func (s *postService) SetPostVote(ctx context.Context, postID int64, userID int64, value int) error {
 	if postID == 0 || userID == 0 || (value != -1 && value != 1) {
 		return ErrInvalidInput
 	}
-	return s.repo.SetPostVote(ctx, postID, userID, value)
+	status, locked, archived, err := s.repo.PostClosed(ctx, postID)
+	if err != nil {
+		return err
+	}
+	if err := threadClosed(status, locked, archived); err != nil {
+		return err
+	}
+	if value < 0 && s.karma != nil {
+		if err := s.karma.Allow(ctx, userID, entity.PrivilegeDownvote); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.SetPostVote(ctx, postID, userID, value); err != nil {
+		return err
+	}
+	for _, l := range s.voters {
+		l.PostVoted(ctx, postID, userID, value)
+	}
+	return nil
 }
 
 func (s *postService) GetPostVotes(ctx context.Context, postID int64) (likes int, dislikes int, err error) {
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"testing"
+	"time"
+)
+
+type postVoteRepoStub struct {
+	repository.PostRepository
+	status string
+	votes  int
+}
+
+func (r *postVoteRepoStub) PostClosed(ctx context.Context, id int64) (string, bool, bool, error) {
+	return r.status, false, false, nil
+}
+
+func (r *postVoteRepoStub) SetPostVote(ctx context.Context, postID, userID int64, value int) error {
+	r.votes++
+	return nil
+}
+
+func TestSetPostVoteRefusesUnpublishedPosts(t *testing.T) {
+	for _, status := range unpublishedStatuses {
+		repo, events := &postVoteRepoStub{status: status}, &threadEvents{}
+		s := NewPostService(repo, WithPostVoteListener(events))
+		if err := s.SetPostVote(context.Background(), 1, 2, 1); !errors.Is(err, ErrPostUnpublished) {
+			t.Errorf("vote on a %s post: %v, want ErrPostUnpublished", status, err)
+		}
+		if repo.votes != 0 || events.voted != 0 {
+			t.Errorf("vote on a %s post was stored or announced", status)
+		}
+	}
+
+	repo, events := &postVoteRepoStub{status: entity.StatusPublished}, &threadEvents{}
+	s := NewPostService(repo, WithPostVoteListener(events))
+	if err := s.SetPostVote(context.Background(), 1, 2, -1); err != nil {
+		t.Fatal(err)
+	}
+	if repo.votes != 1 || events.voted != 1 {
+		t.Fatalf("vote on a published post: %d stored, %d events; want 1 and 1", repo.votes, events.voted)
+	}
+}
+
+// draftPostsStub хранит черновики и отложенные посты как post_repo
+type draftPostsStub struct {
+	threadPostsStub
+}
+
+func newDraftPosts() *draftPostsStub {
+	return &draftPostsStub{threadPostsStub{posts: map[int64]*entity.Post{}}}
+}
+
+func (r *draftPostsStub) CreatePost(ctx context.Context, p *entity.Post) (int64, error) {
+	id := int64(len(r.posts) + 1)
+	cp := *p
+	cp.ID = id
+	if cp.Status == "" {
+		cp.Status = entity.StatusPublished
+	}
+	r.posts[id] = &cp
+	return id, nil
+}
+
+func (r *draftPostsStub) SaveDraft(ctx context.Context, p *entity.Post) error {
+	cur, ok := r.posts[p.ID]
+	if !ok || cur.AuthorID != p.AuthorID || cur.Status != entity.StatusDraft {
+		return sql.ErrNoRows
+	}
+	cp := *p
+	r.posts[p.ID] = &cp
+	return nil
+}
+
+func (r *draftPostsStub) PublishDue(ctx context.Context, limit int, announce func(tx *sql.Tx, id int64) error) ([]int64, error) {
+	var ids []int64
+	for id, p := range r.posts {
+		if len(ids) < limit && p.Status == entity.StatusScheduled && !p.PublishAt.After(time.Now()) {
+			p.Status = entity.StatusPublished
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// announced запоминает посты, о которых узнали слушатели
+type announced []int64
+
+func (a *announced) PostCreated(ctx context.Context, p *entity.Post) { *a = append(*a, p.ID) }
+
+func TestDraftsStayPrivateAndQuiet(t *testing.T) {
+	repo := newDraftPosts()
+	var heard announced
+	s := NewPostService(repo, WithPostListener(&heard))
+	ctx := context.Background()
+
+	if _, err := s.SaveDraft(ctx, &entity.Post{AuthorID: 1}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("draft without a board: %v, want ErrInvalidInput", err)
+	}
+	// черновику не нужны ни заголовок, ни текст
+	id, err := s.SaveDraft(ctx, &entity.Post{AuthorID: 1, BoardID: 1, Status: entity.StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.posts[id].Status != entity.StatusDraft || len(heard) != 0 {
+		t.Fatalf("draft stored as %s, %d announced; want a silent draft", repo.posts[id].Status, len(heard))
+	}
+	if _, err := s.SaveDraft(ctx, &entity.Post{ID: id, AuthorID: 1, BoardID: 1, Title: "Почти готово"}); err != nil {
+		t.Fatalf("autosave: %v", err)
+	}
+	if repo.posts[id].Title != "Почти готово" {
+		t.Fatalf("autosave kept %+v", repo.posts[id])
+	}
+
+	if _, err := s.Draft(ctx, 2, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("someone else's draft: %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.SaveDraft(ctx, &entity.Post{ID: id, AuthorID: 2, BoardID: 1}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("autosave into someone else's draft: %v, want sql.ErrNoRows", err)
+	}
+	if d, err := s.Draft(ctx, 1, id); err != nil || d.ID != id {
+		t.Fatalf("own draft: %+v, %v", d, err)
+	}
+}
+
+func TestScheduledPostsPublishOnTime(t *testing.T) {
+	repo := newDraftPosts()
+	var heard announced
+	s := NewPostService(repo, WithPostListener(&heard))
+	ctx := context.Background()
+	post := func(at time.Time) *entity.Post {
+		return &entity.Post{AuthorID: 1, BoardID: 1, Title: "Анонс", Content: "скоро", PublishAt: &at}
+	}
+
+	now, err := s.CreatePost(ctx, post(time.Now().Add(-time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := repo.posts[now]; p.Status != entity.StatusPublished || p.PublishAt != nil || len(heard) != 1 {
+		t.Fatalf("time in the past: %+v, %d announced; want published at once", p, len(heard))
+	}
+
+	later, _ := s.CreatePost(ctx, post(time.Now().Add(time.Hour)))
+	if repo.posts[later].Status != entity.StatusScheduled || len(heard) != 1 {
+		t.Fatalf("future post: %s, %d announced; want scheduled and quiet", repo.posts[later].Status, len(heard))
+	}
+	// автосохранение не снимает пост с расписания
+	if _, err := s.SaveDraft(ctx, &entity.Post{ID: later, AuthorID: 1, BoardID: 1}); !errors.Is(err, ErrPostScheduled) {
+		t.Fatalf("autosave over a scheduled post: %v, want ErrPostScheduled", err)
+	}
+	if d, err := s.Draft(ctx, 1, later); err != nil || d.Status != entity.StatusScheduled {
+		t.Fatalf("scheduled post in drafts: %+v, %v", d, err)
+	}
+
+	if n, err := s.PublishDue(ctx, 10); err != nil || n != 0 {
+		t.Fatalf("PublishDue before the time: %d, %v", n, err)
+	}
+	past := time.Now().Add(-time.Second)
+	repo.posts[later].PublishAt = &past
+	if n, err := s.PublishDue(ctx, 10); err != nil || n != 1 {
+		t.Fatalf("PublishDue: %d, %v", n, err)
+	}
+	if repo.posts[later].Status != entity.StatusPublished || len(heard) != 2 || heard[1] != later {
+		t.Fatalf("after PublishDue: %s, announced %v", repo.posts[later].Status, heard)
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/ranking"
+	"forum1/internal/repository"
+	"sync"
+	"time"
+)
+
+// RankingService keeps post_scores up to date for the hot, top and rising feeds. Run recomputes
+// the posts that had activity since its previous pass; new posts are scored right away.
+type RankingService interface {
+	PostListener
+	// Run refreshes scores every Interval until ctx is cancelled
+	Run(ctx context.Context)
+	// Refresh recomputes scores once; full recomputes every post
+	Refresh(ctx context.Context, full bool) error
+}
+
+type RankingConfig struct {
+	ranking.Config
+	Interval  time.Duration // как часто пересчитывать посты с новой активностью
+	FullEvery time.Duration // и как часто — все, на случай пропущенного
+	BatchSize int
+}
+
+func DefaultRankingConfig() RankingConfig {
+	return RankingConfig{Config: ranking.DefaultConfig(), Interval: 5 * time.Minute, FullEvery: 24 * time.Hour, BatchSize: 500}
+}
+
+func NewRankingService(repo repository.RankingRepository, cfg RankingConfig) RankingService {
+	def := DefaultRankingConfig()
+	if cfg.Config == (ranking.Config{}) {
+		cfg.Config = def.Config
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.FullEvery <= 0 {
+		cfg.FullEvery = def.FullEvery
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	return &rankingService{repo: repo, cfg: cfg}
+}
+
+type rankingService struct {
+	repo repository.RankingRepository
+	cfg  RankingConfig
+
+	mu       sync.Mutex // один пересчёт за раз
+	lastRun  time.Time
+	lastFull time.Time
+}
+
+func (s *rankingService) Run(ctx context.Context) {
+	if err := s.Refresh(ctx, true); err != nil {
+		fmt.Println("ranking:", err)
+	}
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			full := time.Since(s.lastFull) >= s.cfg.FullEvery
+			s.mu.Unlock()
+			if err := s.Refresh(ctx, full); err != nil {
+				fmt.Println("ranking:", err)
+			}
+		}
+	}
+}
+
+func (s *rankingService) Refresh(ctx context.Context, full bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	started := time.Now()
+	if s.lastRun.IsZero() {
+		full = true
+	}
+	// небольшой запас: голос, записанный во время прошлого прохода, не должен потеряться
+	since := s.lastRun.Add(-time.Minute)
+	ids, err := s.repo.Candidates(ctx, since, started.Add(-s.cfg.RisingMaxAge), full)
+	if err != nil {
+		return err
+	}
+	for len(ids) > 0 {
+		n := min(len(ids), s.cfg.BatchSize)
+		if err := s.score(ctx, ids[:n], started); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	s.lastRun = started
+	if full {
+		s.lastFull = started
+	}
+	return nil
+}
+
+func (s *rankingService) score(ctx context.Context, ids []int64, now time.Time) error {
+	signals, err := s.repo.Signals(ctx, ids, now.Add(-s.cfg.RisingWindow))
+	if err != nil {
+		return err
+	}
+	scored := make([]int64, len(signals))
+	scores := make([]ranking.Scores, len(signals))
+	for i, sig := range signals {
+		scored[i], scores[i] = sig.PostID, s.cfg.Score(sig.Signals, now)
+	}
+	return s.repo.SaveScores(ctx, scored, scores)
+}
+
+// PostCreated: без очков свежий пост оказался бы в самом низу «горячего»
+func (s *rankingService) PostCreated(ctx context.Context, p *entity.Post) {
+	if p.Status != "" && p.Status != entity.StatusPublished {
+		return
+	}
+	if err := s.score(ctx, []int64{p.ID}, time.Now()); err != nil {
+		fmt.Println("ranking: post", p.ID, err)
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/ranking"
+	"forum1/internal/repository"
+	"testing"
+	"time"
+)
+
+// rankingRepoStub: в базе посты 1..5, активность была только у поста 2
+type rankingRepoStub struct {
+	repository.RankingRepository
+	asked  []bool // all при каждом вызове Candidates
+	since  []time.Time
+	saved  map[int64]ranking.Scores
+	chunks []int
+}
+
+func (r *rankingRepoStub) Candidates(ctx context.Context, since, youngerThan time.Time, all bool) ([]int64, error) {
+	r.asked, r.since = append(r.asked, all), append(r.since, since)
+	if all {
+		return []int64{1, 2, 3, 4, 5}, nil
+	}
+	return []int64{2}, nil
+}
+
+func (r *rankingRepoStub) Signals(ctx context.Context, ids []int64, recentSince time.Time) ([]repository.PostSignals, error) {
+	r.chunks = append(r.chunks, len(ids))
+	res := make([]repository.PostSignals, len(ids))
+	for i, id := range ids {
+		res[i] = repository.PostSignals{PostID: id, Signals: ranking.Signals{CreatedAt: time.Now().Add(-time.Hour), Likes: int(id)}}
+	}
+	return res, nil
+}
+
+func (r *rankingRepoStub) SaveScores(ctx context.Context, ids []int64, scores []ranking.Scores) error {
+	if r.saved == nil {
+		r.saved = map[int64]ranking.Scores{}
+	}
+	for i, id := range ids {
+		r.saved[id] = scores[i]
+	}
+	return nil
+}
+
+func TestRankingRefreshStartsFullThenFollowsActivity(t *testing.T) {
+	repo := &rankingRepoStub{}
+	s := NewRankingService(repo, RankingConfig{BatchSize: 2})
+	ctx := context.Background()
+
+	if err := s.Refresh(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.asked[0] || len(repo.saved) != 5 {
+		t.Fatalf("first pass: all=%v, %d scored; want every post", repo.asked[0], len(repo.saved))
+	}
+	if len(repo.chunks) != 3 || repo.chunks[0] != 2 || repo.chunks[2] != 1 {
+		t.Fatalf("chunks %v, want batches of at most 2", repo.chunks)
+	}
+	if repo.saved[5].Top <= repo.saved[1].Top || repo.saved[5].Hot <= repo.saved[1].Hot {
+		t.Fatalf("scores %+v: more likes must score higher", repo.saved)
+	}
+
+	if err := s.Refresh(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if repo.asked[1] || time.Since(repo.since[1]) > 2*time.Minute {
+		t.Fatalf("second pass: all=%v since %v; want only activity since the first pass", repo.asked[1], repo.since[1])
+	}
+}
+
+func TestRankingScoresNewPublishedPosts(t *testing.T) {
+	repo := &rankingRepoStub{}
+	s := NewRankingService(repo, RankingConfig{})
+	ctx := context.Background()
+	s.PostCreated(ctx, &entity.Post{ID: 7, Status: entity.StatusPublished})
+	s.PostCreated(ctx, &entity.Post{ID: 8, Status: entity.StatusScheduled})
+	if _, ok := repo.saved[7]; !ok || len(repo.saved) != 1 {
+		t.Fatalf("scored %v, want only the published post", repo.saved)
+	}
+}
+
+// feedRepoStub запоминает запрос ленты
+type feedRepoStub struct {
+	repository.PostRepository
+	q entity.PostQuery
+}
+
+func (r *feedRepoStub) ListPosts(ctx context.Context, q entity.PostQuery) ([]entity.Post, error) {
+	r.q = q
+	return nil, nil
+}
+
+func TestListPostsParsesSortAndWindow(t *testing.T) {
+	repo := &feedRepoStub{}
+	s := NewPostService(repo)
+	ctx := context.Background()
+
+	s.ListPosts(ctx, 3, "sideways", "")
+	if repo.q.Sort != entity.SortNew || repo.q.BoardID != 3 || !repo.q.Since.IsZero() {
+		t.Fatalf("unknown sort: %+v, want new without a window", repo.q)
+	}
+	s.ListPosts(ctx, 0, entity.SortTop, "decade")
+	if repo.q.Sort != entity.SortTop || time.Since(repo.q.Since) < 6*24*time.Hour || time.Since(repo.q.Since) > 8*24*time.Hour {
+		t.Fatalf("top with an unknown window: %+v, want the last week", repo.q)
+	}
+	s.ListPosts(ctx, 0, entity.SortTop, entity.WindowAll)
+	if !repo.q.Since.IsZero() {
+		t.Fatalf("top of all time: since %v, want no limit", repo.q.Since)
+	}
+	s.ListPosts(ctx, 0, entity.SortHot, entity.WindowDay)
+	if repo.q.Sort != entity.SortHot || !repo.q.Since.IsZero() {
+		t.Fatalf("hot: %+v, want the window ignored", repo.q)
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"sync"
+	"time"
+)
+
+// ReadService tracks how far users have read threads. Mark only remembers the position in memory;
+// Run writes positions in batches, so opening a post costs no write. Positions that are not written
+// yet already count in Thread and Threads.
+type ReadService interface {
+	// Mark records that userID has seen postID up to lastCommentID (0 for a thread without comments).
+	// When too many marks are waiting for the writer, new threads are dropped.
+	Mark(userID, postID, lastCommentID int64)
+	// Run writes marks until ctx is cancelled, then flushes what is left
+	Run(ctx context.Context)
+	// Thread is the read state of one post for userID, as it was before the current visit is marked
+	Thread(ctx context.Context, userID, postID int64) (entity.ReadState, error)
+	// Threads are the read states of the posts with unread comment counts, keyed by post id
+	Threads(ctx context.Context, userID int64, postIDs []int64) (map[int64]entity.ReadState, error)
+	// MarkBoardRead marks every thread and comment of the board as read
+	MarkBoardRead(ctx context.Context, actor *entity.User, boardID int64) error
+}
+
+type ReadConfig struct {
+	Pending       int           // marks waiting for the writer
+	BatchSize     int           // a full batch is written at once…
+	FlushInterval time.Duration // …otherwise whatever is waiting is written this often
+}
+
+func DefaultReadConfig() ReadConfig {
+	return ReadConfig{Pending: 10000, BatchSize: 500, FlushInterval: 5 * time.Second}
+}
+
+func NewReadService(repo repository.ReadRepository, cfg ReadConfig) ReadService {
+	def := DefaultReadConfig()
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.Pending < cfg.BatchSize {
+		cfg.Pending = max(def.Pending, cfg.BatchSize)
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = def.FlushInterval
+	}
+	return &readService{repo: repo, cfg: cfg, pending: map[readKey]int64{}, full: make(chan struct{}, 1)}
+}
+
+type readKey struct{ user, post int64 }
+
+type readService struct {
+	repo repository.ReadRepository
+	cfg  ReadConfig
+
+	mu      sync.Mutex
+	pending map[readKey]int64 // (user, post) -> последний увиденный комментарий
+	writing map[readKey]int64 // пачка, которую сейчас пишет Run
+	full    chan struct{}
+}
+
+func (s *readService) Mark(userID, postID, lastCommentID int64) {
+	if userID <= 0 || postID <= 0 {
+		return
+	}
+	k := readKey{userID, postID}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.pending[k]
+	if !ok && len(s.pending) >= s.cfg.Pending {
+		// writer is behind: a thread that stays "new" is better than a slow page
+		return
+	}
+	if !ok || lastCommentID > last {
+		s.pending[k] = lastCommentID
+	}
+	if len(s.pending) >= s.cfg.BatchSize {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *readService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx уже отменён, поэтому дописываем остаток со своим коротким таймаутом
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			s.flush(flushCtx)
+			cancel()
+			return
+		case <-s.full:
+			s.flush(ctx)
+		case <-ticker.C:
+			s.flush(ctx)
+		}
+	}
+}
+
+func (s *readService) flush(ctx context.Context) {
+	s.mu.Lock()
+	batch := s.pending
+	if len(batch) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.pending, s.writing = map[readKey]int64{}, batch
+	s.mu.Unlock()
+
+	marks := make([]entity.ReadMark, 0, len(batch))
+	for k, last := range batch {
+		marks = append(marks, entity.ReadMark{UserID: k.user, PostID: k.post, LastCommentID: last})
+	}
+	for start := 0; start < len(marks); start += s.cfg.BatchSize {
+		end := min(start+s.cfg.BatchSize, len(marks))
+		if err := s.repo.SaveMarks(ctx, marks[start:end]); err != nil {
+			fmt.Println("read marks:", err, "- dropped", end-start)
+		}
+	}
+
+	s.mu.Lock()
+	s.writing = nil
+	s.mu.Unlock()
+}
+
+// unsaved is the position in postID that userID has reached but Run has not written yet, -1 if none
+func (s *readService) unsaved(userID, postID int64) int64 {
+	k := readKey{userID, postID}
+	last := int64(-1)
+	if v, ok := s.writing[k]; ok {
+		last = v
+	}
+	if v, ok := s.pending[k]; ok && v > last {
+		last = v
+	}
+	return last
+}
+
+func (s *readService) Thread(ctx context.Context, userID, postID int64) (entity.ReadState, error) {
+	states, err := s.Threads(ctx, userID, []int64{postID})
+	if err != nil {
+		return entity.ReadState{PostID: postID}, err
+	}
+	st, ok := states[postID]
+	if !ok {
+		return entity.ReadState{PostID: postID}, nil
+	}
+	return st, nil
+}
+
+func (s *readService) Threads(ctx context.Context, userID int64, postIDs []int64) (map[int64]entity.ReadState, error) {
+	if userID <= 0 || len(postIDs) == 0 {
+		return map[int64]entity.ReadState{}, nil
+	}
+	threads := make([]entity.ReadMark, len(postIDs))
+	s.mu.Lock()
+	for i, id := range postIDs {
+		threads[i] = entity.ReadMark{UserID: userID, PostID: id, LastCommentID: s.unsaved(userID, id)}
+	}
+	s.mu.Unlock()
+	return s.repo.States(ctx, userID, threads)
+}
+
+func (s *readService) MarkBoardRead(ctx context.Context, actor *entity.User, boardID int64) error {
+	if actor == nil {
+		return ErrForbidden
+	}
+	if boardID <= 0 {
+		return ErrInvalidInput
+	}
+	return s.repo.MarkBoard(ctx, actor.ID, boardID)
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memReads хранит позиции как read_repo: позиция никогда не откатывается назад
+type memReads struct {
+	repository.ReadRepository
+	mu      sync.Mutex
+	marks   map[readKey]int64
+	batches []int
+	board   [2]int64 // пользователь и доска последнего MarkBoard
+}
+
+func newMemReads() *memReads { return &memReads{marks: map[readKey]int64{}} }
+
+func (m *memReads) SaveMarks(ctx context.Context, marks []entity.ReadMark) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, len(marks))
+	for _, mk := range marks {
+		k := readKey{mk.UserID, mk.PostID}
+		if last, ok := m.marks[k]; !ok || mk.LastCommentID > last {
+			m.marks[k] = mk.LastCommentID
+		}
+	}
+	return nil
+}
+
+func (m *memReads) States(ctx context.Context, userID int64, threads []entity.ReadMark) (map[int64]entity.ReadState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := map[int64]entity.ReadState{}
+	for _, t := range threads {
+		last, ok := m.marks[readKey{userID, t.PostID}]
+		if !ok {
+			last = -1
+		}
+		last = max(last, t.LastCommentID)
+		if last >= 0 {
+			res[t.PostID] = entity.ReadState{PostID: t.PostID, Visited: true, LastCommentID: last}
+		}
+	}
+	return res, nil
+}
+
+func (m *memReads) MarkBoard(ctx context.Context, userID, boardID int64) error {
+	m.board = [2]int64{userID, boardID}
+	return nil
+}
+
+func (m *memReads) saved() (int, []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.marks), append([]int(nil), m.batches...)
+}
+
+func TestReadMarksCountBeforeTheyAreWritten(t *testing.T) {
+	repo := newMemReads()
+	s := NewReadService(repo, ReadConfig{Pending: 3, BatchSize: 2, FlushInterval: time.Hour})
+	ctx := context.Background()
+
+	s.Mark(1, 10, 5)
+	s.Mark(1, 10, 3) // старая вкладка не откатывает позицию
+	s.Mark(0, 10, 7)
+	if st, _ := s.Thread(ctx, 1, 10); !st.Visited || st.LastCommentID != 5 {
+		t.Fatalf("unsaved position: %+v, want comment 5", st)
+	}
+	if st, _ := s.Thread(ctx, 1, 11); st.Visited {
+		t.Fatalf("unvisited thread: %+v", st)
+	}
+	if n, _ := repo.saved(); n != 0 {
+		t.Fatalf("%d marks written by Mark, want none", n)
+	}
+
+	s.Mark(1, 11, 0)
+	s.Mark(2, 10, 1)
+	s.Mark(2, 11, 1) // очередь полна: новая тема отбрасывается
+	s.Mark(1, 10, 8) // а уже ждущая позиция двигается
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	s.Run(stopped)
+
+	n, batches := repo.saved()
+	if n != 3 || len(batches) != 2 || batches[0] != 2 || batches[1] != 1 {
+		t.Fatalf("%d marks in batches %v, want 3 in batches of at most 2", n, batches)
+	}
+	if repo.marks[readKey{1, 10}] != 8 {
+		t.Fatalf("stored position %d, want 8", repo.marks[readKey{1, 10}])
+	}
+	states, _ := s.Threads(ctx, 2, []int64{10, 11})
+	if _, ok := states[11]; ok || states[10].LastCommentID != 1 {
+		t.Fatalf("states %+v, want only thread 10", states)
+	}
+}
+
+func TestReadRunWritesFullBatches(t *testing.T) {
+	repo := newMemReads()
+	s := NewReadService(repo, ReadConfig{BatchSize: 2, FlushInterval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	s.Mark(1, 1, 1)
+	s.Mark(1, 2, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for n, _ := repo.saved(); n != 2; n, _ = repo.saved() {
+		if time.Now().After(deadline) {
+			t.Fatal("a full batch was not written before the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	s.Mark(1, 3, 1)
+	cancel()
+	<-done
+	if n, _ := repo.saved(); n != 3 {
+		t.Fatalf("%d marks after stopping, want the rest flushed", n)
+	}
+}
+
+func TestMarkBoardRead(t *testing.T) {
+	repo := newMemReads()
+	s := NewReadService(repo, ReadConfig{})
+	ctx := context.Background()
+	if err := s.MarkBoardRead(ctx, nil, 1); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("guest: %v, want ErrForbidden", err)
+	}
+	if err := s.MarkBoardRead(ctx, &entity.User{ID: 4}, 0); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("no board: %v, want ErrInvalidInput", err)
+	}
+	if err := s.MarkBoardRead(ctx, &entity.User{ID: 4}, 2); err != nil || repo.board != [2]int64{4, 2} {
+		t.Fatalf("MarkBoardRead: %v, marked %v", err, repo.board)
+	}
+}
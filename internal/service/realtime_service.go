@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/pubsub"
+	"forum1/internal/repository"
+	"time"
+)
+
+// Event types sent to live clients
+const (
+	EventPost           = "post"
+	EventComment        = "comment"
+	EventCommentDeleted = "comment_deleted"
+	EventVotes          = "votes"
+	EventCommentVotes   = "comment_votes"
+	EventNotification   = "notification"
+	EventMessage        = "message"
+)
+
+// RealtimePublisher forwards domain events to the pub/sub hub:
+// post:{id} gets comments and votes, board:{id} gets new posts and comments,
+// user:{id} gets notifications and private messages
+type RealtimePublisher struct {
+	hub           *pubsub.Hub
+	posts         repository.PostRepository
+	comments      repository.CommentRepository
+	notifications repository.NotificationRepository
+	messages      repository.MessageRepository
+}
+
+func NewRealtimePublisher(hub *pubsub.Hub, posts repository.PostRepository, comments repository.CommentRepository,
+	notifications repository.NotificationRepository, messages repository.MessageRepository) *RealtimePublisher {
+	return &RealtimePublisher{hub: hub, posts: posts, comments: comments, notifications: notifications, messages: messages}
+}
+
+type postEvent struct {
+	ID        int64     `json:"id"`
+	BoardID   int64     `json:"board_id"`
+	AuthorID  int64     `json:"author_id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type commentEvent struct {
+	ID        int64     `json:"id"`
+	PostID    int64     `json:"post_id"`
+	AuthorID  int64     `json:"author_id"`
+	ParentID  *int64    `json:"parent_id,omitempty"`
+	QuoteID   *int64    `json:"quote_id,omitempty"`
+	Content   string    `json:"content"`
+	HasImage  bool      `json:"has_image"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type votesEvent struct {
+	PostID    int64  `json:"post_id"`
+	CommentID *int64 `json:"comment_id,omitempty"`
+	Likes     int    `json:"likes"`
+	Dislikes  int    `json:"dislikes"`
+}
+
+func (p *RealtimePublisher) PostCreated(ctx context.Context, post *entity.Post) {
+	p.publish(ctx, pubsub.BoardTopic(post.BoardID), EventPost, postEvent{
+		ID: post.ID, BoardID: post.BoardID, AuthorID: post.AuthorID, Title: post.Title, CreatedAt: time.Now(),
+	})
+}
+
+func (p *RealtimePublisher) CommentCreated(ctx context.Context, c *entity.Comment) {
+	ev := commentEvent{
+		ID: c.ID, PostID: c.PostID, AuthorID: c.AuthorID, ParentID: c.ParentID, QuoteID: c.QuoteID,
+		// NOTIFY payload is limited, the full text is on the page anyway
+		Content:   excerpt(c.Content, 1000),
+		HasImage:  len(c.ImageData) > 0,
+		CreatedAt: time.Now(),
+	}
+	p.publish(ctx, pubsub.PostTopic(c.PostID), EventComment, ev)
+	if post, err := p.posts.GetPostByID(ctx, c.PostID); err == nil {
+		p.publish(ctx, pubsub.BoardTopic(post.BoardID), EventComment, ev)
+	}
+}
+
+func (p *RealtimePublisher) CommentDeleted(ctx context.Context, c *entity.Comment, byUserID int64) {
+	p.publish(ctx, pubsub.PostTopic(c.PostID), EventCommentDeleted, map[string]int64{"id": c.ID, "post_id": c.PostID})
+}
+
+func (p *RealtimePublisher) PostVoted(ctx context.Context, postID, voterID int64, value int) {
+	likes, dislikes, err := p.posts.GetPostVotes(ctx, postID)
+	if err != nil {
+		return
+	}
+	p.publish(ctx, pubsub.PostTopic(postID), EventVotes, votesEvent{PostID: postID, Likes: likes, Dislikes: dislikes})
+}
+
+func (p *RealtimePublisher) CommentVoted(ctx context.Context, commentID, voterID int64, value int) {
+	c, err := p.comments.GetCommentByID(ctx, commentID)
+	if err != nil {
+		return
+	}
+	likes, dislikes, err := p.comments.GetCommentVotes(ctx, commentID)
+	if err != nil {
+		return
+	}
+	p.publish(ctx, pubsub.PostTopic(c.PostID), EventCommentVotes,
+		votesEvent{PostID: c.PostID, CommentID: &commentID, Likes: likes, Dislikes: dislikes})
+}
+
+func (p *RealtimePublisher) Notified(ctx context.Context, n *entity.Notification) {
+	unread, _ := p.notifications.CountUnread(ctx, n.UserID)
+	p.publish(ctx, pubsub.UserTopic(n.UserID), EventNotification, map[string]any{
+		"notification": n,
+		"link":         n.Link(),
+		"unread":       unread,
+	})
+}
+
+func (p *RealtimePublisher) MessageSent(ctx context.Context, m *entity.Message, recipientIDs []int64) {
+	ev := *m
+	ev.ImageData = nil
+	// для превью; открытый диалог догружает полный текст через API
+	ev.Content = excerpt(m.Content, 200)
+	users := append([]int64{m.SenderID}, recipientIDs...)
+	for _, id := range users {
+		unread, _ := p.messages.UnreadTotal(ctx, id)
+		p.publish(ctx, pubsub.UserTopic(id), EventMessage, map[string]any{
+			"message": ev,
+			"unread":  unread,
+		})
+	}
+}
+
+func (p *RealtimePublisher) publish(ctx context.Context, topic, typ string, data any) {
+	if err := p.hub.Publish(ctx, topic, typ, data); err != nil {
+		fmt.Println("realtime:", err)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"time"
+)
+
+const (
+	SessionTTL = 30 * 24 * time.Hour
+	// sessionTouchEvery limits how often an active session's expiry is pushed forward
+	sessionTouchEvery = time.Hour
+)
+
+type SessionService interface {
+	// Create starts a session and returns the raw token for the cookie
+	Create(ctx context.Context, userID int64, ip, userAgent string) (string, *entity.Session, error)
+	// Resolve returns the user of a live session token
+	Resolve(ctx context.Context, token string) (*entity.User, *entity.Session, error)
+	Revoke(ctx context.Context, token string) error
+	RevokeAll(ctx context.Context, userID int64) error
+	List(ctx context.Context, userID int64) ([]entity.Session, error)
+}
+
+func NewSessionService(repo repository.SessionRepository) SessionService {
+	return &sessionService{repo: repo}
+}
+
+type sessionService struct {
+	repo repository.SessionRepository
+}
+
+func (s *sessionService) Create(ctx context.Context, userID int64, ip, userAgent string) (string, *entity.Session, error) {
+	if userID == 0 {
+		return "", nil, ErrInvalidInput
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(userAgent) > 300 {
+		userAgent = userAgent[:300]
+	}
+	sess := &entity.Session{UserID: userID, IP: ip, UserAgent: userAgent, ExpiresAt: time.Now().Add(SessionTTL)}
+	if err := s.repo.Create(ctx, hashToken(token), sess); err != nil {
+		return "", nil, err
+	}
+	return token, sess, nil
+}
+
+func (s *sessionService) Resolve(ctx context.Context, token string) (*entity.User, *entity.Session, error) {
+	if token == "" {
+		return nil, nil, ErrInvalidInput
+	}
+	u, sess, err := s.repo.GetUser(ctx, hashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	// скользящее продление: активная сессия не истекает
+	if time.Since(sess.LastSeenAt) > sessionTouchEvery {
+		sess.ExpiresAt = time.Now().Add(SessionTTL)
+		_ = s.repo.Touch(ctx, sess.ID, sess.ExpiresAt)
+	}
+	return u, sess, nil
+}
+
+func (s *sessionService) Revoke(ctx context.Context, token string) error {
+	return s.repo.Revoke(ctx, hashToken(token))
+}
+
+func (s *sessionService) RevokeAll(ctx context.Context, userID int64) error {
+	return s.repo.RevokeAll(ctx, userID)
+}
+
+func (s *sessionService) List(ctx context.Context, userID int64) ([]entity.Session, error) {
+	return s.repo.ListActive(ctx, userID)
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/spam"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrSpam rejects content outright; only a filled honeypot does that, everything else is held
+var ErrSpam = errors.New("rejected as spam")
+
+// ErrRejected is returned for content a moderation rule rejects; the error text carries the rule's message
+var ErrRejected = errors.New("rejected")
+
+// SpamSubject is a new post or comment on its way through the filter
+type SpamSubject struct {
+	AuthorID   int64
+	Author     *entity.User // Review loads it
+	BoardID    int64        // у комментария — доска его поста, Review находит сама
+	PostID     int64        // для комментария
+	Comment    bool
+	Title      string
+	Content    string
+	LinkURL    string
+	Attachment bool // приложена картинка
+	Signals    SpamSignals
+}
+
+// Text is everything the author wrote, as the text checks see it
+func (s *SpamSubject) Text() string {
+	text := s.Title + "\n" + s.Content
+	if s.LinkURL != "" {
+		text += "\n" + s.LinkURL
+	}
+	return text
+}
+
+// SpamSignals are facts about the request the content came with; handlers put them into the context
+type SpamSignals struct {
+	Honeypot string // hidden form field that people never fill
+}
+
+type spamSignalsKey struct{}
+
+func WithSpamSignals(ctx context.Context, s SpamSignals) context.Context {
+	return context.WithValue(ctx, spamSignalsKey{}, s)
+}
+
+func spamSignalsFrom(ctx context.Context) SpamSignals {
+	s, _ := ctx.Value(spamSignalsKey{}).(SpamSignals)
+	return s
+}
+
+// SpamHit is one check's finding. Scores of all hits add up; Block rejects without asking anyone,
+// Hold sends the content to the queue whatever the score.
+type SpamHit struct {
+	Check   string  `json:"check"`
+	Reason  string  `json:"reason"`
+	Score   float64 `json:"score"`
+	Block   bool    `json:"block,omitempty"`
+	Hold    bool    `json:"hold,omitempty"`
+	Message string  `json:"message,omitempty"` // shown to the author when the hit blocks
+}
+
+// SpamCheck is one stage of the filter. It returns nil for clean content.
+type SpamCheck interface {
+	Name() string
+	Check(ctx context.Context, s *SpamSubject) (*SpamHit, error)
+}
+
+type SpamVerdict struct {
+	Hits    []SpamHit `json:"hits"`
+	Score   float64   `json:"score"`
+	Held    bool      `json:"held"`    // в очередь модерации
+	Blocked bool      `json:"blocked"` // отклонить сразу
+}
+
+// Err is what CreatePost and CreateComment return for a blocked verdict
+func (v *SpamVerdict) Err() error {
+	for _, h := range v.Hits {
+		if h.Block && h.Message != "" {
+			return fmt.Errorf("%w: %s", ErrRejected, h.Message)
+		}
+	}
+	return ErrSpam
+}
+
+func (v *SpamVerdict) Reasons() []string {
+	res := make([]string, 0, len(v.Hits))
+	for _, h := range v.Hits {
+		res = append(res, h.Check+": "+h.Reason)
+	}
+	return res
+}
+
+type SpamConfig struct {
+	HoldScore float64 // total score that sends content to the moderation queue
+
+	NewAccountAge      time.Duration // accounts younger than this…
+	NewAccountMaxLinks int           // …may post at most this many links
+	MaxLinks           int           // for everyone else
+
+	DuplicateWindow   time.Duration
+	DuplicateMinRunes int // shorter texts are never duplicates
+	DuplicateCopies   int // the same text submitted this many times in the window is held
+
+	BayesThreshold float64 // spam probability that holds content
+	BayesMinDocs   int     // trained documents of each class before the classifier is trusted
+}
+
+func DefaultSpamConfig() SpamConfig {
+	return SpamConfig{
+		HoldScore:          1,
+		NewAccountAge:      72 * time.Hour,
+		NewAccountMaxLinks: 1,
+		MaxLinks:           10,
+		DuplicateWindow:    24 * time.Hour,
+		DuplicateMinRunes:  20,
+		DuplicateCopies:    2,
+		BayesThreshold:     0.9,
+		BayesMinDocs:       20,
+	}
+}
+
+type SpamFilter interface {
+	// Review runs every check. A failing check is logged and skipped: spam that slips through
+	// can still be removed, a forum that rejects everything while the database hiccups cannot.
+	Review(ctx context.Context, s *SpamSubject) *SpamVerdict
+	// Hold puts held content into the moderation queue; commentID is nil for a post
+	Hold(ctx context.Context, postID int64, commentID *int64, authorID int64, v *SpamVerdict) error
+	// Train teaches the classifier a moderator's decision
+	Train(ctx context.Context, text string, isSpam bool) error
+}
+
+// NewSpamFilter runs checks in order; see DefaultSpamChecks for the built-in ones
+func NewSpamFilter(repo repository.SpamRepository, queue repository.ModerationRepository, posts repository.PostRepository,
+	users repository.UserRepository, cfg SpamConfig, checks ...SpamCheck) SpamFilter {
+	return &spamFilter{repo: repo, queue: queue, posts: posts, users: users, cfg: cfg, checks: checks}
+}
+
+// DefaultSpamChecks are the honeypot, link limits, duplicates, banned words and the Bayes classifier
+func DefaultSpamChecks(repo repository.SpamRepository, cfg SpamConfig) []SpamCheck {
+	return []SpamCheck{
+		HoneypotCheck(),
+		LinkLimitCheck(cfg),
+		DuplicateCheck(repo, cfg),
+		WordListCheck(repo),
+		BayesCheck(repo, cfg),
+	}
+}
+
+type spamFilter struct {
+	repo   repository.SpamRepository
+	queue  repository.ModerationRepository
+	posts  repository.PostRepository
+	users  repository.UserRepository
+	cfg    SpamConfig
+	checks []SpamCheck
+}
+
+func (f *spamFilter) Review(ctx context.Context, s *SpamSubject) *SpamVerdict {
+	v := &SpamVerdict{Hits: []SpamHit{}}
+	if s.Author == nil {
+		u, err := f.users.GetUserByID(ctx, s.AuthorID)
+		if err != nil {
+			fmt.Println("spam filter:", err)
+			return v
+		}
+		s.Author = u
+	}
+	// модераторы сами разбирают очередь — их не проверяем
+	if s.Author.HasRole(entity.RoleModerator) {
+		return v
+	}
+	if s.BoardID == 0 && s.PostID != 0 {
+		if p, err := f.posts.GetPostByID(ctx, s.PostID); err == nil {
+			s.BoardID = p.BoardID
+		}
+	}
+	s.Signals = spamSignalsFrom(ctx)
+	for _, c := range f.checks {
+		hit, err := c.Check(ctx, s)
+		if err != nil {
+			fmt.Println("spam check", c.Name()+":", err)
+			continue
+		}
+		if hit == nil {
+			continue
+		}
+		hit.Check = c.Name()
+		v.Hits = append(v.Hits, *hit)
+		v.Score += hit.Score
+		v.Blocked = v.Blocked || hit.Block
+		v.Held = v.Held || hit.Hold
+	}
+	v.Held = !v.Blocked && (v.Held || v.Score >= f.cfg.HoldScore)
+	if v.Held || v.Blocked {
+		kind := "post"
+		if s.Comment {
+			kind = "comment"
+		}
+		fmt.Println("spam filter:", kind, "by", s.Author.Username, v.Reasons(), "blocked:", v.Blocked)
+	}
+	return v
+}
+
+func (f *spamFilter) Hold(ctx context.Context, postID int64, commentID *int64, authorID int64, v *SpamVerdict) error {
+	return f.queue.Enqueue(ctx, &entity.ModerationItem{
+		PostID: postID, CommentID: commentID, AuthorID: authorID, Reasons: v.Reasons(), Score: v.Score,
+	})
+}
+
+func (f *spamFilter) Train(ctx context.Context, text string, isSpam bool) error {
+	return f.repo.Train(ctx, spam.Tokens(text), isSpam)
+}
+
+// HoneypotCheck blocks submissions that filled the hidden field only bots see
+func HoneypotCheck() SpamCheck { return honeypotCheck{} }
+
+type honeypotCheck struct{}
+
+func (honeypotCheck) Name() string { return "honeypot" }
+
+func (honeypotCheck) Check(ctx context.Context, s *SpamSubject) (*SpamHit, error) {
+	if s.Signals.Honeypot == "" {
+		return nil, nil
+	}
+	return &SpamHit{Reason: "hidden field filled", Score: 1, Block: true}, nil
+}
+
+// LinkLimitCheck holds posts with more links than the author's account age allows
+func LinkLimitCheck(cfg SpamConfig) SpamCheck { return linkLimitCheck{cfg: cfg} }
+
+type linkLimitCheck struct{ cfg SpamConfig }
+
+func (linkLimitCheck) Name() string { return "links" }
+
+func (c linkLimitCheck) Check(ctx context.Context, s *SpamSubject) (*SpamHit, error) {
+	n := spam.Links(s.Text())
+	limit := c.cfg.MaxLinks
+	if time.Since(s.Author.CreatedAt) < c.cfg.NewAccountAge {
+		limit = c.cfg.NewAccountMaxLinks
+	}
+	if n <= limit {
+		return nil, nil
+	}
+	return &SpamHit{Reason: fmt.Sprintf("%d links, %d allowed for this account", n, limit), Score: 1}, nil
+}
+
+// DuplicateCheck holds a text already submitted DuplicateCopies times within DuplicateWindow, by anyone
+func DuplicateCheck(repo repository.SpamRepository, cfg SpamConfig) SpamCheck {
+	return &duplicateCheck{repo: repo, cfg: cfg}
+}
+
+type duplicateCheck struct {
+	repo repository.SpamRepository
+	cfg  SpamConfig
+
+	pruneMu  sync.Mutex
+	prunedAt time.Time
+}
+
+func (*duplicateCheck) Name() string { return "duplicate" }
+
+func (c *duplicateCheck) Check(ctx context.Context, s *SpamSubject) (*SpamHit, error) {
+	fp := spam.Fingerprint(s.Title+" "+s.Content, c.cfg.DuplicateMinRunes)
+	if fp == "" {
+		return nil, nil
+	}
+	copies, authors, err := c.repo.CountFingerprint(ctx, fp, time.Now().Add(-c.cfg.DuplicateWindow))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.repo.RecordFingerprint(ctx, fp, s.AuthorID); err != nil {
+		return nil, err
+	}
+	c.prune(ctx)
+	if copies < c.cfg.DuplicateCopies {
+		return nil, nil
+	}
+	return &SpamHit{Reason: fmt.Sprintf("same text posted %d times by %d authors", copies, authors), Score: 1}, nil
+}
+
+// prune drops old fingerprints at most once an hour
+func (c *duplicateCheck) prune(ctx context.Context) {
+	c.pruneMu.Lock()
+	due := time.Since(c.prunedAt) > time.Hour
+	if due {
+		c.prunedAt = time.Now()
+	}
+	c.pruneMu.Unlock()
+	if due {
+		if err := c.repo.PruneFingerprints(ctx, time.Now().Add(-c.cfg.DuplicateWindow)); err != nil {
+			fmt.Println("spam fingerprints prune:", err)
+		}
+	}
+}
+
+// WordListCheck holds content matching a banned word or regular expression of its board
+func WordListCheck(repo repository.SpamRepository) SpamCheck {
+	return &wordListCheck{repo: repo, compiled: map[string]*regexp.Regexp{}}
+}
+
+type wordListCheck struct {
+	repo repository.SpamRepository
+
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp // по id и тексту правила: правило могли удалить и создать заново
+}
+
+func (*wordListCheck) Name() string { return "wordlist" }
+
+func (c *wordListCheck) Check(ctx context.Context, s *SpamSubject) (*SpamHit, error) {
+	rules, err := c.repo.Rules(ctx, s.BoardID)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	text := s.Text()
+	for _, r := range rules {
+		re := c.regexp(r)
+		if re != nil && re.MatchString(text) {
+			return &SpamHit{Reason: fmt.Sprintf("matches rule #%d %q", r.ID, r.Pattern), Score: 1}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *wordListCheck) regexp(r entity.SpamRule) *regexp.Regexp {
+	key := fmt.Sprintf("%d/%t/%s", r.ID, r.Regex, r.Pattern)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if re, ok := c.compiled[key]; ok {
+		return re
+	}
+	re, err := spam.CompileRule(r.Pattern, r.Regex)
+	if err != nil {
+		fmt.Println("spam rule", r.ID, err) // правила проверяются при создании, сюда попадают только старые
+	}
+	c.compiled[key] = re
+	return re
+}
+
+// BayesCheck holds content the classifier trained on moderator decisions considers spam
+func BayesCheck(repo repository.SpamRepository, cfg SpamConfig) SpamCheck {
+	return bayesCheck{repo: repo, cfg: cfg}
+}
+
+type bayesCheck struct {
+	repo repository.SpamRepository
+	cfg  SpamConfig
+}
+
+func (bayesCheck) Name() string { return "bayes" }
+
+func (c bayesCheck) Check(ctx context.Context, s *SpamSubject) (*SpamHit, error) {
+	spamDocs, hamDocs, err := c.repo.Corpus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if spamDocs < c.cfg.BayesMinDocs || hamDocs < c.cfg.BayesMinDocs {
+		return nil, nil // пока мало решений модераторов, классификатор только мешал бы
+	}
+	tokens := spam.Tokens(s.Text())
+	counts, err := c.repo.TokenCounts(ctx, tokens)
+	if err != nil {
+		return nil, err
+	}
+	p := spam.Classify(tokens, counts, spamDocs, hamDocs)
+	if p < c.cfg.BayesThreshold {
+		return nil, nil
+	}
+	return &SpamHit{Reason: fmt.Sprintf("spam probability %.2f", p), Score: 1}, nil
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/spam"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memSpamRepo — правила, отпечатки и обученный корпус в памяти
+type memSpamRepo struct {
+	repository.SpamRepository
+	rules        []entity.SpamRule
+	fingerprints map[string][]int64
+	counts       map[string]spam.Counts
+	docs         [2]int // ham, spam
+}
+
+func newMemSpamRepo() *memSpamRepo {
+	return &memSpamRepo{fingerprints: map[string][]int64{}, counts: map[string]spam.Counts{}}
+}
+
+func (m *memSpamRepo) Rules(ctx context.Context, boardID int64) ([]entity.SpamRule, error) {
+	var res []entity.SpamRule
+	for _, r := range m.rules {
+		if boardID == 0 || r.BoardID == nil || *r.BoardID == boardID {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (m *memSpamRepo) AddRule(ctx context.Context, r *entity.SpamRule) error {
+	r.ID = int64(len(m.rules) + 1)
+	m.rules = append(m.rules, *r)
+	return nil
+}
+
+func (m *memSpamRepo) RecordFingerprint(ctx context.Context, fingerprint string, authorID int64) error {
+	m.fingerprints[fingerprint] = append(m.fingerprints[fingerprint], authorID)
+	return nil
+}
+
+func (m *memSpamRepo) CountFingerprint(ctx context.Context, fingerprint string, since time.Time) (int, int, error) {
+	authors := map[int64]bool{}
+	for _, a := range m.fingerprints[fingerprint] {
+		authors[a] = true
+	}
+	return len(m.fingerprints[fingerprint]), len(authors), nil
+}
+
+func (m *memSpamRepo) PruneFingerprints(ctx context.Context, before time.Time) error { return nil }
+
+func (m *memSpamRepo) TokenCounts(ctx context.Context, tokens []string) (map[string]spam.Counts, error) {
+	res := map[string]spam.Counts{}
+	for _, t := range tokens {
+		if c, ok := m.counts[t]; ok {
+			res[t] = c
+		}
+	}
+	return res, nil
+}
+
+func (m *memSpamRepo) Corpus(ctx context.Context) (int, int, error) { return m.docs[1], m.docs[0], nil }
+
+func (m *memSpamRepo) Train(ctx context.Context, tokens []string, isSpam bool) error {
+	for _, t := range tokens {
+		c := m.counts[t]
+		if isSpam {
+			c.Spam++
+		} else {
+			c.Ham++
+		}
+		m.counts[t] = c
+	}
+	if isSpam {
+		m.docs[1]++
+	} else {
+		m.docs[0]++
+	}
+	return nil
+}
+
+// heldQueueStub запоминает, что фильтр отправил в очередь
+type heldQueueStub struct {
+	repository.ModerationRepository
+	held []entity.ModerationItem
+}
+
+func (q *heldQueueStub) Enqueue(ctx context.Context, item *entity.ModerationItem) error {
+	q.held = append(q.held, *item)
+	return nil
+}
+
+type spamPostRepoStub struct {
+	repository.PostRepository
+	stored []entity.Post
+}
+
+func (r *spamPostRepoStub) CreatePost(ctx context.Context, p *entity.Post) (int64, error) {
+	r.stored = append(r.stored, *p)
+	return int64(len(r.stored)), nil
+}
+
+type spamFixture struct {
+	filter SpamFilter
+	repo   *memSpamRepo
+	queue  *heldQueueStub
+}
+
+const (
+	oldAuthor, newAuthor, modAuthor = 1, 2, 3
+	spamBoard                       = 7
+)
+
+func newSpamFixture() *spamFixture {
+	f := &spamFixture{repo: newMemSpamRepo(), queue: &heldQueueStub{}}
+	users := &memUsers{byID: map[int64]*entity.User{
+		oldAuthor: {ID: oldAuthor, Username: "old", Role: entity.RoleUser, CreatedAt: time.Now().AddDate(-1, 0, 0)},
+		newAuthor: {ID: newAuthor, Username: "new", Role: entity.RoleUser, CreatedAt: time.Now()},
+		modAuthor: {ID: modAuthor, Username: "mod", Role: entity.RoleModerator, CreatedAt: time.Now()},
+	}}
+	posts := &threadPostsStub{posts: map[int64]*entity.Post{1: {ID: 1, BoardID: spamBoard}}}
+	cfg := DefaultSpamConfig()
+	cfg.BayesMinDocs = 2
+	f.filter = NewSpamFilter(f.repo, f.queue, posts, users, cfg, DefaultSpamChecks(f.repo, cfg)...)
+	return f
+}
+
+func TestSpamFilterHoneypotBlocks(t *testing.T) {
+	f := newSpamFixture()
+	ctx := WithSpamSignals(context.Background(), SpamSignals{Honeypot: "http://bot.example"})
+	v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Title: "Привет", Content: "обычный текст"})
+	if !v.Blocked || v.Held || !errors.Is(v.Err(), ErrSpam) {
+		t.Fatalf("filled honeypot: %+v, want blocked with ErrSpam", v)
+	}
+	if v := f.filter.Review(context.Background(), &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: "обычный текст"}); v.Blocked || v.Held {
+		t.Fatalf("no honeypot: %+v", v)
+	}
+}
+
+func TestSpamFilterLimitsLinksOfNewAccounts(t *testing.T) {
+	f := newSpamFixture()
+	text := "смотрите https://a.example и https://b.example"
+	if v := f.filter.Review(context.Background(), &SpamSubject{AuthorID: newAuthor, BoardID: 1, Content: text}); !v.Held {
+		t.Fatalf("two links from a new account: %+v, want held", v)
+	}
+	if v := f.filter.Review(context.Background(), &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: text + " снова"}); v.Held {
+		t.Fatalf("two links from an old account: %+v, want published", v)
+	}
+	// модераторов фильтр не проверяет
+	if v := f.filter.Review(context.Background(), &SpamSubject{AuthorID: modAuthor, BoardID: 1, Content: text + " и ещё"}); v.Held || len(v.Hits) != 0 {
+		t.Fatalf("moderator: %+v, want no checks", v)
+	}
+}
+
+func TestSpamFilterHoldsRepeatedText(t *testing.T) {
+	f := newSpamFixture()
+	ctx := context.Background()
+	texts := []string{"Лучшие часы по низкой цене, пишите в личку", "ЛУЧШИЕ часы по низкой цене!!! Пишите в личку", "лучшие часы по низкой цене — пишите в личку"}
+	for i, text := range texts {
+		v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: text})
+		if want := i >= DefaultSpamConfig().DuplicateCopies; v.Held != want {
+			t.Fatalf("copy %d: held %v, want %v", i+1, v.Held, want)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: "спасибо!"}); v.Held {
+			t.Fatal("a short text was taken for a duplicate")
+		}
+	}
+}
+
+func TestSpamFilterAppliesBoardRules(t *testing.T) {
+	f := newSpamFixture()
+	board := int64(spamBoard)
+	f.repo.rules = []entity.SpamRule{
+		{ID: 1, Pattern: "казино"},
+		{ID: 2, BoardID: &board, Pattern: `скидк[аи]`, Regex: true},
+	}
+	ctx := context.Background()
+	if v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: "Новое КАЗИНО открылось"}); !v.Held {
+		t.Fatalf("rule for all boards: %+v, want held", v)
+	}
+	if v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: "у нас скидки"}); v.Held {
+		t.Fatalf("another board's rule: %+v, want published", v)
+	}
+	// у комментария доска берётся из его поста
+	v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, PostID: 1, Comment: true, Content: "и тут скидка"})
+	if !v.Held || len(v.Reasons()) != 1 || !strings.HasPrefix(v.Reasons()[0], "wordlist: ") {
+		t.Fatalf("comment under the board's post: %+v, want held by the wordlist", v)
+	}
+}
+
+func TestSpamFilterLearnsFromModerators(t *testing.T) {
+	f := newSpamFixture()
+	ctx := context.Background()
+	text := "выигрыш джекпот бонус"
+	if v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: text}); v.Held {
+		t.Fatal("the classifier decided before it was trained")
+	}
+	for _, s := range []string{"джекпот и бонус", "выигрыш бонус сегодня", "джекпот выигрыш"} {
+		f.filter.Train(ctx, s, true)
+	}
+	for _, h := range []string{"ремонт подвески", "вопрос про подвеску", "совет по ремонту"} {
+		f.filter.Train(ctx, h, false)
+	}
+	if v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Title: "Смотрите", Content: text}); !v.Held {
+		t.Fatalf("trained spam words: %+v, want held", v)
+	}
+	if v := f.filter.Review(ctx, &SpamSubject{AuthorID: oldAuthor, BoardID: 1, Content: "ремонт подвески своими руками"}); v.Held {
+		t.Fatalf("trained ham words: %+v, want published", v)
+	}
+}
+
+func TestCreatePostHoldsSuspiciousPosts(t *testing.T) {
+	f := newSpamFixture()
+	repo := &spamPostRepoStub{}
+	s := NewPostService(repo, WithPostSpamFilter(f.filter))
+	ctx := context.Background()
+
+	id, err := s.CreatePost(ctx, &entity.Post{AuthorID: newAuthor, BoardID: 1, Title: "Заработок", Content: "https://a.example https://b.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.stored[0].Status != entity.StatusPending {
+		t.Fatalf("held post stored as %q, want pending", repo.stored[0].Status)
+	}
+	if len(f.queue.held) != 1 || f.queue.held[0].PostID != id || f.queue.held[0].CommentID != nil || len(f.queue.held[0].Reasons) == 0 {
+		t.Fatalf("queue %+v, want the post with its reasons", f.queue.held)
+	}
+
+	ctx = WithSpamSignals(ctx, SpamSignals{Honeypot: "x"})
+	if _, err := s.CreatePost(ctx, &entity.Post{AuthorID: oldAuthor, BoardID: 1, Title: "Бот", Content: "текст"}); !errors.Is(err, ErrSpam) {
+		t.Fatalf("honeypot: %v, want ErrSpam", err)
+	}
+	if len(repo.stored) != 1 {
+		t.Fatal("a blocked post was stored")
+	}
+}
+
+func TestCreateCommentHoldsSuspiciousComments(t *testing.T) {
+	f := newSpamFixture()
+	repo := &threadRepoStub{postStatus: entity.StatusPublished}
+	s := NewCommentService(repo, WithCommentSpamFilter(f.filter))
+
+	id, err := s.CreateComment(context.Background(), &entity.Comment{PostID: 1, AuthorID: newAuthor, Content: "https://a.example https://b.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.comments[0].Status != entity.StatusPending {
+		t.Fatalf("held comment stored as %q, want pending", repo.comments[0].Status)
+	}
+	if len(f.queue.held) != 1 || f.queue.held[0].CommentID == nil || *f.queue.held[0].CommentID != id {
+		t.Fatalf("queue %+v, want the comment", f.queue.held)
+	}
+}
+
+func TestModerationDecideTrainsAndClosesOnce(t *testing.T) {
+	f := newSpamFixture()
+	queue := &moderationQueueStub{items: map[int64]*entity.ModerationItem{
+		1: {ID: 1, PostID: 10, AuthorID: newAuthor, Title: "Джекпот", Content: "бонус", Status: entity.ModerationPending},
+	}}
+	s := NewModerationService(queue, f.repo, f.filter, postStatusStub{}, commentStatusStub{})
+	ctx := context.Background()
+	mod := &entity.User{ID: modAuthor, Role: entity.RoleModerator}
+
+	if err := s.Decide(ctx, &entity.User{ID: oldAuthor, Role: entity.RoleUser}, 1, entity.ModerationSpam); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("user deciding: %v, want ErrForbidden", err)
+	}
+	if err := s.Decide(ctx, mod, 1, "maybe"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("unknown decision: %v, want ErrInvalidInput", err)
+	}
+	if err := s.Decide(ctx, mod, 1, entity.ModerationSpam); err != nil {
+		t.Fatal(err)
+	}
+	if f.repo.docs[1] != 1 || f.repo.counts["джекпот"].Spam != 1 {
+		t.Fatalf("classifier after a spam decision: %v docs, counts %v", f.repo.docs, f.repo.counts)
+	}
+	if err := s.Decide(ctx, mod, 1, entity.ModerationApproved); !errors.Is(err, ErrAlreadyDecided) {
+		t.Fatalf("second decision: %v, want ErrAlreadyDecided", err)
+	}
+	if f.repo.docs != [2]int{0, 1} {
+		t.Fatal("a refused decision trained the classifier")
+	}
+}
+
+func TestModerationAddRuleValidates(t *testing.T) {
+	f := newSpamFixture()
+	s := NewModerationService(&moderationQueueStub{}, f.repo, f.filter, postStatusStub{}, commentStatusStub{})
+	ctx := context.Background()
+	mod := &entity.User{ID: modAuthor, Role: entity.RoleModerator}
+
+	if err := s.AddRule(ctx, mod, &entity.SpamRule{Pattern: "(broken", Regex: true}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("broken regex: %v, want ErrInvalidInput", err)
+	}
+	if err := s.AddRule(ctx, &entity.User{ID: oldAuthor, Role: entity.RoleUser}, &entity.SpamRule{Pattern: "казино"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("user adding a rule: %v, want ErrForbidden", err)
+	}
+	zero := int64(0)
+	if err := s.AddRule(ctx, mod, &entity.SpamRule{Pattern: "  казино ", BoardID: &zero}); err != nil {
+		t.Fatal(err)
+	}
+	r := f.repo.rules[0]
+	if r.Pattern != "казино" || r.BoardID != nil || r.CreatedBy == nil || *r.CreatedBy != modAuthor {
+		t.Fatalf("stored rule %+v, want the trimmed pattern for all boards by the moderator", r)
+	}
+}
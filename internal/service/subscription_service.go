@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/jobs"
+	"forum1/internal/repository"
+	"strconv"
+)
+
+// SubscriptionService keeps users' subscriptions to boards, clubs, threads and authors and
+// notifies subscribers of new posts and comments. Authors are subscribed to their own threads.
+type SubscriptionService interface {
+	Subscribe(ctx context.Context, actor *entity.User, target string, targetID int64) (*entity.Subscription, error)
+	// Unsubscribe removes a subscription; sql.ErrNoRows when there was none
+	Unsubscribe(ctx context.Context, actor *entity.User, target string, targetID int64) error
+	// MuteThread silences (or unsilences) a thread while keeping it on the list; a thread one
+	// never subscribed to can be muted as well, e.g. to stop replies to one's own comment there
+	MuteThread(ctx context.Context, actor *entity.User, postID int64, muted bool) (*entity.Subscription, error)
+	List(ctx context.Context, actor *entity.User) ([]entity.Subscription, error)
+	// Get is the user's subscription to a target, nil when there is none
+	Get(ctx context.Context, userID int64, target string, targetID int64) (*entity.Subscription, error)
+
+	// NotifyPost and NotifyComment fan a new post or comment out to subscribers;
+	// with WithSubscriptionJobs they run as background jobs
+	NotifyPost(ctx context.Context, postID int64) error
+	NotifyComment(ctx context.Context, commentID int64) error
+
+	PostListener
+	CommentListener
+}
+
+type SubscriptionOption func(*subscriptionService)
+
+// WithSubscriptionJobs moves the fan-out of notifications to the job queue, so a board
+// with thousands of subscribers does not slow down posting
+func WithSubscriptionJobs(runner *jobs.Runner) SubscriptionOption {
+	return func(s *subscriptionService) {
+		s.jobs = runner
+		opts := jobs.Options{Queue: "notifications"}
+		jobs.Handle(runner, jobSubscriptionPost, opts, func(ctx context.Context, p subscriptionJob) error {
+			return s.NotifyPost(ctx, p.ID)
+		})
+		jobs.Handle(runner, jobSubscriptionComment, opts, func(ctx context.Context, p subscriptionJob) error {
+			return s.NotifyComment(ctx, p.ID)
+		})
+	}
+}
+
+const (
+	jobSubscriptionPost    = "subscriptions.post"
+	jobSubscriptionComment = "subscriptions.comment"
+)
+
+type subscriptionJob struct {
+	ID int64 `json:"id"`
+}
+
+func NewSubscriptionService(repo repository.SubscriptionRepository, posts repository.PostRepository,
+	comments repository.CommentRepository, boards repository.BoardRepository, clubs repository.ClubRepository,
+	users repository.UserRepository, notifications NotificationService, opts ...SubscriptionOption) SubscriptionService {
+	s := &subscriptionService{repo: repo, posts: posts, comments: comments, boards: boards, clubs: clubs,
+		users: users, notifications: notifications}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+type subscriptionService struct {
+	repo          repository.SubscriptionRepository
+	posts         repository.PostRepository
+	comments      repository.CommentRepository
+	boards        repository.BoardRepository
+	clubs         repository.ClubRepository
+	users         repository.UserRepository
+	notifications NotificationService
+	jobs          *jobs.Runner
+}
+
+// checkTarget makes sure the target exists and the actor may see it
+func (s *subscriptionService) checkTarget(ctx context.Context, actor *entity.User, target string, id int64) error {
+	if !entity.ValidSubscriptionTarget(target) || id <= 0 {
+		return ErrInvalidInput
+	}
+	var err error
+	switch target {
+	case entity.SubscribeBoard:
+		_, err = s.boards.GetByID(ctx, id)
+	case entity.SubscribeClub:
+		_, err = s.clubs.GetByID(ctx, id)
+	case entity.SubscribeUser:
+		if id == actor.ID {
+			return fmt.Errorf("%w: cannot follow yourself", ErrInvalidInput)
+		}
+		_, err = s.users.GetUserByID(ctx, id)
+	case entity.SubscribePost:
+		var post *entity.Post
+		if post, err = s.posts.GetPostByID(ctx, id); err == nil &&
+			post.Status != entity.StatusPublished && post.AuthorID != actor.ID && !isModerator(actor) {
+			return ErrForbidden
+		}
+	}
+	return err
+}
+
+func (s *subscriptionService) Subscribe(ctx context.Context, actor *entity.User, target string, targetID int64) (*entity.Subscription, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	if err := s.checkTarget(ctx, actor, target, targetID); err != nil {
+		return nil, err
+	}
+	sub := &entity.Subscription{UserID: actor.ID, Target: target, TargetID: targetID}
+	if err := s.repo.Save(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *subscriptionService) Unsubscribe(ctx context.Context, actor *entity.User, target string, targetID int64) error {
+	if actor == nil {
+		return ErrForbidden
+	}
+	if !entity.ValidSubscriptionTarget(target) || targetID <= 0 {
+		return ErrInvalidInput
+	}
+	return s.repo.Delete(ctx, actor.ID, target, targetID)
+}
+
+func (s *subscriptionService) MuteThread(ctx context.Context, actor *entity.User, postID int64, muted bool) (*entity.Subscription, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	if err := s.checkTarget(ctx, actor, entity.SubscribePost, postID); err != nil {
+		return nil, err
+	}
+	sub := &entity.Subscription{UserID: actor.ID, Target: entity.SubscribePost, TargetID: postID, Muted: muted}
+	if err := s.repo.Save(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *subscriptionService) List(ctx context.Context, actor *entity.User) ([]entity.Subscription, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	return s.repo.List(ctx, actor.ID)
+}
+
+func (s *subscriptionService) Get(ctx context.Context, userID int64, target string, targetID int64) (*entity.Subscription, error) {
+	if userID == 0 {
+		return nil, nil
+	}
+	sub, err := s.repo.Get(ctx, userID, target, targetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return sub, err
+}
+
+// PostCreated subscribes the author to the new thread and tells the followers about it
+func (s *subscriptionService) PostCreated(ctx context.Context, p *entity.Post) {
+	if err := s.repo.Save(ctx, &entity.Subscription{UserID: p.AuthorID, Target: entity.SubscribePost, TargetID: p.ID}); err != nil {
+		fmt.Println("subscriptions:", err)
+	}
+	s.dispatch(ctx, jobSubscriptionPost, p.ID, s.NotifyPost)
+}
+
+func (s *subscriptionService) CommentCreated(ctx context.Context, c *entity.Comment) {
+	s.dispatch(ctx, jobSubscriptionComment, c.ID, s.NotifyComment)
+}
+
+func (s *subscriptionService) CommentDeleted(ctx context.Context, c *entity.Comment, byUserID int64) {
+}
+
+// dispatch enqueues the fan-out, or runs it right away without a job queue
+func (s *subscriptionService) dispatch(ctx context.Context, kind string, id int64, run func(context.Context, int64) error) {
+	var err error
+	if s.jobs != nil {
+		_, err = s.jobs.Enqueue(ctx, kind, subscriptionJob{ID: id}, jobs.Unique(kind+":"+strconv.FormatInt(id, 10)))
+	} else {
+		err = run(ctx, id)
+	}
+	if err != nil {
+		fmt.Println("subscriptions:", err)
+	}
+}
+
+// NotifyPost is idempotent: notifications carry a dedupe key, so a retried job does not repeat them
+func (s *subscriptionService) NotifyPost(ctx context.Context, postID int64) error {
+	post, err := s.posts.GetPostByID(ctx, postID)
+	if err != nil {
+		return ignoreNoRows(err)
+	}
+	userIDs, err := s.repo.PostSubscribers(ctx, postID)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		err := s.notifications.Notify(ctx, &entity.Notification{
+			UserID: userID, Type: entity.NotificationSubscription, ActorID: &post.AuthorID, PostID: &post.ID,
+			Message:   "Новый пост «" + post.Title + "»",
+			DedupeKey: "subscription:post:" + strconv.FormatInt(post.ID, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotifyComment tells thread watchers about a comment. The post's author and the author of the
+// parent comment are skipped: they get reply_post and reply_comment from NotificationService.
+func (s *subscriptionService) NotifyComment(ctx context.Context, commentID int64) error {
+	c, err := s.comments.GetCommentByID(ctx, commentID)
+	if err != nil {
+		return ignoreNoRows(err)
+	}
+	post, err := s.posts.GetPostByID(ctx, c.PostID)
+	if err != nil {
+		return ignoreNoRows(err)
+	}
+	skip := map[int64]bool{c.AuthorID: true, post.AuthorID: true}
+	if c.ParentID != nil {
+		if parent, err := s.comments.GetCommentByID(ctx, *c.ParentID); err == nil {
+			skip[parent.AuthorID] = true
+		}
+	}
+	userIDs, err := s.repo.ThreadWatchers(ctx, c.PostID)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if skip[userID] {
+			continue
+		}
+		err := s.notifications.Notify(ctx, &entity.Notification{
+			UserID: userID, Type: entity.NotificationThreadReply, ActorID: &c.AuthorID, PostID: &c.PostID, CommentID: &c.ID,
+			Message:   "«" + post.Title + "»: " + excerpt(c.Content, 120),
+			DedupeKey: "subscription:comment:" + strconv.FormatInt(c.ID, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreNoRows: пост или комментарий успели удалить — уведомлять не о чем
+func ignoreNoRows(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"testing"
+)
+
+type subKey struct {
+	userID   int64
+	target   string
+	targetID int64
+}
+
+// memSubs хранит подписки как subscription_repo; подписчиков доски задаёт тест
+type memSubs struct {
+	repository.SubscriptionRepository
+	subs      map[subKey]entity.Subscription
+	followers []int64 // PostSubscribers любого поста
+}
+
+func newMemSubs() *memSubs { return &memSubs{subs: map[subKey]entity.Subscription{}} }
+
+func (m *memSubs) Save(ctx context.Context, s *entity.Subscription) error {
+	m.subs[subKey{s.UserID, s.Target, s.TargetID}] = *s
+	return nil
+}
+
+func (m *memSubs) Delete(ctx context.Context, userID int64, target string, targetID int64) error {
+	k := subKey{userID, target, targetID}
+	if _, ok := m.subs[k]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.subs, k)
+	return nil
+}
+
+func (m *memSubs) Get(ctx context.Context, userID int64, target string, targetID int64) (*entity.Subscription, error) {
+	s, ok := m.subs[subKey{userID, target, targetID}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &s, nil
+}
+
+func (m *memSubs) PostSubscribers(ctx context.Context, postID int64) ([]int64, error) {
+	return m.followers, nil
+}
+
+func (m *memSubs) ThreadWatchers(ctx context.Context, postID int64) ([]int64, error) {
+	var ids []int64
+	for k, s := range m.subs {
+		if k.target == entity.SubscribePost && k.targetID == postID && !s.Muted {
+			ids = append(ids, k.userID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memSubs) ThreadMuted(ctx context.Context, userID, postID int64) (bool, error) {
+	s, ok := m.subs[subKey{userID, entity.SubscribePost, postID}]
+	return ok && s.Muted, nil
+}
+
+type subscriptionFixture struct {
+	s        SubscriptionService
+	subs     *memSubs
+	posts    *threadPostsStub
+	comments *threadRepoStub
+	notes    *notificationRepoStub
+}
+
+const subAuthor, subReader, subReplier = 1, 2, 3
+
+func newSubscriptionFixture() *subscriptionFixture {
+	f := &subscriptionFixture{
+		subs: newMemSubs(),
+		posts: &threadPostsStub{posts: map[int64]*entity.Post{
+			1: {ID: 1, BoardID: 1, AuthorID: subAuthor, Title: "Тред", Status: entity.StatusPublished},
+			2: {ID: 2, BoardID: 1, AuthorID: subAuthor, Title: "Черновик", Status: entity.StatusDraft},
+		}},
+		comments: &threadRepoStub{},
+		notes:    &notificationRepoStub{},
+	}
+	users := &memUsers{byID: map[int64]*entity.User{
+		subAuthor: {ID: subAuthor, Username: "author"}, subReader: {ID: subReader, Username: "reader"},
+	}}
+	notifications := NewNotificationService(f.notes, f.posts, f.comments, nil, WithNotificationMutes(f.subs))
+	f.s = NewSubscriptionService(f.subs, f.posts, f.comments, automodBoardsStub{}, automodClubsStub{}, users, notifications)
+	return f
+}
+
+func subUser(id int64) *entity.User { return &entity.User{ID: id, Role: entity.RoleUser} }
+
+func TestSubscribeChecksTheTarget(t *testing.T) {
+	f := newSubscriptionFixture()
+	ctx := context.Background()
+
+	if _, err := f.s.Subscribe(ctx, nil, entity.SubscribeBoard, 1); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("guest: %v, want ErrForbidden", err)
+	}
+	for name, c := range map[string]struct {
+		target string
+		id     int64
+		want   error
+	}{
+		"unknown target":       {"planet", 1, ErrInvalidInput},
+		"yourself":             {entity.SubscribeUser, subReader, ErrInvalidInput},
+		"missing board":        {entity.SubscribeBoard, 99, sql.ErrNoRows},
+		"someone else's draft": {entity.SubscribePost, 2, ErrForbidden},
+	} {
+		if _, err := f.s.Subscribe(ctx, subUser(subReader), c.target, c.id); !errors.Is(err, c.want) {
+			t.Errorf("%s: %v, want %v", name, err, c.want)
+		}
+	}
+	if len(f.subs.subs) != 0 {
+		t.Fatalf("refused subscriptions were stored: %v", f.subs.subs)
+	}
+
+	for _, c := range []struct {
+		target string
+		id     int64
+	}{{entity.SubscribeBoard, 1}, {entity.SubscribeClub, 2}, {entity.SubscribeUser, subAuthor}, {entity.SubscribePost, 1}} {
+		if _, err := f.s.Subscribe(ctx, subUser(subReader), c.target, c.id); err != nil {
+			t.Errorf("%s %d: %v", c.target, c.id, err)
+		}
+	}
+	if _, err := f.s.Subscribe(ctx, subUser(subAuthor), entity.SubscribePost, 2); err != nil {
+		t.Fatalf("author to their own draft: %v", err)
+	}
+
+	if err := f.s.Unsubscribe(ctx, subUser(subReader), entity.SubscribeBoard, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.s.Unsubscribe(ctx, subUser(subReader), entity.SubscribeBoard, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second unsubscribe: %v, want sql.ErrNoRows", err)
+	}
+	if sub, err := f.s.Get(ctx, subReader, entity.SubscribeBoard, 1); sub != nil || err != nil {
+		t.Fatalf("Get after unsubscribing: %+v, %v; want nil, nil", sub, err)
+	}
+}
+
+func TestNewPostsReachFollowersOnce(t *testing.T) {
+	f := newSubscriptionFixture()
+	f.subs.followers = []int64{subReader, subReplier}
+	ctx := context.Background()
+
+	f.s.PostCreated(ctx, f.posts.posts[1])
+	if _, ok := f.subs.subs[subKey{subAuthor, entity.SubscribePost, 1}]; !ok {
+		t.Fatal("the author was not subscribed to their thread")
+	}
+	// повтор задачи не дублирует уведомления
+	if err := f.s.NotifyPost(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.notes.created) != 2 {
+		t.Fatalf("%d notifications, want one per follower", len(f.notes.created))
+	}
+	for _, n := range f.notes.created {
+		if n.Type != entity.NotificationSubscription || *n.ActorID != subAuthor {
+			t.Fatalf("notification %+v", n)
+		}
+	}
+	if err := f.s.NotifyPost(ctx, 404); err != nil {
+		t.Fatalf("deleted post: %v, want it skipped", err)
+	}
+}
+
+func TestThreadWatchersSkipRepliedAndMuted(t *testing.T) {
+	f := newSubscriptionFixture()
+	ctx := context.Background()
+	const watcher, muter = 4, 5
+	for _, id := range []int64{subAuthor, subReader, subReplier, watcher} {
+		f.subs.Save(ctx, &entity.Subscription{UserID: id, Target: entity.SubscribePost, TargetID: 1})
+	}
+	if _, err := f.s.MuteThread(ctx, subUser(muter), 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := int64(1)
+	f.comments.comments = []entity.Comment{
+		{ID: 1, PostID: 1, AuthorID: subReader, Content: "вопрос"},
+		{ID: 2, PostID: 1, AuthorID: subReplier, ParentID: &parent, Content: "ответ"},
+	}
+	f.s.CommentCreated(ctx, &f.comments.comments[1])
+
+	// автор поста и автор родительского комментария получат reply_* от NotificationService
+	if len(f.notes.created) != 1 || f.notes.created[0].UserID != watcher || f.notes.created[0].Type != entity.NotificationThreadReply {
+		t.Fatalf("notifications %+v, want only the plain watcher", f.notes.created)
+	}
+
+	// заглушённый тред молчит и для ответов на свой комментарий, но упоминания доходят
+	notifications := NewNotificationService(f.notes, f.posts, f.comments, nil, WithNotificationMutes(f.subs))
+	postID := int64(1)
+	f.notes.created = nil
+	for _, typ := range []string{entity.NotificationReplyComment, entity.NotificationMention} {
+		notifications.Notify(ctx, &entity.Notification{UserID: muter, Type: typ, PostID: &postID, DedupeKey: typ})
+	}
+	if len(f.notes.created) != 1 || f.notes.created[0].Type != entity.NotificationMention {
+		t.Fatalf("muted thread: %+v, want only the mention", f.notes.created)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"time"
+)
+
+// Thread actions of moderators
+const (
+	ThreadPin       = "pin"
+	ThreadUnpin     = "unpin"
+	ThreadLock      = "lock"
+	ThreadUnlock    = "unlock"
+	ThreadArchive   = "archive"
+	ThreadUnarchive = "unarchive"
+)
+
+// ThreadService pins, locks and archives threads, and archives the ones nobody writes to anymore
+type ThreadService interface {
+	// Apply runs one of the Thread* actions on a post; position is only used by pin
+	Apply(ctx context.Context, actor *entity.User, postID int64, action string, position int) (*entity.Post, error)
+	// ArchiveInactive archives threads without comments for ArchiveAfter; it runs as a cron job
+	ArchiveInactive(ctx context.Context) (int64, error)
+}
+
+type ThreadConfig struct {
+	ArchiveAfter time.Duration // без новых комментариев столько — в архив; 0 — не архивировать
+}
+
+func DefaultThreadConfig() ThreadConfig {
+	return ThreadConfig{ArchiveAfter: 180 * 24 * time.Hour}
+}
+
+type ThreadOption func(*threadService)
+
+// WithThreadListener tells l about actions that changed a thread
+func WithThreadListener(l ModerationListener) ThreadOption {
+	return func(s *threadService) { s.listeners = append(s.listeners, l) }
+}
+
+func NewThreadService(posts repository.PostRepository, cfg ThreadConfig, opts ...ThreadOption) ThreadService {
+	s := &threadService{posts: posts, cfg: cfg}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+type threadService struct {
+	posts     repository.PostRepository
+	cfg       ThreadConfig
+	listeners []ModerationListener
+}
+
+func (s *threadService) Apply(ctx context.Context, actor *entity.User, postID int64, action string, position int) (*entity.Post, error) {
+	if !isModerator(actor) {
+		return nil, ErrForbidden
+	}
+	if postID <= 0 || position < 0 {
+		return nil, ErrInvalidInput
+	}
+	before, err := s.posts.GetPostByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	switch action {
+	case ThreadPin:
+		err = s.posts.PinPost(ctx, postID, position)
+	case ThreadUnpin:
+		err = s.posts.UnpinPost(ctx, postID)
+	case ThreadLock, ThreadUnlock:
+		err = s.posts.SetPostLocked(ctx, postID, action == ThreadLock)
+	case ThreadArchive, ThreadUnarchive:
+		err = s.posts.SetPostArchived(ctx, postID, action == ThreadArchive)
+	default:
+		return nil, ErrInvalidInput
+	}
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.posts.GetPostByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	// повторный lock или перестановка закреплённого поста — не новость для автора
+	if before.Locked() != after.Locked() || before.Pinned() != after.Pinned() || before.Archived() != after.Archived() {
+		for _, l := range s.listeners {
+			l.ThreadChanged(ctx, after, action, actor.ID)
+		}
+	}
+	return after, nil
+}
+
+func (s *threadService) ArchiveInactive(ctx context.Context) (int64, error) {
+	if s.cfg.ArchiveAfter <= 0 {
+		return 0, nil
+	}
+	n, err := s.posts.ArchiveInactive(ctx, time.Now().Add(-s.cfg.ArchiveAfter))
+	if n > 0 {
+		fmt.Println("archived inactive threads:", n)
+	}
+	return n, err
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"testing"
+	"time"
+)
+
+// closedPostsStub — пост в состоянии, которое задаёт тест, и срок последнего ArchiveInactive
+type closedPostsStub struct {
+	threadPostsStub
+	locked, archived bool
+	votes            int
+	archiveBefore    time.Time
+}
+
+func (r *closedPostsStub) PostClosed(ctx context.Context, id int64) (string, bool, bool, error) {
+	return entity.StatusPublished, r.locked, r.archived, nil
+}
+
+func (r *closedPostsStub) SetPostVote(ctx context.Context, postID, userID int64, value int) error {
+	r.votes++
+	return nil
+}
+
+func (r *closedPostsStub) ArchiveInactive(ctx context.Context, before time.Time) (int64, error) {
+	r.archiveBefore = before
+	return 2, nil
+}
+
+func TestThreadClosedOrder(t *testing.T) {
+	cases := []struct {
+		status           string
+		locked, archived bool
+		want             error
+	}{
+		{entity.StatusPublished, false, false, nil},
+		{entity.StatusPublished, true, false, ErrPostLocked},
+		{entity.StatusPublished, false, true, ErrPostArchived},
+		{entity.StatusPublished, true, true, ErrPostArchived}, // архив важнее: разблокировка его не откроет
+		{entity.StatusPending, true, true, ErrPostUnpublished},
+	}
+	for _, c := range cases {
+		if err := threadClosed(c.status, c.locked, c.archived); err != c.want {
+			t.Errorf("threadClosed(%s, locked %v, archived %v) = %v, want %v", c.status, c.locked, c.archived, err, c.want)
+		}
+	}
+}
+
+func TestClosedThreadsRefuseVotes(t *testing.T) {
+	ctx := context.Background()
+	for name, want := range map[string]error{"locked": ErrPostLocked, "archived": ErrPostArchived} {
+		repo := &closedPostsStub{locked: name == "locked", archived: name == "archived"}
+		if err := NewPostService(repo).SetPostVote(ctx, 1, 2, 1); !errors.Is(err, want) || repo.votes != 0 {
+			t.Errorf("vote on a %s post: %v, %d stored; want %v", name, err, repo.votes, want)
+		}
+	}
+
+	comments := &threadRepoStub{postStatus: entity.StatusPublished, locked: true, comments: []entity.Comment{
+		{ID: 1, PostID: 1, AuthorID: 3, Content: "c", Status: entity.StatusPublished},
+	}}
+	if err := NewCommentService(comments).SetCommentVote(ctx, 1, 2, 1); !errors.Is(err, ErrPostLocked) || comments.votes != 0 {
+		t.Fatalf("comment vote in a locked thread: %v, want ErrPostLocked", err)
+	}
+}
+
+func TestThreadApplyValidates(t *testing.T) {
+	posts := &threadPostsStub{posts: map[int64]*entity.Post{1: {ID: 1, AuthorID: 5}}}
+	s := NewThreadService(posts, DefaultThreadConfig())
+	mod := &entity.User{ID: 9, Role: entity.RoleModerator}
+	ctx := context.Background()
+
+	if _, err := s.Apply(ctx, nil, 1, ThreadLock, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("guest: %v, want ErrForbidden", err)
+	}
+	for name, call := range map[string]func() error{
+		"unknown action":    func() error { _, err := s.Apply(ctx, mod, 1, "delete", 0); return err },
+		"negative position": func() error { _, err := s.Apply(ctx, mod, 1, ThreadPin, -1); return err },
+		"no post id":        func() error { _, err := s.Apply(ctx, mod, 0, ThreadLock, 0); return err },
+	} {
+		if err := call(); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: %v, want ErrInvalidInput", name, err)
+		}
+	}
+
+	p, err := s.Apply(ctx, mod, 1, ThreadPin, 2)
+	if err != nil || !p.Pinned() || *p.PinPosition != 2 {
+		t.Fatalf("pin: %+v, %v; want pinned at 2", p, err)
+	}
+	if p, _ := s.Apply(ctx, mod, 1, ThreadUnpin, 0); p.Pinned() {
+		t.Fatal("unpin left the post pinned")
+	}
+	if p, _ := s.Apply(ctx, mod, 1, ThreadArchive, 0); !p.Archived() {
+		t.Fatal("archive did not archive")
+	}
+}
+
+func TestArchiveInactiveUsesTheConfiguredAge(t *testing.T) {
+	repo := &closedPostsStub{}
+	n, err := NewThreadService(repo, ThreadConfig{ArchiveAfter: 30 * 24 * time.Hour}).ArchiveInactive(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("ArchiveInactive: %d, %v", n, err)
+	}
+	if age := time.Since(repo.archiveBefore); age < 30*24*time.Hour || age > 30*24*time.Hour+time.Minute {
+		t.Fatalf("archived threads inactive since %v, want 30 days ago", repo.archiveBefore)
+	}
+
+	repo = &closedPostsStub{}
+	if n, _ := NewThreadService(repo, ThreadConfig{}).ArchiveInactive(context.Background()); n != 0 || !repo.archiveBefore.IsZero() {
+		t.Fatal("ArchiveAfter 0 must turn archiving off")
+	}
+}
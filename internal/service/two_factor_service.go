@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"forum1/internal/totp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCode        = errors.New("invalid two-factor code")
+	ErrTwoFactorRequired  = errors.New("two-factor authentication is required for your role")
+	ErrTwoFactorEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotActive = errors.New("two-factor authentication is not enabled")
+)
+
+const (
+	loginChallengeTTL  = 5 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeDigest = "recovery_code"
+)
+
+// throttleTwoFactorPerUser caps code guesses per account: the password is already known to whoever is guessing
+var throttleTwoFactorPerUser = throttle{10, 15 * time.Minute}
+
+type TwoFactorConfig struct {
+	Issuer string // shown in the authenticator app
+	// RequiredRole makes 2FA mandatory for this role and above; "" means optional for everyone
+	RequiredRole     string
+	TrustedDeviceTTL time.Duration
+	Secret           []byte // AUTH_SECRET: encrypts TOTP secrets, keys recovery code hashes
+}
+
+func DefaultTwoFactorConfig() TwoFactorConfig {
+	return TwoFactorConfig{
+		Issuer:           "Forum",
+		RequiredRole:     entity.RoleModerator,
+		TrustedDeviceTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// TwoFactorStatus is what the security settings page shows
+type TwoFactorStatus struct {
+	Enabled           bool                   `json:"enabled"`
+	Required          bool                   `json:"required"`
+	RecoveryCodesLeft int                    `json:"recovery_codes_left"`
+	TrustedDevices    []entity.TrustedDevice `json:"trusted_devices"`
+}
+
+// TwoFactorEnrollment is handed to the user once, to be scanned or typed into an authenticator app
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth://, encode it as a QR code
+}
+
+type TwoFactorService interface {
+	// Required reports whether the policy makes 2FA mandatory for the user
+	Required(u *entity.User) bool
+	Status(ctx context.Context, u *entity.User) (*TwoFactorStatus, error)
+	// BeginEnroll generates a new secret; 2FA is enabled only after ConfirmEnroll
+	BeginEnroll(ctx context.Context, u *entity.User) (*TwoFactorEnrollment, error)
+	// ConfirmEnroll checks the first code from the app, enables 2FA and returns the recovery codes
+	ConfirmEnroll(ctx context.Context, u *entity.User, code string) ([]string, error)
+	Disable(ctx context.Context, u *entity.User, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, u *entity.User, code string) ([]string, error)
+
+	// StartLogin is called after a correct password; the returned challenge is exchanged
+	// for the user by CompleteLogin together with a TOTP or recovery code
+	StartLogin(ctx context.Context, u *entity.User) (string, error)
+	CompleteLogin(ctx context.Context, challenge, code, ip string) (*entity.User, error)
+
+	// TrustDevice remembers the browser so the second factor is skipped there; returns the cookie token
+	TrustDevice(ctx context.Context, userID int64, userAgent string) (string, time.Time, error)
+	IsTrustedDevice(ctx context.Context, userID int64, token string) bool
+	ForgetDevices(ctx context.Context, userID int64) error
+}
+
+func NewTwoFactorService(repo repository.TwoFactorRepository, users repository.UserRepository,
+	tokens repository.TokenRepository, throttles repository.ThrottleRepository, cfg TwoFactorConfig) TwoFactorService {
+	def := DefaultTwoFactorConfig()
+	if cfg.Issuer == "" {
+		cfg.Issuer = def.Issuer
+	}
+	if cfg.TrustedDeviceTTL <= 0 {
+		cfg.TrustedDeviceTTL = def.TrustedDeviceTTL
+	}
+	key := sha256.Sum256(append([]byte("totp\x00"), cfg.Secret...))
+	return &twoFactorService{
+		repo:      repo,
+		users:     users,
+		tokens:    tokens,
+		throttles: throttles,
+		cfg:       cfg,
+		signer:    tokenSigner{secret: cfg.Secret},
+		key:       key[:],
+	}
+}
+
+type twoFactorService struct {
+	repo      repository.TwoFactorRepository
+	users     repository.UserRepository
+	tokens    repository.TokenRepository
+	throttles repository.ThrottleRepository
+	cfg       TwoFactorConfig
+	signer    tokenSigner
+	key       []byte
+}
+
+func (s *twoFactorService) allow(ctx context.Context, action, key string, t throttle) error {
+	return t.allow(ctx, s.throttles, action, key)
+}
+
+func (s *twoFactorService) Required(u *entity.User) bool {
+	return s.cfg.RequiredRole != "" && u.HasRole(s.cfg.RequiredRole)
+}
+
+func (s *twoFactorService) Status(ctx context.Context, u *entity.User) (*TwoFactorStatus, error) {
+	st := &TwoFactorStatus{Enabled: u.TwoFactorEnabled(), Required: s.Required(u), TrustedDevices: []entity.TrustedDevice{}}
+	if !st.Enabled {
+		return st, nil
+	}
+	n, err := s.repo.CountRecoveryCodes(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	st.RecoveryCodesLeft = n
+	if st.TrustedDevices, err = s.repo.ListTrustedDevices(ctx, u.ID); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *twoFactorService) BeginEnroll(ctx context.Context, u *entity.User) (*TwoFactorEnrollment, error) {
+	if u.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetPendingSecret(ctx, u.ID, sealed); err == sql.ErrNoRows {
+		return nil, ErrTwoFactorEnabled // включили в другой вкладке
+	} else if err != nil {
+		return nil, err
+	}
+	return &TwoFactorEnrollment{Secret: secret, URI: totp.ProvisioningURI(s.cfg.Issuer, u.Username, secret)}, nil
+}
+
+func (s *twoFactorService) ConfirmEnroll(ctx context.Context, u *entity.User, code string) ([]string, error) {
+	if u.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if err := s.checkTOTP(ctx, u.ID, code); err != nil {
+		return nil, err
+	}
+	codes, err := s.newRecoveryCodes(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Enable(ctx, u.ID); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *twoFactorService) Disable(ctx context.Context, u *entity.User, code string) error {
+	if !u.TwoFactorEnabled() {
+		return ErrTwoFactorNotActive
+	}
+	if s.Required(u) {
+		return ErrTwoFactorRequired
+	}
+	if err := s.verify(ctx, u.ID, code); err != nil {
+		return err
+	}
+	return s.repo.Disable(ctx, u.ID)
+}
+
+func (s *twoFactorService) RegenerateRecoveryCodes(ctx context.Context, u *entity.User, code string) ([]string, error) {
+	if !u.TwoFactorEnabled() {
+		return nil, ErrTwoFactorNotActive
+	}
+	if err := s.verify(ctx, u.ID, code); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(ctx, u.ID)
+}
+
+func (s *twoFactorService) StartLogin(ctx context.Context, u *entity.User) (string, error) {
+	token, hash, err := s.signer.New(entity.TokenLogin2FA)
+	if err != nil {
+		return "", err
+	}
+	if err := s.tokens.Create(ctx, u.ID, entity.TokenLogin2FA, hash, "", time.Now().Add(loginChallengeTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *twoFactorService) CompleteLogin(ctx context.Context, challenge, code, ip string) (*entity.User, error) {
+	if err := s.allow(ctx, "token_use", ip, throttleTokenUsePerIP); err != nil {
+		return nil, err
+	}
+	hash, ok := s.signer.Check(entity.TokenLogin2FA, challenge)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	// код проверяем до того, как потратить challenge: с опечаткой можно попробовать ещё раз
+	t, err := s.tokens.Peek(ctx, entity.TokenLogin2FA, hash)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verify(ctx, t.UserID, code); err != nil {
+		return nil, err
+	}
+	if _, err := s.tokens.Consume(ctx, entity.TokenLogin2FA, hash); err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	return s.users.GetUserByID(ctx, t.UserID)
+}
+
+func (s *twoFactorService) TrustDevice(ctx context.Context, userID int64, userAgent string) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if len(userAgent) > 300 {
+		userAgent = userAgent[:300]
+	}
+	expires := time.Now().Add(s.cfg.TrustedDeviceTTL)
+	if err := s.repo.AddTrustedDevice(ctx, userID, hashToken(token), userAgent, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+func (s *twoFactorService) IsTrustedDevice(ctx context.Context, userID int64, token string) bool {
+	if token == "" {
+		return false
+	}
+	ok, err := s.repo.TrustedDevice(ctx, userID, hashToken(token))
+	return err == nil && ok
+}
+
+func (s *twoFactorService) ForgetDevices(ctx context.Context, userID int64) error {
+	return s.repo.ForgetTrustedDevices(ctx, userID)
+}
+
+// verify accepts a TOTP code or an unused recovery code of an enabled account
+func (s *twoFactorService) verify(ctx context.Context, userID int64, code string) error {
+	code = normalizeCode(code)
+	if len(code) == totp.Digits && isDigits(code) {
+		return s.checkTOTP(ctx, userID, code)
+	}
+	if err := s.allow(ctx, "2fa_code", fmt.Sprint(userID), throttleTwoFactorPerUser); err != nil {
+		return err
+	}
+	if code == "" {
+		return ErrInvalidCode
+	}
+	ok, err := s.repo.UseRecoveryCode(ctx, userID, s.signer.Digest(recoveryCodeDigest, code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func (s *twoFactorService) checkTOTP(ctx context.Context, userID int64, code string) error {
+	if err := s.allow(ctx, "2fa_code", fmt.Sprint(userID), throttleTwoFactorPerUser); err != nil {
+		return err
+	}
+	sealed, _, err := s.repo.Secret(ctx, userID)
+	if err == sql.ErrNoRows {
+		return ErrTwoFactorNotActive
+	}
+	if err != nil {
+		return err
+	}
+	secret, err := s.open(sealed)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, normalizeCode(code), time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+	// один и тот же код нельзя использовать дважды (перехват, подглядывание)
+	fresh, err := s.repo.UseStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func (s *twoFactorService) newRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, s.signer.Digest(recoveryCodeDigest, raw))
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// seal encrypts a TOTP secret for storage
+func (s *twoFactorService) seal(secret string) (string, error) {
+	gcm, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func (s *twoFactorService) open(sealed string) (string, error) {
+	gcm, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < gcm.NonceSize() {
+		return "", errors.New("corrupt totp secret")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("totp secret does not decrypt, was AUTH_SECRET changed?")
+	}
+	return string(plain), nil
+}
+
+func (s *twoFactorService) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// normalizeCode drops what people type around codes: spaces, dashes, upper case
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
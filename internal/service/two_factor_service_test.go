@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/repository"
+	"forum1/internal/totp"
+	"testing"
+	"time"
+)
+
+// twoFactorRepoStub помнит последний использованный шаг так же, как UPDATE … WHERE totp_last_step < $2
+type twoFactorRepoStub struct {
+	repository.TwoFactorRepository
+	sealed   string
+	lastStep int64
+}
+
+func (r *twoFactorRepoStub) Secret(ctx context.Context, userID int64) (string, bool, error) {
+	return r.sealed, true, nil
+}
+
+func (r *twoFactorRepoStub) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	if step <= r.lastStep {
+		return false, nil
+	}
+	r.lastStep = step
+	return true, nil
+}
+
+type throttleStub struct{ hits map[string]int }
+
+func (t *throttleStub) Hit(ctx context.Context, action, key string, window time.Duration) (int, error) {
+	t.hits[action+":"+key]++
+	return t.hits[action+":"+key], nil
+}
+
+func newTwoFactorForTest(t *testing.T) (*twoFactorService, *twoFactorRepoStub, string) {
+	t.Helper()
+	repo := &twoFactorRepoStub{}
+	s := NewTwoFactorService(repo, nil, nil, &throttleStub{hits: map[string]int{}},
+		TwoFactorConfig{Secret: []byte("test secret")}).(*twoFactorService)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.sealed, err = s.seal(secret); err != nil {
+		t.Fatal(err)
+	}
+	return s, repo, secret
+}
+
+func TestTOTPCodeCannotBeReplayed(t *testing.T) {
+	s, _, secret := newTwoFactorForTest(t)
+	ctx := context.Background()
+	now := totp.Step(time.Now())
+	current, _ := totp.CodeAt(secret, now)
+	previous, _ := totp.CodeAt(secret, now-1)
+
+	if err := s.verify(ctx, 1, current); err != nil {
+		t.Fatalf("fresh code: %v", err)
+	}
+	if err := s.verify(ctx, 1, current); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("same code again: %v, want ErrInvalidCode", err)
+	}
+	// код предыдущего шага ещё в окне сдвига часов, но после более позднего он уже не годится
+	if err := s.verify(ctx, 1, previous); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("earlier step after a later one: %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestTOTPAcceptsSkewedCodesInOrder(t *testing.T) {
+	s, repo, secret := newTwoFactorForTest(t)
+	ctx := context.Background()
+	now := totp.Step(time.Now())
+	previous, _ := totp.CodeAt(secret, now-1)
+	current, _ := totp.CodeAt(secret, now)
+	stale, _ := totp.CodeAt(secret, now-3)
+
+	if err := s.verify(ctx, 1, stale); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("code from three steps ago: %v, want ErrInvalidCode", err)
+	}
+	if err := s.verify(ctx, 1, previous[:3]+" "+previous[3:]); err != nil {
+		t.Fatalf("code of the previous step: %v", err)
+	}
+	if repo.lastStep != now-1 {
+		t.Fatalf("stored step %d, want the step the code matched (%d)", repo.lastStep, now-1)
+	}
+	if err := s.verify(ctx, 1, current); err != nil {
+		t.Fatalf("next step's code: %v", err)
+	}
+}
+
+func TestTOTPGuessesAreThrottledPerUser(t *testing.T) {
+	s, _, _ := newTwoFactorForTest(t)
+	ctx := context.Background()
+	for i := 0; i < throttleTwoFactorPerUser.limit; i++ {
+		if err := s.verify(ctx, 1, "000000"); errors.Is(err, ErrRateLimited) {
+			t.Fatalf("guess %d throttled", i+1)
+		}
+	}
+	if err := s.verify(ctx, 1, "000000"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("guess past the limit: %v, want ErrRateLimited", err)
+	}
+	if err := s.verify(ctx, 2, "000000"); errors.Is(err, ErrRateLimited) {
+		t.Fatal("another account shares the guess limit")
+	}
+}
@@ -2,24 +2,94 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"forum1/internal/entity"
 	"forum1/internal/repository"
+	"forum1/utils"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyHash is compared against for unknown usernames so both paths cost one bcrypt check
+var dummyHash = sync.OnceValue(func() string {
+	h, _ := utils.HashPassword("dummy password for timing")
+	return h
+})
+
 type UserService interface {
-	Register(ctx context.Context, username, email, password string) (int64, error)
+	// Register creates the account; locale is the preferred e-mail language
+	Register(ctx context.Context, username, email, password, locale string) (int64, error)
 	GetProfile(ctx context.Context, id int64) (*entity.User, error)
 	Login(ctx context.Context, username, password string) (*entity.User, error)
+	Autocomplete(ctx context.Context, prefix string, limit int) ([]entity.User, error)
+	// SetRole is an admin action; admins can't demote themselves so the forum always keeps one
+	SetRole(ctx context.Context, actor *entity.User, userID int64, role string) error
+}
+
+type UserOption func(*userService)
+
+// WithUserAccounts sends the e-mail confirmation link after registration
+func WithUserAccounts(a AccountService) UserOption {
+	return func(s *userService) { s.accounts = a }
+}
+
+// WithUserAdmins gives the admin role to these usernames when they register (ADMIN_USERNAMES)
+func WithUserAdmins(usernames []string) UserOption {
+	return func(s *userService) {
+		s.admins = map[string]bool{}
+		for _, name := range usernames {
+			if name = strings.TrimSpace(name); name != "" {
+				s.admins[name] = true
+			}
+		}
+	}
 }
 
-type userService struct{ repo repository.UserRepository }
+type userService struct {
+	repo     repository.UserRepository
+	accounts AccountService
+	admins   map[string]bool
+}
 
-func NewUserService(r repository.UserRepository) UserService { return &userService{repo: r} }
+func NewUserService(r repository.UserRepository, opts ...UserOption) UserService {
+	s := &userService{repo: r}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
 
-func (s *userService) Register(ctx context.Context, username, email, password string) (int64, error) {
-	u := &entity.User{Username: username, Email: email, Password: password}
-	return s.repo.CreateUser(ctx, u)
+func (s *userService) Register(ctx context.Context, username, email, password, locale string) (int64, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return 0, errors.New("username required")
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return 0, ErrWeakPassword
+	}
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	u := &entity.User{Username: username, Email: strings.TrimSpace(email), Password: hash, Locale: locale, Role: entity.RoleUser}
+	if s.admins[username] {
+		u.Role = entity.RoleAdmin
+	}
+	id, err := s.repo.CreateUser(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+	u.ID = id
+	if s.accounts != nil && u.Email != "" {
+		if err := s.accounts.SendVerification(ctx, u, ""); err != nil {
+			fmt.Println("verification email:", err)
+		}
+	}
+	return id, nil
 }
 
 func (s *userService) GetProfile(ctx context.Context, id int64) (*entity.User, error) {
@@ -28,13 +98,34 @@ func (s *userService) GetProfile(ctx context.Context, id int64) (*entity.User, e
 
 func (s *userService) Login(ctx context.Context, username, password string) (*entity.User, error) {
 	u, err := s.repo.GetUserByName(ctx, username)
-	if err != nil {
-		return nil, err
+	if err != nil || u == nil {
+		// сравниваем с фиктивным хэшем, чтобы время ответа не выдавало существование логина
+		utils.CheckPasswordHash(password, dummyHash())
+		return nil, ErrInvalidCredentials
 	}
-	// NOTE: password is expected to be hashed earlier in a proper AuthService; for now compare raw
-	// In real implementation, store bcrypt hashes and compare using bcrypt
-	if u == nil {
-		return nil, fmt.Errorf("not found")
+	if !utils.CheckPasswordHash(password, u.Password) {
+		return nil, ErrInvalidCredentials
 	}
 	return u, nil
 }
+
+func (s *userService) Autocomplete(ctx context.Context, prefix string, limit int) ([]entity.User, error) {
+	prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "@")
+	if prefix == "" {
+		return []entity.User{}, nil
+	}
+	if limit <= 0 || limit > 20 {
+		limit = 10
+	}
+	return s.repo.SearchByPrefix(ctx, prefix, limit)
+}
+
+func (s *userService) SetRole(ctx context.Context, actor *entity.User, userID int64, role string) error {
+	if actor == nil || !actor.HasRole(entity.RoleAdmin) {
+		return ErrForbidden
+	}
+	if !entity.ValidRole(role) || (actor.ID == userID && role != entity.RoleAdmin) {
+		return ErrInvalidInput
+	}
+	return s.repo.SetRole(ctx, userID, role)
+}
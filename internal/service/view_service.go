@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ViewService counts unique post views. Record only queues a view; Run writes them in batches,
+// so opening a post costs no database round trip.
+type ViewService interface {
+	// Record queues a view of postID by userID (0 for guests, who are told apart by ip and user agent).
+	// Crawlers are ignored; when the queue is full the view is dropped.
+	Record(postID, userID int64, ip, userAgent string)
+	// Run writes queued views until ctx is cancelled, then flushes what is left
+	Run(ctx context.Context)
+	// History is the per-day view counts of a post for its author and moderators
+	History(ctx context.Context, actor *entity.User, postID int64, days int) ([]entity.ViewDay, error)
+}
+
+type ViewConfig struct {
+	Queue         int           // views waiting for the writer
+	BatchSize     int           // a full batch is written at once…
+	FlushInterval time.Duration // …otherwise whatever is queued is written this often
+	Secret        []byte        // keys the hash of guests' ip and user agent
+}
+
+func DefaultViewConfig() ViewConfig {
+	return ViewConfig{Queue: 10000, BatchSize: 500, FlushInterval: 5 * time.Second}
+}
+
+func NewViewService(repo repository.ViewRepository, posts repository.PostRepository, cfg ViewConfig) ViewService {
+	def := DefaultViewConfig()
+	if cfg.Queue <= 0 {
+		cfg.Queue = def.Queue
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = def.FlushInterval
+	}
+	return &viewService{repo: repo, posts: posts, cfg: cfg, queue: make(chan entity.PostView, cfg.Queue)}
+}
+
+type viewService struct {
+	repo  repository.ViewRepository
+	posts repository.PostRepository
+	cfg   ViewConfig
+	queue chan entity.PostView
+}
+
+// crawlerMarkers are substrings of user agents that are not people
+var crawlerMarkers = []string{"bot", "crawler", "spider", "slurp", "preview", "curl", "wget", "python-requests"}
+
+func isCrawler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return true
+	}
+	for _, m := range crawlerMarkers {
+		if strings.Contains(ua, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// visitorKey: пользователь — по id, гость — по HMAC от ip и user agent, сами они не хранятся
+func (s *viewService) visitorKey(userID int64, ip, userAgent string) string {
+	if userID != 0 {
+		return "u:" + strconv.FormatInt(userID, 10)
+	}
+	mac := hmac.New(sha256.New, s.cfg.Secret)
+	mac.Write([]byte(ip + "\x00" + userAgent))
+	return "a:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (s *viewService) Record(postID, userID int64, ip, userAgent string) {
+	if postID <= 0 || (userID == 0 && isCrawler(userAgent)) {
+		return
+	}
+	v := entity.PostView{PostID: postID, UserID: userID, Visitor: s.visitorKey(userID, ip, userAgent)}
+	select {
+	case s.queue <- v:
+	default:
+		// writer is behind: losing a view is better than slowing the page down
+	}
+}
+
+func (s *viewService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]entity.PostView, 0, s.cfg.BatchSize)
+	seen := map[entity.PostView]bool{}
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.repo.RecordViews(ctx, batch); err != nil {
+			fmt.Println("post views:", err, "- dropped", len(batch))
+		}
+		batch = batch[:0]
+		clear(seen)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// дописываем очередь; ctx уже отменён, поэтому свой короткий таймаут
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case v := <-s.queue:
+					if !seen[v] {
+						seen[v] = true
+						batch = append(batch, v)
+					}
+					if len(batch) >= s.cfg.BatchSize {
+						flush(flushCtx)
+					}
+				default:
+					flush(flushCtx)
+					return
+				}
+			}
+		case v := <-s.queue:
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			batch = append(batch, v)
+			if len(batch) >= s.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+func (s *viewService) History(ctx context.Context, actor *entity.User, postID int64, days int) ([]entity.ViewDay, error) {
+	if actor == nil {
+		return nil, ErrForbidden
+	}
+	post, err := s.posts.GetPostByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if post.AuthorID != actor.ID && !isModerator(actor) {
+		return nil, ErrForbidden
+	}
+	if days <= 0 || days > 365 {
+		days = 30
+	}
+	return s.repo.History(ctx, postID, days)
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"forum1/internal/entity"
+	"forum1/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// viewRepoStub запоминает пачки, которые записал Run
+type viewRepoStub struct {
+	repository.ViewRepository
+	batches [][]entity.PostView
+	days    int
+}
+
+func (r *viewRepoStub) RecordViews(ctx context.Context, views []entity.PostView) error {
+	r.batches = append(r.batches, append([]entity.PostView(nil), views...))
+	return nil
+}
+
+func (r *viewRepoStub) History(ctx context.Context, postID int64, days int) ([]entity.ViewDay, error) {
+	r.days = days
+	return []entity.ViewDay{{Day: time.Now(), Views: 3, Users: 1}}, nil
+}
+
+const browserUA = "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0"
+
+// runViews пишет всё, что уже в очереди, и возвращает записанные просмотры
+func runViews(s ViewService, repo *viewRepoStub) []entity.PostView {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx)
+	var all []entity.PostView
+	for _, b := range repo.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func TestViewsAreUniquePerVisitor(t *testing.T) {
+	repo := &viewRepoStub{}
+	s := NewViewService(repo, nil, ViewConfig{BatchSize: 100, Secret: []byte("test")})
+
+	s.Record(1, 7, "10.0.0.1", browserUA)
+	s.Record(1, 7, "10.0.0.2", "другой браузер") // тот же пользователь
+	s.Record(1, 0, "10.0.0.1", browserUA)
+	s.Record(1, 0, "10.0.0.1", browserUA) // тот же гость
+	s.Record(1, 0, "10.0.0.1", "Mozilla/5.0 Chrome/128.0")
+	s.Record(2, 7, "10.0.0.1", browserUA)
+	for _, ua := range []string{"", "Googlebot/2.1", "curl/8.5.0", "TelegramBot (like TwitterBot)"} {
+		s.Record(1, 0, "10.0.0.9", ua)
+	}
+	s.Record(0, 7, "10.0.0.1", browserUA)
+
+	views := runViews(s, repo)
+	if len(views) != 4 {
+		t.Fatalf("recorded %+v, want user 7 on posts 1 and 2 and two guests on post 1", views)
+	}
+	for _, v := range views {
+		if v.UserID == 0 && (!strings.HasPrefix(v.Visitor, "a:") || strings.Contains(v.Visitor, "10.0.0.1")) {
+			t.Errorf("guest key %q must be a hash, not the address", v.Visitor)
+		}
+		if v.UserID == 7 && v.Visitor != "u:7" {
+			t.Errorf("user key %q, want u:7", v.Visitor)
+		}
+	}
+
+	// ключ гостя зависит от секрета
+	other := &viewRepoStub{}
+	s2 := NewViewService(other, nil, ViewConfig{Secret: []byte("other")})
+	s2.Record(1, 0, "10.0.0.1", browserUA)
+	if got := runViews(s2, other); got[0].Visitor == views[1].Visitor || got[0].Visitor == views[2].Visitor {
+		t.Fatal("guest keys do not depend on the secret")
+	}
+}
+
+func TestViewsAreWrittenInBatches(t *testing.T) {
+	repo := &viewRepoStub{}
+	s := NewViewService(repo, nil, ViewConfig{Queue: 5, BatchSize: 2})
+	for user := int64(1); user <= 7; user++ {
+		s.Record(1, user, "", browserUA)
+	}
+	views := runViews(s, repo)
+	if len(views) != 5 {
+		t.Fatalf("%d views written, want the 5 that fit the queue", len(views))
+	}
+	for _, b := range repo.batches {
+		if len(b) > 2 {
+			t.Fatalf("batch of %d, want at most 2", len(b))
+		}
+	}
+}
+
+func TestViewHistoryIsForTheAuthorAndModerators(t *testing.T) {
+	repo := &viewRepoStub{}
+	posts := &threadPostsStub{posts: map[int64]*entity.Post{1: {ID: 1, AuthorID: 5}}}
+	s := NewViewService(repo, posts, ViewConfig{})
+	ctx := context.Background()
+
+	for name, actor := range map[string]*entity.User{"guest": nil, "another user": {ID: 6, Role: entity.RoleUser}} {
+		if _, err := s.History(ctx, actor, 1, 7); !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s: %v, want ErrForbidden", name, err)
+		}
+	}
+	if days, err := s.History(ctx, &entity.User{ID: 5, Role: entity.RoleUser}, 1, 7); err != nil || len(days) != 1 || repo.days != 7 {
+		t.Fatalf("author: %v %v, asked %d days", days, err, repo.days)
+	}
+	if _, err := s.History(ctx, &entity.User{ID: 9, Role: entity.RoleModerator}, 1, 1000); err != nil || repo.days != 30 {
+		t.Fatalf("moderator asking for 1000 days: %v, asked %d, want the default 30", err, repo.days)
+	}
+}
@@ -0,0 +1,52 @@
+package spam
+
+import (
+	"math"
+	"sort"
+)
+
+// Counts is how many trained spam and ham documents contained a token
+type Counts struct {
+	Spam int
+	Ham  int
+}
+
+// interesting is how many of the most telling tokens take part in a decision (Graham's 15)
+const interesting = 15
+
+// Classify is the probability that a text with the given token counts is spam, from spamDocs and
+// hamDocs trained documents. Tokens missing from counts are unknown and ignored. Each token's
+// probability is smoothed towards 0.5 by Robinson's method, so a word seen once doesn't decide
+// alone; the most extreme ones are then combined as independent evidence.
+func Classify(tokens []string, counts map[string]Counts, spamDocs, hamDocs int) float64 {
+	if spamDocs == 0 || hamDocs == 0 {
+		return 0.5 // не на чем учиться
+	}
+	const strength, prior = 1.0, 0.5
+	probs := make([]float64, 0, len(tokens))
+	for _, t := range tokens {
+		c, ok := counts[t]
+		if !ok || c.Spam+c.Ham == 0 {
+			continue
+		}
+		s := float64(c.Spam) / float64(spamDocs)
+		h := float64(c.Ham) / float64(hamDocs)
+		p := s / (s + h)
+		n := float64(c.Spam + c.Ham)
+		p = (strength*prior + n*p) / (strength + n)
+		probs = append(probs, math.Min(0.99, math.Max(0.01, p)))
+	}
+	if len(probs) == 0 {
+		return 0.5
+	}
+	sort.Slice(probs, func(i, j int) bool { return math.Abs(probs[i]-0.5) > math.Abs(probs[j]-0.5) })
+	if len(probs) > interesting {
+		probs = probs[:interesting]
+	}
+	// в логарифмах, чтобы произведение из 15 малых чисел не ушло в ноль
+	var eta float64
+	for _, p := range probs {
+		eta += math.Log(1-p) - math.Log(p)
+	}
+	return 1 / (1 + math.Exp(eta))
+}
@@ -0,0 +1,29 @@
+package spam
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	counts := map[string]Counts{
+		"казино":   {Spam: 40, Ham: 1},
+		"бонус":    {Spam: 30, Ham: 2},
+		"ремонт":   {Spam: 1, Ham: 35},
+		"подвеска": {Spam: 0, Ham: 20},
+		"редкое":   {Spam: 1, Ham: 0},
+	}
+	if p := Classify([]string{"казино", "бонус", "__link__"}, counts, 50, 50); p < 0.99 {
+		t.Errorf("spammy text: %.3f, want near 1", p)
+	}
+	if p := Classify([]string{"ремонт", "подвеска"}, counts, 50, 50); p > 0.01 {
+		t.Errorf("ordinary text: %.3f, want near 0", p)
+	}
+	// одно слово, виденное один раз, не решает само
+	if p := Classify([]string{"редкое"}, counts, 50, 50); p > 0.8 {
+		t.Errorf("a word seen once: %.3f, want it smoothed towards 0.5", p)
+	}
+	if p := Classify([]string{"неизвестное"}, counts, 50, 50); p != 0.5 {
+		t.Errorf("unknown words only: %.3f, want 0.5", p)
+	}
+	if p := Classify([]string{"казино"}, counts, 50, 0); p != 0.5 {
+		t.Errorf("no ham trained: %.3f, want 0.5", p)
+	}
+}
@@ -0,0 +1,105 @@
+// Package spam holds the text analysis behind the forum's spam filter: tokenizing, duplicate
+// fingerprints, link counting, banned word patterns and a naive Bayes classifier. Storage and the
+// decision what to do with a suspicious post live in the service layer.
+package spam
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MaxPatternLen bounds moderator-supplied patterns; RE2 is linear, but a huge alternation is still slow
+const MaxPatternLen = 500
+
+var linkRe = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.|\[[^\]]*\]\(`)
+
+// Links counts links in text: bare URLs, www. hosts and markdown links
+func Links(text string) int {
+	return len(linkRe.FindAllStringIndex(text, -1))
+}
+
+var hostRe = regexp.MustCompile(`(?i)(?:\bhttps?://|\bwww\.)([\p{L}\p{N}.-]+)`)
+
+// LinkHosts returns the lower-cased host of every bare URL and www. link in text, without "www."
+func LinkHosts(text string) []string {
+	var hosts []string
+	for _, m := range hostRe.FindAllStringSubmatch(text, -1) {
+		host := strings.TrimPrefix(strings.Trim(strings.ToLower(m[1]), ".-"), "www.")
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// HostMatches reports whether host is one of domains or a subdomain of one
+func HostMatches(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Tokens splits text into distinct lower-cased words of 2 to 30 letters, in order of appearance.
+// A text with links also gets the pseudo-token "__link__".
+func Tokens(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) }) {
+		if n := len([]rune(w)); n < 2 || n > 30 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		out = append(out, w)
+	}
+	if Links(text) > 0 {
+		out = append(out, "__link__")
+	}
+	return out
+}
+
+// Fingerprint identifies a text regardless of case, punctuation and spacing, so that
+// "BUY NOW!!!" and "buy now" collide. Texts shorter than minRunes letters get "":
+// "+1" and "спасибо" are legitimately repeated all the time.
+func Fingerprint(text string, minRunes int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.ToLower(text) {
+		if isWordRune(r) {
+			b.WriteRune(r)
+			n++
+		}
+	}
+	if n < minRunes {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// CompileRule turns a banned word into a case-insensitive whole-word match, or compiles a regular
+// expression as is (case-insensitive unless it sets its own flags). \b in RE2 only knows ASCII,
+// so word boundaries are spelled out to work for Cyrillic too.
+func CompileRule(pattern string, isRegex bool) (*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || len(pattern) > MaxPatternLen {
+		return nil, fmt.Errorf("spam: pattern must be 1..%d bytes", MaxPatternLen)
+	}
+	if isRegex {
+		if !strings.HasPrefix(pattern, "(?") {
+			pattern = "(?i)" + pattern
+		}
+		return regexp.Compile(pattern)
+	}
+	return regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}])` + regexp.QuoteMeta(pattern) + `(?:$|[^\p{L}\p{N}])`)
+}
@@ -0,0 +1,86 @@
+package spam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	cases := map[string]int{
+		"без ссылок":                               0,
+		"https://a.example и http://b.example":     2,
+		"заходите на www.example.com":              1,
+		"[жми](https://example.com)":               2, // markdown-ссылка и сам адрес
+		"почта user@example.com — не ссылка":       0,
+		"HTTPS://EXAMPLE.COM в верхнем регистре":   1,
+		"https:// пустой адрес тоже считается":     1,
+		"example.com без схемы и www не считается": 0,
+	}
+	for text, want := range cases {
+		if got := Links(text); got != want {
+			t.Errorf("Links(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestLinkHosts(t *testing.T) {
+	got := LinkHosts("https://WWW.Shop.Example/buy и www.casino.test. и http://")
+	want := []string{"shop.example", "casino.test"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("LinkHosts = %q, want %q", got, want)
+	}
+	if !HostMatches("cdn.shop.example", []string{"shop.example"}) || HostMatches("badshop.example", []string{"shop.example"}) {
+		t.Fatal("HostMatches must take subdomains and nothing that merely ends the same")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	got := Tokens("Купи СЕЙЧАС, купи! a https://x.example")
+	want := []string{"купи", "сейчас", "https", "example", "__link__"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tokens = %q, want %q", got, want)
+	}
+}
+
+func TestFingerprintIgnoresCaseAndPunctuation(t *testing.T) {
+	a := Fingerprint("BUY NOW!!! Cheap watches", 10)
+	if a == "" || a != Fingerprint("buy now — cheap   watches", 10) {
+		t.Fatal("the same words with other case and punctuation must collide")
+	}
+	if a == Fingerprint("buy now cheap watch", 10) {
+		t.Fatal("different texts collide")
+	}
+	if Fingerprint("спасибо!", 10) != "" {
+		t.Fatal("short texts must not get a fingerprint")
+	}
+}
+
+func TestCompileRule(t *testing.T) {
+	word, err := CompileRule(" казино ", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]bool{
+		"Лучшее КАЗИНО города": true,
+		"казино.":              true,
+		"казиноroyal":          false,
+		"наказино":             false,
+	} {
+		if word.MatchString(text) != want {
+			t.Errorf("word rule on %q: %v, want %v", text, !want, want)
+		}
+	}
+
+	re, err := CompileRule(`v[i1]agra`, true)
+	if err != nil || !re.MatchString("ViAgRa") || !re.MatchString("v1agra") {
+		t.Fatalf("regex rule: %v", err)
+	}
+	if cs, _ := CompileRule(`(?-i)Spam`, true); cs.MatchString("spam") {
+		t.Fatal("a regex with its own flags got (?i) added")
+	}
+	for _, bad := range []string{"", "   ", "(unclosed"} {
+		if _, err := CompileRule(bad, true); err == nil {
+			t.Errorf("CompileRule(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodesMatchRFC6238Vectors(t *testing.T) {
+	// RFC 6238, приложение B: восьмизначные коды SHA1; у шестизначных те же младшие цифры
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, code := range vectors {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := code[len(code)-Digits:]; got != want {
+			t.Errorf("T=%d: %s, want %s", unix, got, want)
+		}
+	}
+	// секрет, как его вводят руками: строчными, группами и с паддингом
+	got, err := CodeAt(strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ===="), Step(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Fatalf("hand-typed secret: %q, %v", got, err)
+	}
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Fatal("a malformed secret produced a code")
+	}
+}
+
+func TestValidateAllowsOneStepOfSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	for offset := int64(-3); offset <= 3; offset++ {
+		code, _ := CodeAt(rfcSecret, step+offset)
+		matched, ok := Validate(rfcSecret, code, now)
+		wantOK := offset >= -Skew && offset <= Skew
+		if ok != wantOK {
+			t.Errorf("code from %+d steps: accepted %v, want %v", offset, ok, wantOK)
+		}
+		// вызывающий запоминает именно шаг кода, а не текущий: иначе код соседнего шага можно повторить
+		if ok && matched != step+offset {
+			t.Errorf("code from %+d steps matched step %d, want %d", offset, matched, step+offset)
+		}
+	}
+
+	code, _ := CodeAt(rfcSecret, step)
+	for _, typed := range []string{" " + code + " ", code[:3] + " " + code[3:]} {
+		if _, ok := Validate(rfcSecret, typed, now); !ok {
+			t.Errorf("%q rejected", typed)
+		}
+	}
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestGenerateSecretAndProvisioningURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := decodeSecret(secret); err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Fatal("two secrets are equal")
+	}
+
+	u, err := url.Parse(ProvisioningURI("Forum", "anna k", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Forum:anna k" {
+		t.Fatalf("URI %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != secret || q.Get("issuer") != "Forum" || q.Get("digits") != "6" || q.Get("period") != "30" || q.Get("algorithm") != "SHA1" {
+		t.Fatalf("URI parameters %v", q)
+	}
+}
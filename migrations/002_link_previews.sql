@@ -0,0 +1,13 @@
+-- Link previews (OpenGraph / Twitter card / oEmbed) for posts.link_url
+CREATE TABLE IF NOT EXISTS link_previews (
+    url TEXT PRIMARY KEY,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    site_name TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',           -- последняя ошибка загрузки, '' если успешно
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS link_previews_expires_at_idx ON link_previews (expires_at);
@@ -0,0 +1,15 @@
+-- @mentions in posts and comments (comment_id IS NULL means the post body itself)
+CREATE TABLE IF NOT EXISTS mentions (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    author_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS mentions_source_user_idx ON mentions (post_id, COALESCE(comment_id, 0), user_id);
+CREATE INDEX IF NOT EXISTS mentions_user_idx ON mentions (user_id, created_at DESC);
+
+-- Quote-reply: comment quoting another comment of the same post
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS quote_id BIGINT REFERENCES comments(id) ON DELETE SET NULL;
@@ -0,0 +1,32 @@
+-- Preferred language for e-mails
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT 'ru';
+
+-- Durable outbox: messages are rendered on enqueue and sent by a background worker with retries
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    to_address TEXT NOT NULL,
+    template TEXT NOT NULL,
+    lang TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL DEFAULT '',
+    transactional BOOLEAN NOT NULL DEFAULT false, -- письма по безопасности аккаунта не зависят от отписки
+    status TEXT NOT NULL DEFAULT 'pending',        -- pending | sending | sent | failed | suppressed
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON email_outbox (next_attempt_at) WHERE status IN ('pending', 'sending');
+
+-- Addresses we must not mail: hard bounces, spam complaints, unsubscribes
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    email TEXT PRIMARY KEY, -- в нижнем регистре
+    reason TEXT NOT NULL,   -- bounce | complaint | unsubscribe
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
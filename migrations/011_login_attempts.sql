@@ -0,0 +1,25 @@
+-- Password login attempts, for backoff, lockout and the admin audit view.
+-- username is stored lower-cased as typed, also for accounts that don't exist,
+-- so lockouts don't reveal which usernames are registered.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    reason TEXT NOT NULL DEFAULT '', -- bad_password; attempts rejected by backoff aren't recorded
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_username_idx ON login_attempts (username, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_failed_idx ON login_attempts (created_at) WHERE NOT success;
+
+-- Temporary lockouts; a row stays after expiry so failures are counted from locked_at again
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    username TEXT PRIMARY KEY,
+    locked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ NOT NULL,
+    failures INT NOT NULL DEFAULT 0
+);
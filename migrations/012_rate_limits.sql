@@ -0,0 +1,10 @@
+-- Token buckets of the rate limiter when RATELIMIT_BACKEND=postgres (several app instances).
+-- key is "<policy>:<u|t|ip>:<id>"; idle rows are swept once they would be full again.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_updated_idx ON rate_limits (updated_at);
@@ -0,0 +1,52 @@
+-- Anti-spam: posts and comments held by the filter wait in the moderation queue unpublished.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published'; -- published | pending | rejected
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published';
+CREATE INDEX IF NOT EXISTS posts_unpublished_idx ON posts (status) WHERE status <> 'published';
+CREATE INDEX IF NOT EXISTS comments_unpublished_idx ON comments (status) WHERE status <> 'published';
+
+CREATE TABLE IF NOT EXISTS moderation_queue (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id BIGINT REFERENCES comments(id) ON DELETE CASCADE, -- NULL: в очереди сам пост
+    author_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending | approved | spam
+    decided_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS moderation_queue_pending_idx ON moderation_queue (created_at) WHERE status = 'pending';
+
+-- Banned words and regular expressions; board_id NULL applies to every board
+CREATE TABLE IF NOT EXISTS spam_rules (
+    id BIGSERIAL PRIMARY KEY,
+    board_id BIGINT REFERENCES boards(id) ON DELETE CASCADE,
+    pattern TEXT NOT NULL,
+    is_regex BOOLEAN NOT NULL DEFAULT false,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Fingerprints of recently submitted texts, for duplicate detection
+CREATE TABLE IF NOT EXISTS spam_fingerprints (
+    fingerprint TEXT NOT NULL,
+    author_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS spam_fingerprints_idx ON spam_fingerprints (fingerprint, created_at);
+
+-- Naive Bayes word counts, trained from moderator decisions
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    token TEXT PRIMARY KEY,
+    spam INT NOT NULL DEFAULT 0,
+    ham INT NOT NULL DEFAULT 0
+);
+
+-- Number of trained documents per class
+CREATE TABLE IF NOT EXISTS spam_corpus (
+    label TEXT PRIMARY KEY, -- spam | ham
+    docs INT NOT NULL DEFAULT 0
+);
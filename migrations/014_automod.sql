@@ -0,0 +1,37 @@
+-- Automoderator: rules stored in the DB, for the whole forum, a club or a board.
+-- Clubs get an owner, who manages the rules of the club and its boards.
+ALTER TABLE clubs ADD COLUMN IF NOT EXISTS owner_id BIGINT REFERENCES users(id) ON DELETE SET NULL;
+
+-- Closed threads take no new comments
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (post_id, tag)
+);
+
+CREATE TABLE IF NOT EXISTS automod_rules (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    club_id BIGINT REFERENCES clubs(id) ON DELETE CASCADE,   -- NULL вместе с board_id: весь форум
+    board_id BIGINT REFERENCES boards(id) ON DELETE CASCADE,
+    target TEXT NOT NULL DEFAULT 'all',                      -- all | post | comment
+    -- conditions; every one that is set must match
+    pattern TEXT NOT NULL DEFAULT '',
+    max_account_age_hours INT NOT NULL DEFAULT 0,
+    max_karma INT,
+    link_domains TEXT[] NOT NULL DEFAULT '{}',
+    attachment BOOLEAN NOT NULL DEFAULT false,
+    action TEXT NOT NULL,                                    -- reject | hold | tag | lock | notify
+    tag TEXT NOT NULL DEFAULT '',
+    message TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (club_id IS NULL OR board_id IS NULL)
+);
+
+CREATE INDEX IF NOT EXISTS automod_rules_club_idx ON automod_rules (club_id) WHERE club_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS automod_rules_board_idx ON automod_rules (board_id) WHERE board_id IS NOT NULL;
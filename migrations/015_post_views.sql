@@ -0,0 +1,15 @@
+-- Unique post views: logged-in users by id, anonymous visitors by a hashed key.
+-- visitor is "u:<id>" or "a:<hash>", so one index covers both.
+ALTER TABLE post_views ADD COLUMN IF NOT EXISTS visitor TEXT;
+UPDATE post_views SET visitor = 'u:' || user_id WHERE visitor IS NULL;
+ALTER TABLE post_views ALTER COLUMN visitor SET NOT NULL;
+ALTER TABLE post_views DROP CONSTRAINT IF EXISTS post_views_pkey;
+ALTER TABLE post_views ALTER COLUMN user_id DROP NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS post_views_visitor_idx ON post_views (post_id, visitor);
+CREATE INDEX IF NOT EXISTS post_views_history_idx ON post_views (post_id, created_at);
+
+-- Denormalized counter for post cards; the batched writer keeps it in step with post_views
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS view_count INT NOT NULL DEFAULT 0;
+UPDATE posts p SET view_count = v.n
+FROM (SELECT post_id, count(*) AS n FROM post_views GROUP BY post_id) v
+WHERE p.id = v.post_id AND p.view_count <> v.n;
@@ -0,0 +1,18 @@
+-- Feed ranking: scores are recomputed by a background job and only sorted by here.
+-- Votes get a timestamp, so that "rising" can tell recent ones.
+ALTER TABLE post_votes ADD COLUMN IF NOT EXISTS voted_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS post_votes_voted_at_idx ON post_votes (voted_at);
+CREATE INDEX IF NOT EXISTS comments_created_at_idx ON comments (created_at);
+CREATE INDEX IF NOT EXISTS post_views_created_at_idx ON post_views (created_at);
+
+CREATE TABLE IF NOT EXISTS post_scores (
+    post_id BIGINT PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    hot DOUBLE PRECISION NOT NULL DEFAULT 0,
+    top DOUBLE PRECISION NOT NULL DEFAULT 0,    -- нижняя граница Уилсона для доли лайков
+    rising DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS post_scores_hot_idx ON post_scores (hot DESC);
+CREATE INDEX IF NOT EXISTS post_scores_top_idx ON post_scores (top DESC);
+CREATE INDEX IF NOT EXISTS post_scores_rising_idx ON post_scores (rising DESC) WHERE rising > 0;
@@ -0,0 +1,6 @@
+-- Thread states: pinned announcements (ordered within a board) and archived threads.
+-- Locked threads use posts.locked_at from 014. Locked and archived threads take no comments or votes.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS pin_position INT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS posts_pinned_idx ON posts (board_id, pin_position) WHERE pin_position IS NOT NULL;
+CREATE INDEX IF NOT EXISTS comments_post_created_idx ON comments (post_id, created_at);
@@ -0,0 +1,30 @@
+-- Polls attached to posts. Votes always keep the voter, so nobody votes twice;
+-- anonymous only hides who voted for what.
+CREATE TABLE IF NOT EXISTS polls (
+    post_id BIGINT PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    question TEXT NOT NULL,
+    multiple BOOLEAN NOT NULL DEFAULT false,
+    anonymous BOOLEAN NOT NULL DEFAULT false,
+    allow_change BOOLEAN NOT NULL DEFAULT true,
+    hide_results BOOLEAN NOT NULL DEFAULT false,  -- результаты только после закрытия
+    closes_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL REFERENCES polls(post_id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    text TEXT NOT NULL,
+    UNIQUE (post_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    post_id BIGINT NOT NULL REFERENCES polls(post_id) ON DELETE CASCADE,
+    option_id BIGINT NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (option_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS poll_votes_user_idx ON poll_votes (post_id, user_id);
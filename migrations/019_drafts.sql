@@ -0,0 +1,6 @@
+-- Drafts and scheduled posts are posts with status draft / scheduled; listings only show published ones.
+-- The scheduler publishes a due post by switching its status in one statement, so it happens once
+-- however many instances poll.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS publish_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS posts_scheduled_idx ON posts (publish_at) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS posts_drafts_idx ON posts (author_id, updated_at DESC) WHERE status IN ('draft', 'scheduled');
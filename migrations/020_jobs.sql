@@ -0,0 +1,25 @@
+-- Durable background jobs. Workers claim ready rows with FOR UPDATE SKIP LOCKED, so instances never
+-- take the same job; a running job whose locked_at is too old belonged to a dead instance and is
+-- returned to pending. unique_key deduplicates cron ticks and other one-off jobs across instances;
+-- a dead job drops its key so the same job can be enqueued again.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    queue TEXT NOT NULL DEFAULT 'default',
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT 'null',
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    unique_key TEXT,
+    last_error TEXT NOT NULL DEFAULT '',
+    locked_by TEXT,
+    locked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS jobs_ready_idx ON jobs (queue, run_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_at) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS jobs_dead_idx ON jobs (updated_at DESC) WHERE status = 'dead';
+CREATE INDEX IF NOT EXISTS jobs_done_idx ON jobs (updated_at) WHERE status = 'done';
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key);
@@ -0,0 +1,12 @@
+-- Subscriptions to boards, clubs, threads (posts) and authors (users). target_id has no foreign key
+-- because it points into different tables; the repository drops rows of deleted targets from lists.
+-- A muted thread row silences the thread even for its author.
+CREATE TABLE IF NOT EXISTS subscriptions (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target TEXT NOT NULL,
+    target_id BIGINT NOT NULL,
+    muted BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, target, target_id)
+);
+CREATE INDEX IF NOT EXISTS subscriptions_target_idx ON subscriptions (target, target_id) WHERE NOT muted;
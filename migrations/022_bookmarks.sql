@@ -0,0 +1,24 @@
+-- Bookmarks of posts and comments, optionally sorted into the user's folders and annotated.
+-- Deleting a folder keeps its bookmarks unfiled. Post bookmarks are also a ranking signal.
+CREATE TABLE IF NOT EXISTS bookmark_folders (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id BIGINT REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+    folder_id BIGINT REFERENCES bookmark_folders(id) ON DELETE SET NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ((post_id IS NULL) <> (comment_id IS NULL))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS bookmarks_user_post_idx ON bookmarks (user_id, post_id) WHERE post_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS bookmarks_user_comment_idx ON bookmarks (user_id, comment_id) WHERE comment_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS bookmarks_user_idx ON bookmarks (user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS bookmarks_post_idx ON bookmarks (post_id, created_at) WHERE post_id IS NOT NULL;
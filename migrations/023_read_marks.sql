@@ -0,0 +1,20 @@
+-- How far each user has read each thread: the highest comment id seen on the post page.
+-- Written in batches by the read tracker, so a row may lag a few seconds behind the visit.
+CREATE TABLE IF NOT EXISTS post_reads (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    last_comment_id BIGINT NOT NULL DEFAULT 0,
+    read_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, post_id)
+);
+
+-- "Mark board as read": posts and comments created before read_at count as read in that board
+CREATE TABLE IF NOT EXISTS board_reads (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    board_id BIGINT NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+    read_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, board_id)
+);
+
+-- unread counts look up comments of a post above the last read id
+CREATE INDEX IF NOT EXISTS comments_post_unread_idx ON comments (post_id, id);
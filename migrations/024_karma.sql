@@ -0,0 +1,46 @@
+-- Karma: the sum of votes other users gave to someone's published posts and comments.
+-- Vote statements add their delta here as they go; a daily job recounts everything to
+-- pick up deleted and re-moderated content.
+CREATE TABLE IF NOT EXISTS user_karma (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    post_karma INT NOT NULL DEFAULT 0,
+    comment_karma INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- The same per club, for votes on posts and comments in the club's boards
+CREATE TABLE IF NOT EXISTS club_karma (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    club_id BIGINT NOT NULL REFERENCES clubs(id) ON DELETE CASCADE,
+    karma INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, club_id)
+);
+CREATE INDEX IF NOT EXISTS club_karma_top_idx ON club_karma (club_id, karma DESC);
+
+-- First run: fill from the votes already stored
+INSERT INTO user_karma (user_id, post_karma, comment_karma)
+SELECT author_id, sum(pk), sum(ck) FROM (
+    SELECT p.author_id, v.value AS pk, 0 AS ck
+    FROM post_votes v JOIN posts p ON p.id = v.post_id
+    WHERE v.user_id <> p.author_id AND p.status = 'published'
+    UNION ALL
+    SELECT c.author_id, 0, v.value
+    FROM comment_votes v JOIN comments c ON c.id = v.comment_id
+    WHERE v.user_id <> c.author_id AND c.status = 'published'
+) k
+WHERE NOT EXISTS (SELECT 1 FROM user_karma)
+GROUP BY author_id;
+
+INSERT INTO club_karma (user_id, club_id, karma)
+SELECT author_id, club_id, sum(value) FROM (
+    SELECT p.author_id, b.club_id, v.value
+    FROM post_votes v JOIN posts p ON p.id = v.post_id JOIN boards b ON b.id = p.board_id
+    WHERE v.user_id <> p.author_id AND p.status = 'published' AND b.club_id IS NOT NULL
+    UNION ALL
+    SELECT c.author_id, b.club_id, v.value
+    FROM comment_votes v JOIN comments c ON c.id = v.comment_id JOIN posts p ON p.id = c.post_id
+    JOIN boards b ON b.id = p.board_id
+    WHERE v.user_id <> c.author_id AND c.status = 'published' AND b.club_id IS NOT NULL
+) k
+WHERE NOT EXISTS (SELECT 1 FROM club_karma)
+GROUP BY author_id, club_id;
@@ -0,0 +1,20 @@
+// CSRF: fetch на свой сайт с изменяющим методом получает заголовок X-CSRF-Token из <meta name="csrf-token">.
+// Формы передают токен сами — скрытым полем {{ csrfField }}.
+;(function () {
+	const meta = document.querySelector('meta[name="csrf-token"]')
+	const token = meta ? meta.content : ''
+	if (!token || !window.fetch) return
+	const safe = ['GET', 'HEAD', 'OPTIONS']
+	const origFetch = window.fetch
+	window.fetch = function (input, init) {
+		const req = input instanceof Request ? input : null
+		const method = ((init && init.method) || (req ? req.method : 'GET')).toUpperCase()
+		const url = new URL(req ? req.url : String(input), location.href)
+		if (url.origin === location.origin && !safe.includes(method)) {
+			const headers = new Headers((init && init.headers) || (req ? req.headers : undefined))
+			if (!headers.has('X-CSRF-Token')) headers.set('X-CSRF-Token', token)
+			init = Object.assign({}, init, { headers: headers })
+		}
+		return origFetch.call(this, input, init)
+	}
+})()
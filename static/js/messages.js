@@ -0,0 +1,105 @@
+// Личные сообщения: отправка без перезагрузки и live-обновление диалога
+document.addEventListener('DOMContentLoaded', function () {
+	const list = document.getElementById('chat-messages')
+	const form = document.getElementById('message-form')
+	if (!list) return
+
+	const conversationId = parseInt(list.getAttribute('data-conversation-id'))
+	const me = parseInt(list.getAttribute('data-me'))
+
+	function lastMessageId() {
+		const items = list.querySelectorAll('.chat-message')
+		if (!items.length) return 0
+		return parseInt(items[items.length - 1].id.replace('message-', '')) || 0
+	}
+
+	function appendMessage(m) {
+		if (document.getElementById('message-' + m.id)) return
+		const item = document.createElement('div')
+		item.className = 'chat-message' + (m.sender_id === me ? ' mine' : '')
+		item.id = 'message-' + m.id
+
+		const meta = document.createElement('small')
+		const at = new Date(m.created_at)
+		meta.textContent =
+			m.sender_name +
+			' · ' +
+			at.toLocaleDateString([], { day: '2-digit', month: '2-digit' }) +
+			' ' +
+			at.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' })
+		item.appendChild(meta)
+		item.appendChild(document.createElement('br'))
+
+		const bubble = document.createElement('div')
+		bubble.className = 'bubble'
+		bubble.textContent = m.content
+		if (m.has_image) {
+			const img = document.createElement('img')
+			img.src = '/messages/image/' + m.id
+			img.alt = 'Вложение'
+			bubble.appendChild(img)
+		}
+		item.appendChild(bubble)
+		list.appendChild(item)
+		list.scrollTop = list.scrollHeight
+	}
+
+	function markRead() {
+		fetch('/api/messages/' + conversationId + '/read', {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ message_id: lastMessageId() }),
+		}).catch(() => {})
+	}
+
+	// догружаем сообщения новее последнего показанного
+	function loadNew() {
+		fetch('/api/messages/' + conversationId + '?limit=50', {
+			headers: { Accept: 'application/json' },
+		})
+			.then(response => response.json())
+			.then(data => {
+				const last = lastMessageId()
+				data.messages
+					.filter(m => m.id > last)
+					.reverse()
+					.forEach(appendMessage)
+				markRead()
+			})
+			.catch(() => {})
+	}
+
+	list.scrollTop = list.scrollHeight
+
+	if (form) {
+		form.addEventListener('submit', function (e) {
+			e.preventDefault()
+			fetch(form.action, {
+				method: 'POST',
+				body: new FormData(form),
+				headers: { Accept: 'application/json' },
+			})
+				.then(response => {
+					if (!response.ok) {
+						return response.text().then(text => {
+							throw new Error(text)
+						})
+					}
+					return response.json()
+				})
+				.then(m => {
+					appendMessage(m)
+					form.reset()
+				})
+				.catch(err => alert('Не удалось отправить: ' + err.message))
+		})
+	}
+
+	if (window.EventSource) {
+		const stream = new EventSource('/api/stream?topic=notifications')
+		stream.addEventListener('message', function (e) {
+			const data = JSON.parse(e.data).data
+			if (data.message.conversation_id === conversationId) loadNew()
+		})
+	}
+})
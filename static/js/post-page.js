@@ -79,6 +79,13 @@ document.addEventListener('DOMContentLoaded', function () {
 		}
 	})
 
+	// Комментарий, задержанный антиспамом, появится только после проверки модератором
+	function notifyHeld(data) {
+		if (data && data.status === 'pending') {
+			alert('Комментарий отправлен на проверку модератору и появится после одобрения')
+		}
+	}
+
 	// Обработчик отправки форм ответов
 	document.addEventListener('submit', function (e) {
 		if (e.target.classList.contains('reply-form-content')) {
@@ -88,19 +95,23 @@ document.addEventListener('DOMContentLoaded', function () {
 
 			fetch('/api/comment', {
 				method: 'POST',
+				headers: { Accept: 'application/json' },
 				body: formData,
 			})
 				.then(response => {
-					if (response.ok) {
+					if (!response.ok) {
+						alert('Ошибка при отправке ответа')
+						return
+					}
+					return response.json().then(data => {
 						// Очистить форму
 						e.target.querySelector('textarea').value = ''
 						// Скрыть форму
 						e.target.closest('.reply-form').style.display = 'none'
+						notifyHeld(data)
 						// Перезагрузить страницу для показа нового комментария
 						location.reload()
-					} else {
-						alert('Ошибка при отправке ответа')
-					}
+					})
 				})
 				.catch(error => {
 					console.error('Error:', error)
@@ -121,18 +132,22 @@ document.addEventListener('DOMContentLoaded', function () {
 
 			fetch('/api/comment', {
 				method: 'POST',
+				headers: { Accept: 'application/json' },
 				body: formData,
 				// Не устанавливаем Content-Type, браузер сам установит multipart/form-data
 			})
 				.then(response => {
-					if (response.ok) {
+					if (!response.ok) {
+						alert('Ошибка при отправке комментария')
+						return
+					}
+					return response.json().then(data => {
 						// Очистить форму
 						this.querySelector('textarea').value = ''
+						notifyHeld(data)
 						// Перезагрузить страницу
 						location.reload()
-					} else {
-						alert('Ошибка при отправке комментария')
-					}
+					})
 				})
 				.catch(error => {
 					console.error('Error:', error)
@@ -141,67 +156,150 @@ document.addEventListener('DOMContentLoaded', function () {
 		})
 	}
 
-	// Обработчик лайков/дизлайков постов
 	const postId = window.postId || 0
-	if (postId > 0) {
-		const likeLink = document.querySelector(
-			'a[href="/post/' + postId + '/like"]'
-		)
-		const dislikeLink = document.querySelector(
-			'a[href="/post/' + postId + '/dislike"]'
-		)
-		const likesHeader = document.querySelector('h3')
-
-		function updatePostCounts(data) {
-			if (likesHeader) {
-				likesHeader.textContent =
-					'Продвинуто: ' + data.likes + ' · Не нравится: ' + data.dislikes
-			}
-		}
 
-		if (likeLink) {
-			likeLink.addEventListener('click', function (e) {
-				e.preventDefault()
-				fetch(this.href, { headers: { Accept: 'application/json' } })
-					.then(response => response.json())
-					.then(data => updatePostCounts(data))
-					.catch(() => {})
+	// Лайки/дизлайки постов и комментариев: POST-формы отправляем без перезагрузки страницы
+	const likesHeader = document.getElementById('post-votes')
+	document.querySelectorAll('form.vote-form').forEach(function (form) {
+		form.addEventListener('submit', function (e) {
+			e.preventDefault()
+			fetch(form.action, {
+				method: 'POST',
+				headers: { Accept: 'application/json' },
+				body: new FormData(form),
 			})
-		}
+				.then(response => response.json())
+				.then(data => {
+					const text =
+						'Продвинуто: ' + data.likes + ' · Не нравится: ' + data.dislikes
+					if (/\/post\/\d+\//.test(form.getAttribute('action'))) {
+						if (likesHeader) likesHeader.textContent = text
+						return
+					}
+					const container = form.closest('li')
+					const span = container && container.querySelector('.comment-votes')
+					if (span) span.textContent = text
+				})
+				.catch(() => {})
+		})
+	})
+
+	// Цитирование: подставляем quote_id в основную форму комментария
+	const commentForm = document.getElementById('comment-form')
+	if (commentForm) {
+		const quoteInput = commentForm.querySelector('input[name="quote_id"]')
+		const indicator = commentForm.querySelector('.quote-indicator')
+		const indicatorLink = commentForm.querySelector('.quote-indicator-link')
 
-		if (dislikeLink) {
-			dislikeLink.addEventListener('click', function (e) {
+		document.addEventListener('click', function (e) {
+			if (e.target.classList.contains('quote-link')) {
 				e.preventDefault()
-				fetch(this.href, { headers: { Accept: 'application/json' } })
-					.then(response => response.json())
-					.then(data => updatePostCounts(data))
-					.catch(() => {})
-			})
+				const commentId = e.target.getAttribute('data-comment-id')
+				quoteInput.value = commentId
+				indicatorLink.textContent = '#' + commentId
+				indicatorLink.href = '#comment-' + commentId
+				indicator.style.display = 'block'
+				commentForm.scrollIntoView({ behavior: 'smooth' })
+				commentForm.querySelector('textarea').focus()
+			}
+			if (e.target.classList.contains('quote-clear')) {
+				e.preventDefault()
+				quoteInput.value = ''
+				indicator.style.display = 'none'
+			}
+		})
+	}
+
+	// Автодополнение @упоминаний
+	let suggestions = null
+	function closeSuggestions() {
+		if (suggestions) {
+			suggestions.remove()
+			suggestions = null
 		}
 	}
 
-	// Обработчик лайков/дизлайков комментариев
-	document.querySelectorAll('a[href^="/comment/"]').forEach(function (link) {
-		if (/\/comment\/\d+\/(like|dislike)/.test(link.getAttribute('href'))) {
-			link.addEventListener('click', function (e) {
-				e.preventDefault()
-				fetch(this.href, { headers: { Accept: 'application/json' } })
-					.then(response => response.json())
-					.then(data => {
-						const container = this.closest('li')
-						if (container) {
-							const span = container.querySelector('span')
-							if (span) {
-								span.textContent =
-									'Продвинуто: ' +
-									data.likes +
-									' · Не нравится: ' +
-									data.dislikes
-							}
-						}
+	document.querySelectorAll('.mention-input').forEach(function (textarea) {
+		textarea.addEventListener('input', function () {
+			const before = textarea.value.slice(0, textarea.selectionStart)
+			const match = before.match(/(^|\s)@([\p{L}\p{N}_.-]{1,32})$/u)
+			if (!match) {
+				closeSuggestions()
+				return
+			}
+			const prefix = match[2]
+			fetch('/api/users/autocomplete?q=' + encodeURIComponent(prefix), {
+				headers: { Accept: 'application/json' },
+			})
+				.then(response => response.json())
+				.then(users => {
+					closeSuggestions()
+					if (!users.length) return
+					suggestions = document.createElement('ul')
+					suggestions.className = 'mention-suggestions'
+					users.forEach(function (u) {
+						const li = document.createElement('li')
+						li.textContent = '@' + u.username
+						li.addEventListener('mousedown', function (e) {
+							e.preventDefault()
+							const start = textarea.selectionStart - prefix.length
+							textarea.value =
+								textarea.value.slice(0, start) +
+								u.username +
+								' ' +
+								textarea.value.slice(textarea.selectionStart)
+							textarea.focus()
+							closeSuggestions()
+						})
+						suggestions.appendChild(li)
 					})
-					.catch(() => {})
+					textarea.parentNode.insertBefore(suggestions, textarea.nextSibling)
+				})
+				.catch(() => {})
+		})
+		textarea.addEventListener('blur', closeSuggestions)
+	})
+
+	// Live-обновления поста через SSE
+	if (postId > 0 && window.EventSource) {
+		const banner = document.getElementById('live-comments')
+		const bannerLink = banner ? banner.querySelector('.live-reload') : null
+		let newComments = 0
+
+		function formatVotes(data) {
+			return 'Продвинуто: ' + data.likes + ' · Не нравится: ' + data.dislikes
+		}
+
+		const stream = new EventSource('/api/stream?topic=post:' + postId)
+		stream.addEventListener('votes', function (e) {
+			const payload = JSON.parse(e.data)
+			const header = document.getElementById('post-votes')
+			if (header) header.textContent = formatVotes(payload.data)
+		})
+		stream.addEventListener('comment_votes', function (e) {
+			const data = JSON.parse(e.data).data
+			const li = document.getElementById('comment-' + data.comment_id)
+			const span = li ? li.querySelector('.comment-votes') : null
+			if (span) span.textContent = formatVotes(data)
+		})
+		stream.addEventListener('comment', function (e) {
+			const data = JSON.parse(e.data).data
+			if (document.getElementById('comment-' + data.id) || !banner) return
+			newComments++
+			bannerLink.textContent =
+				'Новых комментариев: ' + newComments + ' — показать'
+			banner.style.display = 'block'
+		})
+		stream.addEventListener('comment_deleted', function (e) {
+			const data = JSON.parse(e.data).data
+			const li = document.getElementById('comment-' + data.id)
+			if (li) li.remove()
+		})
+		if (bannerLink) {
+			bannerLink.addEventListener('click', function (e) {
+				e.preventDefault()
+				location.reload()
 			})
 		}
-	})
+	}
 })
@@ -0,0 +1,75 @@
+// Настройки безопасности: включение/отключение 2FA, коды восстановления
+document.addEventListener('DOMContentLoaded', function () {
+	const $ = id => document.getElementById(id)
+	const errorBox = $('tf-error')
+
+	async function post(url, body) {
+		errorBox.textContent = ''
+		const res = await fetch(url, {
+			method: 'POST',
+			credentials: 'include',
+			headers: { 'Content-Type': 'application/json', Accept: 'application/json' },
+			body: JSON.stringify(body || {}),
+		})
+		const data = await res.json().catch(() => ({}))
+		if (!res.ok) {
+			errorBox.textContent =
+				res.status === 401 ? 'Неверный код' : res.status === 429 ? 'Слишком много попыток, подождите' : data.error || 'Ошибка'
+			return null
+		}
+		return data
+	}
+
+	function showCodes(codes) {
+		const list = $('tf-codes-list')
+		list.innerHTML = ''
+		codes.forEach(c => {
+			const div = document.createElement('div')
+			div.textContent = c
+			list.appendChild(div)
+		})
+		$('tf-codes').hidden = false
+		const setup = $('tf-setup')
+		if (setup) setup.hidden = true
+	}
+
+	const enroll = $('tf-enroll')
+	if (enroll) {
+		enroll.addEventListener('click', async () => {
+			const data = await post('/api/2fa/enroll')
+			if (!data) return
+			$('tf-secret').textContent = data.secret.replace(/(.{4})/g, '$1 ').trim()
+			$('tf-uri').href = data.uri
+			$('tf-setup').hidden = false
+			enroll.hidden = true
+			$('tf-confirm-code').focus()
+		})
+		$('tf-confirm').addEventListener('click', async () => {
+			const data = await post('/api/2fa/confirm', { code: $('tf-confirm-code').value })
+			if (data) showCodes(data.recovery_codes)
+		})
+	}
+
+	const regenerate = $('tf-regenerate')
+	if (regenerate) {
+		regenerate.addEventListener('click', async () => {
+			const data = await post('/api/2fa/recovery-codes', { code: $('tf-code').value })
+			if (data) showCodes(data.recovery_codes)
+		})
+	}
+
+	const disable = $('tf-disable')
+	if (disable) {
+		disable.addEventListener('click', async () => {
+			if (!confirm('Отключить двухфакторную аутентификацию?')) return
+			if (await post('/api/2fa/disable', { code: $('tf-code').value })) location.reload()
+		})
+	}
+
+	const forget = $('tf-forget')
+	if (forget) {
+		forget.addEventListener('click', async () => {
+			if (await post('/api/2fa/devices/forget')) location.reload()
+		})
+	}
+})
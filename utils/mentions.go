@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// @name must not be glued to a preceding word, otherwise "mail@example.com" would count
+var mentionRe = regexp.MustCompile(`(^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}_][\p{L}\p{N}_.\-]{0,31})`)
+
+// FindMentions returns the distinct usernames mentioned in text, in order of appearance
+func FindMentions(text string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range mentionRe.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimRight(m[2], ".-")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// RenderMentions escapes text and turns @name of known users into links to their profiles
+func RenderMentions(text string, users map[string]int64) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, loc := range mentionRe.FindAllStringSubmatchIndex(text, -1) {
+		start, end := loc[4], loc[5] // name group
+		name := strings.TrimRight(text[start:end], ".-")
+		id, ok := users[name]
+		if !ok {
+			continue
+		}
+		at := start - 1 // the '@'
+		b.WriteString(template.HTMLEscapeString(text[last:at]))
+		b.WriteString(`<a class="mention" href="/profile/` + strconv.FormatInt(id, 10) + `">@`)
+		b.WriteString(template.HTMLEscapeString(name))
+		b.WriteString(`</a>`)
+		last = start + len(name)
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
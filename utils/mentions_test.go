@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindMentions(t *testing.T) {
+	cases := map[string][]string{
+		"@alice, посмотри":           {"alice"},
+		"привет @alice и @Борис_2!":  {"alice", "Борис_2"},
+		"@alice @alice @bob":         {"alice", "bob"},
+		"пишите на mail@example.com": nil,
+		"(@carol) и @dave.":          {"carol", "dave"},
+		"@first.last-":               {"first.last"},
+		"@@double и word@glued":      nil,
+		"":                           nil,
+	}
+	for text, want := range cases {
+		if got := FindMentions(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("FindMentions(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestRenderMentionsLinksKnownUsersAndEscapes(t *testing.T) {
+	got := string(RenderMentions(`<b>@alice</b> и @ghost, "@bob".`, map[string]int64{"alice": 1, "bob": 2}))
+	want := `&lt;b&gt;<a class="mention" href="/profile/1">@alice</a>&lt;/b&gt; и @ghost, &#34;<a class="mention" href="/profile/2">@bob</a>&#34;.`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
@@ -9,6 +9,23 @@ import (
 
 var templatesBase string
 
+// HoneypotField is the name of a form field hidden from people; bots that fill every input give themselves away
+const HoneypotField = "homepage_url"
+
+// funcs are available in every template
+var funcs = template.FuncMap{
+	"mentions": RenderMentions,
+	"honeypotField": func() template.HTML {
+		return template.HTML(`<div class="hp-field" aria-hidden="true"><label>Не заполняйте это поле <input type="text" name="` +
+			HoneypotField + `" value="" tabindex="-1" autocomplete="off" /></label></div>`)
+	},
+}
+
+// csrfTokenWriter is implemented by the ResponseWriter of handler.CSRF
+type csrfTokenWriter interface {
+	CSRFToken() string
+}
+
 func ensureTemplatesBase() string {
 	if templatesBase != "" {
 		return templatesBase
@@ -33,7 +50,17 @@ func RenderTemplate(w http.ResponseWriter, name string, data interface{}) {
 	base := ensureTemplatesBase()
 	layout := filepath.Join(base, "layout.html")
 	page := filepath.Join(base, name)
-	tmpl, err := template.ParseFiles(layout, page)
+	token := ""
+	if cw, ok := w.(csrfTokenWriter); ok {
+		token = cw.CSRFToken()
+	}
+	// csrfField — скрытое поле для каждой POST-формы, csrfToken — для <meta> и скриптов
+	tmpl, err := template.New(filepath.Base(layout)).Funcs(funcs).Funcs(template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="csrf_token" value="` + template.HTMLEscapeString(token) + `" />`)
+		},
+	}).ParseFiles(layout, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return